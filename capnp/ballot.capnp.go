@@ -192,6 +192,8 @@ func (s VoteAbortBadRead) TxnId() []byte               { return C.Struct(s).GetO
 func (s VoteAbortBadRead) SetTxnId(v []byte)           { C.Struct(s).SetObject(0, s.Segment.NewData(v)) }
 func (s VoteAbortBadRead) TxnActions() Action_List     { return Action_List(C.Struct(s).GetObject(1)) }
 func (s VoteAbortBadRead) SetTxnActions(v Action_List) { C.Struct(s).SetObject(1, C.Object(v)) }
+func (s VoteAbortBadRead) Collected() bool             { return C.Struct(s).Get1(16) }
+func (s VoteAbortBadRead) SetCollected(v bool)         { C.Struct(s).Set1(16, v) }
 func (s Vote) SetAbortDeadlock()                       { C.Struct(s).Set16(0, 2) }
 func (s Vote) WriteJSON(w io.Writer) error {
 	b := bufio.NewWriter(w)
@@ -272,6 +274,25 @@ func (s Vote) WriteJSON(w io.Writer) error {
 					return err
 				}
 			}
+			err = b.WriteByte(',')
+			if err != nil {
+				return err
+			}
+			_, err = b.WriteString("\"collected\":")
+			if err != nil {
+				return err
+			}
+			{
+				s := s.Collected()
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
 			err = b.WriteByte('}')
 			if err != nil {
 				return err
@@ -380,6 +401,25 @@ func (s Vote) WriteCapLit(w io.Writer) error {
 					return err
 				}
 			}
+			_, err = b.WriteString(", ")
+			if err != nil {
+				return err
+			}
+			_, err = b.WriteString("collected = ")
+			if err != nil {
+				return err
+			}
+			{
+				s := s.Collected()
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
 			err = b.WriteByte(')')
 			if err != nil {
 				return err
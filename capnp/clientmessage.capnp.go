@@ -0,0 +1,645 @@
+package capnp
+
+// AUTO GENERATED - DO NOT EDIT
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	C "github.com/glycerine/go-capnproto"
+	cmsgs "goshawkdb.io/common/capnp"
+	"io"
+)
+
+type ClientMessage C.Struct
+type ClientMessage_Which uint16
+
+const (
+	CLIENTMESSAGE_HEARTBEAT           ClientMessage_Which = 0
+	CLIENTMESSAGE_CLIENTTXNSUBMISSION ClientMessage_Which = 1
+	CLIENTMESSAGE_CLIENTTXNOUTCOME    ClientMessage_Which = 2
+	CLIENTMESSAGE_WATCH               ClientMessage_Which = 3
+	CLIENTMESSAGE_UNWATCH             ClientMessage_Which = 4
+	CLIENTMESSAGE_DEADLINESUBMISSION  ClientMessage_Which = 5
+	CLIENTMESSAGE_CANCEL              ClientMessage_Which = 6
+)
+
+func NewClientMessage(s *C.Segment) ClientMessage      { return ClientMessage(s.NewStruct(8, 1)) }
+func NewRootClientMessage(s *C.Segment) ClientMessage  { return ClientMessage(s.NewRootStruct(8, 1)) }
+func AutoNewClientMessage(s *C.Segment) ClientMessage  { return ClientMessage(s.NewStructAR(8, 1)) }
+func ReadRootClientMessage(s *C.Segment) ClientMessage { return ClientMessage(s.Root(0).ToStruct()) }
+func (s ClientMessage) Which() ClientMessage_Which     { return ClientMessage_Which(C.Struct(s).Get16(0)) }
+func (s ClientMessage) SetHeartbeat()                  { C.Struct(s).Set16(0, 0) }
+func (s ClientMessage) ClientTxnSubmission() cmsgs.ClientTxn {
+	return cmsgs.ClientTxn(C.Struct(s).GetObject(0).ToStruct())
+}
+func (s ClientMessage) SetClientTxnSubmission(v cmsgs.ClientTxn) {
+	C.Struct(s).Set16(0, 1)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s ClientMessage) ClientTxnOutcome() cmsgs.ClientTxnOutcome {
+	return cmsgs.ClientTxnOutcome(C.Struct(s).GetObject(0).ToStruct())
+}
+func (s ClientMessage) SetClientTxnOutcome(v cmsgs.ClientTxnOutcome) {
+	C.Struct(s).Set16(0, 2)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s ClientMessage) Watch() ClientWatch { return ClientWatch(C.Struct(s).GetObject(0).ToStruct()) }
+func (s ClientMessage) SetWatch(v ClientWatch) {
+	C.Struct(s).Set16(0, 3)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s ClientMessage) Unwatch() []byte { return C.Struct(s).GetObject(0).ToData() }
+func (s ClientMessage) SetUnwatch(v []byte) {
+	C.Struct(s).Set16(0, 4)
+	C.Struct(s).SetObject(0, s.Segment.NewData(v))
+}
+func (s ClientMessage) DeadlineSubmission() ClientTxnDeadline {
+	return ClientTxnDeadline(C.Struct(s).GetObject(0).ToStruct())
+}
+func (s ClientMessage) SetDeadlineSubmission(v ClientTxnDeadline) {
+	C.Struct(s).Set16(0, 5)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s ClientMessage) Cancel() []byte { return C.Struct(s).GetObject(0).ToData() }
+func (s ClientMessage) SetCancel(v []byte) {
+	C.Struct(s).Set16(0, 6)
+	C.Struct(s).SetObject(0, s.Segment.NewData(v))
+}
+func (s ClientMessage) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	if s.Which() == CLIENTMESSAGE_HEARTBEAT {
+		_, err = b.WriteString("\"heartbeat\":")
+		if err != nil {
+			return err
+		}
+		_ = s
+		_, err = b.WriteString("null")
+		if err != nil {
+			return err
+		}
+	}
+	if s.Which() == CLIENTMESSAGE_CLIENTTXNSUBMISSION {
+		_, err = b.WriteString("\"clientTxnSubmission\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.ClientTxnSubmission()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == CLIENTMESSAGE_CLIENTTXNOUTCOME {
+		_, err = b.WriteString("\"clientTxnOutcome\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.ClientTxnOutcome()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == CLIENTMESSAGE_WATCH {
+		_, err = b.WriteString("\"watch\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.Watch()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == CLIENTMESSAGE_UNWATCH {
+		_, err = b.WriteString("\"unwatch\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.Unwatch()
+			buf, err = json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			_, err = b.Write(buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == CLIENTMESSAGE_DEADLINESUBMISSION {
+		_, err = b.WriteString("\"deadlineSubmission\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.DeadlineSubmission()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == CLIENTMESSAGE_CANCEL {
+		_, err = b.WriteString("\"cancel\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.Cancel()
+			buf, err = json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			_, err = b.Write(buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ClientMessage) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s ClientMessage) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	if s.Which() == CLIENTMESSAGE_HEARTBEAT {
+		_, err = b.WriteString("heartbeat = ")
+		if err != nil {
+			return err
+		}
+		_ = s
+		_, err = b.WriteString("null")
+		if err != nil {
+			return err
+		}
+	}
+	if s.Which() == CLIENTMESSAGE_CLIENTTXNSUBMISSION {
+		_, err = b.WriteString("clientTxnSubmission = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.ClientTxnSubmission()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == CLIENTMESSAGE_CLIENTTXNOUTCOME {
+		_, err = b.WriteString("clientTxnOutcome = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.ClientTxnOutcome()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == CLIENTMESSAGE_WATCH {
+		_, err = b.WriteString("watch = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.Watch()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == CLIENTMESSAGE_UNWATCH {
+		_, err = b.WriteString("unwatch = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.Unwatch()
+			buf, err = json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			_, err = b.Write(buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == CLIENTMESSAGE_DEADLINESUBMISSION {
+		_, err = b.WriteString("deadlineSubmission = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.DeadlineSubmission()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == CLIENTMESSAGE_CANCEL {
+		_, err = b.WriteString("cancel = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.Cancel()
+			buf, err = json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			_, err = b.Write(buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ClientMessage) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type ClientMessage_List C.PointerList
+
+func NewClientMessageList(s *C.Segment, sz int) ClientMessage_List {
+	return ClientMessage_List(s.NewCompositeList(8, 1, sz))
+}
+func (s ClientMessage_List) Len() int { return C.PointerList(s).Len() }
+func (s ClientMessage_List) At(i int) ClientMessage {
+	return ClientMessage(C.PointerList(s).At(i).ToStruct())
+}
+func (s ClientMessage_List) ToArray() []ClientMessage {
+	n := s.Len()
+	a := make([]ClientMessage, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s ClientMessage_List) Set(i int, item ClientMessage) { C.PointerList(s).Set(i, C.Object(item)) }
+
+type ClientWatch C.Struct
+
+func NewClientWatch(s *C.Segment) ClientWatch      { return ClientWatch(s.NewStruct(0, 2)) }
+func NewRootClientWatch(s *C.Segment) ClientWatch  { return ClientWatch(s.NewRootStruct(0, 2)) }
+func AutoNewClientWatch(s *C.Segment) ClientWatch  { return ClientWatch(s.NewStructAR(0, 2)) }
+func ReadRootClientWatch(s *C.Segment) ClientWatch { return ClientWatch(s.Root(0).ToStruct()) }
+func (s ClientWatch) Id() []byte                   { return C.Struct(s).GetObject(0).ToData() }
+func (s ClientWatch) SetId(v []byte)               { C.Struct(s).SetObject(0, s.Segment.NewData(v)) }
+func (s ClientWatch) VarIds() C.DataList           { return C.DataList(C.Struct(s).GetObject(1)) }
+func (s ClientWatch) SetVarIds(v C.DataList)       { C.Struct(s).SetObject(1, C.Object(v)) }
+func (s ClientWatch) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"id\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Id()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"varIds\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.VarIds()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ClientWatch) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s ClientWatch) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("id = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Id()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("varIds = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.VarIds()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ClientWatch) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type ClientWatch_List C.PointerList
+
+func NewClientWatchList(s *C.Segment, sz int) ClientWatch_List {
+	return ClientWatch_List(s.NewCompositeList(0, 2, sz))
+}
+func (s ClientWatch_List) Len() int { return C.PointerList(s).Len() }
+func (s ClientWatch_List) At(i int) ClientWatch {
+	return ClientWatch(C.PointerList(s).At(i).ToStruct())
+}
+func (s ClientWatch_List) ToArray() []ClientWatch {
+	n := s.Len()
+	a := make([]ClientWatch, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s ClientWatch_List) Set(i int, item ClientWatch) { C.PointerList(s).Set(i, C.Object(item)) }
+
+type ClientTxnDeadline C.Struct
+
+func NewClientTxnDeadline(s *C.Segment) ClientTxnDeadline {
+	return ClientTxnDeadline(s.NewStruct(8, 1))
+}
+func NewRootClientTxnDeadline(s *C.Segment) ClientTxnDeadline {
+	return ClientTxnDeadline(s.NewRootStruct(8, 1))
+}
+func AutoNewClientTxnDeadline(s *C.Segment) ClientTxnDeadline {
+	return ClientTxnDeadline(s.NewStructAR(8, 1))
+}
+func ReadRootClientTxnDeadline(s *C.Segment) ClientTxnDeadline {
+	return ClientTxnDeadline(s.Root(0).ToStruct())
+}
+func (s ClientTxnDeadline) Txn() cmsgs.ClientTxn {
+	return cmsgs.ClientTxn(C.Struct(s).GetObject(0).ToStruct())
+}
+func (s ClientTxnDeadline) SetTxn(v cmsgs.ClientTxn) {
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s ClientTxnDeadline) Timeout() uint64     { return C.Struct(s).Get64(0) }
+func (s ClientTxnDeadline) SetTimeout(v uint64) { C.Struct(s).Set64(0, v) }
+func (s ClientTxnDeadline) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"txn\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Txn()
+		err = s.WriteJSON(b)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"timeout\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Timeout()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ClientTxnDeadline) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s ClientTxnDeadline) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("txn = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Txn()
+		err = s.WriteCapLit(b)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("timeout = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Timeout()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ClientTxnDeadline) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type ClientTxnDeadline_List C.PointerList
+
+func NewClientTxnDeadlineList(s *C.Segment, sz int) ClientTxnDeadline_List {
+	return ClientTxnDeadline_List(s.NewCompositeList(8, 1, sz))
+}
+func (s ClientTxnDeadline_List) Len() int { return C.PointerList(s).Len() }
+func (s ClientTxnDeadline_List) At(i int) ClientTxnDeadline {
+	return ClientTxnDeadline(C.PointerList(s).At(i).ToStruct())
+}
+func (s ClientTxnDeadline_List) ToArray() []ClientTxnDeadline {
+	n := s.Len()
+	a := make([]ClientTxnDeadline, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s ClientTxnDeadline_List) Set(i int, item ClientTxnDeadline) {
+	C.PointerList(s).Set(i, C.Object(item))
+}
@@ -19,9 +19,9 @@ const (
 	CONFIGURATION_STABLE          Configuration_Which = 1
 )
 
-func NewConfiguration(s *C.Segment) Configuration      { return Configuration(s.NewStruct(16, 13)) }
-func NewRootConfiguration(s *C.Segment) Configuration  { return Configuration(s.NewRootStruct(16, 13)) }
-func AutoNewConfiguration(s *C.Segment) Configuration  { return Configuration(s.NewStructAR(16, 13)) }
+func NewConfiguration(s *C.Segment) Configuration      { return Configuration(s.NewStruct(16, 17)) }
+func NewRootConfiguration(s *C.Segment) Configuration  { return Configuration(s.NewRootStruct(16, 17)) }
+func AutoNewConfiguration(s *C.Segment) Configuration  { return Configuration(s.NewStructAR(16, 17)) }
 func ReadRootConfiguration(s *C.Segment) Configuration { return Configuration(s.Root(0).ToStruct()) }
 func (s Configuration) Which() Configuration_Which     { return Configuration_Which(C.Struct(s).Get16(8)) }
 func (s Configuration) ClusterId() string              { return C.Struct(s).GetObject(0).ToText() }
@@ -43,6 +43,16 @@ func (s Configuration) RmsRemoved() C.UInt32List       { return C.UInt32List(C.S
 func (s Configuration) SetRmsRemoved(v C.UInt32List)   { C.Struct(s).SetObject(3, C.Object(v)) }
 func (s Configuration) Fingerprints() C.DataList       { return C.DataList(C.Struct(s).GetObject(4)) }
 func (s Configuration) SetFingerprints(v C.DataList)   { C.Struct(s).SetObject(4, C.Object(v)) }
+func (s Configuration) HistoryRetentionSeconds() uint32 { return C.Struct(s).Get32(12) }
+func (s Configuration) SetHistoryRetentionSeconds(v uint32) { C.Struct(s).Set32(12, v) }
+func (s Configuration) Zones() C.TextList                    { return C.TextList(C.Struct(s).GetObject(13)) }
+func (s Configuration) SetZones(v C.TextList)                { C.Struct(s).SetObject(13, C.Object(v)) }
+func (s Configuration) Weights() C.UInt16List                 { return C.UInt16List(C.Struct(s).GetObject(14)) }
+func (s Configuration) SetWeights(v C.UInt16List)             { C.Struct(s).SetObject(14, C.Object(v)) }
+func (s Configuration) ClientRoles() ClientRole_List           { return ClientRole_List(C.Struct(s).GetObject(15)) }
+func (s Configuration) SetClientRoles(v ClientRole_List)       { C.Struct(s).SetObject(15, C.Object(v)) }
+func (s Configuration) RootNames() C.TextList                { return C.TextList(C.Struct(s).GetObject(16)) }
+func (s Configuration) SetRootNames(v C.TextList)            { C.Struct(s).SetObject(16, C.Object(v)) }
 func (s Configuration) TransitioningTo() ConfigurationTransitioningTo {
 	return ConfigurationTransitioningTo(s)
 }
@@ -344,6 +354,169 @@ func (s Configuration) WriteJSON(w io.Writer) error {
 			return err
 		}
 	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"historyRetentionSeconds\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.HistoryRetentionSeconds()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"zones\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Zones()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"weights\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Weights()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"clientRoles\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ClientRoles()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteJSON(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"rootNames\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.RootNames()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
 	if s.Which() == CONFIGURATION_TRANSITIONINGTO {
 		_, err = b.WriteString("\"transitioningTo\":")
 		if err != nil {
@@ -917,6 +1090,169 @@ func (s Configuration) WriteCapLit(w io.Writer) error {
 			return err
 		}
 	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("historyRetentionSeconds = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.HistoryRetentionSeconds()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("zones = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Zones()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("weights = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Weights()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("clientRoles = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ClientRoles()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteCapLit(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("rootNames = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.RootNames()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
 	if s.Which() == CONFIGURATION_TRANSITIONINGTO {
 		_, err = b.WriteString("transitioningTo = ")
 		if err != nil {
@@ -1246,7 +1582,7 @@ func (s Configuration) MarshalCapLit() ([]byte, error) {
 type Configuration_List C.PointerList
 
 func NewConfigurationList(s *C.Segment, sz int) Configuration_List {
-	return Configuration_List(s.NewCompositeList(16, 13, sz))
+	return Configuration_List(s.NewCompositeList(16, 17, sz))
 }
 func (s Configuration_List) Len() int { return C.PointerList(s).Len() }
 func (s Configuration_List) At(i int) Configuration {
@@ -1470,6 +1806,222 @@ func (s ConditionPair_List) ToArray() []ConditionPair {
 }
 func (s ConditionPair_List) Set(i int, item ConditionPair) { C.PointerList(s).Set(i, C.Object(item)) }
 
+type ClientRole C.Struct
+
+func NewClientRole(s *C.Segment) ClientRole      { return ClientRole(s.NewStruct(8, 2)) }
+func NewRootClientRole(s *C.Segment) ClientRole  { return ClientRole(s.NewRootStruct(8, 2)) }
+func AutoNewClientRole(s *C.Segment) ClientRole  { return ClientRole(s.NewStructAR(8, 2)) }
+func ReadRootClientRole(s *C.Segment) ClientRole { return ClientRole(s.Root(0).ToStruct()) }
+func (s ClientRole) Fingerprint() []byte         { return C.Struct(s).GetObject(0).ToData() }
+func (s ClientRole) SetFingerprint(v []byte)     { C.Struct(s).SetObject(0, s.Segment.NewData(v)) }
+func (s ClientRole) ReadOnly() bool              { return C.Struct(s).Get1(0) }
+func (s ClientRole) SetReadOnly(v bool)          { C.Struct(s).Set1(0, v) }
+func (s ClientRole) Roots() C.TextList           { return C.TextList(C.Struct(s).GetObject(1)) }
+func (s ClientRole) SetRoots(v C.TextList)       { C.Struct(s).SetObject(1, C.Object(v)) }
+func (s ClientRole) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"fingerprint\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Fingerprint()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"readOnly\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ReadOnly()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"roots\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Roots()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ClientRole) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s ClientRole) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("fingerprint = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Fingerprint()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("readOnly = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ReadOnly()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("roots = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Roots()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s ClientRole) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type ClientRole_List C.PointerList
+
+func NewClientRoleList(s *C.Segment, sz int) ClientRole_List {
+	return ClientRole_List(s.NewCompositeList(8, 2, sz))
+}
+func (s ClientRole_List) Len() int { return C.PointerList(s).Len() }
+func (s ClientRole_List) At(i int) ClientRole {
+	return ClientRole(C.PointerList(s).At(i).ToStruct())
+}
+func (s ClientRole_List) ToArray() []ClientRole {
+	n := s.Len()
+	a := make([]ClientRole, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s ClientRole_List) Set(i int, item ClientRole) { C.PointerList(s).Set(i, C.Object(item)) }
+
 type Condition C.Struct
 type Condition_Which uint16
 
@@ -288,12 +288,12 @@ func (s MigrationComplete_List) Set(i int, item MigrationComplete) {
 
 type MigrationElement C.Struct
 
-func NewMigrationElement(s *C.Segment) MigrationElement { return MigrationElement(s.NewStruct(0, 2)) }
+func NewMigrationElement(s *C.Segment) MigrationElement { return MigrationElement(s.NewStruct(0, 3)) }
 func NewRootMigrationElement(s *C.Segment) MigrationElement {
-	return MigrationElement(s.NewRootStruct(0, 2))
+	return MigrationElement(s.NewRootStruct(0, 3))
 }
 func AutoNewMigrationElement(s *C.Segment) MigrationElement {
-	return MigrationElement(s.NewStructAR(0, 2))
+	return MigrationElement(s.NewStructAR(0, 3))
 }
 func ReadRootMigrationElement(s *C.Segment) MigrationElement {
 	return MigrationElement(s.Root(0).ToStruct())
@@ -302,6 +302,10 @@ func (s MigrationElement) Txn() Txn           { return Txn(C.Struct(s).GetObject
 func (s MigrationElement) SetTxn(v Txn)       { C.Struct(s).SetObject(0, C.Object(v)) }
 func (s MigrationElement) Vars() Var_List     { return Var_List(C.Struct(s).GetObject(1)) }
 func (s MigrationElement) SetVars(v Var_List) { C.Struct(s).SetObject(1, C.Object(v)) }
+func (s MigrationElement) History() MigrationHistory_List {
+	return MigrationHistory_List(C.Struct(s).GetObject(2))
+}
+func (s MigrationElement) SetHistory(v MigrationHistory_List) { C.Struct(s).SetObject(2, C.Object(v)) }
 func (s MigrationElement) WriteJSON(w io.Writer) error {
 	b := bufio.NewWriter(w)
 	var err error
@@ -355,6 +359,39 @@ func (s MigrationElement) WriteJSON(w io.Writer) error {
 			return err
 		}
 	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"history\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.History()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteJSON(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte('}')
 	if err != nil {
 		return err
@@ -420,6 +457,39 @@ func (s MigrationElement) WriteCapLit(w io.Writer) error {
 			return err
 		}
 	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("history = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.History()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				err = s.WriteCapLit(b)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte(')')
 	if err != nil {
 		return err
@@ -436,7 +506,7 @@ func (s MigrationElement) MarshalCapLit() ([]byte, error) {
 type MigrationElement_List C.PointerList
 
 func NewMigrationElementList(s *C.Segment, sz int) MigrationElement_List {
-	return MigrationElement_List(s.NewCompositeList(0, 2, sz))
+	return MigrationElement_List(s.NewCompositeList(0, 3, sz))
 }
 func (s MigrationElement_List) Len() int { return C.PointerList(s).Len() }
 func (s MigrationElement_List) At(i int) MigrationElement {
@@ -453,3 +523,255 @@ func (s MigrationElement_List) ToArray() []MigrationElement {
 func (s MigrationElement_List) Set(i int, item MigrationElement) {
 	C.PointerList(s).Set(i, C.Object(item))
 }
+
+type MigrationHistory C.Struct
+
+func NewMigrationHistory(s *C.Segment) MigrationHistory { return MigrationHistory(s.NewStruct(16, 3)) }
+func NewRootMigrationHistory(s *C.Segment) MigrationHistory {
+	return MigrationHistory(s.NewRootStruct(16, 3))
+}
+func AutoNewMigrationHistory(s *C.Segment) MigrationHistory {
+	return MigrationHistory(s.NewStructAR(16, 3))
+}
+func ReadRootMigrationHistory(s *C.Segment) MigrationHistory {
+	return MigrationHistory(s.Root(0).ToStruct())
+}
+func (s MigrationHistory) VarId() []byte            { return C.Struct(s).GetObject(0).ToData() }
+func (s MigrationHistory) SetVarId(v []byte)        { C.Struct(s).SetObject(0, s.Segment.NewData(v)) }
+func (s MigrationHistory) ClockElem() uint64        { return C.Struct(s).Get64(0) }
+func (s MigrationHistory) SetClockElem(v uint64)    { C.Struct(s).Set64(0, v) }
+func (s MigrationHistory) SupersededAt() uint64     { return C.Struct(s).Get64(8) }
+func (s MigrationHistory) SetSupersededAt(v uint64) { C.Struct(s).Set64(8, v) }
+func (s MigrationHistory) Var() Var                 { return Var(C.Struct(s).GetObject(1).ToStruct()) }
+func (s MigrationHistory) SetVar(v Var)             { C.Struct(s).SetObject(1, C.Object(v)) }
+func (s MigrationHistory) Txn() Txn                 { return Txn(C.Struct(s).GetObject(2).ToStruct()) }
+func (s MigrationHistory) SetTxn(v Txn)             { C.Struct(s).SetObject(2, C.Object(v)) }
+func (s MigrationHistory) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"varId\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.VarId()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"clockElem\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ClockElem()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"supersededAt\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.SupersededAt()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"var\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Var()
+		err = s.WriteJSON(b)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"txn\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Txn()
+		err = s.WriteJSON(b)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s MigrationHistory) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s MigrationHistory) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("varId = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.VarId()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("clockElem = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ClockElem()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("supersededAt = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.SupersededAt()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("var = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Var()
+		err = s.WriteCapLit(b)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("txn = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Txn()
+		err = s.WriteCapLit(b)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s MigrationHistory) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type MigrationHistory_List C.PointerList
+
+func NewMigrationHistoryList(s *C.Segment, sz int) MigrationHistory_List {
+	return MigrationHistory_List(s.NewCompositeList(16, 3, sz))
+}
+func (s MigrationHistory_List) Len() int { return C.PointerList(s).Len() }
+func (s MigrationHistory_List) At(i int) MigrationHistory {
+	return MigrationHistory(C.PointerList(s).At(i).ToStruct())
+}
+func (s MigrationHistory_List) ToArray() []MigrationHistory {
+	n := s.Len()
+	a := make([]MigrationHistory, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s MigrationHistory_List) Set(i int, item MigrationHistory) {
+	C.PointerList(s).Set(i, C.Object(item))
+}
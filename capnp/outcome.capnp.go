@@ -343,9 +343,9 @@ func (s Outcome_List) Set(i int, item Outcome) { C.PointerList(s).Set(i, C.Objec
 
 type Update C.Struct
 
-func NewUpdate(s *C.Segment) Update       { return Update(s.NewStruct(0, 3)) }
-func NewRootUpdate(s *C.Segment) Update   { return Update(s.NewRootStruct(0, 3)) }
-func AutoNewUpdate(s *C.Segment) Update   { return Update(s.NewStructAR(0, 3)) }
+func NewUpdate(s *C.Segment) Update       { return Update(s.NewStruct(8, 3)) }
+func NewRootUpdate(s *C.Segment) Update   { return Update(s.NewRootStruct(8, 3)) }
+func AutoNewUpdate(s *C.Segment) Update   { return Update(s.NewStructAR(8, 3)) }
 func ReadRootUpdate(s *C.Segment) Update  { return Update(s.Root(0).ToStruct()) }
 func (s Update) TxnId() []byte            { return C.Struct(s).GetObject(0).ToData() }
 func (s Update) SetTxnId(v []byte)        { C.Struct(s).SetObject(0, s.Segment.NewData(v)) }
@@ -353,6 +353,8 @@ func (s Update) Actions() Action_List     { return Action_List(C.Struct(s).GetOb
 func (s Update) SetActions(v Action_List) { C.Struct(s).SetObject(1, C.Object(v)) }
 func (s Update) Clock() VectorClock       { return VectorClock(C.Struct(s).GetObject(2).ToStruct()) }
 func (s Update) SetClock(v VectorClock)   { C.Struct(s).SetObject(2, C.Object(v)) }
+func (s Update) Collected() bool          { return C.Struct(s).Get1(0) }
+func (s Update) SetCollected(v bool)      { C.Struct(s).Set1(0, v) }
 func (s Update) WriteJSON(w io.Writer) error {
 	b := bufio.NewWriter(w)
 	var err error
@@ -425,6 +427,25 @@ func (s Update) WriteJSON(w io.Writer) error {
 			return err
 		}
 	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"collected\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Collected()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte('}')
 	if err != nil {
 		return err
@@ -509,6 +530,25 @@ func (s Update) WriteCapLit(w io.Writer) error {
 			return err
 		}
 	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("collected = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Collected()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte(')')
 	if err != nil {
 		return err
@@ -524,7 +564,7 @@ func (s Update) MarshalCapLit() ([]byte, error) {
 
 type Update_List C.PointerList
 
-func NewUpdateList(s *C.Segment, sz int) Update_List { return Update_List(s.NewCompositeList(0, 3, sz)) }
+func NewUpdateList(s *C.Segment, sz int) Update_List { return Update_List(s.NewCompositeList(8, 3, sz)) }
 func (s Update_List) Len() int                       { return C.PointerList(s).Len() }
 func (s Update_List) At(i int) Update                { return Update(C.PointerList(s).At(i).ToStruct()) }
 func (s Update_List) ToArray() []Update {
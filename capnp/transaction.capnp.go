@@ -32,6 +32,10 @@ func (s Txn) FInc() uint8                      { return C.Struct(s).Get8(9) }
 func (s Txn) SetFInc(v uint8)                  { C.Struct(s).Set8(9, v) }
 func (s Txn) TopologyVersion() uint32          { return C.Struct(s).Get32(12) }
 func (s Txn) SetTopologyVersion(v uint32)      { C.Struct(s).Set32(12, v) }
+func (s Txn) Collect() bool                    { return C.Struct(s).Get1(65) }
+func (s Txn) SetCollect(v bool)                { C.Struct(s).Set1(65, v) }
+func (s Txn) ReadOnly() bool                   { return C.Struct(s).Get1(66) }
+func (s Txn) SetReadOnly(v bool)               { C.Struct(s).Set1(66, v) }
 func (s Txn) WriteJSON(w io.Writer) error {
 	b := bufio.NewWriter(w)
 	var err error
@@ -217,6 +221,44 @@ func (s Txn) WriteJSON(w io.Writer) error {
 			return err
 		}
 	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"collect\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Collect()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"readOnly\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ReadOnly()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte('}')
 	if err != nil {
 		return err
@@ -414,6 +456,44 @@ func (s Txn) WriteCapLit(w io.Writer) error {
 			return err
 		}
 	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("collect = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Collect()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("readOnly = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ReadOnly()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte(')')
 	if err != nil {
 		return err
@@ -2,41 +2,106 @@ package client
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
 	"goshawkdb.io/common"
 	cmsgs "goshawkdb.io/common/capnp"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
 	"goshawkdb.io/server/paxos"
 	"time"
 )
 
+var TxnDeadlineExceeded = errors.New("TxnDeadlineExceeded")
+var TxnCancelled = errors.New("TxnCancelled")
+
 type ClientTxnCompletionConsumer func(*cmsgs.ClientTxnOutcome, error)
 
 type ClientTxnSubmitter struct {
 	*SimpleTxnSubmitter
+	access       *configuration.ClientAccess
 	versionCache versionCache
-	txnLive      bool
+	liveTxns     map[common.TxnId]*liveClientTxn
+	watches      map[common.TxnId]*clientWatch
+}
+
+type liveClientTxn struct {
+	curTxnId  *common.TxnId
+	cancelled error
+	finish    ClientTxnCompletionConsumer
 }
 
-func NewClientTxnSubmitter(rmId common.RMId, bootCount uint32, cm paxos.ConnectionManager) *ClientTxnSubmitter {
+func NewClientTxnSubmitter(rmId common.RMId, bootCount uint32, cm paxos.ConnectionManager, access *configuration.ClientAccess) *ClientTxnSubmitter {
 	return &ClientTxnSubmitter{
 		SimpleTxnSubmitter: NewSimpleTxnSubmitter(rmId, bootCount, cm),
+		access:             access,
 		versionCache:       NewVersionCache(),
-		txnLive:            false,
+		liveTxns:           make(map[common.TxnId]*liveClientTxn),
+		watches:            make(map[common.TxnId]*clientWatch),
 	}
 }
 
 func (cts *ClientTxnSubmitter) Status(sc *server.StatusConsumer) {
-	sc.Emit(fmt.Sprintf("ClientTxnSubmitter: txnLive? %v", cts.txnLive))
+	sc.Emit("ClientTxnSubmitter")
+	sc.EmitKV("access", cts.access)
+	sc.EmitKV("liveTxns", len(cts.liveTxns))
+	sc.EmitKV("watches", len(cts.watches))
 	cts.SimpleTxnSubmitter.Status(sc.Fork())
 	sc.Join()
 }
 
-func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn, continuation ClientTxnCompletionConsumer) {
-	if cts.txnLive {
-		continuation(nil, fmt.Errorf("Cannot submit client as a live txn already exists"))
+func (cts *ClientTxnSubmitter) Shutdown() {
+	for _, cw := range cts.watches {
+		cw.remove()
+	}
+	cts.SimpleTxnSubmitter.Shutdown()
+}
+
+// SetAccess replaces the rights of the client, as they may change
+// with the topology. Txns already submitted are unaffected.
+func (cts *ClientTxnSubmitter) SetAccess(access *configuration.ClientAccess) {
+	cts.access = access
+}
+
+// TopologyChanged makes the roots which the client is permitted to
+// reach available to it. Whilst the topology is changing, watches
+// are parked so that they do not hold up the change.
+func (cts *ClientTxnSubmitter) TopologyChanged(topology *configuration.Topology) {
+	if !cts.setTopology(topology) {
+		return
+	}
+	for name, root := range topology.AllRoots() {
+		if cts.access.PermitsRoot(name) {
+			cts.hashCache.AddPosition(root.VarUUId, root.Positions)
+		}
+	}
+	if topology.Next() != nil {
+		for _, cw := range cts.watches {
+			cw.park()
+		}
+	}
+	cts.calculateDisabledHashcodes()
+}
+
+// SubmitClientTransaction resubmits the txn for as long as it is
+// aborted with updates the client has already seen. If deadline is
+// not zero, we give up with TxnDeadlineExceeded rather than resubmit
+// after the deadline.
+func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn, deadline time.Time, continuation ClientTxnCompletionConsumer) {
+	// Several txns may be in flight at once. They are keyed by the
+	// id the client gave them, which is the id of the outcome.
+	origTxnId := common.MakeTxnId(ctxnCap.Id())
+	if _, found := cts.liveTxns[*origTxnId]; found {
+		continuation(nil, fmt.Errorf("Cannot submit client txn %v as it is already live", origTxnId))
+		return
+	} else if cts.txnIdInUse(origTxnId) {
+		continuation(nil, fmt.Errorf("Cannot submit client txn %v as its id is in use by a resubmission", origTxnId))
+		return
+	} else if cts.access.ReadOnly && hasWrites(ctxnCap) {
+		clientTxnsDenied.Inc()
+		continuation(nil, fmt.Errorf("Cannot submit client txn %v as the client is read-only", origTxnId))
 		return
 	}
 
@@ -45,6 +110,21 @@ func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn,
 	clientOutcome.SetId(ctxnCap.Id())
 
 	curTxnId := common.MakeTxnId(ctxnCap.Id())
+	reads := readVersions(ctxnCap)
+	readOnly := isReadOnly(ctxnCap)
+	cache := cts.versionCache.View()
+	live := &liveClientTxn{curTxnId: curTxnId}
+	finish := func(clientOutcome *cmsgs.ClientTxnOutcome, err error) {
+		delete(cts.liveTxns, *origTxnId)
+		switch err {
+		case TxnDeadlineExceeded:
+			clientTxnsDeadlineExceeded.Inc()
+		case TxnCancelled:
+			clientTxnsCancelled.Inc()
+		}
+		continuation(clientOutcome, err)
+	}
+	live.finish = finish
 
 	delay := time.Duration(0)
 	retryCount := 0
@@ -52,18 +132,17 @@ func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn,
 	var cont TxnCompletionConsumer
 	cont = func(txnId *common.TxnId, outcome *msgs.Outcome, err error) {
 		if outcome == nil || err != nil { // node is shutting down or error
-			cts.txnLive = false
-			continuation(nil, err)
+			finish(nil, err)
 			return
 		}
 		switch outcome.Which() {
 		case msgs.OUTCOME_COMMIT:
-			cts.versionCache.UpdateFromCommit(txnId, outcome)
+			cache.UpdateFromCommit(txnId, outcome)
+			cache.Merge()
 			clientOutcome.SetFinalId(txnId[:])
 			clientOutcome.SetCommit()
 			cts.addCreatesToCache(outcome)
-			cts.txnLive = false
-			continuation(&clientOutcome, nil)
+			finish(&clientOutcome, nil)
 			return
 
 		default:
@@ -71,40 +150,177 @@ func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn,
 			resubmit := abort.Which() == msgs.OUTCOMEABORT_RESUBMIT
 			if !resubmit {
 				updates := abort.Rerun()
-				validUpdates := cts.versionCache.UpdateFromAbort(&updates)
+				if vUUId := collectedVar(&updates, ctxnCap); vUUId != nil {
+					clientTxnsCollected.Inc()
+					finish(nil, fmt.Errorf("Client txn %v uses %v, which has been garbage collected", origTxnId, vUUId))
+					return
+				}
+				validUpdates := cache.UpdateFromAbort(&updates, reads)
 				server.Log("Updates:", updates.Len(), "; valid: ", len(validUpdates))
 				resubmit = len(validUpdates) == 0
 				if !resubmit {
+					cache.Merge()
 					clientOutcome.SetFinalId(txnId[:])
 					clientOutcome.SetAbort(cts.translateUpdates(seg, validUpdates))
-					cts.txnLive = false
-					continuation(&clientOutcome, nil)
+					finish(&clientOutcome, nil)
 					return
 				}
 			}
-			server.Log("Resubmitting", txnId, "; orig resubmit?", abort.Which() == msgs.OUTCOMEABORT_RESUBMIT)
-			retryCount++
-			switch {
-			case retryCount == server.SubmissionInitialAttempts:
-				delay = server.SubmissionInitialBackoff
-			case retryCount > server.SubmissionInitialAttempts:
-				delay = delay + time.Duration(cts.rng.Intn(int(delay)))
-				if delay > server.SubmissionMaxSubmitDelay {
-					delay = time.Duration(cts.rng.Intn(int(server.SubmissionMaxSubmitDelay)))
+			if readOnly {
+				// The fast path failed; fall back to a normal
+				// submission straight away.
+				readOnly = false
+			} else {
+				retryCount++
+				switch {
+				case retryCount == server.SubmissionInitialAttempts:
+					delay = server.SubmissionInitialBackoff
+				case retryCount > server.SubmissionInitialAttempts:
+					delay = delay + time.Duration(cts.rng.Intn(int(delay)))
+					if delay > server.SubmissionMaxSubmitDelay {
+						delay = time.Duration(cts.rng.Intn(int(server.SubmissionMaxSubmitDelay)))
+					}
 				}
 			}
+			if live.cancelled == nil && !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+				live.cancelled = TxnDeadlineExceeded
+			}
+			if live.cancelled != nil {
+				finish(nil, live.cancelled)
+				return
+			}
+			server.Log("Resubmitting", txnId, "; orig resubmit?", abort.Which() == msgs.OUTCOMEABORT_RESUBMIT)
+			clientTxnResubmits.Inc()
 
-			curTxnIdNum := binary.BigEndian.Uint64(txnId[:8])
-			curTxnIdNum += 1 + uint64(cts.rng.Intn(8))
-			binary.BigEndian.PutUint64(curTxnId[:8], curTxnIdNum)
+			cts.bumpTxnId(txnId, curTxnId)
 			ctxnCap.SetId(curTxnId[:])
 
 			cts.SimpleTxnSubmitter.SubmitClientTransaction(ctxnCap, cont, delay, false)
 		}
 	}
 
-	cts.txnLive = true
-	cts.SimpleTxnSubmitter.SubmitClientTransaction(ctxnCap, cont, 0, false)
+	cts.liveTxns[*origTxnId] = live
+	if readOnly {
+		cts.SimpleTxnSubmitter.SubmitReadOnlyTransaction(ctxnCap, cont)
+	} else {
+		cts.SimpleTxnSubmitter.SubmitClientTransaction(ctxnCap, cont, 0, false)
+	}
+}
+
+// CancelClientTransaction stops the client txn from being
+// resubmitted, and the continuation is given err. If the txn is
+// buffered waiting for a topology change, or is a retry txn, it is
+// abandoned straight away. Otherwise it is in flight and cannot be
+// withdrawn: we must wait for its outcome, and if it commits, that
+// is what the client is told.
+func (cts *ClientTxnSubmitter) CancelClientTransaction(origTxnId *common.TxnId, err error) {
+	live, found := cts.liveTxns[*origTxnId]
+	if !found || live.cancelled != nil {
+		return
+	}
+	live.cancelled = err
+	if cts.CancelRetry(live.curTxnId) {
+		live.finish(nil, err)
+	}
+}
+
+// bumpTxnId sets next to a txn id a little after prev. With several
+// txns in flight, we must not pick an id which is already in use by
+// another of them.
+func (cts *ClientTxnSubmitter) bumpTxnId(prev, next *common.TxnId) {
+	curTxnIdNum := binary.BigEndian.Uint64(prev[:8])
+	for {
+		curTxnIdNum += 1 + uint64(cts.rng.Intn(8))
+		binary.BigEndian.PutUint64(next[:8], curTxnIdNum)
+		if !cts.txnIdInUse(next) {
+			return
+		}
+	}
+}
+
+func (cts *ClientTxnSubmitter) txnIdInUse(txnId *common.TxnId) bool {
+	if _, found := cts.outcomeConsumers[*txnId]; found {
+		return true
+	} else if _, found := cts.buffered[*txnId]; found {
+		return true
+	} else if _, found := cts.watches[*txnId]; found {
+		return true
+	}
+	_, found := cts.liveTxns[*txnId]
+	return found
+}
+
+// collectedVar returns a var of the client txn which the updates
+// show has been garbage collected, if there is one.
+func collectedVar(updates *msgs.Update_List, ctxnCap *cmsgs.ClientTxn) *common.VarUUId {
+	var collected map[common.VarUUId]server.EmptyStruct
+	for idx, l := 0, updates.Len(); idx < l; idx++ {
+		update := updates.At(idx)
+		if !update.Collected() {
+			continue
+		}
+		actions := update.Actions()
+		if collected == nil {
+			collected = make(map[common.VarUUId]server.EmptyStruct, actions.Len())
+		}
+		for idy, m := 0, actions.Len(); idy < m; idy++ {
+			collected[*common.MakeVarUUId(actions.At(idy).VarId())] = server.EmptyStructVal
+		}
+	}
+	if collected == nil {
+		return nil
+	}
+	actions := ctxnCap.Actions()
+	for idx, l := 0, actions.Len(); idx < l; idx++ {
+		vUUId := common.MakeVarUUId(actions.At(idx).VarId())
+		if _, found := collected[*vUUId]; found {
+			return vUUId
+		}
+	}
+	return nil
+}
+
+// isReadOnly is true if the txn contains only reads and is not a
+// retry txn.
+func isReadOnly(ctxnCap *cmsgs.ClientTxn) bool {
+	if ctxnCap.Retry() {
+		return false
+	}
+	actions := ctxnCap.Actions()
+	for idx, l := 0, actions.Len(); idx < l; idx++ {
+		if actions.At(idx).Which() != cmsgs.CLIENTACTION_READ {
+			return false
+		}
+	}
+	return actions.Len() != 0
+}
+
+// hasWrites is true if the txn writes, creates or rolls any var.
+func hasWrites(ctxnCap *cmsgs.ClientTxn) bool {
+	actions := ctxnCap.Actions()
+	for idx, l := 0, actions.Len(); idx < l; idx++ {
+		if actions.At(idx).Which() != cmsgs.CLIENTACTION_READ {
+			return true
+		}
+	}
+	return false
+}
+
+// readVersions returns the versions at which the client txn reads
+// each var.
+func readVersions(ctxnCap *cmsgs.ClientTxn) map[common.VarUUId]*common.TxnId {
+	reads := make(map[common.VarUUId]*common.TxnId)
+	actions := ctxnCap.Actions()
+	for idx, l := 0, actions.Len(); idx < l; idx++ {
+		action := actions.At(idx)
+		switch action.Which() {
+		case cmsgs.CLIENTACTION_READ:
+			reads[*common.MakeVarUUId(action.VarId())] = common.MakeTxnId(action.Read().Version())
+		case cmsgs.CLIENTACTION_READWRITE:
+			reads[*common.MakeVarUUId(action.VarId())] = common.MakeTxnId(action.Readwrite().Version())
+		}
+	}
+	return reads
 }
 
 func (cts *ClientTxnSubmitter) addCreatesToCache(outcome *msgs.Outcome) {
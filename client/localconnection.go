@@ -89,6 +89,8 @@ type localConnectionMsgRunClientTxn struct {
 	txn         *cmsgs.ClientTxn
 	varPosMap   map[common.VarUUId]*common.Positions
 	assignTxnId bool
+	collect     bool
+	readOnly    bool
 	outcome     *msgs.Outcome
 }
 
@@ -198,6 +200,45 @@ func (lc *LocalConnection) RunClientTransaction(txn *cmsgs.ClientTxn, varPosMap
 	}
 }
 
+// RunCollectTransaction is as RunClientTransaction, but every var
+// written to by the txn is removed from disk once the txn
+// commits. It must only be used for vars which are unreachable from
+// the root.
+func (lc *LocalConnection) RunCollectTransaction(txn *cmsgs.ClientTxn, varPosMap map[common.VarUUId]*common.Positions) (*msgs.Outcome, error) {
+	query := &localConnectionMsgRunClientTxn{
+		txn:         txn,
+		varPosMap:   varPosMap,
+		assignTxnId: true,
+		collect:     true,
+	}
+	query.init()
+	if lc.enqueueQuerySync(query, query.resultChan) {
+		return query.outcome, query.err
+	} else {
+		return nil, nil
+	}
+}
+
+// RunReadOnlyTransaction is as RunClientTransaction, but the txn,
+// which must contain only reads, is submitted as a read-only
+// txn. Unlike for clients, there is no fall back to a normal
+// submission: if any of the active RMs can't confirm the reads, the
+// outcome is an abort resubmit.
+func (lc *LocalConnection) RunReadOnlyTransaction(txn *cmsgs.ClientTxn, varPosMap map[common.VarUUId]*common.Positions) (*msgs.Outcome, error) {
+	query := &localConnectionMsgRunClientTxn{
+		txn:         txn,
+		varPosMap:   varPosMap,
+		assignTxnId: true,
+		readOnly:    true,
+	}
+	query.init()
+	if lc.enqueueQuerySync(query, query.resultChan) {
+		return query.outcome, query.err
+	} else {
+		return nil, nil
+	}
+}
+
 func (lc *LocalConnection) RunTransaction(txn *msgs.Txn, assignTxnId bool, activeRMs ...common.RMId) (*msgs.Outcome, error) {
 	query := &localConnectionMsgRunTxn{
 		txn:         txn,
@@ -320,7 +361,13 @@ func (lc *LocalConnection) runClientTransaction(txnQuery *localConnectionMsgRunC
 	if varPosMap := txnQuery.varPosMap; varPosMap != nil {
 		lc.submitter.EnsurePositions(varPosMap)
 	}
-	lc.submitter.SubmitClientTransaction(txn, txnQuery.consumer, 0, true)
+	if txnQuery.collect {
+		lc.submitter.SubmitCollectTransaction(txn, txnQuery.consumer)
+	} else if txnQuery.readOnly {
+		lc.submitter.SubmitReadOnlyTransaction(txn, txnQuery.consumer)
+	} else {
+		lc.submitter.SubmitClientTransaction(txn, txnQuery.consumer, 0, true)
+	}
 }
 
 func (lc *LocalConnection) runTransaction(txnQuery *localConnectionMsgRunTxn) {
@@ -0,0 +1,19 @@
+package client
+
+import (
+	"goshawkdb.io/server"
+)
+
+var (
+	outcomesCommit             = server.Metrics.Counter("goshawkdb_txn_outcomes_total", "Outcomes of txns submitted from this node.", "outcome", "commit")
+	outcomesAbortResubmit      = server.Metrics.Counter("goshawkdb_txn_outcomes_total", "Outcomes of txns submitted from this node.", "outcome", "abort_resubmit")
+	outcomesAbortRerun         = server.Metrics.Counter("goshawkdb_txn_outcomes_total", "Outcomes of txns submitted from this node.", "outcome", "abort_rerun")
+	clientTxnResubmits         = server.Metrics.Counter("goshawkdb_client_txn_resubmits_total", "Client txns resubmitted by the server.")
+	readOnlyTxnsCommit         = server.Metrics.Counter("goshawkdb_read_only_txns_total", "Read-only txns submitted from this node.", "outcome", "commit")
+	readOnlyTxnsFallback       = server.Metrics.Counter("goshawkdb_read_only_txns_total", "Read-only txns submitted from this node.", "outcome", "fallback")
+	clientTxnsDenied           = server.Metrics.Counter("goshawkdb_client_txns_denied_total", "Client txns rejected as the client may not write.")
+	clientTxnsCollected        = server.Metrics.Counter("goshawkdb_client_txns_collected_total", "Client txns failed as they used a garbage collected var.")
+	clientWatches              = server.Metrics.Gauge("goshawkdb_client_watches", "Watches currently registered by clients.")
+	clientTxnsDeadlineExceeded = server.Metrics.Counter("goshawkdb_client_txns_abandoned_total", "Client txns abandoned before completion.", "reason", "deadline")
+	clientTxnsCancelled        = server.Metrics.Counter("goshawkdb_client_txns_abandoned_total", "Client txns abandoned before completion.", "reason", "cancelled")
+)
@@ -0,0 +1,93 @@
+package client
+
+import (
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/paxos"
+	eng "goshawkdb.io/server/txnengine"
+)
+
+// SubmitReadOnlyTransaction submits a txn which contains only reads
+// without going through paxos: each active RM checks the reads of
+// its vars and we commit only if they all agree. Any abort is a
+// resubmit, and the txn should then be submitted normally, which
+// will find the updates if any of the reads are bad. A commit carries
+// the clocks of every active RM merged together, just as a normal
+// commit carries the clocks of the votes.
+func (sts *SimpleTxnSubmitter) SubmitReadOnlyTransaction(ctxnCap *cmsgs.ClientTxn, continuation TxnCompletionConsumer) {
+	sts.submitClientTransaction(ctxnCap, continuation, 0, false, false, true)
+}
+
+func (sts *SimpleTxnSubmitter) submitReadOnlyTransaction(txnCap *msgs.Txn, activeRMs []common.RMId, continuation TxnCompletionConsumer) {
+	seg := capn.NewBuffer(nil)
+	msg := msgs.NewRootMessage(seg)
+	msg.SetTxnSubmission(*txnCap)
+
+	txnId := common.MakeTxnId(txnCap.Id())
+	server.Log(txnId, "Submitting read-only txn")
+	txnSender := paxos.NewRepeatingSender(server.SegToBytes(seg), activeRMs...)
+	sts.connPub.AddServerConnectionSubscriber(txnSender)
+
+	clock := eng.NewVectorClock()
+	pending := make(map[common.RMId]server.EmptyStruct, len(activeRMs))
+	for _, rmId := range activeRMs {
+		pending[rmId] = server.EmptyStructVal
+	}
+
+	// Nothing is held for us by the active RMs, so unlike a normal
+	// txn, there is no need to tell them when we're done.
+	shutdownFun := func(shutdown bool) {
+		delete(sts.outcomeConsumers, *txnId)
+		delete(sts.readOnlyTxns, *txnId)
+		sts.connPub.RemoveServerConnectionSubscriber(txnSender)
+		if shutdown {
+			continuation(txnId, nil, nil)
+		}
+	}
+	shutdownFunPtr := &shutdownFun
+	sts.onShutdown[shutdownFunPtr] = server.EmptyStructVal
+
+	complete := func(commit bool) {
+		delete(sts.onShutdown, shutdownFunPtr)
+		shutdownFun(false)
+		outcomeSeg := capn.NewBuffer(nil)
+		outcome := msgs.NewOutcome(outcomeSeg)
+		outcome.SetTxn(*txnCap)
+		outcome.SetId(msgs.NewOutcomeIdList(outcomeSeg, 0))
+		if commit {
+			readOnlyTxnsCommit.Inc()
+			outcome.SetCommit(clock.AddToSeg(outcomeSeg))
+		} else {
+			readOnlyTxnsFallback.Inc()
+			outcome.SetAbort()
+			outcome.Abort().SetResubmit()
+		}
+		continuation(txnId, &outcome, nil)
+	}
+
+	sts.outcomeConsumers[*txnId] = func(sender common.RMId, txnId *common.TxnId, outcome *msgs.Outcome) {
+		if _, found := pending[sender]; !found {
+			return
+		} else if outcome.Which() != msgs.OUTCOME_COMMIT {
+			complete(false)
+			return
+		}
+		delete(pending, sender)
+		clock.MergeInMax(eng.VectorClockFromCap(outcome.Commit()))
+		if len(pending) == 0 {
+			complete(true)
+		}
+	}
+	// If we lose an active RM we'll never hear from it, so give up.
+	sts.readOnlyTxns[*txnId] = func() {
+		for rmId := range pending {
+			if _, found := sts.connections[rmId]; !found {
+				complete(false)
+				return
+			}
+		}
+	}
+}
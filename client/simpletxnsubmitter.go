@@ -24,11 +24,15 @@ type SimpleTxnSubmitter struct {
 	connPub             paxos.ServerConnectionPublisher
 	outcomeConsumers    map[common.TxnId]txnOutcomeConsumer
 	onShutdown          map[*func(bool)]server.EmptyStruct
+	retries             map[common.TxnId]func()
 	resolver            *ch.Resolver
+	zones               map[common.RMId]string
 	hashCache           *ch.ConsistentHashCache
 	topology            *configuration.Topology
 	rng                 *rand.Rand
 	bufferedSubmissions []func()
+	buffered            map[common.TxnId]server.EmptyStruct
+	readOnlyTxns        map[common.TxnId]func()
 }
 
 type txnOutcomeConsumer func(common.RMId, *common.TxnId, *msgs.Outcome)
@@ -45,6 +49,9 @@ func NewSimpleTxnSubmitter(rmId common.RMId, bootCount uint32, connPub paxos.Ser
 		connPub:          connPub,
 		outcomeConsumers: make(map[common.TxnId]txnOutcomeConsumer),
 		onShutdown:       make(map[*func(bool)]server.EmptyStruct),
+		retries:          make(map[common.TxnId]func()),
+		buffered:         make(map[common.TxnId]server.EmptyStruct),
+		readOnlyTxns:     make(map[common.TxnId]func()),
 		hashCache:        cache,
 		rng:              rng,
 	}
@@ -56,8 +63,9 @@ func (sts *SimpleTxnSubmitter) Status(sc *server.StatusConsumer) {
 	for txnId := range sts.outcomeConsumers {
 		txnIds = append(txnIds, txnId)
 	}
-	sc.Emit(fmt.Sprintf("SimpleTxnSubmitter: live TxnIds: %v", txnIds))
-	sc.Emit(fmt.Sprintf("SimpleTxnSubmitter: buffered Txns: %v", len(sts.bufferedSubmissions)))
+	sc.Emit("SimpleTxnSubmitter")
+	sc.EmitKV("live TxnIds", txnIds)
+	sc.EmitKV("buffered Txns", len(sts.buffered))
 	sc.Join()
 }
 
@@ -105,6 +113,7 @@ func (sts *SimpleTxnSubmitter) SubmitTransaction(txnCap *msgs.Txn, activeRMs []c
 
 	shutdownFun := func(shutdown bool) {
 		delete(sts.outcomeConsumers, *txnId)
+		delete(sts.retries, *txnId)
 		// fmt.Printf("sts%v ", len(sts.outcomeConsumers))
 		if delay == 0 {
 			sts.connPub.RemoveServerConnectionSubscriber(txnSender)
@@ -126,10 +135,25 @@ func (sts *SimpleTxnSubmitter) SubmitTransaction(txnCap *msgs.Txn, activeRMs []c
 	}
 	shutdownFunPtr := &shutdownFun
 	sts.onShutdown[shutdownFunPtr] = server.EmptyStructVal
+	if txnCap.Retry() {
+		sts.retries[*txnId] = func() {
+			delete(sts.onShutdown, shutdownFunPtr)
+			shutdownFun(false)
+			paxos.NewOneShotSender(paxos.MakeTxnSubmissionAbortMsg(txnId), sts.connPub, activeRMs...)
+		}
+	}
 
 	outcomeAccumulator := paxos.NewOutcomeAccumulator(int(txnCap.FInc()), acceptors)
 	consumer := func(sender common.RMId, txnId *common.TxnId, outcome *msgs.Outcome) {
 		if outcome, _ = outcomeAccumulator.BallotOutcomeReceived(sender, outcome); outcome != nil {
+			switch {
+			case outcome.Which() == msgs.OUTCOME_COMMIT:
+				outcomesCommit.Inc()
+			case outcome.Abort().Which() == msgs.OUTCOMEABORT_RESUBMIT:
+				outcomesAbortResubmit.Inc()
+			default:
+				outcomesAbortRerun.Inc()
+			}
 			delete(sts.onShutdown, shutdownFunPtr)
 			shutdownFun(false)
 			continuation(txnId, outcome, nil)
@@ -139,10 +163,44 @@ func (sts *SimpleTxnSubmitter) SubmitTransaction(txnCap *msgs.Txn, activeRMs []c
 	// fmt.Printf("sts%v ", len(sts.outcomeConsumers))
 }
 
+// CancelRetry abandons a retry txn which has not yet completed, just
+// as if we were shutting down, except that the continuation is not
+// called. A txn which is still buffered is never submitted. It
+// returns false if the txn is neither in flight nor buffered.
+func (sts *SimpleTxnSubmitter) CancelRetry(txnId *common.TxnId) bool {
+	if cancel, found := sts.retries[*txnId]; found {
+		cancel()
+		return true
+	} else if _, found := sts.buffered[*txnId]; found {
+		delete(sts.buffered, *txnId)
+		return true
+	}
+	return false
+}
+
 func (sts *SimpleTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn, continuation TxnCompletionConsumer, delay time.Duration, useNextVersion bool) {
+	sts.submitClientTransaction(ctxnCap, continuation, delay, useNextVersion, false, false)
+}
+
+// SubmitCollectTransaction marks the txn as collecting: once it
+// commits, every var it writes to is removed from disk.
+func (sts *SimpleTxnSubmitter) SubmitCollectTransaction(ctxnCap *cmsgs.ClientTxn, continuation TxnCompletionConsumer) {
+	sts.submitClientTransaction(ctxnCap, continuation, 0, true, true, false)
+}
+
+func (sts *SimpleTxnSubmitter) submitClientTransaction(ctxnCap *cmsgs.ClientTxn, continuation TxnCompletionConsumer, delay time.Duration, useNextVersion, collect, readOnly bool) {
 	// Frames could attempt rolls before we have a topology.
 	if sts.topology.IsBlank() || (sts.topology.Next() != nil && (!useNextVersion || !sts.topology.NextBarrierReached1(sts.rmId))) {
-		fun := func() { sts.SubmitClientTransaction(ctxnCap, continuation, delay, useNextVersion) }
+		// Buffered txns keep their ids, which must not be reused.
+		txnId := common.MakeTxnId(ctxnCap.Id())
+		sts.buffered[*txnId] = server.EmptyStructVal
+		fun := func() {
+			if _, found := sts.buffered[*txnId]; !found { // cancelled
+				return
+			}
+			delete(sts.buffered, *txnId)
+			sts.submitClientTransaction(ctxnCap, continuation, delay, useNextVersion, collect, readOnly)
+		}
 		if sts.bufferedSubmissions == nil {
 			sts.bufferedSubmissions = []func(){fun}
 		} else {
@@ -159,27 +217,47 @@ func (sts *SimpleTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn,
 		continuation(nil, nil, err)
 		return
 	}
+	txnCap.SetCollect(collect)
+	if readOnly {
+		txnCap.SetReadOnly(true)
+		sts.submitReadOnlyTransaction(txnCap, activeRMs, continuation)
+		return
+	}
 	sts.SubmitTransaction(txnCap, activeRMs, continuation, delay)
 }
 
 func (sts *SimpleTxnSubmitter) TopologyChanged(topology *configuration.Topology) {
+	if !sts.setTopology(topology) {
+		return
+	}
+	for _, root := range topology.AllRoots() {
+		sts.hashCache.AddPosition(root.VarUUId, root.Positions)
+	}
+	sts.calculateDisabledHashcodes()
+}
+
+// setTopology returns false if the topology is not yet usable for
+// submitting txns. Once the roots are in the hashCache,
+// calculateDisabledHashcodes must be called.
+func (sts *SimpleTxnSubmitter) setTopology(topology *configuration.Topology) bool {
 	if topology == nil || topology.RMs().NonEmptyLen() < int(topology.TwoFInc) {
 		// topology is needed for client txns. As we're booting up, we
 		// just don't care.
-		return
+		return false
 	}
 	sts.topology = topology
-	sts.resolver = ch.NewResolver(topology.RMs(), topology.TwoFInc)
+	sts.zones = topology.RMZones()
+	sts.resolver = ch.NewResolver(topology.RMs(), topology.TwoFInc, sts.zones, topology.RMWeights())
 	sts.hashCache.SetResolver(sts.resolver)
-	if topology.Root.VarUUId != nil {
-		sts.hashCache.AddPosition(topology.Root.VarUUId, topology.Root.Positions)
-	}
-	sts.calculateDisabledHashcodes()
+	return true
 }
 
 func (sts *SimpleTxnSubmitter) ServerConnectionsChanged(servers map[common.RMId]paxos.Connection) {
 	sts.connections = servers
 	sts.calculateDisabledHashcodes()
+	for _, fun := range sts.readOnlyTxns {
+		fun()
+	}
 }
 
 func (sts *SimpleTxnSubmitter) calculateDisabledHashcodes() {
@@ -226,7 +304,7 @@ func (sts *SimpleTxnSubmitter) clientToServerTxn(clientTxnCap *cmsgs.ClientTxn,
 	clientActions := clientTxnCap.Actions()
 	actions := msgs.NewActionList(outgoingSeg, clientActions.Len())
 	txnCap.SetActions(actions)
-	picker := ch.NewCombinationPicker(int(sts.topology.FInc), sts.disabledHashCodes)
+	picker := ch.NewCombinationPicker(int(sts.topology.FInc), sts.disabledHashCodes, sts.zones)
 
 	rmIdToActionIndices, err := sts.translateActions(outgoingSeg, picker, &actions, &clientActions)
 	if err != nil {
@@ -18,27 +18,88 @@ func NewVersionCache() versionCache {
 	return make(map[common.VarUUId]*cached)
 }
 
-func (vc versionCache) UpdateFromCommit(txnId *common.TxnId, outcome *msgs.Outcome) {
+// versionCacheView is the view of the versionCache held by a single
+// client txn. Lookups see the shared cache, overlaid with the updates
+// made through the view. Those updates are only merged into the
+// shared cache once the client is sent the outcome of the txn, so
+// the shared cache only ever holds versions the client has been
+// told of, however the outcomes of its txns interleave.
+type versionCacheView struct {
+	shared  versionCache
+	updates map[common.VarUUId]*cachedUpdate
+}
+
+type cachedUpdate struct {
+	cached
+	missing bool
+}
+
+func (vc versionCache) View() *versionCacheView {
+	return &versionCacheView{
+		shared:  vc,
+		updates: make(map[common.VarUUId]*cachedUpdate),
+	}
+}
+
+func (vcv *versionCacheView) get(vUUId *common.VarUUId) (*cached, bool) {
+	if c, found := vcv.updates[*vUUId]; found {
+		return &c.cached, !c.missing
+	}
+	c, found := vcv.shared[*vUUId]
+	return c, found
+}
+
+func (vcv *versionCacheView) set(vUUId *common.VarUUId, txnId *common.TxnId, clockElem uint64, missing bool) {
+	vcv.updates[*vUUId] = &cachedUpdate{
+		cached: cached{
+			txnId:     txnId,
+			clockElem: clockElem,
+		},
+		missing: missing,
+	}
+}
+
+// Merge applies the updates made through the view to the shared
+// cache, except where the outcome of another txn has meanwhile told
+// the client of a later version.
+func (vcv *versionCacheView) Merge() {
+	for vUUId, c := range vcv.updates {
+		if cur, found := vcv.shared[vUUId]; found && !c.after(cur) {
+			continue
+		} else if c.missing {
+			delete(vcv.shared, vUUId)
+		} else {
+			cachedCopy := c.cached
+			vcv.shared[vUUId] = &cachedCopy
+		}
+	}
+	vcv.updates = make(map[common.VarUUId]*cachedUpdate)
+}
+
+func (c *cached) after(other *cached) bool {
+	return c.clockElem > other.clockElem || (c.clockElem == other.clockElem && other.txnId.Compare(c.txnId) == common.LT)
+}
+
+func (vcv *versionCacheView) UpdateFromCommit(txnId *common.TxnId, outcome *msgs.Outcome) {
 	clock := eng.VectorClockFromCap(outcome.Commit())
 	actions := outcome.Txn().Actions()
 	for idx, l := 0, actions.Len(); idx < l; idx++ {
 		action := actions.At(idx)
 		if action.Which() != msgs.ACTION_READ {
 			vUUId := common.MakeVarUUId(action.VarId())
-			if c, found := vc[*vUUId]; found {
-				c.txnId = txnId
-				c.clockElem = clock.Clock[*vUUId]
-			} else {
-				vc[*vUUId] = &cached{
-					txnId:     txnId,
-					clockElem: clock.Clock[*vUUId],
-				}
-			}
+			vcv.set(vUUId, txnId, clock.Clock[*vUUId], false)
 		}
 	}
 }
 
-func (vc versionCache) UpdateFromAbort(updates *msgs.Update_List) map[*msgs.Update][]*msgs.Action {
+// UpdateFromAbort returns the updates the client has not yet
+// seen. With several txns in flight, the client may already have
+// been sent an update through the outcome of a different txn after
+// the aborted txn was submitted. So reads holds the versions the
+// aborted txn read at: any update to a var read at an older version
+// is also returned, otherwise the txn would be resubmitted unchanged
+// forever.
+func (vcv *versionCacheView) UpdateFromAbort(updates *msgs.Update_List, reads map[common.VarUUId]*common.TxnId) map[*msgs.Update][]*msgs.Action {
 	validUpdates := make(map[*msgs.Update][]*msgs.Action)
 
 	for idx, l := 0, updates.Len(); idx < l; idx++ {
@@ -55,33 +116,33 @@ func (vc versionCache) UpdateFromAbort(updates *msgs.Update_List) map[*msgs.Upda
 
 			switch action.Which() {
 			case msgs.ACTION_MISSING:
-				if c, found := vc[*vUUId]; found {
+				if c, found := vcv.get(vUUId); found {
 					cmp := c.txnId.Compare(txnId)
 					if clockElem > c.clockElem && cmp == common.EQ {
 						panic(fmt.Sprintf("Clock version increased on missing for %v@%v (%v > %v)", vUUId, txnId, clockElem, c.clockElem))
 					}
 					if clockElem > c.clockElem || (clockElem == c.clockElem && cmp == common.LT) {
-						delete(vc, *vUUId)
+						vcv.set(vUUId, txnId, clockElem, true)
 						validActions = append(validActions, &action)
 					}
+				} else if _, found := reads[*vUUId]; found {
+					validActions = append(validActions, &action)
 				}
 
 			case msgs.ACTION_WRITE:
-				if c, found := vc[*vUUId]; found {
+				if c, found := vcv.get(vUUId); found {
 					cmp := c.txnId.Compare(txnId)
 					if clockElem > c.clockElem && cmp == common.EQ {
 						panic(fmt.Sprintf("Clock version increased on write for %v@%v (%v > %v)", vUUId, txnId, clockElem, c.clockElem))
 					}
 					if clockElem > c.clockElem || (clockElem == c.clockElem && cmp == common.LT) {
-						c.txnId = txnId
-						c.clockElem = clockElem
+						vcv.set(vUUId, txnId, clockElem, false)
+						validActions = append(validActions, &action)
+					} else if read, found := reads[*vUUId]; found && cmp == common.EQ && read.Compare(txnId) != common.EQ {
 						validActions = append(validActions, &action)
 					}
 				} else {
-					vc[*vUUId] = &cached{
-						txnId:     txnId,
-						clockElem: clockElem,
-					}
+					vcv.set(vUUId, txnId, clockElem, false)
 					validActions = append(validActions, &action)
 				}
 
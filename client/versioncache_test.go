@@ -0,0 +1,278 @@
+package client
+
+import (
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	eng "goshawkdb.io/server/txnengine"
+	"testing"
+	"time"
+)
+
+func testVarUUId(n byte) *common.VarUUId {
+	bites := make([]byte, common.KeyLen)
+	bites[0] = n
+	return common.MakeVarUUId(bites)
+}
+
+func testTxnId(n uint64) *common.TxnId {
+	txnId := common.MakeTxnId(make([]byte, common.KeyLen))
+	for idx := 7; idx >= 0; idx-- {
+		txnId[idx] = byte(n)
+		n >>= 8
+	}
+	return txnId
+}
+
+// testUpdates makes the updates of an abort, in which txnId wrote
+// vUUId (or, if missing, vUUId is no longer available) at clockElem.
+func testUpdates(txnId *common.TxnId, vUUId *common.VarUUId, clockElem uint64, missing bool) *msgs.Update_List {
+	seg := capn.NewBuffer(nil)
+	updates := msgs.NewUpdateList(seg, 1)
+	update := updates.At(0)
+	update.SetTxnId(txnId[:])
+	actions := msgs.NewActionList(seg, 1)
+	update.SetActions(actions)
+	action := actions.At(0)
+	action.SetVarId(vUUId[:])
+	if missing {
+		action.SetMissing()
+	} else {
+		action.SetWrite()
+		write := action.Write()
+		write.SetValue([]byte{byte(clockElem)})
+		write.SetReferences(msgs.NewVarIdPosList(seg, 0))
+	}
+	update.SetClock(eng.NewVectorClock().Bump(*vUUId, clockElem).AddToSeg(seg))
+	return &updates
+}
+
+// testCommit makes the commit of txnId, which wrote vUUId at
+// clockElem.
+func testCommit(txnId *common.TxnId, vUUId *common.VarUUId, clockElem uint64) *msgs.Outcome {
+	seg := capn.NewBuffer(nil)
+	outcome := msgs.NewOutcome(seg)
+	txn := msgs.NewTxn(seg)
+	txn.SetId(txnId[:])
+	actions := msgs.NewActionList(seg, 1)
+	txn.SetActions(actions)
+	action := actions.At(0)
+	action.SetVarId(vUUId[:])
+	action.SetWrite()
+	write := action.Write()
+	write.SetValue([]byte{byte(clockElem)})
+	write.SetReferences(msgs.NewVarIdPosList(seg, 0))
+	outcome.SetTxn(txn)
+	outcome.SetCommit(eng.NewVectorClock().Bump(*vUUId, clockElem).AddToSeg(seg))
+	return &outcome
+}
+
+func assertCached(t *testing.T, vc versionCache, vUUId *common.VarUUId, txnId *common.TxnId, clockElem uint64) {
+	c, found := vc[*vUUId]
+	if !found {
+		t.Fatalf("%v is not cached; expected %v@%v", vUUId, txnId, clockElem)
+	} else if c.txnId.Compare(txnId) != common.EQ || c.clockElem != clockElem {
+		t.Fatalf("%v is cached at %v@%v; expected %v@%v", vUUId, c.txnId, c.clockElem, txnId, clockElem)
+	}
+}
+
+func TestVersionCacheMergeOrder(t *testing.T) {
+	vUUId := testVarUUId(1)
+	older, newer := testTxnId(10), testTxnId(20)
+	reads := map[common.VarUUId]*common.TxnId{*vUUId: common.VersionZero}
+
+	// Two txns in flight on the same var; each is aborted with a
+	// different version. Whichever order their outcomes are sent to
+	// the client in, the shared cache ends up with the later version.
+	for _, newerFirst := range []bool{true, false} {
+		vc := NewVersionCache()
+		viewOlder, viewNewer := vc.View(), vc.View()
+		if valid := viewOlder.UpdateFromAbort(testUpdates(older, vUUId, 1, false), reads); len(valid) != 1 {
+			t.Fatalf("Found %v valid updates; expected 1", len(valid))
+		}
+		if valid := viewNewer.UpdateFromAbort(testUpdates(newer, vUUId, 2, false), reads); len(valid) != 1 {
+			t.Fatalf("Found %v valid updates; expected 1", len(valid))
+		}
+		if len(vc) != 0 {
+			t.Fatalf("Shared cache was updated before any merge: %v", vc)
+		}
+		if newerFirst {
+			viewNewer.Merge()
+			viewOlder.Merge()
+		} else {
+			viewOlder.Merge()
+			viewNewer.Merge()
+		}
+		assertCached(t, vc, vUUId, newer, 2)
+	}
+}
+
+func TestVersionCacheCommitThenAbort(t *testing.T) {
+	vUUId := testVarUUId(1)
+	first, committed := testTxnId(10), testTxnId(20)
+	vc := NewVersionCache()
+	vc[*vUUId] = &cached{txnId: first, clockElem: 1}
+
+	// Both txns read the var at first. The view of the aborting txn
+	// is taken before the commit is merged.
+	viewCommit, viewAbort := vc.View(), vc.View()
+	viewCommit.UpdateFromCommit(committed, testCommit(committed, vUUId, 2))
+	viewCommit.Merge()
+	assertCached(t, vc, vUUId, committed, 2)
+
+	// The abort tells of the committed write, which the client now
+	// already knows of. But as the aborted txn read an older version,
+	// the update must still be sent, or the txn would be resubmitted
+	// unchanged forever.
+	reads := map[common.VarUUId]*common.TxnId{*vUUId: first}
+	if valid := viewAbort.UpdateFromAbort(testUpdates(committed, vUUId, 2, false), reads); len(valid) != 1 {
+		t.Fatalf("Found %v valid updates; expected 1", len(valid))
+	}
+	viewAbort.Merge()
+	assertCached(t, vc, vUUId, committed, 2)
+
+	// Had it read the committed version, there is nothing new.
+	reads[*vUUId] = committed
+	if valid := vc.View().UpdateFromAbort(testUpdates(committed, vUUId, 2, false), reads); len(valid) != 0 {
+		t.Fatalf("Found %v valid updates; expected 0", len(valid))
+	}
+}
+
+func TestVersionCacheStaleAbortAfterCommit(t *testing.T) {
+	vUUId := testVarUUId(1)
+	older, committed := testTxnId(10), testTxnId(20)
+	vc := NewVersionCache()
+	reads := map[common.VarUUId]*common.TxnId{*vUUId: common.VersionZero}
+
+	// The abort is merged after a later commit: it must not roll the
+	// shared cache back.
+	viewCommit, viewAbort := vc.View(), vc.View()
+	if valid := viewAbort.UpdateFromAbort(testUpdates(older, vUUId, 1, false), reads); len(valid) != 1 {
+		t.Fatalf("Found %v valid updates; expected 1", len(valid))
+	}
+	viewCommit.UpdateFromCommit(committed, testCommit(committed, vUUId, 2))
+	viewCommit.Merge()
+	viewAbort.Merge()
+	assertCached(t, vc, vUUId, committed, 2)
+
+	// A var which has since gone missing is removed by a later
+	// version, but not by an earlier one.
+	view := vc.View()
+	if valid := view.UpdateFromAbort(testUpdates(older, vUUId, 1, true), reads); len(valid) != 0 {
+		t.Fatalf("Found %v valid updates; expected 0", len(valid))
+	}
+	view.Merge()
+	assertCached(t, vc, vUUId, committed, 2)
+	if valid := view.UpdateFromAbort(testUpdates(testTxnId(30), vUUId, 3, true), reads); len(valid) != 1 {
+		t.Fatalf("Found %v valid updates; expected 1", len(valid))
+	}
+	view.Merge()
+	if _, found := vc[*vUUId]; found {
+		t.Fatalf("%v is still cached after it went missing", vUUId)
+	}
+}
+
+func testClientTxnSubmitter() *ClientTxnSubmitter {
+	return NewClientTxnSubmitter(common.RMId(1), 1, nil, &configuration.ClientAccess{})
+}
+
+func TestClientTxnSubmitterBumpTxnId(t *testing.T) {
+	cts := testClientTxnSubmitter()
+	prev := testTxnId(100)
+	// Every id up to two bumps away is in use by one of the txns in
+	// flight, so bumping must skip past all of them.
+	for n := uint64(101); n <= 116; n++ {
+		txnId := testTxnId(n)
+		switch n % 4 {
+		case 0:
+			cts.liveTxns[*txnId] = &liveClientTxn{curTxnId: txnId}
+		case 1:
+			cts.buffered[*txnId] = server.EmptyStructVal
+		case 2:
+			cts.outcomeConsumers[*txnId] = nil
+		default:
+			cts.watches[*txnId] = nil
+		}
+	}
+	for idx := 0; idx < 100; idx++ {
+		next := common.MakeTxnId(prev[:])
+		cts.bumpTxnId(prev, next)
+		if next.Compare(testTxnId(116)) != common.GT {
+			t.Fatalf("Bumped %v to %v; expected beyond %v", prev, next, testTxnId(116))
+		} else if cts.txnIdInUse(next) {
+			t.Fatalf("Bumped %v to %v, which is in use", prev, next)
+		}
+	}
+}
+
+func testClientTxn(txnId *common.TxnId) *cmsgs.ClientTxn {
+	seg := capn.NewBuffer(nil)
+	ctxn := cmsgs.NewClientTxn(seg)
+	ctxn.SetId(txnId[:])
+	ctxn.SetRetry(false)
+	actions := cmsgs.NewClientActionList(seg, 1)
+	ctxn.SetActions(actions)
+	action := actions.At(0)
+	action.SetVarId(testVarUUId(1)[:])
+	action.SetRead()
+	action.Read().SetVersion(common.VersionZero[:])
+	return &ctxn
+}
+
+func TestClientTxnSubmitterRefusesIdsInUse(t *testing.T) {
+	cts := testClientTxnSubmitter()
+	live, resubmitted := testTxnId(1), testTxnId(2)
+	cts.liveTxns[*live] = &liveClientTxn{curTxnId: live}
+	cts.outcomeConsumers[*resubmitted] = nil
+
+	for _, txnId := range []*common.TxnId{live, resubmitted} {
+		var outcome *cmsgs.ClientTxnOutcome
+		var err error
+		called := false
+		cts.SubmitClientTransaction(testClientTxn(txnId), time.Time{}, func(o *cmsgs.ClientTxnOutcome, e error) {
+			called, outcome, err = true, o, e
+		})
+		if !called || outcome != nil || err == nil {
+			t.Fatalf("Submission of %v, which is in use, gave %v, %v; expected an error", txnId, outcome, err)
+		}
+	}
+	// The refusals must not disturb the txns in flight.
+	if _, found := cts.liveTxns[*live]; !found || len(cts.liveTxns) != 1 {
+		t.Fatalf("Live txns are %v; expected only %v", cts.liveTxns, live)
+	}
+}
+
+func TestClientTxnSubmitterCancelBuffered(t *testing.T) {
+	cts := testClientTxnSubmitter()
+	txnId := testTxnId(1)
+	var errs []error
+	// With no topology, the submission is buffered.
+	cts.SubmitClientTransaction(testClientTxn(txnId), time.Time{}, func(outcome *cmsgs.ClientTxnOutcome, err error) {
+		errs = append(errs, err)
+	})
+	if len(errs) != 0 || len(cts.bufferedSubmissions) != 1 {
+		t.Fatalf("Submission without a topology gave %v and %v buffered; expected it buffered", errs, len(cts.bufferedSubmissions))
+	}
+
+	cts.CancelClientTransaction(testTxnId(2), TxnCancelled)
+	if len(errs) != 0 {
+		t.Fatalf("Cancelling an unknown txn completed %v", txnId)
+	}
+	cts.CancelClientTransaction(txnId, TxnCancelled)
+	if len(errs) != 1 || errs[0] != TxnCancelled {
+		t.Fatalf("Cancelling %v gave %v; expected %v", txnId, errs, TxnCancelled)
+	} else if len(cts.liveTxns) != 0 || len(cts.buffered) != 0 {
+		t.Fatalf("Cancelled %v is still live (%v) or buffered (%v)", txnId, len(cts.liveTxns), len(cts.buffered))
+	}
+
+	// Once the topology arrives, the abandoned submission is dropped.
+	for _, fun := range cts.bufferedSubmissions {
+		fun()
+	}
+	if len(errs) != 1 || len(cts.outcomeConsumers) != 0 {
+		t.Fatalf("Cancelled %v was submitted after all", txnId)
+	}
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"time"
+)
+
+// A watch is a retry txn over a set of vars which, rather than
+// completing once any of the vars changes, is resubmitted
+// indefinitely. Each time it aborts with updates that the client
+// has not yet seen, those updates are passed to the consumer as the
+// abort of a ClientTxnOutcome with the id of the watch. The first
+// submission reads any var the client has not yet seen at version
+// zero, so the consumer is immediately given its current value,
+// unless the var has never been written.
+//
+// Whilst the topology is changing, the retry txn is abandoned so
+// that the submitter can become idle, and it is resubmitted once the
+// change has completed.
+type clientWatch struct {
+	*ClientTxnSubmitter
+	id        *common.TxnId
+	curTxnId  *common.TxnId
+	vUUIds    []*common.VarUUId
+	consumer  ClientTxnCompletionConsumer
+	cancelled bool
+}
+
+// AddWatch registers a watch on the vars, which must all be known to
+// the client. The id of the watch is taken from the client's txn
+// ids, and must not be used by any of its txns.
+func (cts *ClientTxnSubmitter) AddWatch(watchId *common.TxnId, vUUIds []*common.VarUUId, consumer ClientTxnCompletionConsumer) error {
+	if _, found := cts.watches[*watchId]; found {
+		return fmt.Errorf("Watch %v already exists", watchId)
+	} else if cts.txnIdInUse(watchId) {
+		return fmt.Errorf("Cannot add watch %v as its id is in use by a txn", watchId)
+	} else if len(vUUIds) == 0 {
+		return fmt.Errorf("Watch %v has no vars", watchId)
+	}
+	for _, vUUId := range vUUIds {
+		if cts.hashCache.GetPositions(vUUId) == nil {
+			return fmt.Errorf("Watch %v contains unknown var %v", watchId, vUUId)
+		}
+	}
+	cw := &clientWatch{
+		ClientTxnSubmitter: cts,
+		id:                 watchId,
+		curTxnId:           common.MakeTxnId(watchId[:]),
+		vUUIds:             vUUIds,
+		consumer:           consumer,
+	}
+	cts.watches[*watchId] = cw
+	clientWatches.Inc()
+	cw.submit(0)
+	return nil
+}
+
+// RemoveWatch cancels the watch. Nothing more is sent to its
+// consumer. It is not an error if the watch does not exist, as it
+// may have failed.
+func (cts *ClientTxnSubmitter) RemoveWatch(watchId *common.TxnId) {
+	if cw, found := cts.watches[*watchId]; found {
+		cw.remove()
+		cw.CancelRetry(cw.curTxnId)
+	}
+}
+
+func (cw *clientWatch) remove() {
+	cw.cancelled = true
+	delete(cw.watches, *cw.id)
+	clientWatches.Dec()
+}
+
+// park abandons the current retry txn, if there is one, and submits
+// it afresh, which buffers it until the topology change completes.
+func (cw *clientWatch) park() {
+	if cw.CancelRetry(cw.curTxnId) {
+		cw.submit(0)
+	}
+}
+
+func (cw *clientWatch) submit(delay time.Duration) {
+	// As with resubmission of client txns, we bump the txn id each
+	// time round.
+	cw.bumpTxnId(cw.curTxnId, cw.curTxnId)
+
+	seg := capn.NewBuffer(nil)
+	ctxn := cmsgs.NewClientTxn(seg)
+	ctxn.SetId(cw.curTxnId[:])
+	ctxn.SetRetry(true)
+	actions := cmsgs.NewClientActionList(seg, len(cw.vUUIds))
+	ctxn.SetActions(actions)
+	cache := cw.versionCache.View()
+	for idx, vUUId := range cw.vUUIds {
+		action := actions.At(idx)
+		action.SetVarId(vUUId[:])
+		action.SetRead()
+		version := common.VersionZero
+		if c, found := cache.get(vUUId); found {
+			version = c.txnId
+		}
+		action.Read().SetVersion(version[:])
+	}
+	reads := readVersions(&ctxn)
+	// Never use the next topology: if a topology change is under way,
+	// this is buffered until it completes.
+	cw.SimpleTxnSubmitter.SubmitClientTransaction(&ctxn, func(txnId *common.TxnId, outcome *msgs.Outcome, err error) {
+		cw.outcomeReceived(&ctxn, cache, reads, txnId, outcome, err)
+	}, delay, false)
+}
+
+func (cw *clientWatch) outcomeReceived(ctxn *cmsgs.ClientTxn, cache *versionCacheView, reads map[common.VarUUId]*common.TxnId, txnId *common.TxnId, outcome *msgs.Outcome, err error) {
+	if cw.cancelled {
+		return
+	}
+	if outcome == nil || err != nil { // node is shutting down or error
+		cw.remove()
+		cw.consumer(nil, err)
+		return
+	}
+	if outcome.Which() == msgs.OUTCOME_ABORT {
+		if abort := outcome.Abort(); abort.Which() == msgs.OUTCOMEABORT_RERUN {
+			updates := abort.Rerun()
+			if vUUId := collectedVar(&updates, ctxn); vUUId != nil {
+				cw.remove()
+				cw.consumer(nil, fmt.Errorf("Watch %v uses %v, which has been garbage collected", cw.id, vUUId))
+				return
+			}
+			validUpdates := cache.UpdateFromAbort(&updates, reads)
+			server.Log("Watch", cw.id, "updates:", updates.Len(), "; valid: ", len(validUpdates))
+			if len(validUpdates) != 0 {
+				cache.Merge()
+				seg := capn.NewBuffer(nil)
+				clientOutcome := cmsgs.NewClientTxnOutcome(seg)
+				clientOutcome.SetId(cw.id[:])
+				clientOutcome.SetFinalId(txnId[:])
+				clientOutcome.SetAbort(cw.translateUpdates(seg, validUpdates))
+				cw.consumer(&clientOutcome, nil)
+				if !cw.cancelled { // consumer may have removed the watch
+					cw.submit(0)
+				}
+				return
+			}
+		}
+	}
+	cw.submit(server.SubmissionInitialBackoff)
+}
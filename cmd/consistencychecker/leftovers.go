@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	msgs "goshawkdb.io/server/capnp"
+	eng "goshawkdb.io/server/txnengine"
+	"log"
+)
+
+// leftoverChecker looks for Proposers and BallotOutcomes entries which
+// should have been deleted. The paxos instances for a txn finish in
+// this order: each learner writes its proposer state once the txn is
+// locally complete; each acceptor deletes its ballot outcome once
+// every learner is locally complete; and then each learner deletes
+// its proposer state once it learns the txn is globally complete. So
+// with every store of a quiesced cluster supplied:
+//
+// - a proposer state is stale if none of its acceptors still has a
+// ballot outcome for the txn;
+//
+// - a ballot outcome is stale if none of the learners has a proposer
+// state for the txn, but every learner has already applied it.
+//
+// Entries for which any of the relevant stores are not supplied are
+// not checked.
+type leftoverChecker struct {
+	stores         map[common.RMId]*store
+	report         *report
+	proposers      map[common.RMId]map[common.TxnId][]common.RMId
+	ballotOutcomes map[common.RMId]map[common.TxnId]*msgs.AcceptorState
+}
+
+func newLeftoverChecker(stores stores, r *report) *leftoverChecker {
+	return &leftoverChecker{
+		stores:         stores.ByRMId(),
+		report:         r,
+		proposers:      make(map[common.RMId]map[common.TxnId][]common.RMId, len(stores)),
+		ballotOutcomes: make(map[common.RMId]map[common.TxnId]*msgs.AcceptorState, len(stores)),
+	}
+}
+
+func (loc *leftoverChecker) check() error {
+	for rmId, s := range loc.stores {
+		log.Printf("Loading proposers and ballot outcomes from %v", s)
+		if err := loc.load(rmId, s); err != nil {
+			return err
+		}
+	}
+	for rmId, proposers := range loc.proposers {
+		for txnId, acceptors := range proposers {
+			txnIdCopy := txnId
+			loc.checkProposer(loc.stores[rmId], &txnIdCopy, acceptors)
+		}
+	}
+	for rmId, ballotOutcomes := range loc.ballotOutcomes {
+		for txnId, state := range ballotOutcomes {
+			txnIdCopy := txnId
+			if err := loc.checkBallotOutcome(loc.stores[rmId], &txnIdCopy, state); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (loc *leftoverChecker) load(rmId common.RMId, s *store) error {
+	proposers := make(map[common.TxnId][]common.RMId)
+	ballotOutcomes := make(map[common.TxnId]*msgs.AcceptorState)
+	loc.proposers[rmId] = proposers
+	loc.ballotOutcomes[rmId] = ballotOutcomes
+	_, err := s.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		rtxn.WithCursor(s.db.Proposers, func(cursor *mdbs.Cursor) interface{} {
+			key, value, err := cursor.Get(nil, nil, mdb.FIRST)
+			for ; err == nil; key, value, err = cursor.Get(nil, nil, mdb.NEXT) {
+				seg, _, err := capn.ReadFromMemoryZeroCopy(value)
+				if err != nil {
+					cursor.Error(fmt.Errorf("Err on decoding proposer state in %v: %v (%v)", s, err, key))
+					return nil
+				}
+				acceptorsCap := msgs.ReadRootProposerState(seg).Acceptors()
+				acceptors := make([]common.RMId, acceptorsCap.Len())
+				for idx := range acceptors {
+					acceptors[idx] = common.RMId(acceptorsCap.At(idx))
+				}
+				proposers[*common.MakeTxnId(key)] = acceptors
+			}
+			if err != nil && err != mdb.NotFound {
+				cursor.Error(err)
+			}
+			return nil
+		})
+		rtxn.WithCursor(s.db.BallotOutcomes, func(cursor *mdbs.Cursor) interface{} {
+			key, value, err := cursor.Get(nil, nil, mdb.FIRST)
+			for ; err == nil; key, value, err = cursor.Get(nil, nil, mdb.NEXT) {
+				// copy as we use the state outside the txn.
+				seg, _, err := capn.ReadFromMemoryZeroCopy(append([]byte{}, value...))
+				if err != nil {
+					cursor.Error(fmt.Errorf("Err on decoding acceptor state in %v: %v (%v)", s, err, key))
+					return nil
+				}
+				state := msgs.ReadRootAcceptorState(seg)
+				ballotOutcomes[*common.MakeTxnId(key)] = &state
+			}
+			if err != nil && err != mdb.NotFound {
+				cursor.Error(err)
+			}
+			return nil
+		})
+		return nil
+	}).ResultError()
+	return err
+}
+
+func (loc *leftoverChecker) checkProposer(s *store, txnId *common.TxnId, acceptors []common.RMId) {
+	for _, rmId := range acceptors {
+		ballotOutcomes, found := loc.ballotOutcomes[rmId]
+		if !found {
+			return
+		} else if _, found = ballotOutcomes[*txnId]; found {
+			return
+		}
+	}
+	loc.report.add(problemStaleProposer, s, nil, txnId, "Proposer for %v remains but none of its acceptors %v has a ballot outcome for it", txnId, acceptors)
+}
+
+func (loc *leftoverChecker) checkBallotOutcome(s *store, txnId *common.TxnId, state *msgs.AcceptorState) error {
+	txnCap := state.Txn()
+	outcome := state.Outcome()
+	actions := txnCap.Actions()
+	var commitClock *eng.VectorClock
+	if outcome.Which() == msgs.OUTCOME_COMMIT {
+		commitClock = eng.VectorClockFromCap(outcome.Commit())
+	}
+	allocations := txnCap.Allocations()
+	for idx, l := 0, allocations.Len(); idx < l; idx++ {
+		allocation := allocations.At(idx)
+		if allocation.Active() == 0 {
+			continue
+		}
+		rmId := common.RMId(allocation.RmId())
+		learner, found := loc.stores[rmId]
+		if !found {
+			return nil
+		} else if _, found = loc.proposers[rmId][*txnId]; found {
+			return nil
+		}
+		if commitClock == nil {
+			continue
+		}
+		indices := allocation.ActionIndices()
+		for idy, m := 0, indices.Len(); idy < m; idy++ {
+			action := actions.At(int(indices.At(idy)))
+			if action.Which() == msgs.ACTION_READ {
+				continue
+			}
+			applied, err := loc.applied(learner, common.MakeVarUUId(action.VarId()), commitClock)
+			if err != nil || !applied {
+				return err
+			}
+		}
+	}
+	loc.report.add(problemStaleBallotOutcome, s, nil, txnId, "Ballot outcome for %v remains but no learner has a proposer for it and every learner has applied it", txnId)
+	return nil
+}
+
+// applied returns true if the var in s is at a version no earlier
+// than the commit clock.
+func (loc *leftoverChecker) applied(s *store, vUUId *common.VarUUId, commitClock *eng.VectorClock) (bool, error) {
+	res, err := s.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		bites, err := rtxn.Get(s.db.Vars, vUUId[:])
+		if err == mdb.NotFound {
+			return false
+		} else if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		seg, _, err := capn.ReadFromMemoryZeroCopy(bites)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		varCap := msgs.ReadRootVar(seg)
+		return eng.VectorClockFromCap(varCap.WriteTxnClock()).Clock[*vUUId] >= commitClock.Clock[*vUUId]
+	}).ResultError()
+	if err != nil || res == nil {
+		return false, err
+	}
+	return res.(bool), nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"flag"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
 	mdb "github.com/msackman/gomdb"
@@ -13,7 +14,7 @@ import (
 	"goshawkdb.io/server/configuration"
 	ch "goshawkdb.io/server/consistenthash"
 	"goshawkdb.io/server/db"
-	_ "goshawkdb.io/server/txnengine"
+	eng "goshawkdb.io/server/txnengine"
 	"io/ioutil"
 	"log"
 	"os"
@@ -23,6 +24,7 @@ import (
 
 type store struct {
 	dir      string
+	lock     *os.File
 	db       *db.Databases
 	rmId     common.RMId
 	topology *configuration.Topology
@@ -30,23 +32,77 @@ type store struct {
 
 type stores []*store
 
+// The exit code is 0 if no problems are found, 1 if problems are
+// found, and 2 if the checks could not be completed. Problems are
+// logged, and also written as a JSON report if -report is given.
+//
+// With -repair, problems which can be fixed from the other stores are
+// repaired. The planned repairs are always logged first, and are not
+// made if -dry-run is also given. The exit code and report describe
+// the problems found before repair, so run again to confirm. Stores
+// in use by a running server are never repaired.
+
 func main() {
 	log.SetPrefix(common.ProductName + "ConsistencyChecker ")
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 	log.Println(os.Args)
 
-	dirs := os.Args[1:]
+	var reportFile string
+	var repair, dryRun bool
+	flag.StringVar(&reportFile, "report", "", "`Path` to write the JSON report to (- for stdout).")
+	flag.BoolVar(&repair, "repair", false, "Repair the stores where possible.")
+	flag.BoolVar(&dryRun, "dry-run", false, "With -repair, only show the repairs which would be made.")
+	flag.Parse()
+
+	dirs := flag.Args()
 	if len(dirs) == 0 {
-		log.Fatal("No dirs supplied")
+		log.Println("No dirs supplied")
+		os.Exit(exitFailed)
 	}
 
 	runtime.GOMAXPROCS(1 + (2 * len(dirs)))
 
+	r := newReport(dirs)
+	exitCode := check(r, dirs, repair, dryRun)
+
+	if reportFile != "" {
+		if err := writeReport(r, reportFile); err != nil {
+			log.Println(err)
+			exitCode = exitFailed
+		}
+	}
+	log.Printf("Finished: %v vars checked; %v problems found.", r.Vars, len(r.Problems))
+	os.Exit(exitCode)
+}
+
+// writeReport writes the report to path, or to stdout if path is -.
+func writeReport(r *report, path string) error {
+	if path == "-" {
+		return r.Write(os.Stdout)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = r.Write(file)
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func check(r *report, dirs []string, repair, dryRun bool) int {
 	stores := stores(make([]*store, 0, len(dirs)))
 	defer stores.Shutdown()
 	for _, dir := range dirs {
 		log.Printf("...loading from %v\n", dir)
 		store := &store{dir: dir}
+		if repair {
+			if err := store.Lock(); err != nil {
+				return r.fail(fmt.Errorf("Refusing to repair: %v", err))
+			}
+		}
+		stores = append(stores, store)
 		var err error
 		if err = store.LoadRMId(); err == nil {
 			if err = store.StartDisk(); err == nil {
@@ -54,39 +110,72 @@ func main() {
 			}
 		}
 		if err != nil {
-			log.Println(err)
-			return
+			return r.fail(err)
 		}
-		stores = append(stores, store)
 	}
 
 	if err := stores.CheckEqualTopology(); err != nil {
-		log.Println(err)
-		return
+		return r.fail(err)
+	}
+
+	var rep *repairer
+	if repair {
+		rep = newRepairer(stores)
 	}
 
-	locationChecker := newLocationChecker(stores)
+	locationChecker := newLocationChecker(stores, r, rep)
 	if err := stores.IterateVars(locationChecker.locationCheck); err != nil {
-		log.Println(err)
-	} else {
-		log.Println("Finished with no fatal errors.")
+		return r.fail(err)
 	}
+	for _, s := range stores {
+		if err := newRefCountChecker(s, r, rep).check(); err != nil {
+			return r.fail(err)
+		}
+	}
+	if err := newLeftoverChecker(stores, r).check(); err != nil {
+		return r.fail(err)
+	}
+
+	if rep != nil {
+		if err := rep.resolve(); err != nil {
+			return r.fail(err)
+		}
+		rep.summary()
+		switch {
+		case rep.isEmpty():
+			log.Println("Nothing to repair.")
+		case dryRun:
+			log.Println("Dry run: no repairs made.")
+		default:
+			if err := rep.apply(); err != nil {
+				return r.fail(err)
+			}
+			log.Println("Repairs made.")
+		}
+	}
+	return r.exitCode()
 }
 
 type locationChecker struct {
-	resolver *ch.Resolver
-	stores   map[common.RMId]*store
+	resolver  *ch.Resolver
+	stores    map[common.RMId]*store
+	report    *report
+	repairer  *repairer
+	zones     map[common.RMId]string
+	zoneCount int
 }
 
-func newLocationChecker(stores stores) *locationChecker {
-	resolver := ch.NewResolver(stores[0].topology.RMs(), stores[0].topology.TwoFInc)
-	m := make(map[common.RMId]*store, len(stores))
-	for _, s := range stores {
-		m[s.rmId] = s
-	}
+func newLocationChecker(stores stores, r *report, rep *repairer) *locationChecker {
+	topology := stores[0].topology
+	zones := topology.RMZones()
+	resolver := ch.NewResolver(topology.RMs(), topology.TwoFInc, zones, topology.RMWeights())
 	return &locationChecker{
-		resolver: resolver,
-		stores:   m,
+		resolver:  resolver,
+		stores:    stores.ByRMId(),
+		report:    r,
+		repairer:  rep,
+		zones:     zones,
+		zoneCount: len(zonesOf(zones, topology.RMs().NonEmpty())),
 	}
 }
 
@@ -95,6 +184,7 @@ func (lc *locationChecker) locationCheck(cell *varWrapperCell) error {
 	varCap := cell.varCap
 	foundIn := cell.store
 	fmt.Printf("%v %v\n", foundIn, vUUId)
+	lc.report.Vars++
 	txnId := common.MakeTxnId(varCap.WriteTxnId())
 
 	res, err := foundIn.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
@@ -105,7 +195,8 @@ func (lc *locationChecker) locationCheck(cell *varWrapperCell) error {
 	}
 	txnBites, ok := res.([]byte)
 	if res == nil || (ok && txnBites == nil) {
-		return fmt.Errorf("Failed to find %v from %v in %v", txnId, vUUId, foundIn)
+		lc.report.add(problemMissingTxn, foundIn, vUUId, txnId, "Failed to find %v from %v", txnId, vUUId)
+		return nil
 	}
 	seg, _, err := capn.ReadFromMemoryZeroCopy(txnBites)
 	if err != nil {
@@ -128,6 +219,10 @@ func (lc *locationChecker) locationCheck(cell *varWrapperCell) error {
 		// It must have emigrated but we don't delete.
 		txnId = nil
 	}
+	if lc.zones != nil && lc.firstStoreOf(rmIds) == foundIn {
+		// each var need only be checked once
+		lc.placementCheck(vUUId, foundIn, rmIds)
+	}
 	for _, rmId := range rmIds {
 		if rmId == foundIn.rmId {
 			continue
@@ -153,19 +248,26 @@ func (lc *locationChecker) locationCheck(cell *varWrapperCell) error {
 							txnCap.Actions().Len() == 1 && txnCap.Actions().At(0).Which() == msgs.ACTION_CREATE)) {
 					fmt.Printf("Failed to find %v in %v (%v, %v, %v) but it looks like it's a bad root.\n", vUUId, remote, rmIds, positions, foundIn)
 				} else {
-					return fmt.Errorf("Failed to find %v in %v (%v, %v, %v)", vUUId, remote, rmIds, positions, foundIn)
+					lc.report.add(problemLocation, remote, vUUId, nil, "Failed to find %v (%v, %v, %v)", vUUId, rmIds, positions, foundIn)
+					if foundLocal {
+						lc.repairer.copyVar(remote, vUUId, varCap)
+					}
 				}
 			} else {
 				seg, _, err := capn.ReadFromMemoryZeroCopy(varBites)
 				if err != nil {
 					return err
 				}
-				remoteTxnId := common.MakeTxnId(msgs.ReadRootVar(seg).WriteTxnId())
+				remoteVarCap := msgs.ReadRootVar(seg)
+				remoteTxnId := common.MakeTxnId(remoteVarCap.WriteTxnId())
 				if txnId == nil {
 					txnId = remoteTxnId
 				}
 				if remoteTxnId.Compare(txnId) != common.EQ {
-					return fmt.Errorf("%v on %v is at %v; on %v is at %v", vUUId, foundIn, txnId, remote, remoteTxnId)
+					lc.report.add(problemVersion, remote, vUUId, remoteTxnId, "%v on %v is at %v; on %v is at %v", vUUId, foundIn, txnId, remote, remoteTxnId)
+				} else if foundLocal && foundIn.rmId < rmId {
+					// each pair of replicas need only be checked once
+					lc.clockCheck(vUUId, foundIn, varCap, remote, &remoteVarCap)
 				}
 			}
 		}
@@ -173,6 +275,69 @@ func (lc *locationChecker) locationCheck(cell *varWrapperCell) error {
 	return nil
 }
 
+func (lc *locationChecker) firstStoreOf(rmIds common.RMIds) *store {
+	for _, rmId := range rmIds {
+		if s, found := lc.stores[rmId]; found {
+			return s
+		}
+	}
+	return nil
+}
+
+// placementCheck verifies that the replicas of a var are spread
+// across as many zones as the topology allows. RMs without a zone are
+// each their own zone.
+func (lc *locationChecker) placementCheck(vUUId *common.VarUUId, s *store, rmIds common.RMIds) {
+	expected := lc.zoneCount
+	if len(rmIds) < expected {
+		expected = len(rmIds)
+	}
+	if found := zonesOf(lc.zones, rmIds); len(found) < expected {
+		lc.report.add(problemPlacement, s, vUUId, nil, "%v is placed on %v which span %v zones; expected %v", vUUId, rmIds, len(found), expected)
+	}
+}
+
+func zonesOf(zones map[common.RMId]string, rmIds common.RMIds) map[interface{}]server.EmptyStruct {
+	found := make(map[interface{}]server.EmptyStruct, len(rmIds))
+	for _, rmId := range rmIds {
+		if zone, ok := zones[rmId]; ok {
+			found[zone] = server.EmptyStructVal
+		} else {
+			found[rmId] = server.EmptyStructVal
+		}
+	}
+	return found
+}
+
+// clockCheck compares the clocks of two replicas of a var at the same
+// version. Each replica independently drops elements from its clocks
+// as txns become globally complete, so the clocks need only agree
+// where they overlap, but both must have the elem for the var itself.
+func (lc *locationChecker) clockCheck(vUUId *common.VarUUId, a *store, aCap *msgs.Var, b *store, bCap *msgs.Var) {
+	txnId := common.MakeTxnId(aCap.WriteTxnId())
+	aTxnClock, bTxnClock := eng.VectorClockFromCap(aCap.WriteTxnClock()), eng.VectorClockFromCap(bCap.WriteTxnClock())
+	aElem, aFound := aTxnClock.Clock[*vUUId]
+	bElem, bFound := bTxnClock.Clock[*vUUId]
+	switch {
+	case !aFound || !bFound:
+		lc.report.add(problemClock, b, vUUId, txnId, "WriteTxnClock of %v lacks its own elem: on %v %v; on %v %v", vUUId, a, aTxnClock, b, bTxnClock)
+	case aElem != bElem || !aTxnClock.EqualOnIntersection(bTxnClock):
+		lc.report.add(problemClock, b, vUUId, txnId, "WriteTxnClock of %v differs: on %v %v; on %v %v", vUUId, a, aTxnClock, b, bTxnClock)
+	}
+	aWritesClock, bWritesClock := eng.VectorClockFromCap(aCap.WritesClock()), eng.VectorClockFromCap(bCap.WritesClock())
+	if !aWritesClock.EqualOnIntersection(bWritesClock) {
+		lc.report.add(problemClock, b, vUUId, txnId, "WritesClock of %v differs: on %v %v; on %v %v", vUUId, a, aWritesClock, b, bWritesClock)
+	}
+}
+
+func (ss stores) ByRMId() map[common.RMId]*store {
+	m := make(map[common.RMId]*store, len(ss))
+	for _, s := range ss {
+		m[s.rmId] = s
+	}
+	return m
+}
+
 func (ss stores) CheckEqualTopology() error {
 	var first *store
 	for idx, s := range ss {
@@ -202,11 +367,25 @@ func (ss stores) Shutdown() {
 }
 
 func (s *store) Shutdown() {
-	if s.db == nil {
-		return
+	if s.db != nil {
+		s.db.Shutdown()
+		s.db = nil
+	}
+	if s.lock != nil {
+		server.CheckWarn(s.lock.Close())
+		s.lock = nil
+	}
+}
+
+// Lock prevents a server from starting on the store, and fails if one
+// is already running on it.
+func (s *store) Lock() error {
+	lock, err := db.LockDataDir(s.dir)
+	if err != nil {
+		return err
 	}
-	s.db.Shutdown()
-	s.db = nil
+	s.lock = lock
+	return nil
 }
 
 func (s *store) String() string {
@@ -233,77 +412,13 @@ func (s *store) StartDisk() error {
 }
 
 func (s *store) LoadTopology() error {
-	res, err := s.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		bites, err := rtxn.Get(s.db.Vars, configuration.TopologyVarUUId[:])
-		if err != nil {
-			rtxn.Error(err)
-			return nil
-		}
-		seg, _, err := capn.ReadFromMemoryZeroCopy(bites)
-		if err != nil {
-			rtxn.Error(err)
-			return nil
-		}
-		varCap := msgs.ReadRootVar(seg)
-		txnId := common.MakeTxnId(varCap.WriteTxnId())
-		bites = s.db.ReadTxnBytesFromDisk(rtxn, txnId)
-		if bites == nil {
-			rtxn.Error(fmt.Errorf("Unable to find txn for topology: %v", txnId))
-			return nil
-		}
-		seg, _, err = capn.ReadFromMemoryZeroCopy(bites)
-		if err != nil {
-			rtxn.Error(err)
-			return nil
-		}
-		txnCap := msgs.ReadRootTxn(seg)
-		actions := txnCap.Actions()
-		if actions.Len() != 1 {
-			rtxn.Error(fmt.Errorf("Topology txn has %v actions; expected 1", actions.Len()))
-			return nil
-		}
-		action := actions.At(0)
-		var refs msgs.VarIdPos_List
-		switch action.Which() {
-		case msgs.ACTION_WRITE:
-			w := action.Write()
-			bites = w.Value()
-			refs = w.References()
-		case msgs.ACTION_READWRITE:
-			rw := action.Readwrite()
-			bites = rw.Value()
-			refs = rw.References()
-		case msgs.ACTION_CREATE:
-			c := action.Create()
-			bites = c.Value()
-			refs = c.References()
-		default:
-			rtxn.Error(fmt.Errorf("Expected topology txn action to be w, rw, or c; found %v", action.Which()))
-			return nil
-		}
-
-		if refs.Len() != 1 {
-			rtxn.Error(fmt.Errorf("Topology txn action has %v references; expected 1", refs.Len()))
-			return nil
-		}
-		rootRef := refs.At(0)
-
-		seg, _, err = capn.ReadFromMemoryZeroCopy(bites)
-		if err != nil {
-			rtxn.Error(err)
-			return nil
-		}
-		topology, err := configuration.TopologyFromCap(txnId, &rootRef, bites)
-		if err != nil {
-			rtxn.Error(err)
-			return nil
-		}
-		return topology
-	}).ResultError()
+	topology, err := s.db.LoadTopology()
 	if err != nil {
 		return err
+	} else if topology == nil || topology.Root.VarUUId == nil {
+		return fmt.Errorf("%v: no topology with a root found", s)
 	}
-	s.topology = res.(*configuration.Topology)
+	s.topology = topology
 	return nil
 }
 
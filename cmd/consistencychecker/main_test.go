@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/harness"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newStoppedCluster runs a cluster of three nodes, each of which
+// holds every var, which retains history. The root is written twice,
+// so the txn of the first write is referenced only by the history.
+// The nodes are then stopped, so their stores can be checked. It
+// returns the data dirs and the ids of the two write txns.
+func newStoppedCluster(t *testing.T) (*harness.Cluster, []string, *common.TxnId, *common.TxnId) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c, err := harness.NewCluster(3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.AwaitStable(time.Minute); err == nil {
+		err = c.ChangeConfiguration(func(config *configuration.Configuration) {
+			config.HistoryRetentionSeconds = 3600
+		})
+	}
+	if err == nil {
+		err = c.AwaitStable(time.Minute)
+	}
+	var first, second *common.TxnId
+	if err == nil {
+		first, err = c.Nodes[0].WriteRoot([]byte("First"))
+	}
+	if err == nil {
+		second, err = c.Nodes[0].WriteRoot([]byte("Second"))
+	}
+	if err != nil {
+		c.Shutdown()
+		t.Fatal(err)
+	}
+	// Give the txns time to become globally complete, so that no
+	// paxos state is left behind.
+	time.Sleep(time.Second)
+	dirs := make([]string, len(c.Nodes))
+	for idx, node := range c.Nodes {
+		node.Stop()
+		dirs[idx] = node.DataDir
+	}
+	return c, dirs, first, second
+}
+
+// openTestStore opens the store without locking it. The caller must
+// shut it down.
+func openTestStore(t *testing.T, dir string) *store {
+	s := &store{dir: dir}
+	err := s.LoadRMId()
+	if err == nil {
+		err = s.StartDisk()
+	}
+	if err != nil {
+		s.Shutdown()
+		t.Fatal(err)
+	}
+	return s
+}
+
+// modifyTestStore deliberately breaks the store at dir.
+func modifyTestStore(t *testing.T, dir string, fun func(*store, *mdbs.RWTxn) error) *store {
+	s := openTestStore(t, dir)
+	defer s.Shutdown()
+	_, err := s.db.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
+		if err := fun(s, rwtxn); err != nil {
+			rwtxn.Error(err)
+		}
+		return nil
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// readTestTxn returns the txn from the store at dir, or nil if it
+// is not there.
+func readTestTxn(t *testing.T, dir string, txnId *common.TxnId) []byte {
+	s := openTestStore(t, dir)
+	defer s.Shutdown()
+	bites, err := s.readTxn(txnId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bites
+}
+
+func putRefCount(s *store, rwtxn *mdbs.RWTxn, txnId *common.TxnId, count uint32) error {
+	bites := make([]byte, 4)
+	binary.BigEndian.PutUint32(bites, count)
+	return rwtxn.Put(s.db.TransactionRefs, txnId[:], bites, 0)
+}
+
+func fakeTxnId(n byte) *common.TxnId {
+	return common.MakeTxnId(bytes.Repeat([]byte{n}, common.KeyLen))
+}
+
+// runCheck runs the checks, writes the report to a file, and returns
+// the report as read back from the file, along with the exit code.
+func runCheck(t *testing.T, dirs []string, repair, dryRun bool) (*report, int) {
+	r := newReport(dirs)
+	exitCode := check(r, dirs, repair, dryRun)
+	dir, err := ioutil.TempDir("", "consistencychecker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "report.json")
+	if err = writeReport(r, path); err != nil {
+		t.Fatal(err)
+	}
+	bites, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &report{}
+	if err = json.Unmarshal(bites, decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded, exitCode
+}
+
+// assertProblems checks that the report has exactly the expected
+// problems, in any order. The details are not compared.
+func assertProblems(t *testing.T, r *report, expected ...*problem) {
+	key := func(p *problem) string { return fmt.Sprintf("%v in %v of %v %v", p.Kind, p.Store, p.VarId, p.TxnId) }
+	found := make(map[string]int, len(r.Problems))
+	for _, p := range r.Problems {
+		found[key(p)]++
+	}
+	for _, p := range expected {
+		if found[key(p)] == 0 {
+			t.Fatalf("%v not reported; found %v", key(p), found)
+		}
+		found[key(p)]--
+	}
+	for k, count := range found {
+		if count != 0 {
+			t.Fatalf("%v reported unexpectedly", k)
+		}
+	}
+}
+
+func formatTxnId(txnId *common.TxnId) string {
+	return hex.EncodeToString(txnId[:])
+}
+
+func TestCheckBrokenStores(t *testing.T) {
+	c, dirs, first, second := newStoppedCluster(t)
+	defer c.Shutdown()
+
+	// The txn of the first write is held only by the history, which
+	// the refcounts must account for.
+	r, exitCode := runCheck(t, dirs, false, false)
+	if exitCode != exitConsistent || r.Error != "" {
+		t.Fatalf("Healthy stores gave exit code %v (%v); expected %v", exitCode, r.Error, exitConsistent)
+	}
+	assertProblems(t, r)
+	if r.Vars == 0 {
+		t.Fatal("No vars checked")
+	}
+	for _, dir := range dirs {
+		if readTestTxn(t, dir, first) == nil {
+			t.Fatalf("%v does not retain %v in its history", dir, first)
+		}
+	}
+
+	// A wrong refcount for the current version of the root.
+	broken := modifyTestStore(t, dirs[0], func(s *store, rwtxn *mdbs.RWTxn) error {
+		return putRefCount(s, rwtxn, second, 5)
+	})
+	refCount := &problem{Kind: problemRefCount, Store: broken.String(), TxnId: formatTxnId(second)}
+
+	// A txn left behind with nothing referencing it.
+	orphanTxnId := fakeTxnId(0xfe)
+	txnBites := readTestTxn(t, dirs[1], second)
+	broken = modifyTestStore(t, dirs[1], func(s *store, rwtxn *mdbs.RWTxn) error {
+		return rwtxn.Put(s.db.Transactions, orphanTxnId[:], txnBites, 0)
+	})
+	orphan := &problem{Kind: problemOrphanTxn, Store: broken.String(), TxnId: formatTxnId(orphanTxnId)}
+
+	// A proposer left behind after every acceptor has finished.
+	proposerTxnId := fakeTxnId(0xfd)
+	broken = modifyTestStore(t, dirs[2], func(s *store, rwtxn *mdbs.RWTxn) error {
+		seg := capn.NewBuffer(nil)
+		state := msgs.NewRootProposerState(seg)
+		acceptors := seg.NewUInt32List(len(c.Nodes))
+		for idx, node := range c.Nodes {
+			acceptors.Set(idx, uint32(node.RMId))
+		}
+		state.SetAcceptors(acceptors)
+		return rwtxn.Put(s.db.Proposers, proposerTxnId[:], server.SegToBytes(seg), 0)
+	})
+	staleProposer := &problem{Kind: problemStaleProposer, Store: broken.String(), TxnId: formatTxnId(proposerTxnId)}
+
+	r, exitCode = runCheck(t, dirs, false, false)
+	if exitCode != exitInconsistent || r.Error != "" {
+		t.Fatalf("Broken stores gave exit code %v (%v); expected %v", exitCode, r.Error, exitInconsistent)
+	}
+	assertProblems(t, r, refCount, orphan, staleProposer)
+	if len(r.Dirs) != len(dirs) {
+		t.Fatalf("Report has dirs %v; expected %v", r.Dirs, dirs)
+	}
+
+	// Checks which cannot be completed are neither consistent nor
+	// inconsistent.
+	r, exitCode = runCheck(t, append(dirs, filepath.Join(c.Dir, "missing")), false, false)
+	if exitCode != exitFailed || r.Error == "" {
+		t.Fatalf("Missing store gave exit code %v (%v); expected %v with an error", exitCode, r.Error, exitFailed)
+	}
+}
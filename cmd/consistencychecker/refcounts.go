@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	msgs "goshawkdb.io/server/capnp"
+	"log"
+)
+
+// refCountChecker checks the TransactionRefs of a store. A txn is
+// referenced once by each var whose current version it wrote, and once
+// by each retained past version in VarHistory which it wrote. The
+// tombstones of collected vars in CollectedVars take no references,
+// and nor do acceptors, which keep their own copy of the txn in
+// BallotOutcomes. Every referenced txn must exist in Transactions,
+// and every txn in Transactions must be referenced.
+//
+// When repairing, the references which planned var copies will add
+// are included, and the refcounts are planned to be set to match.
+type refCountChecker struct {
+	store    *store
+	report   *report
+	repairer *repairer
+	expected map[common.TxnId]uint32
+	pending  map[common.TxnId]uint32
+}
+
+func newRefCountChecker(s *store, r *report, rep *repairer) *refCountChecker {
+	return &refCountChecker{
+		store:    s,
+		report:   r,
+		repairer: rep,
+		expected: make(map[common.TxnId]uint32),
+		pending:  rep.pendingRefs(s),
+	}
+}
+
+func (rcc *refCountChecker) check() error {
+	log.Printf("Checking txn refcounts in %v", rcc.store)
+	disk := rcc.store.db
+	_, err := disk.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		if err := rcc.countRefs(rtxn, disk.Vars, func(key, value []byte) []byte { return value }); err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		// history values are prefixed with the time of supersession.
+		if err := rcc.countRefs(rtxn, disk.VarHistory, func(key, value []byte) []byte {
+			if len(value) < 8 {
+				return nil
+			}
+			return value[8:]
+		}); err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		if err := rcc.compareRefs(rtxn); err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		if err := rcc.findOrphans(rtxn); err != nil {
+			rtxn.Error(err)
+		}
+		return nil
+	}).ResultError()
+	return err
+}
+
+// countRefs adds a reference for the write txn of each Var in dbi,
+// using varBytes to find the Var root bytes within each value.
+func (rcc *refCountChecker) countRefs(rtxn *mdbs.RTxn, dbi *mdbs.DBISettings, varBytes func(key, value []byte) []byte) error {
+	_, err := rtxn.WithCursor(dbi, func(cursor *mdbs.Cursor) interface{} {
+		key, value, err := cursor.Get(nil, nil, mdb.FIRST)
+		for ; err == nil; key, value, err = cursor.Get(nil, nil, mdb.NEXT) {
+			bites := varBytes(key, value)
+			if bites == nil {
+				cursor.Error(fmt.Errorf("Malformed entry in %v: %v", rcc.store, key))
+				return nil
+			}
+			seg, _, err := capn.ReadFromMemoryZeroCopy(bites)
+			if err != nil {
+				cursor.Error(fmt.Errorf("Err on decoding var in %v: %v (%v)", rcc.store, err, key))
+				return nil
+			}
+			varCap := msgs.ReadRootVar(seg)
+			rcc.expected[*common.MakeTxnId(varCap.WriteTxnId())]++
+		}
+		if err != nil && err != mdb.NotFound {
+			cursor.Error(err)
+		}
+		return nil
+	})
+	return err
+}
+
+func (rcc *refCountChecker) compareRefs(rtxn *mdbs.RTxn) error {
+	found := make(map[common.TxnId]bool, len(rcc.expected))
+	_, err := rtxn.WithCursor(rcc.store.db.TransactionRefs, func(cursor *mdbs.Cursor) interface{} {
+		key, value, err := cursor.Get(nil, nil, mdb.FIRST)
+		for ; err == nil; key, value, err = cursor.Get(nil, nil, mdb.NEXT) {
+			txnId := common.MakeTxnId(key)
+			found[*txnId] = true
+			expected := rcc.expected[*txnId]
+			if len(value) != 4 {
+				rcc.report.add(problemRefCount, rcc.store, nil, txnId, "Malformed refcount for %v: %v", txnId, value)
+				rcc.repairer.setRefCount(rcc.store, txnId, expected+rcc.pending[*txnId])
+				continue
+			}
+			count := binary.BigEndian.Uint32(value)
+			if count != expected {
+				rcc.report.add(problemRefCount, rcc.store, nil, txnId, "Refcount for %v is %v; %v references found", txnId, count, expected)
+			}
+			if count != expected+rcc.pending[*txnId] {
+				rcc.repairer.setRefCount(rcc.store, txnId, expected+rcc.pending[*txnId])
+			}
+		}
+		if err != nil && err != mdb.NotFound {
+			cursor.Error(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for txnId, expected := range rcc.expected {
+		txnIdCopy := txnId
+		if !found[txnId] {
+			rcc.report.add(problemRefCount, rcc.store, nil, &txnIdCopy, "%v has no refcount; %v references found", &txnIdCopy, expected)
+			if rcc.pending[txnId] == 0 {
+				rcc.repairer.setRefCount(rcc.store, &txnIdCopy, expected)
+			}
+		}
+		if rcc.store.db.ReadTxnBytesFromDisk(rtxn, &txnIdCopy) == nil {
+			rcc.report.add(problemMissingTxn, rcc.store, nil, &txnIdCopy, "%v is referenced but is not in Transactions", &txnIdCopy)
+			rcc.repairer.wantTxn(rcc.store, &txnIdCopy)
+		}
+	}
+	for txnId, pending := range rcc.pending {
+		if !found[txnId] {
+			txnIdCopy := txnId
+			rcc.repairer.setRefCount(rcc.store, &txnIdCopy, rcc.expected[txnId]+pending)
+		}
+	}
+	return nil
+}
+
+func (rcc *refCountChecker) findOrphans(rtxn *mdbs.RTxn) error {
+	_, err := rtxn.WithCursor(rcc.store.db.Transactions, func(cursor *mdbs.Cursor) interface{} {
+		key, _, err := cursor.Get(nil, nil, mdb.FIRST)
+		for ; err == nil; key, _, err = cursor.Get(nil, nil, mdb.NEXT) {
+			txnId := common.MakeTxnId(key)
+			if _, found := rcc.expected[*txnId]; !found {
+				rcc.report.add(problemOrphanTxn, rcc.store, nil, txnId, "%v is in Transactions but nothing references it", txnId)
+				if rcc.pending[*txnId] == 0 {
+					rcc.repairer.setRefCount(rcc.store, txnId, 0)
+				}
+			}
+		}
+		if err != nil && err != mdb.NotFound {
+			cursor.Error(err)
+		}
+		return nil
+	})
+	return err
+}
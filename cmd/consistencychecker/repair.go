@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	eng "goshawkdb.io/server/txnengine"
+	"log"
+	"sort"
+)
+
+// repairer collects the repairs which the checks find possible, and
+// then applies them. Vars which are missing from a store are copied
+// from the healthy replica with the newest version, along with its
+// write txn; if healthy replicas have different txns at the same
+// clock elem, their histories conflict and the var is not copied at
+// all. Txns which are
+// referenced but missing are copied from any store which has them.
+// Refcounts are then set to the number of references the store will
+// have once those copies are made, and txns which will have no
+// references are deleted. Nothing is written until apply is called,
+// so a dry run just describes the plan.
+type repairer struct {
+	stores stores
+	plans  map[common.RMId]*repairPlan
+}
+
+type repairPlan struct {
+	store     *store
+	vars      map[common.VarUUId]*varCopy
+	txns      map[common.TxnId][]byte
+	refCounts map[common.TxnId]uint32
+	// txns wanted in this store, but not yet found elsewhere
+	wanted map[common.TxnId]bool
+}
+
+// varCopy is the newest replica of a var found so far.
+type varCopy struct {
+	bites     []byte
+	txnId     *common.TxnId
+	clockElem uint64
+	conflict  bool
+}
+
+func newRepairer(stores stores) *repairer {
+	plans := make(map[common.RMId]*repairPlan, len(stores))
+	for _, s := range stores {
+		plans[s.rmId] = &repairPlan{
+			store:     s,
+			vars:      make(map[common.VarUUId]*varCopy),
+			txns:      make(map[common.TxnId][]byte),
+			refCounts: make(map[common.TxnId]uint32),
+			wanted:    make(map[common.TxnId]bool),
+		}
+	}
+	return &repairer{
+		stores: stores,
+		plans:  plans,
+	}
+}
+
+// copyVar plans to copy the var, as found in a healthy replica, to
+// the store missing it, unless another healthy replica is newer. The
+// write txn of the var is copied too unless the store already has
+// it.
+func (r *repairer) copyVar(to *store, vUUId *common.VarUUId, varCap *msgs.Var) {
+	if r == nil {
+		return
+	}
+	txnId := common.MakeTxnId(varCap.WriteTxnId())
+	clockElem := eng.VectorClockFromCap(varCap.WriteTxnClock()).Clock[*vUUId]
+	vars := r.plans[to.rmId].vars
+	cur, found := vars[*vUUId]
+	switch {
+	case !found || clockElem > cur.clockElem:
+		vars[*vUUId] = &varCopy{
+			bites:     server.SegToBytes(varCap.Segment),
+			txnId:     txnId,
+			clockElem: clockElem,
+		}
+	case clockElem == cur.clockElem && txnId.Compare(cur.txnId) != common.EQ:
+		cur.conflict = true
+	}
+}
+
+// wantTxn plans to copy the txn from any store which has it, unless
+// to already has it.
+func (r *repairer) wantTxn(to *store, txnId *common.TxnId) {
+	if r == nil {
+		return
+	}
+	r.plans[to.rmId].wanted[*txnId] = true
+}
+
+// pendingRefs returns the number of references to txns which will be
+// added to the store by the planned var copies.
+func (r *repairer) pendingRefs(s *store) map[common.TxnId]uint32 {
+	refs := make(map[common.TxnId]uint32)
+	if r == nil {
+		return refs
+	}
+	for _, vc := range r.plans[s.rmId].vars {
+		if !vc.conflict {
+			refs[*vc.txnId]++
+		}
+	}
+	return refs
+}
+
+func (r *repairer) setRefCount(s *store, txnId *common.TxnId, count uint32) {
+	if r == nil {
+		return
+	}
+	r.plans[s.rmId].refCounts[*txnId] = count
+}
+
+// resolve drops the copies of vars with conflicting histories, and
+// then finds every wanted txn: from the store itself, if it is
+// already there, or else from any other store.
+func (r *repairer) resolve() error {
+	for _, plan := range r.plans {
+		for vUUId, vc := range plan.vars {
+			if vc.conflict {
+				log.Printf("Unable to repair %v: replicas of %v have conflicting histories.", plan.store, common.MakeVarUUId(vUUId[:]))
+				delete(plan.vars, vUUId)
+			} else {
+				plan.wanted[*vc.txnId] = true
+			}
+		}
+		for txnId := range plan.wanted {
+			txnIdCopy := txnId
+			bites, err := plan.store.readTxn(&txnIdCopy)
+			if err != nil {
+				return err
+			} else if bites != nil {
+				continue
+			}
+			for _, s := range r.stores {
+				if s == plan.store {
+					continue
+				} else if bites, err = s.readTxn(&txnIdCopy); err != nil {
+					return err
+				} else if bites != nil {
+					plan.txns[txnId] = bites
+					break
+				}
+			}
+			if bites == nil {
+				log.Printf("Unable to repair %v: %v is not in any store.", plan.store, &txnIdCopy)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *repairer) isEmpty() bool {
+	for _, plan := range r.plans {
+		if len(plan.vars) != 0 || len(plan.txns) != 0 || len(plan.refCounts) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *repairer) summary() {
+	for _, s := range r.stores {
+		plan := r.plans[s.rmId]
+		deletions := 0
+		for _, count := range plan.refCounts {
+			if count == 0 {
+				deletions++
+			}
+		}
+		log.Printf("Repairs for %v: copy %v vars; copy %v txns; set %v refcounts; delete %v txns.",
+			s, len(plan.vars), len(plan.txns), len(plan.refCounts)-deletions, deletions)
+		vUUIds := make([]string, 0, len(plan.vars))
+		for vUUId := range plan.vars {
+			vUUIds = append(vUUIds, common.MakeVarUUId(vUUId[:]).String())
+		}
+		sort.Strings(vUUIds)
+		for _, vUUId := range vUUIds {
+			log.Printf("  copy %v", vUUId)
+		}
+		for txnId := range plan.txns {
+			log.Printf("  copy %v", common.MakeTxnId(txnId[:]))
+		}
+		for txnId, count := range plan.refCounts {
+			if count == 0 {
+				log.Printf("  delete %v", common.MakeTxnId(txnId[:]))
+			} else {
+				log.Printf("  refcount %v = %v", common.MakeTxnId(txnId[:]), count)
+			}
+		}
+	}
+}
+
+// apply makes all the planned repairs, with one write txn per store.
+func (r *repairer) apply() error {
+	for _, s := range r.stores {
+		plan := r.plans[s.rmId]
+		if len(plan.vars) == 0 && len(plan.txns) == 0 && len(plan.refCounts) == 0 {
+			continue
+		}
+		log.Printf("Repairing %v", s)
+		_, err := s.db.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
+			for vUUId, vc := range plan.vars {
+				if err := rwtxn.Put(s.db.Vars, vUUId[:], vc.bites, 0); err != nil {
+					rwtxn.Error(err)
+					return nil
+				}
+			}
+			for txnId, txnBites := range plan.txns {
+				if err := rwtxn.Put(s.db.Transactions, txnId[:], txnBites, 0); err != nil {
+					rwtxn.Error(err)
+					return nil
+				}
+			}
+			for txnId, count := range plan.refCounts {
+				if count == 0 {
+					if err := rwtxn.Del(s.db.TransactionRefs, txnId[:], nil); err != nil && err != mdb.NotFound {
+						rwtxn.Error(err)
+						return nil
+					}
+					if err := rwtxn.Del(s.db.Transactions, txnId[:], nil); err != nil && err != mdb.NotFound {
+						rwtxn.Error(err)
+						return nil
+					}
+				} else {
+					bites := make([]byte, 4)
+					binary.BigEndian.PutUint32(bites, count)
+					if err := rwtxn.Put(s.db.TransactionRefs, txnId[:], bites, 0); err != nil {
+						rwtxn.Error(err)
+						return nil
+					}
+				}
+			}
+			return nil
+		}).ResultError()
+		if err != nil {
+			return fmt.Errorf("Error when repairing %v: %v", s, err)
+		}
+	}
+	return nil
+}
+
+// readTxn returns a copy of the txn's bytes, or nil if the store does
+// not have it.
+func (s *store) readTxn(txnId *common.TxnId) ([]byte, error) {
+	res, err := s.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		if bites := s.db.ReadTxnBytesFromDisk(rtxn, txnId); bites != nil {
+			return append([]byte{}, bites...)
+		}
+		return nil
+	}).ResultError()
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.([]byte), nil
+}
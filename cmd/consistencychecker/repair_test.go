@@ -0,0 +1,169 @@
+package main
+
+import (
+	capn "github.com/glycerine/go-capnproto"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/db"
+	eng "goshawkdb.io/server/txnengine"
+	"strings"
+	"testing"
+)
+
+func testVar(vUUId *common.VarUUId, txnId *common.TxnId, clockElem uint64) *msgs.Var {
+	seg := capn.NewBuffer(nil)
+	varCap := msgs.NewRootVar(seg)
+	varCap.SetId(vUUId[:])
+	varCap.SetPositions(seg.NewUInt8List(0))
+	varCap.SetWriteTxnId(txnId[:])
+	clock := eng.NewVectorClock().Bump(*vUUId, clockElem)
+	varCap.SetWriteTxnClock(clock.AddToSeg(seg))
+	varCap.SetWritesClock(clock.AddToSeg(seg))
+	return &varCap
+}
+
+func TestRepairCopiesNewestReplica(t *testing.T) {
+	to, a, b := &store{rmId: 1}, &store{rmId: 2}, &store{rmId: 3}
+	rep := newRepairer(stores{to, a, b})
+	vUUId := common.MakeVarUUId(make([]byte, common.KeyLen))
+	older, newer := fakeTxnId(1), fakeTxnId(2)
+
+	// Whichever order the healthy replicas are found in, the newest
+	// is copied, and only its txn gains a reference.
+	rep.copyVar(to, vUUId, testVar(vUUId, newer, 2))
+	rep.copyVar(to, vUUId, testVar(vUUId, older, 1))
+	rep.copyVar(to, vUUId, testVar(vUUId, newer, 2))
+	if vc := rep.plans[to.rmId].vars[*vUUId]; vc == nil || vc.conflict || vc.txnId.Compare(newer) != common.EQ {
+		t.Fatalf("Planned copy of %v is %v; expected %v", vUUId, vc, newer)
+	}
+	if refs := rep.pendingRefs(to); len(refs) != 1 || refs[*newer] != 1 {
+		t.Fatalf("Pending refs are %v; expected 1 to %v", refs, newer)
+	}
+	// Nothing is planned for the healthy stores.
+	if len(rep.plans[a.rmId].vars) != 0 || len(rep.plans[b.rmId].vars) != 0 {
+		t.Fatal("Planned to copy vars to healthy stores")
+	}
+}
+
+func TestRepairRefusesConflictingReplicas(t *testing.T) {
+	to, a, b := &store{rmId: 1}, &store{rmId: 2}, &store{rmId: 3}
+	rep := newRepairer(stores{to, a, b})
+	vUUId := common.MakeVarUUId(make([]byte, common.KeyLen))
+
+	// Two replicas with different txns at the same clock elem have
+	// diverged: neither may be copied.
+	rep.copyVar(to, vUUId, testVar(vUUId, fakeTxnId(1), 2))
+	rep.copyVar(to, vUUId, testVar(vUUId, fakeTxnId(2), 2))
+	if vc := rep.plans[to.rmId].vars[*vUUId]; vc == nil || !vc.conflict {
+		t.Fatalf("Planned copy of %v is %v; expected a conflict", vUUId, vc)
+	} else if refs := rep.pendingRefs(to); len(refs) != 0 {
+		t.Fatalf("Pending refs are %v; expected none for a conflict", refs)
+	}
+	if err := rep.resolve(); err != nil {
+		t.Fatal(err)
+	} else if !rep.isEmpty() {
+		t.Fatalf("Repairs planned despite the conflict: %v", rep.plans[to.rmId])
+	}
+
+	// A newer replica supersedes the conflict.
+	rep.copyVar(to, vUUId, testVar(vUUId, fakeTxnId(1), 2))
+	rep.copyVar(to, vUUId, testVar(vUUId, fakeTxnId(2), 2))
+	rep.copyVar(to, vUUId, testVar(vUUId, fakeTxnId(3), 3))
+	if vc := rep.plans[to.rmId].vars[*vUUId]; vc == nil || vc.conflict || vc.txnId.Compare(fakeTxnId(3)) != common.EQ {
+		t.Fatalf("Planned copy of %v is %v; expected %v", vUUId, vc, fakeTxnId(3))
+	}
+}
+
+// readTestVar returns the id of the txn which wrote the var in the
+// store at dir, or nil if the var is not there.
+func readTestVar(t *testing.T, dir string, vUUId *common.VarUUId) *common.TxnId {
+	s := openTestStore(t, dir)
+	defer s.Shutdown()
+	res, err := s.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		bites, err := rtxn.Get(s.db.Vars, vUUId[:])
+		if err == mdb.NotFound {
+			return nil
+		} else if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		seg, _, err := capn.ReadFromMemoryZeroCopy(bites)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		varCap := msgs.ReadRootVar(seg)
+		return common.MakeTxnId(append([]byte{}, varCap.WriteTxnId()...))
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	} else if res == nil {
+		return nil
+	}
+	return res.(*common.TxnId)
+}
+
+func TestRepairBrokenStores(t *testing.T) {
+	c, dirs, _, second := newStoppedCluster(t)
+	defer c.Shutdown()
+
+	s := openTestStore(t, dirs[0])
+	err := s.LoadTopology()
+	s.Shutdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := s.topology.Root.VarUUId
+
+	// The root goes missing from one store, so its txn is left there
+	// unreferenced. Another store gains a txn nothing references.
+	modifyTestStore(t, dirs[0], func(s *store, rwtxn *mdbs.RWTxn) error {
+		return rwtxn.Del(s.db.Vars, root[:], nil)
+	})
+	orphanTxnId := fakeTxnId(0xfe)
+	txnBites := readTestTxn(t, dirs[1], second)
+	modifyTestStore(t, dirs[1], func(s *store, rwtxn *mdbs.RWTxn) error {
+		return rwtxn.Put(s.db.Transactions, orphanTxnId[:], txnBites, 0)
+	})
+	assertUnrepaired := func() {
+		if txnId := readTestVar(t, dirs[0], root); txnId != nil {
+			t.Fatalf("%v has %v at %v; expected it to be missing", dirs[0], root, txnId)
+		} else if readTestTxn(t, dirs[1], orphanTxnId) == nil {
+			t.Fatalf("%v no longer has %v", dirs[1], orphanTxnId)
+		}
+	}
+
+	// A dry run changes nothing.
+	if _, exitCode := runCheck(t, dirs, true, true); exitCode != exitInconsistent {
+		t.Fatalf("Dry run gave exit code %v; expected %v", exitCode, exitInconsistent)
+	}
+	assertUnrepaired()
+
+	// Nor does a repair whilst a server holds the lock on any store.
+	lock, err := db.LockDataDir(dirs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, exitCode := runCheck(t, dirs, true, false)
+	lock.Close()
+	if exitCode != exitFailed || !strings.Contains(r.Error, "Refusing to repair") {
+		t.Fatalf("Repair of a locked store gave exit code %v (%v); expected a refusal", exitCode, r.Error)
+	}
+	assertUnrepaired()
+
+	// The report and exit code describe the problems found before
+	// repair.
+	if _, exitCode = runCheck(t, dirs, true, false); exitCode != exitInconsistent {
+		t.Fatalf("Repair gave exit code %v; expected %v", exitCode, exitInconsistent)
+	}
+	if txnId := readTestVar(t, dirs[0], root); txnId == nil || txnId.Compare(second) != common.EQ {
+		t.Fatalf("%v has %v at %v after repair; expected %v", dirs[0], root, txnId, second)
+	} else if readTestTxn(t, dirs[1], orphanTxnId) != nil {
+		t.Fatalf("%v still has %v after its refcount was repaired to zero", dirs[1], orphanTxnId)
+	}
+	if r, exitCode = runCheck(t, dirs, false, false); exitCode != exitConsistent {
+		t.Fatalf("Repaired stores gave exit code %v with %v problems; expected %v", exitCode, len(r.Problems), exitConsistent)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server/dump"
+	"io"
+	"log"
+	"sync"
+)
+
+// Exit codes.
+const (
+	exitConsistent   = 0
+	exitInconsistent = 1
+	exitFailed       = 2
+)
+
+const (
+	problemLocation           = "Location"
+	problemVersion            = "Version"
+	problemClock              = "Clock"
+	problemPlacement          = "Placement"
+	problemMissingTxn         = "MissingTxn"
+	problemRefCount           = "RefCount"
+	problemOrphanTxn          = "OrphanTxn"
+	problemStaleProposer      = "StaleProposer"
+	problemStaleBallotOutcome = "StaleBallotOutcome"
+)
+
+type problem struct {
+	Kind   string
+	Store  string
+	VarId  string `json:",omitempty"`
+	TxnId  string `json:",omitempty"`
+	Detail string
+}
+
+// report is written as JSON once all the checks have run. Problems
+// are inconsistencies in the data; Error is set if the checks could
+// not be completed.
+type report struct {
+	lock     sync.Mutex
+	Dirs     []string
+	Vars     int
+	Problems []*problem
+	Error    string `json:",omitempty"`
+}
+
+func newReport(dirs []string) *report {
+	return &report{
+		Dirs:     dirs,
+		Problems: []*problem{},
+	}
+}
+
+func (r *report) add(kind string, s *store, vUUId *common.VarUUId, txnId *common.TxnId, format string, args ...interface{}) {
+	p := &problem{
+		Kind:   kind,
+		Store:  s.String(),
+		Detail: fmt.Sprintf(format, args...),
+	}
+	if vUUId != nil {
+		p.VarId = dump.FormatVarUUId(vUUId)
+	}
+	if txnId != nil {
+		p.TxnId = hex.EncodeToString(txnId[:])
+	}
+	log.Printf("%v in %v: %v", kind, s, p.Detail)
+	r.lock.Lock()
+	r.Problems = append(r.Problems, p)
+	r.lock.Unlock()
+}
+
+func (r *report) fail(err error) int {
+	log.Println(err)
+	r.Error = err.Error()
+	return exitFailed
+}
+
+func (r *report) exitCode() int {
+	switch {
+	case r.Error != "":
+		return exitFailed
+	case len(r.Problems) != 0:
+		return exitInconsistent
+	default:
+		return exitConsistent
+	}
+}
+
+func (r *report) Write(w io.Writer) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
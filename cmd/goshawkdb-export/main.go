@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server/dump"
+	_ "goshawkdb.io/server/txnengine"
+	"io"
+	"log"
+	"os"
+	"runtime"
+)
+
+// The data dirs must not be in use by running servers: either stop
+// the cluster first, or export from backups. Every node's dir should
+// be supplied so that every reachable var can be found. Load the
+// resulting dump into a fresh cluster with goshawkdb -import.
+
+func main() {
+	log.SetPrefix(common.ProductName + "Export ")
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	log.Println(os.Args)
+
+	var outFile string
+	flag.StringVar(&outFile, "out", "", "`Path` to write the JSON dump to (default stdout).")
+	flag.Parse()
+
+	dirs := flag.Args()
+	if len(dirs) == 0 {
+		log.Fatal("No dirs supplied")
+	}
+
+	runtime.GOMAXPROCS(1 + (2 * len(dirs)))
+
+	stores := dump.Stores(make([]*dump.Store, 0, len(dirs)))
+	defer stores.Shutdown()
+	for _, dir := range dirs {
+		log.Printf("...loading from %v\n", dir)
+		store, err := dump.OpenStore(dir)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		stores = append(stores, store)
+	}
+
+	if err := stores.CheckEqualTopology(); err != nil {
+		log.Println(err)
+		return
+	}
+
+	d, err := dump.Export(stores)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	var out io.Writer = os.Stdout
+	if outFile != "" {
+		file, err := os.Create(outFile)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer file.Close()
+		out = file
+	}
+	if err = d.Write(out); err != nil {
+		log.Println(err)
+	} else {
+		log.Printf("Exported %v vars.", len(d.Vars))
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	goshawk "goshawkdb.io/server"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type adminServer struct {
+	*server
+	listener net.Listener
+	mux      *http.ServeMux
+}
+
+// newAdminServer serves over HTTPS on every interface if adminTLS is
+// set, requiring client certificates signed by the cluster
+// certificate. Otherwise it serves plain HTTP, and only on the
+// loopback interface, as there is no authentication.
+func newAdminServer(s *server) (*adminServer, error) {
+	host := "127.0.0.1"
+	if s.adminTLS {
+		host = ""
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("%v:%v", host, s.adminPort))
+	if err != nil {
+		return nil, err
+	}
+	if s.adminTLS {
+		// The node certificate follows any switch of the cluster
+		// certificate, but the trusted cluster certificates are those
+		// at start up.
+		config := s.connectionManager.TLSConfig()
+		config.Certificates = nil
+		config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &s.connectionManager.TLSConfig().Certificates[0], nil
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.RootCAs = nil
+		listener = tls.NewListener(listener, config)
+	}
+
+	as := &adminServer{
+		server:   s,
+		listener: listener,
+		mux:      http.NewServeMux(),
+	}
+	as.mux.HandleFunc("/status", as.serveStatus)
+	as.mux.HandleFunc("/metrics", serveMetrics)
+	as.mux.HandleFunc("/backup", as.serveBackup)
+	as.mux.HandleFunc("/history", as.serveHistory)
+	as.mux.HandleFunc("/changes", as.serveChanges)
+	if s.enableFaults {
+		as.mux.HandleFunc("/faults", as.serveFaults)
+	}
+	as.mux.HandleFunc("/plan-config", as.servePlanConfig)
+	as.mux.HandleFunc("/topology", as.serveTopology)
+	as.mux.HandleFunc("/abort-config", as.serveAbortConfig)
+	// Revoking clients and rotating the cluster certificate must only
+	// be possible for holders of a certificate signed by the cluster
+	// certificate: plain HTTP on the loopback interface is not enough.
+	if s.adminTLS {
+		as.mux.HandleFunc("/revoked-clients", as.serveRevokedClients)
+		as.mux.HandleFunc("/cluster-certs", as.serveClusterCerts)
+	}
+
+	go func() {
+		log.Printf("Admin listener on %v (TLS: %v)", listener.Addr(), s.adminTLS)
+		if err := http.Serve(listener, as.mux); err != nil {
+			log.Println("Admin listener:", err)
+		}
+	}()
+	return as, nil
+}
+
+// newMetricsListener serves only the metrics, over plain HTTP, and
+// only on the loopback interface.
+func newMetricsListener(port uint16) (net.Listener, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%v", port))
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", serveMetrics)
+	go func() {
+		log.Printf("Metrics listener on %v", listener.Addr())
+		if err := http.Serve(listener, mux); err != nil {
+			log.Println("Metrics listener:", err)
+		}
+	}()
+	return listener, nil
+}
+
+func (as *adminServer) Shutdown() {
+	goshawk.CheckWarn(as.listener.Close())
+}
+
+func (as *adminServer) serveStatus(w http.ResponseWriter, r *http.Request) {
+	nodeChan := make(chan *goshawk.StatusNode, 1)
+	sc := goshawk.NewStatusConsumer()
+	go sc.ConsumeNode(func(node *goshawk.StatusNode) { nodeChan <- node })
+	as.status(sc)
+
+	select {
+	case node := <-nodeChan:
+		w.Header().Set("Content-Type", "application/json")
+		goshawk.CheckWarn(json.NewEncoder(w).Encode(node))
+	case <-time.After(goshawk.AdminStatusTimeout):
+		http.Error(w, "Timed out gathering status", http.StatusServiceUnavailable)
+	}
+}
+
+// serveBackup requires a POST with a path parameter naming the
+// directory to write the backup to, relative to the -backup-root
+// directory. The manifest is returned.
+func (as *adminServer) serveBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Backup requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if as.backupRoot == "" {
+		http.Error(w, "Backups are disabled: no -backup-root supplied", http.StatusForbidden)
+		return
+	}
+	path := r.FormValue("path")
+	if path == "" {
+		http.Error(w, "No path supplied", http.StatusBadRequest)
+		return
+	}
+	target := filepath.Join(as.backupRoot, path)
+	if rel, err := filepath.Rel(as.backupRoot, target); err != nil || rel == "." || filepath.IsAbs(path) || strings.HasPrefix(rel, "..") {
+		http.Error(w, fmt.Sprintf("Illegal path %v: must be within the backup root", path), http.StatusBadRequest)
+		return
+	}
+	manifest, err := backup(as.disk, as.dataDir, target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	goshawk.CheckWarn(json.NewEncoder(w).Encode(manifest))
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, err := goshawk.Metrics.WriteTo(w)
+	goshawk.CheckWarn(err)
+}
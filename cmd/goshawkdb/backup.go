@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	goshawk "goshawkdb.io/server"
+	"goshawkdb.io/server/db"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const backupManifestFile = "backup.json"
+
+type backupManifest struct {
+	Taken           time.Time
+	DataDir         string
+	ClusterId       string `json:",omitempty"`
+	TopologyVersion uint32
+	TopologyTxnId   string `json:",omitempty"`
+}
+
+// backup writes a consistent copy of the database in dataDir to
+// target, along with the rmid and bootcount files. The MDB copy is
+// taken by the disk server, so writes queue behind it, but the node
+// otherwise keeps serving throughout. The copy is then opened in
+// order to record in the manifest the topology it contains.
+func backup(disk *db.Databases, dataDir, target string) (*backupManifest, error) {
+	if err := os.MkdirAll(target, 0750); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"data.mdb", backupManifestFile} {
+		if _, err := os.Stat(filepath.Join(target, name)); err == nil {
+			return nil, fmt.Errorf("Backup target %v already contains %v", target, name)
+		}
+	}
+
+	manifest := &backupManifest{
+		Taken:   time.Now(),
+		DataDir: dataDir,
+	}
+	log.Printf("Backup of %v to %v starting.", dataDir, target)
+	_, err := disk.WithEnv(func(env *mdb.Env) (interface{}, error) {
+		return nil, env.Copy(target)
+	}).ResultError()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range []string{"rmid", "bootcount"} {
+		src := filepath.Join(dataDir, name)
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		bites, err := ioutil.ReadFile(src)
+		if err != nil {
+			return nil, err
+		}
+		if err = ioutil.WriteFile(filepath.Join(target, name), bites, info.Mode()); err != nil {
+			return nil, err
+		}
+	}
+
+	copyDisk, err := mdbs.NewMDBServer(target, 0, 0600, goshawk.MDBInitialSize, 1, time.Millisecond, db.DB)
+	if err != nil {
+		return nil, err
+	}
+	topology, err := copyDisk.(*db.Databases).LoadTopology()
+	copyDisk.(*db.Databases).Shutdown()
+	if err != nil {
+		return nil, err
+	}
+	if topology != nil {
+		manifest.ClusterId = topology.ClusterId
+		manifest.TopologyVersion = topology.Version
+		manifest.TopologyTxnId = topology.DBVersion.String()
+	}
+
+	bites, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(filepath.Join(target, backupManifestFile), bites, 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("Backup of %v to %v completed at topology version %v.", dataDir, target, manifest.TopologyVersion)
+	return manifest, nil
+}
+
+func offlineBackup(dataDir, target string) error {
+	disk, err := mdbs.NewMDBServer(dataDir, 0, 0600, goshawk.MDBInitialSize, 1, time.Millisecond, db.DB)
+	if err != nil {
+		return err
+	}
+	defer disk.(*db.Databases).Shutdown()
+	_, err = backup(disk.(*db.Databases), dataDir, target)
+	return err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server/harness"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupRestore(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c, err := harness.NewCluster(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+	if err = c.AwaitStable(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	node := c.Nodes[0]
+
+	value := []byte("Backed up")
+	txnId, err := node.WriteRoot(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	as := &adminServer{server: &server{
+		dataDir:    node.DataDir,
+		disk:       node.Disk(),
+		backupRoot: filepath.Join(c.Dir, "backups"),
+	}}
+	rec := httptest.NewRecorder()
+	as.serveBackup(rec, httptest.NewRequest("POST", "/backup?path=nightly", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Backup failed with %v: %v", rec.Code, rec.Body.String())
+	}
+	manifest := &backupManifest{}
+	if err = json.Unmarshal(rec.Body.Bytes(), manifest); err != nil {
+		t.Fatal(err)
+	}
+	topology := node.Topology()
+	if manifest.ClusterId != topology.ClusterId || manifest.TopologyVersion != topology.Version {
+		t.Fatalf("Backup manifest records %v at version %v; expected %v at version %v",
+			manifest.ClusterId, manifest.TopologyVersion, topology.ClusterId, topology.Version)
+	}
+	target := filepath.Join(as.backupRoot, "nightly")
+	for _, name := range []string{"rmid", "bootcount"} {
+		expected, err := ioutil.ReadFile(filepath.Join(node.DataDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bites, err := ioutil.ReadFile(filepath.Join(target, name)); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(bites, expected) {
+			t.Fatalf("Backup of %v contains %v; expected %v", name, bites, expected)
+		}
+	}
+
+	// A backup never overwrites another.
+	rec = httptest.NewRecorder()
+	as.serveBackup(rec, httptest.NewRequest("POST", "/backup?path=nightly", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Second backup to the same path gave %v; expected %v", rec.Code, http.StatusInternalServerError)
+	}
+
+	// The node kept serving throughout, but its later writes must not
+	// survive the restore.
+	if _, err = node.WriteRoot([]byte("After backup")); err != nil {
+		t.Fatal(err)
+	}
+	node.Stop()
+	bites, err := ioutil.ReadFile(filepath.Join(target, "data.mdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(node.DataDir, "data.mdb"), bites, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filepath.Join(node.DataDir, "lock.mdb")); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if err = node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.AwaitStable(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	read, readTxnId, err := node.ReadRoot()
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(read, value) || readTxnId.Compare(txnId) != common.EQ {
+		t.Fatalf("%v read %q@%v after restore; expected %q@%v", node, read, readTxnId, value, txnId)
+	}
+}
+
+func TestBackupConfinedToRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "backups")
+	as := &adminServer{server: &server{backupRoot: root}}
+
+	for _, path := range []string{"", ".", "a/..", "../escape", "a/../../escape", "/escape", root} {
+		rec := httptest.NewRecorder()
+		as.serveBackup(rec, httptest.NewRequest("POST", "/backup?path="+url.QueryEscape(path), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("Backup to %q gave %v; expected %v", path, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(dir, "escape")); !os.IsNotExist(err) {
+		t.Fatalf("Backup escaped the backup root: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	as.serveBackup(rec, httptest.NewRequest("GET", "/backup?path=nightly", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET backup gave %v; expected %v", rec.Code, http.StatusMethodNotAllowed)
+	}
+	as.backupRoot = ""
+	rec = httptest.NewRecorder()
+	as.serveBackup(rec, httptest.NewRequest("POST", "/backup?path=nightly", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Backup without a backup root gave %v; expected %v", rec.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"goshawkdb.io/common/certs"
+	goshawk "goshawkdb.io/server"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const revokedClientsFile = "revoked-clients"
+
+func (s *server) revokedClientsPath() string {
+	return filepath.Join(s.dataDir, revokedClientsFile)
+}
+
+// loadRevokedClients reads the fingerprints of the revoked client
+// certificates from the data directory: one hex fingerprint per line.
+func (s *server) loadRevokedClients() ([][sha256.Size]byte, error) {
+	file, err := os.Open(s.revokedClientsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fingerprints := [][sha256.Size]byte{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			fingerprint, err := parseFingerprint(line)
+			if err != nil {
+				return nil, fmt.Errorf("Error in %v: %v", s.revokedClientsPath(), err)
+			}
+			fingerprints = append(fingerprints, fingerprint)
+		}
+	}
+	return fingerprints, scanner.Err()
+}
+
+// saveRevokedClients replaces the revoked client certificates in the
+// data directory with those currently revoked.
+func (s *server) saveRevokedClients() error {
+	buf := new(bytes.Buffer)
+	for _, fingerprint := range s.connectionManager.RevokedClientCertificates() {
+		fmt.Fprintln(buf, fingerprint)
+	}
+	path := s.revokedClientsPath()
+	if err := ioutil.WriteFile(path+".tmp", buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func parseFingerprint(str string) ([sha256.Size]byte, error) {
+	fingerprint := [sha256.Size]byte{}
+	bites, err := hex.DecodeString(str)
+	if err != nil {
+		return fingerprint, fmt.Errorf("Illegal fingerprint %v: %v", str, err)
+	} else if len(bites) != sha256.Size {
+		return fingerprint, fmt.Errorf("Illegal fingerprint %v: must be %v bytes long", str, sha256.Size)
+	}
+	copy(fingerprint[:], bites)
+	return fingerprint, nil
+}
+
+// parseCertificates returns every certificate in the PEM encoded
+// bites. Other blocks, such as private keys, are ignored.
+func parseCertificates(bites []byte) ([]*x509.Certificate, error) {
+	result := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, bites = pem.Decode(bites)
+		if block == nil {
+			break
+		} else if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, cert)
+	}
+	if len(result) == 0 {
+		return nil, errors.New("No certificates found")
+	}
+	return result, nil
+}
+
+// serveRevokedClients controls the client certificates revoked on
+// this node. GET returns the fingerprints revoked. POST revokes the
+// fingerprint parameters, immediately closing any connections from
+// clients using them, and DELETE unrevokes them. The revocations are
+// kept in the data directory, so survive restarts, but must be made
+// on every node. Clients with revoked certificates should also be
+// removed from the configuration. It is only served with -admin-tls.
+func (as *adminServer) serveRevokedClients(w http.ResponseWriter, r *http.Request) {
+	cm := as.connectionManager
+	switch r.Method {
+	case "GET":
+	case "POST", "DELETE":
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fingerprintStrs := r.Form["fingerprint"]
+		if len(fingerprintStrs) == 0 {
+			http.Error(w, "No fingerprint supplied", http.StatusBadRequest)
+			return
+		}
+		fingerprints := make([][sha256.Size]byte, len(fingerprintStrs))
+		for idx, str := range fingerprintStrs {
+			fingerprint, err := parseFingerprint(str)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			fingerprints[idx] = fingerprint
+		}
+		if r.Method == "POST" {
+			cm.RevokeClientCertificates(fingerprints...)
+		} else {
+			cm.UnrevokeClientCertificates(fingerprints...)
+		}
+		if err := as.saveRevokedClients(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Revoked clients requires GET, POST or DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	goshawk.CheckWarn(json.NewEncoder(w).Encode(cm.RevokedClientCertificates()))
+}
+
+// clusterCertificatesReport is served by the cluster certificates
+// endpoint. Fingerprints are of the cluster certificates.
+type clusterCertificatesReport struct {
+	Presented string
+	Trusted   []string
+}
+
+// serveClusterCerts controls the cluster certificates used by this
+// node, for rotating the cluster certificate without a restart. GET
+// returns the cluster certificate of the node certificate presented,
+// and those trusted. POST with phase=trust trusts the PEM encoded
+// cluster certificates in the body, in addition to those already
+// trusted. POST with phase=switch takes a PEM encoded cluster
+// certificate and key, as would be passed with -cert, and switches to
+// presenting a node certificate signed by it; every node must trust
+// it first. DELETE untrusts the cluster certificate with the
+// fingerprint parameter, once no node presents it. None of this is
+// kept over restarts: use -cert and -trust-cert. It is only served
+// with -admin-tls.
+func (as *adminServer) serveClusterCerts(w http.ResponseWriter, r *http.Request) {
+	cm := as.connectionManager
+	switch r.Method {
+	case "GET":
+	case "POST":
+		bites, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch phase := r.URL.Query().Get("phase"); phase {
+		case "trust":
+			clusterCerts, err := parseCertificates(bites)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for _, cert := range clusterCerts {
+				cm.TrustClusterCertificate(cert)
+			}
+		case "switch":
+			nodeCertPrivKeyPair, err := certs.GenerateNodeCertificatePrivateKeyPair(bites)
+			for idx := range bites {
+				bites[idx] = 0
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			cm.SetNodeCertificatePrivateKeyPair(nodeCertPrivKeyPair)
+		default:
+			http.Error(w, fmt.Sprintf("Illegal phase: %v (must be trust or switch)", phase), http.StatusBadRequest)
+			return
+		}
+	case "DELETE":
+		fingerprint, err := parseFingerprint(r.URL.Query().Get("fingerprint"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := cm.UntrustClusterCertificate(fingerprint); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	default:
+		http.Error(w, "Cluster certificates requires GET, POST or DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+	presented, trusted := cm.ClusterCertificates()
+	w.Header().Set("Content-Type", "application/json")
+	goshawk.CheckWarn(json.NewEncoder(w).Encode(&clusterCertificatesReport{Presented: presented, Trusted: trusted}))
+}
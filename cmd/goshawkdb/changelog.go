@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	goshawk "goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	eng "goshawkdb.io/server/txnengine"
+	"net/http"
+	"path/filepath"
+	"strconv"
+)
+
+const changeLogFile = "changes.log"
+
+func (s *server) changeLogPath() string {
+	return filepath.Join(s.dataDir, changeLogFile)
+}
+
+// changeRecord is a record of the change log as served by the
+// changes endpoint. Position is the position of the following
+// record: a consumer which has processed this record resumes from
+// there. TxnId is the hex id of the committed txn, by which
+// consumers of several nodes deduplicate.
+type changeRecord struct {
+	Position int64
+	TxnId    string
+	Outcome  json.RawMessage
+}
+
+var errChangesLimitReached = errors.New("Limit reached")
+
+// serveChanges writes the records of the change log from the from
+// parameter (default 0) onwards, one JSON object per line. from must
+// be 0 or the position of a record. At most the limit parameter
+// (default and maximum goshawk.ChangeLogServeLimit) records are
+// written: a consumer continues from the position of the last. Only
+// records which are synced to disk are written.
+//
+// Only this node's change log is served; there is no deduplication
+// across nodes. A txn is recorded by every node which holds a var it
+// writes, at a different position in each log, so a consumer which
+// follows several nodes, as it must to see every change, must
+// discard records whose TxnId it has already processed. Each node's
+// log is causally ordered, and records from different nodes can be
+// ordered by the commit clocks in their outcomes.
+func (as *adminServer) serveChanges(w http.ResponseWriter, r *http.Request) {
+	if as.changes == nil {
+		http.Error(w, "Change log is not enabled (missing -change-log parameter)", http.StatusNotFound)
+		return
+	}
+	from := int64(0)
+	if fromStr := r.FormValue("from"); fromStr != "" {
+		var err error
+		if from, err = strconv.ParseInt(fromStr, 10, 64); err != nil || from < 0 {
+			http.Error(w, fmt.Sprintf("Illegal from: %v", fromStr), http.StatusBadRequest)
+			return
+		}
+	}
+	limit := goshawk.ChangeLogServeLimit
+	if limitStr := r.FormValue("limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 || limit > goshawk.ChangeLogServeLimit {
+			http.Error(w, fmt.Sprintf("Illegal limit: %v (must be between 1 and %v)", limitStr, goshawk.ChangeLogServeLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	count := 0
+	_, err := as.changes.ReadSynced(from, func(position int64, outcome *msgs.Outcome) error {
+		bites, err := outcome.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		txn := outcome.Txn()
+		record := &changeRecord{Position: position, TxnId: hex.EncodeToString(txn.Id()), Outcome: bites}
+		if err = encoder.Encode(record); err != nil {
+			return err
+		}
+		count++
+		if count == limit {
+			return errChangesLimitReached
+		}
+		return nil
+	})
+	switch {
+	case err == eng.ErrChangeLogPosition && count == 0:
+		http.Error(w, fmt.Sprintf("Illegal from: %v is not the position of a record", from), http.StatusBadRequest)
+	case err != errChangesLimitReached:
+		goshawk.CheckWarn(err)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	goshawk "goshawkdb.io/server"
+	"goshawkdb.io/server/network"
+	"net/http"
+)
+
+// serveFaults controls the faults injected into the links to other
+// nodes. GET returns the current seed and rules. POST replaces them
+// with those in the body, which has the same form: an object with a
+// Seed and a map of Rules from RMId (0 for every node) to rule, where
+// MaxDelay is in nanoseconds. DELETE removes all the rules. It is only
+// served with -enable-faults.
+func (as *adminServer) serveFaults(w http.ResponseWriter, r *http.Request) {
+	faults := as.connectionManager.Faults
+	switch r.Method {
+	case "GET":
+	case "POST":
+		config := network.FaultConfig{}
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, fmt.Sprintf("Illegal fault configuration: %v", err), http.StatusBadRequest)
+			return
+		}
+		faults.SetConfig(config)
+	case "DELETE":
+		faults.Clear()
+	default:
+		http.Error(w, "Faults requires GET, POST or DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	goshawk.CheckWarn(json.NewEncoder(w).Encode(faults.Config()))
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	goshawk "goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/dump"
+	eng "goshawkdb.io/server/txnengine"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historicVar is a version of a var as returned by the history
+// endpoint. SupersededAt is nil for the current version.
+type historicVar struct {
+	ClockElem    uint64
+	SupersededAt *time.Time
+	Var          *dump.Var
+}
+
+// serveHistory returns past versions of the vars named by the var
+// parameters, "as of" either the txn parameter, which must be the id
+// of the txn which wrote the version of the single var given, or the
+// clock parameter. The clock is either a single clock elem, n, for a
+// single var, or a vector clock, <var>:<n>,<var>:<n>,..., such as
+// that of a committed txn, which must contain every var given. For
+// each var, the latest version with a clock elem for the var no
+// greater than the clock's is returned.
+//
+// The result is a list with one version per var, in the order the
+// vars were given. Only this node's disk is consulted, within a
+// single read txn, so every var must be held by this node, and the
+// versions must be no older than the cluster's history retention
+// window.
+//
+// As-of reads are only partly delivered: they are not routed through
+// the cluster to the RMs of each var, and clients cannot make them,
+// as the client protocol, defined in goshawkdb.io/common, has no way
+// to express them. Nodes have no way to reach each other's admin
+// servers, so to read a var which this node does not hold, ask the
+// admin server of a node which does.
+func (as *adminServer) serveHistory(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	vUUIds := make([]*common.VarUUId, len(r.Form["var"]))
+	for idx, str := range r.Form["var"] {
+		vUUId, err := dump.ParseVarUUId(str)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Illegal var: %v", err), http.StatusBadRequest)
+			return
+		}
+		vUUIds[idx] = vUUId
+	}
+	if len(vUUIds) == 0 {
+		http.Error(w, "No var supplied", http.StatusBadRequest)
+		return
+	}
+	var txnId *common.TxnId
+	var clock map[common.VarUUId]uint64
+	switch txnStr, clockStr := r.FormValue("txn"), r.FormValue("clock"); {
+	case txnStr != "" && clockStr == "":
+		bites, err := hex.DecodeString(txnStr)
+		if err != nil || len(bites) != common.KeyLen {
+			http.Error(w, fmt.Sprintf("Illegal txn: %v", txnStr), http.StatusBadRequest)
+			return
+		} else if len(vUUIds) != 1 {
+			http.Error(w, "Exactly one var must be supplied with txn", http.StatusBadRequest)
+			return
+		}
+		txnId = common.MakeTxnId(bites)
+	case txnStr == "" && clockStr != "":
+		var err error
+		if clock, err = parseHistoryClock(clockStr, vUUIds); err != nil {
+			http.Error(w, fmt.Sprintf("Illegal clock: %v", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Exactly one of txn or clock must be supplied", http.StatusBadRequest)
+		return
+	}
+
+	res, err := as.disk.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		hvs := make([]*historicVar, len(vUUIds))
+		for idx, vUUId := range vUUIds {
+			hv, err := findHistoricVar(as.disk, rtxn, vUUId, txnId, clock[*vUUId])
+			if err != nil {
+				rtxn.Error(err)
+				return nil
+			} else if hv == nil {
+				return vUUId
+			}
+			hvs[idx] = hv
+		}
+		return hvs
+	}).ResultError()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch resT := res.(type) {
+	case []*historicVar:
+		w.Header().Set("Content-Type", "application/json")
+		goshawk.CheckWarn(json.NewEncoder(w).Encode(resT))
+	case *common.VarUUId:
+		http.Error(w, fmt.Sprintf("Version of %v not found on this node", resT), http.StatusNotFound)
+	default:
+		http.Error(w, "Version not found", http.StatusNotFound)
+	}
+}
+
+// parseHistoryClock parses either a single clock elem, for a single
+// var, or a vector clock which must contain every var.
+func parseHistoryClock(str string, vUUIds []*common.VarUUId) (map[common.VarUUId]uint64, error) {
+	clock := make(map[common.VarUUId]uint64)
+	if !strings.Contains(str, ":") {
+		if len(vUUIds) != 1 {
+			return nil, errors.New("A vector clock must be supplied with several vars")
+		}
+		elem, err := strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		clock[*vUUIds[0]] = elem
+		return clock, nil
+	}
+	for _, pair := range strings.Split(str, ",") {
+		fields := strings.Split(pair, ":")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Expected <var>:<elem>; found %v", pair)
+		}
+		vUUId, err := dump.ParseVarUUId(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		elem, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		clock[*vUUId] = elem
+	}
+	for _, vUUId := range vUUIds {
+		if _, found := clock[*vUUId]; !found {
+			return nil, fmt.Errorf("No elem for var %v", vUUId)
+		}
+	}
+	return clock, nil
+}
+
+// findHistoricVar returns nil, nil if there is no such version on
+// disk. If txnId is nil, the version is found by clockElem.
+func findHistoricVar(disk *db.Databases, rtxn *mdbs.RTxn, vUUId *common.VarUUId, txnId *common.TxnId, clockElem uint64) (*historicVar, error) {
+	matches := func(varCap *msgs.Var, elem uint64) bool {
+		if txnId == nil {
+			return elem <= clockElem
+		}
+		return txnId.Compare(common.MakeTxnId(varCap.WriteTxnId())) == common.EQ
+	}
+
+	bites, err := rtxn.Get(disk.Vars, vUUId[:])
+	if err == nil {
+		seg, _, err := capn.ReadFromMemoryZeroCopy(bites)
+		if err != nil {
+			return nil, err
+		}
+		varCap := msgs.ReadRootVar(seg)
+		elem := eng.VectorClockFromCap(varCap.WriteTxnClock()).Clock[*vUUId]
+		if matches(&varCap, elem) {
+			return makeHistoricVar(disk, rtxn, vUUId, &varCap, elem, nil)
+		}
+	} else if err != mdb.NotFound {
+		return nil, err
+	}
+
+	entries, err := disk.ReadVarHistory(rtxn, vUUId)
+	if err != nil {
+		return nil, err
+	}
+	// newest first, so by clock we find the latest version which is
+	// no later than clockElem.
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		entry := entries[idx]
+		if matches(entry.Var, entry.ClockElem) {
+			return makeHistoricVar(disk, rtxn, vUUId, entry.Var, entry.ClockElem, &entry.SupersededAt)
+		}
+	}
+	return nil, nil
+}
+
+func makeHistoricVar(disk *db.Databases, rtxn *mdbs.RTxn, vUUId *common.VarUUId, varCap *msgs.Var, elem uint64, supersededAt *time.Time) (*historicVar, error) {
+	txnId := common.MakeTxnId(varCap.WriteTxnId())
+	bites := disk.ReadTxnBytesFromDisk(rtxn, txnId)
+	if bites == nil {
+		return nil, fmt.Errorf("Unable to find txn %v which wrote %v", txnId, vUUId)
+	}
+	seg, _, err := capn.ReadFromMemoryZeroCopy(bites)
+	if err != nil {
+		return nil, err
+	}
+	txnCap := msgs.ReadRootTxn(seg)
+	v, _, err := dump.MakeVar(vUUId, varCap, &txnCap)
+	if err != nil {
+		return nil, err
+	}
+	// copy the value as we're outside the txn by the time we encode it.
+	v.Value = append([]byte{}, v.Value...)
+	return &historicVar{
+		ClockElem:    elem,
+		SupersededAt: supersededAt,
+		Var:          v,
+	}, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"goshawkdb.io/server/dump"
+	"goshawkdb.io/server/network"
+	"log"
+	"os"
+)
+
+// loadImportFile reads the dump written by goshawkdb-export which is
+// to be loaded into the cluster.
+func (s *server) loadImportFile() (*dump.Dump, error) {
+	file, err := os.Open(s.importFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return dump.Read(file)
+}
+
+// runImport loads the dump into the cluster once it has formed. The
+// cluster must be fresh: see dump.Import.
+func (s *server) runImport(d *dump.Dump, cm *network.ConnectionManager) {
+	log.Printf("Import of %v vars from %v waiting for cluster.", len(d.Vars), s.importFile)
+	if err := dump.Import(d, cm); err != nil {
+		log.Println("Import failed:", err)
+		return
+	}
+	log.Printf("Import of %v vars from %v completed.", len(d.Vars), s.importFile)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/binary"
 	"encoding/hex"
 	"flag"
@@ -14,11 +15,13 @@ import (
 	"goshawkdb.io/server/db"
 	"goshawkdb.io/server/network"
 	"goshawkdb.io/server/paxos"
+	eng "goshawkdb.io/server/txnengine"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"runtime/trace"
@@ -43,17 +46,34 @@ func main() {
 }
 
 func newServer() (*server, error) {
-	var configFile, dataDir, certFile string
-	var port int
-	var version, genClusterCert, genClientCert bool
+	var configFile, dataDir, certFile, trustCertFile, backupDir, backupRoot, importFile, planConfigFile, auditLog string
+	var port, adminPort, metricsPort int
+	var auditLogSize int64
+	var version, genClusterCert, genClientCert, adminTLS, enableFaults, changeLog, auditLogValues bool
+	var gcInterval, gcTombstoneRetention time.Duration
 
 	flag.StringVar(&configFile, "config", "", "`Path` to configuration file (required to start server).")
 	flag.StringVar(&dataDir, "dir", "", "`Path` to data directory (required to run server).")
 	flag.StringVar(&certFile, "cert", "", "`Path` to cluster certificate and key file (required to run server).")
+	flag.StringVar(&trustCertFile, "trust-cert", "", "`Path` to further cluster certificates to trust, whilst rotating the cluster certificate (optional).")
 	flag.IntVar(&port, "port", common.DefaultPort, "Port to listen on (required if non-default).")
+	flag.IntVar(&adminPort, "admin-port", 0, "Port for the admin HTTP listener (optional; disabled if 0). Only listens on localhost unless -admin-tls is given.")
+	flag.IntVar(&metricsPort, "metrics-port", 0, "Port on localhost for serving metrics (optional; disabled if 0).")
+	flag.BoolVar(&adminTLS, "admin-tls", false, "Serve the admin listener over HTTPS on every interface, requiring client certificates signed by the cluster certificate.")
+	flag.BoolVar(&enableFaults, "enable-faults", false, "Serve /faults on the admin listener, allowing faults to be injected into the links to other nodes. For testing only: never use in production.")
 	flag.BoolVar(&version, "version", false, "Display version and exit.")
 	flag.BoolVar(&genClusterCert, "gen-cluster-cert", false, "Generate new cluster certificate key pair.")
 	flag.BoolVar(&genClientCert, "gen-client-cert", false, "Generate client certificate key pair.")
+	flag.StringVar(&backupDir, "backup", "", "`Path` to write a backup of the data directory to, then exit. The data directory may be in use by a running server.")
+	flag.StringVar(&backupRoot, "backup-root", "", "`Path` to the directory under which backups requested through the admin listener are written (optional; such backups are disabled if empty).")
+	flag.DurationVar(&gcInterval, "gc-interval", 0, "Interval between garbage collections of vars unreachable from the root (optional; disabled if 0). Should be set on every node.")
+	flag.DurationVar(&gcTombstoneRetention, "gc-tombstone-retention", 24*time.Hour, "How long the tombstones of garbage collected vars are kept. Whilst a var's tombstone is kept, txns which use the var fail with an error; afterwards, the var is seen to be empty.")
+	flag.BoolVar(&changeLog, "change-log", false, "Record committed txns which write to this node's vars in a change log in the data directory, served by the admin listener at /changes.")
+	flag.StringVar(&auditLog, "audit-log", "", "`Path` to append a record of every client txn submitted to this node to (optional; disabled if empty).")
+	flag.Int64Var(&auditLogSize, "audit-log-size", 64*1024*1024, "Size in bytes at which the audit log is rotated.")
+	flag.BoolVar(&auditLogValues, "audit-log-values", false, "Include the values written by client txns in the audit log.")
+	flag.StringVar(&importFile, "import", "", "`Path` to a dump written by goshawkdb-export, to be loaded once the cluster has formed. The cluster must be fresh.")
+	flag.StringVar(&planConfigFile, "plan-config", "", "`Path` to a new configuration file. Prints the change to it which would be made from the topology in the data directory, without making it, then exits.")
 	flag.Parse()
 
 	if version {
@@ -70,6 +90,20 @@ func newServer() (*server, error) {
 		return nil, nil
 	}
 
+	if backupDir != "" {
+		if dataDir == "" {
+			return nil, fmt.Errorf("No data dir supplied (missing -dir parameter). Cannot backup.")
+		}
+		return nil, offlineBackup(dataDir, backupDir)
+	}
+
+	if planConfigFile != "" {
+		if dataDir == "" {
+			return nil, fmt.Errorf("No data dir supplied (missing -dir parameter). Cannot plan configuration change.")
+		}
+		return nil, offlinePlanConfig(dataDir, planConfigFile, uint16(port))
+	}
+
 	if len(certFile) == 0 {
 		return nil, fmt.Errorf("No certificate supplied (missing -cert parameter). Use -gen-cluster-cert to create cluster certificate.")
 	}
@@ -78,6 +112,17 @@ func newServer() (*server, error) {
 		return nil, err
 	}
 
+	var trustedCerts []*x509.Certificate
+	if trustCertFile != "" {
+		bites, err := ioutil.ReadFile(trustCertFile)
+		if err != nil {
+			return nil, err
+		}
+		if trustedCerts, err = parseCertificates(bites); err != nil {
+			return nil, fmt.Errorf("Error in %v: %v", trustCertFile, err)
+		}
+	}
+
 	if genClientCert {
 		certificatePrivateKeyPair, err := certs.NewClientCertificate(certificate)
 		if err != nil {
@@ -101,6 +146,12 @@ func newServer() (*server, error) {
 		return nil, err
 	}
 
+	if backupRoot != "" {
+		if backupRoot, err = filepath.Abs(backupRoot); err != nil {
+			return nil, err
+		}
+	}
+
 	if configFile != "" {
 		_, err := ioutil.ReadFile(configFile)
 		if err != nil {
@@ -108,17 +159,51 @@ func newServer() (*server, error) {
 		}
 	}
 
+	if importFile != "" {
+		if _, err := os.Stat(importFile); err != nil {
+			return nil, err
+		}
+	}
+
 	if !(0 < port && port < 65536) {
 		return nil, fmt.Errorf("Supplied port is illegal (%v). Port must be > 0 and < 65536", port)
 	}
+	if !(0 <= adminPort && adminPort < 65536) {
+		return nil, fmt.Errorf("Supplied admin port is illegal (%v). Admin port must be >= 0 and < 65536", adminPort)
+	}
+	if !(0 <= metricsPort && metricsPort < 65536) {
+		return nil, fmt.Errorf("Supplied metrics port is illegal (%v). Metrics port must be >= 0 and < 65536", metricsPort)
+	}
+	if auditLogSize <= 0 {
+		return nil, fmt.Errorf("Supplied audit log size is illegal (%v). Audit log size must be > 0", auditLogSize)
+	}
+	if gcInterval < 0 {
+		return nil, fmt.Errorf("Supplied gc interval is illegal (%v). GC interval must be >= 0", gcInterval)
+	}
+	if gcTombstoneRetention < 0 {
+		return nil, fmt.Errorf("Supplied gc tombstone retention is illegal (%v). GC tombstone retention must be >= 0", gcTombstoneRetention)
+	}
 
 	s := &server{
-		configFile:   configFile,
-		certificate:  certificate,
-		dataDir:      dataDir,
-		port:         uint16(port),
-		onShutdown:   []func(){},
-		shutdownChan: make(chan goshawk.EmptyStruct),
+		configFile:     configFile,
+		certificate:    certificate,
+		trustedCerts:   trustedCerts,
+		dataDir:        dataDir,
+		port:           uint16(port),
+		adminPort:      uint16(adminPort),
+		adminTLS:       adminTLS,
+		enableFaults:   enableFaults,
+		backupRoot:     backupRoot,
+		metricsPort:    uint16(metricsPort),
+		importFile:     importFile,
+		gcInterval:     gcInterval,
+		gcTombstones:   gcTombstoneRetention,
+		changeLog:      changeLog,
+		auditLog:       auditLog,
+		auditLogSize:   auditLogSize,
+		auditLogValues: auditLogValues,
+		onShutdown:     []func(){},
+		shutdownChan:   make(chan goshawk.EmptyStruct),
 	}
 
 	if err = s.ensureRMId(); err != nil {
@@ -134,10 +219,25 @@ func newServer() (*server, error) {
 type server struct {
 	configFile        string
 	certificate       []byte
+	trustedCerts      []*x509.Certificate
 	dataDir           string
 	port              uint16
+	adminPort         uint16
+	adminTLS          bool
+	enableFaults      bool
+	backupRoot        string
+	metricsPort       uint16
+	importFile        string
+	gcInterval        time.Duration
+	gcTombstones      time.Duration
+	changeLog         bool
+	auditLog          string
+	auditLogSize      int64
+	auditLogValues    bool
 	rmId              common.RMId
 	bootCount         uint32
+	disk              *db.Databases
+	changes           *eng.ChangeLog
 	connectionManager *network.ConnectionManager
 	transmogrifier    *network.TopologyTransmogrifier
 	profileFile       *os.File
@@ -169,23 +269,78 @@ func (s *server) start() {
 	s.certificate = nil
 	s.maybeShutdown(err)
 
+	lockFile, err := db.LockDataDir(s.dataDir)
+	s.maybeShutdown(err)
+	s.addOnShutdown(func() { goshawk.CheckWarn(lockFile.Close()) })
+
 	disk, err := mdbs.NewMDBServer(s.dataDir, 0, 0600, goshawk.MDBInitialSize, procs/2, time.Millisecond, db.DB)
 	s.maybeShutdown(err)
 	db := disk.(*db.Databases)
 	s.addOnShutdown(db.Shutdown)
+	s.disk = db
+
+	var changeLog *eng.ChangeLog
+	if s.changeLog {
+		changeLog, err = eng.NewChangeLog(s.changeLogPath())
+		s.maybeShutdown(err)
+		s.addOnShutdown(func() { goshawk.CheckWarn(changeLog.Close()) })
+		s.changes = changeLog
+	}
 
-	cm, transmogrifier := network.NewConnectionManager(s.rmId, s.bootCount, procs, db, nodeCertPrivKeyPair, s.port, s, commandLineConfig)
+	var auditLog *network.AuditLog
+	if s.auditLog != "" {
+		auditLog, err = network.NewAuditLog(s.auditLog, s.auditLogSize, s.auditLogValues)
+		s.maybeShutdown(err)
+		s.addOnShutdown(func() { goshawk.CheckWarn(auditLog.Close()) })
+	}
+
+	cm, transmogrifier := network.NewConnectionManager(s.rmId, s.bootCount, procs, db, nodeCertPrivKeyPair, s.port, s, commandLineConfig, changeLog, auditLog)
 	s.addOnShutdown(func() { cm.Shutdown(paxos.Sync) })
 	s.addOnShutdown(transmogrifier.Shutdown)
 	s.connectionManager = cm
 	s.transmogrifier = transmogrifier
 
+	for _, cert := range s.trustedCerts {
+		cm.TrustClusterCertificate(cert)
+	}
+	revokedClients, err := s.loadRevokedClients()
+	s.maybeShutdown(err)
+	if len(revokedClients) != 0 {
+		cm.RevokeClientCertificates(revokedClients...)
+	}
+
 	go s.signalHandler()
 
 	listener, err := network.NewListener(s.port, cm)
 	s.maybeShutdown(err)
 	s.addOnShutdown(listener.Shutdown)
 
+	if s.adminPort != 0 {
+		admin, err := newAdminServer(s)
+		s.maybeShutdown(err)
+		s.addOnShutdown(admin.Shutdown)
+	}
+
+	if s.metricsPort != 0 {
+		metricsListener, err := newMetricsListener(s.metricsPort)
+		s.maybeShutdown(err)
+		s.addOnShutdown(func() { goshawk.CheckWarn(metricsListener.Close()) })
+	}
+
+	if s.gcInterval != 0 {
+		gc := network.NewGarbageCollector(cm, db, s.gcInterval, s.gcTombstones)
+		s.addOnShutdown(gc.Shutdown)
+	}
+
+	historyPruner := network.NewHistoryPruner(cm, db, goshawk.HistoryPruneInterval)
+	s.addOnShutdown(historyPruner.Shutdown)
+
+	if s.importFile != "" {
+		d, err := s.loadImportFile()
+		s.maybeShutdown(err)
+		go s.runImport(d, cm)
+	}
+
 	defer s.shutdown(nil)
 	<-s.shutdownChan
 }
@@ -262,9 +417,14 @@ func (s *server) signalStatus() {
 	go sc.Consume(func(str string) {
 		log.Printf("System Status for %v\n%v\nStatus End\n", s.rmId, str)
 	})
-	sc.Emit(fmt.Sprintf("Configuration File: %v", s.configFile))
-	sc.Emit(fmt.Sprintf("Data Directory: %v", s.dataDir))
-	sc.Emit(fmt.Sprintf("Port: %v", s.port))
+	s.status(sc)
+}
+
+func (s *server) status(sc *goshawk.StatusConsumer) {
+	sc.EmitKV("RMId", s.rmId)
+	sc.EmitKV("Configuration File", s.configFile)
+	sc.EmitKV("Data Directory", s.dataDir)
+	sc.EmitKV("Port", s.port)
 	s.connectionManager.Status(sc)
 }
 
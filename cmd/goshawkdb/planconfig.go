@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	goshawk "goshawkdb.io/server"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/network"
+	"net/http"
+	"os"
+	"time"
+)
+
+// servePlanConfig requires a POST with a configuration as the
+// body. The change to that configuration is planned against the
+// active topology, but not made, and the plan is returned.
+func (as *adminServer) servePlanConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Planning a configuration requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	config, err := configuration.LoadConfiguration(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	plan, err := as.transmogrifier.PlanConfigurationChange(config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	goshawk.CheckWarn(json.NewEncoder(w).Encode(plan))
+}
+
+// offlinePlanConfig plans the change to the configuration in
+// configFile against the topology in dataDir, which is opened read
+// only. With no connections, every added host is given a placeholder
+// RMId.
+func offlinePlanConfig(dataDir, configFile string, port uint16) error {
+	config, err := configuration.LoadConfigurationFromPath(configFile)
+	if err != nil {
+		return err
+	}
+	disk, err := mdbs.NewMDBServer(dataDir, mdb.RDONLY, 0600, goshawk.MDBInitialSize, 1, time.Millisecond, db.ReadOnly())
+	if err != nil {
+		return err
+	}
+	defer disk.(*db.Databases).Shutdown()
+	active, err := disk.(*db.Databases).LoadTopology()
+	if err != nil {
+		return err
+	} else if active == nil {
+		return fmt.Errorf("No topology found in %v. Cannot plan.", dataDir)
+	}
+	localHost, _, err := active.LocalRemoteHosts(port)
+	if err != nil {
+		return err
+	}
+	plan, err := network.PlanConfigurationChange(active, config, localHost, func(string) (common.RMId, bool) {
+		return common.RMIdEmpty, false
+	})
+	if err != nil {
+		return err
+	}
+	if err = plan.CountVarMoves(disk.(*db.Databases)); err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
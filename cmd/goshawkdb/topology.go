@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	goshawk "goshawkdb.io/server"
+	"goshawkdb.io/server/network"
+	"net/http"
+	"strconv"
+)
+
+// topologyReport is served by the topology endpoint. A consumer of
+// the events which has processed up to Seq n resumes with from=n.
+type topologyReport struct {
+	Progress *network.TopologyProgress
+	Events   []*network.TopologyEvent
+}
+
+// serveTopology writes the progress of the current topology change,
+// and the retained events with Seq greater than the from parameter
+// (default 0).
+func (as *adminServer) serveTopology(w http.ResponseWriter, r *http.Request) {
+	from := uint64(0)
+	if fromStr := r.FormValue("from"); fromStr != "" {
+		var err error
+		if from, err = strconv.ParseUint(fromStr, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("Illegal from: %v", fromStr), http.StatusBadRequest)
+			return
+		}
+	}
+	progress, events := as.transmogrifier.Progress(from)
+	w.Header().Set("Content-Type", "application/json")
+	goshawk.CheckWarn(json.NewEncoder(w).Encode(&topologyReport{Progress: progress, Events: events}))
+}
+
+// serveAbortConfig requires a POST, and aborts the configuration
+// change in progress, provided no barrier has been reached. It must
+// be sent to a node of the active topology. The version of the change
+// being aborted is returned; the abort is complete once the topology
+// endpoint reports a ChangeAborted event. If the change can't be
+// aborted, the reason is returned with a 409.
+func (as *adminServer) serveAbortConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Aborting a configuration change requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	version, err := as.transmogrifier.AbortConfigurationChange()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	goshawk.CheckWarn(json.NewEncoder(w).Encode(struct{ AbortingVersion uint32 }{version}))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server/harness"
+	"goshawkdb.io/server/network"
+	"log"
+	"os"
+	"time"
+)
+
+// historychecker starts an in-process cluster, drives a concurrent
+// workload of client txns against it, and checks that the history of
+// committed txns is strictly serializable. It exits with status 1 if
+// any anomaly is found, and 2 if the workload could not be run.
+
+func main() {
+	log.SetPrefix(common.ProductName + "HistoryChecker ")
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	log.Println(os.Args)
+
+	var nodes, f int
+	var historyFile string
+	var drop, duplicate, reorder float64
+	var maxDelay time.Duration
+	w := &harness.Workload{}
+	flag.IntVar(&nodes, "nodes", 3, "Number of nodes in the cluster.")
+	flag.IntVar(&f, "f", 1, "Number of failures the cluster can tolerate.")
+	flag.IntVar(&w.Vars, "vars", 8, "Number of vars the workload uses.")
+	flag.IntVar(&w.Clients, "clients", 8, "Number of concurrent clients.")
+	flag.IntVar(&w.Txns, "txns", 100, "Number of txns each client runs.")
+	flag.IntVar(&w.MaxActions, "max-actions", 3, "Maximum number of vars each txn uses.")
+	flag.Int64Var(&w.Seed, "seed", time.Now().UnixNano(), "Seed for the workload and any faults.")
+	flag.Float64Var(&drop, "drop", 0, "Probability of dropping each message between nodes.")
+	flag.Float64Var(&duplicate, "duplicate", 0, "Probability of duplicating each message between nodes.")
+	flag.Float64Var(&reorder, "reorder", 0, "Probability of reordering each message between nodes.")
+	flag.DurationVar(&maxDelay, "max-delay", 0, "Maximum delay of each message between nodes.")
+	flag.StringVar(&historyFile, "history", "", "`Path` to write the history of committed txns to, as JSON.")
+	flag.Parse()
+	log.Printf("Seed: %v", w.Seed)
+
+	c, err := harness.NewCluster(nodes, uint8(f))
+	if err != nil {
+		log.Println(err)
+		os.Exit(2)
+	}
+	if err = c.AwaitStable(time.Minute); err != nil {
+		c.Shutdown()
+		log.Println(err)
+		os.Exit(2)
+	}
+
+	if drop > 0 || duplicate > 0 || reorder > 0 || maxDelay > 0 {
+		rule := &network.FaultRule{Drop: drop, Duplicate: duplicate, Reorder: reorder, MaxDelay: maxDelay}
+		for _, node := range c.Nodes {
+			node.Faults().SetConfig(network.FaultConfig{
+				Seed:  w.Seed,
+				Rules: map[common.RMId]*network.FaultRule{common.RMIdEmpty: rule},
+			})
+		}
+	}
+
+	start := time.Now()
+	history, err := w.Run(c)
+	c.Shutdown()
+	if err != nil {
+		log.Println(err)
+		os.Exit(2)
+	}
+	log.Printf("%v txns committed in %v.", len(history.Txns), time.Now().Sub(start))
+
+	if historyFile != "" {
+		file, err := os.Create(historyFile)
+		if err == nil {
+			err = history.Write(file)
+			if errClose := file.Close(); err == nil {
+				err = errClose
+			}
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	anomalies := history.Check()
+	for _, anomaly := range anomalies {
+		log.Println(anomaly)
+	}
+	if len(anomalies) != 0 {
+		log.Printf("Found %v anomalies.", len(anomalies))
+		os.Exit(1)
+	}
+	log.Println("History is strictly serializable.")
+}
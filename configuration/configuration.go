@@ -11,8 +11,10 @@ import (
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
 	ch "goshawkdb.io/server/consistenthash"
+	"io"
 	"net"
 	"os"
+	"sort"
 	"strconv"
 )
 
@@ -23,13 +25,97 @@ type Configuration struct {
 	F                             uint8
 	MaxRMCount                    uint16
 	NoSync                        bool
+	HistoryRetentionSeconds       uint32
+	Zones                         map[string]string
+	// Weights maps hosts to their weight, which is 1 if not given. A
+	// host holds a share of the vars in proportion to its weight. As
+	// weights apply to existing vars as well as new ones, changing
+	// them migrates vars. Any weight other than 1 switches placement
+	// from the unweighted permutation to weighted rendezvous hashing,
+	// so the first such change, and the return to every weight being
+	// 1, move almost every var. Use -plan-config to count the vars a
+	// change would move.
+	Weights                       map[string]uint16
+	RootNames                     []string
 	ClientCertificateFingerprints []string
+	ClientCertificateRoles        map[string]*ClientCertificateRole
 	rms                           common.RMIds
 	rmsRemoved                    map[common.RMId]server.EmptyStruct
-	fingerprints                  map[[sha256.Size]byte]server.EmptyStruct
+	fingerprints                  map[[sha256.Size]byte]*ClientAccess
 	nextConfiguration             *NextConfiguration
 }
 
+const (
+	ClientRoleReadWrite = "ReadWrite"
+	ClientRoleReadOnly  = "ReadOnly"
+	// DefaultRootName names the root object every cluster has. Further
+	// roots are named in RootNames.
+	DefaultRootName = "default"
+)
+
+// ClientCertificateRole gives the rights of the client certificate
+// with the fingerprint it is keyed by. Role is ClientRoleReadWrite or
+// ClientRoleReadOnly. Roots names the roots the client may reach; if
+// it is empty then every root is permitted. Fingerprints listed in
+// ClientCertificateFingerprints are ReadWrite and may reach every
+// root.
+type ClientCertificateRole struct {
+	Role  string
+	Roots []string
+}
+
+// ClientAccess is the rights of an authenticated client. A nil Roots
+// permits every root.
+type ClientAccess struct {
+	ReadOnly bool
+	Roots    map[string]server.EmptyStruct
+}
+
+func (ca *ClientAccess) PermitsRoot(name string) bool {
+	if ca.Roots == nil {
+		return true
+	}
+	_, found := ca.Roots[name]
+	return found
+}
+
+func (a *ClientAccess) Equal(b *ClientAccess) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.ReadOnly != b.ReadOnly || (a.Roots == nil) != (b.Roots == nil) || len(a.Roots) != len(b.Roots) {
+		return false
+	}
+	for name := range a.Roots {
+		if _, found := b.Roots[name]; !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (ca *ClientAccess) String() string {
+	role := ClientRoleReadWrite
+	if ca.ReadOnly {
+		role = ClientRoleReadOnly
+	}
+	if ca.Roots == nil {
+		return role
+	}
+	roots := make([]string, 0, len(ca.Roots))
+	for name := range ca.Roots {
+		roots = append(roots, name)
+	}
+	sort.Strings(roots)
+	return fmt.Sprintf("%v%v", role, roots)
+}
+
+// unrestricted is true if the client may write and reach every root,
+// as for fingerprints with no role.
+func (ca *ClientAccess) unrestricted() bool {
+	return !ca.ReadOnly && ca.Roots == nil
+}
+
 type NextConfiguration struct {
 	*Configuration
 	AllHosts        []string
@@ -125,7 +211,11 @@ func LoadConfigurationFromPath(path string) (*Configuration, error) {
 		return nil, err
 	}
 	defer file.Close()
-	decoder := json.NewDecoder(file)
+	return LoadConfiguration(file)
+}
+
+func LoadConfiguration(reader io.Reader) (*Configuration, error) {
+	decoder := json.NewDecoder(reader)
 	config, err := decodeConfiguration(decoder)
 	if err != nil {
 		return nil, err
@@ -157,43 +247,151 @@ func decodeConfiguration(decoder *json.Decoder) (*Configuration, error) {
 		return nil, fmt.Errorf("MaxRMCount given as %v but must be at least the number of hosts (%v).", config.MaxRMCount, len(config.Hosts))
 	}
 	for idx, hostPort := range config.Hosts {
-		port := common.DefaultPort
-		hostOnly := hostPort
-		if host, portStr, err := net.SplitHostPort(hostPort); err == nil {
-			portInt64, err := strconv.ParseUint(portStr, 0, 16)
-			if err != nil {
-				return nil, err
-			}
-			port = int(portInt64)
-			hostOnly = host
+		hostPort, err := normaliseHostPort(hostPort)
+		if err != nil {
+			return nil, err
 		}
-		hostPort = net.JoinHostPort(hostOnly, fmt.Sprint(port))
 		config.Hosts[idx] = hostPort
 		if _, err := net.ResolveTCPAddr("tcp", hostPort); err != nil {
 			return nil, err
 		}
 	}
-	if len(config.ClientCertificateFingerprints) == 0 {
-		return nil, errors.New("No ClientCertificateFingerprints defined")
+	if len(config.Zones) != 0 {
+		zones := make(map[string]string, len(config.Zones))
+		for hostPort, zone := range config.Zones {
+			hostPort, err := normaliseHostPort(hostPort)
+			if err != nil {
+				return nil, err
+			}
+			if !containsHost(config.Hosts, hostPort) {
+				return nil, fmt.Errorf("Invalid configuration: zone given for %v which is not in hosts", hostPort)
+			} else if zone == "" {
+				return nil, fmt.Errorf("Invalid configuration: empty zone given for %v", hostPort)
+			}
+			zones[hostPort] = zone
+		}
+		config.Zones = zones
+	} else {
+		config.Zones = nil
+	}
+	if config.Weights != nil {
+		weights := make(map[string]uint16, len(config.Weights))
+		for hostPort, weight := range config.Weights {
+			hostPort, err := normaliseHostPort(hostPort)
+			if err != nil {
+				return nil, err
+			}
+			if !containsHost(config.Hosts, hostPort) {
+				return nil, fmt.Errorf("Invalid configuration: weight given for %v which is not in hosts", hostPort)
+			} else if weight == 0 {
+				return nil, fmt.Errorf("Invalid configuration: weight for %v must be at least 1", hostPort)
+			}
+			if weight != 1 {
+				weights[hostPort] = weight
+			}
+		}
+		config.Weights = weights
+	}
+	if len(config.Weights) == 0 {
+		// all weights are 1, which is no different to no weights.
+		config.Weights = nil
+	}
+	if len(config.RootNames) != 0 {
+		names := make(map[string]server.EmptyStruct, len(config.RootNames))
+		for _, name := range config.RootNames {
+			if name == "" {
+				return nil, errors.New("Invalid configuration: empty root name")
+			} else if name == DefaultRootName {
+				return nil, fmt.Errorf("Invalid configuration: root name '%v' is reserved", name)
+			} else if _, found := names[name]; found {
+				return nil, fmt.Errorf("Invalid configuration: root name '%v' given more than once", name)
+			}
+			names[name] = server.EmptyStructVal
+		}
+	} else {
+		config.RootNames = nil
+	}
+	if len(config.ClientCertificateFingerprints) == 0 && len(config.ClientCertificateRoles) == 0 {
+		return nil, errors.New("No ClientCertificateFingerprints or ClientCertificateRoles defined")
 	} else {
-		fingerprints := make(map[[sha256.Size]byte]server.EmptyStruct, len(config.ClientCertificateFingerprints))
+		fingerprints := make(map[[sha256.Size]byte]*ClientAccess, len(config.ClientCertificateFingerprints)+len(config.ClientCertificateRoles))
 		for _, fingerprint := range config.ClientCertificateFingerprints {
-			fingerprintBytes, err := hex.DecodeString(fingerprint)
+			ary, err := decodeFingerprint(fingerprint)
 			if err != nil {
 				return nil, err
-			} else if l := len(fingerprintBytes); l != sha256.Size {
-				return nil, fmt.Errorf("Invalid fingerprint: expected %v bytes, and found %v", sha256.Size, l)
 			}
-			ary := [sha256.Size]byte{}
-			copy(ary[:], fingerprintBytes)
-			fingerprints[ary] = server.EmptyStructVal
+			fingerprints[ary] = &ClientAccess{}
+		}
+		for fingerprint, role := range config.ClientCertificateRoles {
+			ary, err := decodeFingerprint(fingerprint)
+			if err != nil {
+				return nil, err
+			} else if _, found := fingerprints[ary]; found {
+				return nil, fmt.Errorf("Invalid configuration: fingerprint %v given more than once", fingerprint)
+			} else if role == nil {
+				return nil, fmt.Errorf("Invalid configuration: no role given for fingerprint %v", fingerprint)
+			}
+			access := &ClientAccess{}
+			switch role.Role {
+			case ClientRoleReadWrite:
+			case ClientRoleReadOnly:
+				access.ReadOnly = true
+			default:
+				return nil, fmt.Errorf("Invalid configuration: role for fingerprint %v must be %v or %v; found '%v'", fingerprint, ClientRoleReadWrite, ClientRoleReadOnly, role.Role)
+			}
+			if len(role.Roots) != 0 {
+				access.Roots = make(map[string]server.EmptyStruct, len(role.Roots))
+				for _, name := range role.Roots {
+					if !config.HasRoot(name) {
+						return nil, fmt.Errorf("Invalid configuration: unknown root '%v' given for fingerprint %v", name, fingerprint)
+					}
+					access.Roots[name] = server.EmptyStructVal
+				}
+			}
+			fingerprints[ary] = access
 		}
 		config.fingerprints = fingerprints
 		config.ClientCertificateFingerprints = nil
+		config.ClientCertificateRoles = nil
 	}
 	return &config, err
 }
 
+func decodeFingerprint(fingerprint string) ([sha256.Size]byte, error) {
+	ary := [sha256.Size]byte{}
+	fingerprintBytes, err := hex.DecodeString(fingerprint)
+	if err != nil {
+		return ary, err
+	} else if l := len(fingerprintBytes); l != sha256.Size {
+		return ary, fmt.Errorf("Invalid fingerprint: expected %v bytes, and found %v", sha256.Size, l)
+	}
+	copy(ary[:], fingerprintBytes)
+	return ary, nil
+}
+
+func normaliseHostPort(hostPort string) (string, error) {
+	port := common.DefaultPort
+	hostOnly := hostPort
+	if host, portStr, err := net.SplitHostPort(hostPort); err == nil {
+		portInt64, err := strconv.ParseUint(portStr, 0, 16)
+		if err != nil {
+			return "", err
+		}
+		port = int(portInt64)
+		hostOnly = host
+	}
+	return net.JoinHostPort(hostOnly, fmt.Sprint(port)), nil
+}
+
+func containsHost(hosts []string, hostPort string) bool {
+	for _, host := range hosts {
+		if host == hostPort {
+			return true
+		}
+	}
+	return false
+}
+
 func ConfigurationFromCap(config *msgs.Configuration) *Configuration {
 	c := &Configuration{
 		ClusterId:  config.ClusterId(),
@@ -202,6 +400,29 @@ func ConfigurationFromCap(config *msgs.Configuration) *Configuration {
 		F:          config.F(),
 		MaxRMCount: config.MaxRMCount(),
 		NoSync:     config.NoSync(),
+		HistoryRetentionSeconds: config.HistoryRetentionSeconds(),
+	}
+
+	if zones := config.Zones(); zones.Len() != 0 {
+		c.Zones = make(map[string]string, zones.Len())
+		for idx, host := range c.Hosts {
+			if idx < zones.Len() && zones.At(idx) != "" {
+				c.Zones[host] = zones.At(idx)
+			}
+		}
+	}
+
+	if weights := config.Weights(); weights.Len() != 0 {
+		c.Weights = make(map[string]uint16, weights.Len())
+		for idx, host := range c.Hosts {
+			if idx < weights.Len() && weights.At(idx) != 1 {
+				c.Weights[host] = weights.At(idx)
+			}
+		}
+	}
+
+	if rootNames := config.RootNames(); rootNames.Len() != 0 {
+		c.RootNames = rootNames.ToArray()
 	}
 
 	rms := config.Rms()
@@ -217,11 +438,25 @@ func ConfigurationFromCap(config *msgs.Configuration) *Configuration {
 	}
 
 	fingerprints := config.Fingerprints()
-	fingerprintsMap := make(map[[sha256.Size]byte]server.EmptyStruct, fingerprints.Len())
+	clientRoles := config.ClientRoles()
+	fingerprintsMap := make(map[[sha256.Size]byte]*ClientAccess, fingerprints.Len()+clientRoles.Len())
 	for idx, l := 0, fingerprints.Len(); idx < l; idx++ {
 		ary := [sha256.Size]byte{}
 		copy(ary[:], fingerprints.At(idx))
-		fingerprintsMap[ary] = server.EmptyStructVal
+		fingerprintsMap[ary] = &ClientAccess{}
+	}
+	for idx, l := 0, clientRoles.Len(); idx < l; idx++ {
+		clientRole := clientRoles.At(idx)
+		ary := [sha256.Size]byte{}
+		copy(ary[:], clientRole.Fingerprint())
+		access := &ClientAccess{ReadOnly: clientRole.ReadOnly()}
+		if roots := clientRole.Roots(); roots.Len() != 0 {
+			access.Roots = make(map[string]server.EmptyStruct, roots.Len())
+			for idy, m := 0, roots.Len(); idy < m; idy++ {
+				access.Roots[roots.At(idy)] = server.EmptyStructVal
+			}
+		}
+		fingerprintsMap[ary] = access
 	}
 	c.fingerprints = fingerprintsMap
 
@@ -281,7 +516,7 @@ func (a *Configuration) Equal(b *Configuration) bool {
 	if a == nil || b == nil {
 		return a == b
 	}
-	if !(a.ClusterId == b.ClusterId && a.Version == b.Version && a.F == b.F && a.MaxRMCount == b.MaxRMCount && a.NoSync == b.NoSync && len(a.Hosts) == len(b.Hosts) && len(a.fingerprints) == len(b.fingerprints) && len(a.rms) == len(b.rms) && len(a.rmsRemoved) == len(b.rmsRemoved)) {
+	if !(a.ClusterId == b.ClusterId && a.Version == b.Version && a.F == b.F && a.MaxRMCount == b.MaxRMCount && a.NoSync == b.NoSync && a.HistoryRetentionSeconds == b.HistoryRetentionSeconds && len(a.Hosts) == len(b.Hosts) && len(a.RootNames) == len(b.RootNames) && len(a.fingerprints) == len(b.fingerprints) && len(a.rms) == len(b.rms) && len(a.rmsRemoved) == len(b.rmsRemoved)) {
 		return false
 	}
 	for idx, aHost := range a.Hosts {
@@ -289,6 +524,27 @@ func (a *Configuration) Equal(b *Configuration) bool {
 			return false
 		}
 	}
+	for idx, aName := range a.RootNames {
+		if aName != b.RootNames[idx] {
+			return false
+		}
+	}
+	if len(a.Zones) != len(b.Zones) {
+		return false
+	}
+	for host, aZone := range a.Zones {
+		if bZone, found := b.Zones[host]; !found || aZone != bZone {
+			return false
+		}
+	}
+	if len(a.Weights) != len(b.Weights) {
+		return false
+	}
+	for host, aWeight := range a.Weights {
+		if bWeight, found := b.Weights[host]; !found || aWeight != bWeight {
+			return false
+		}
+	}
 	for idx, aRM := range a.rms {
 		if aRM != b.rms[idx] {
 			return false
@@ -299,8 +555,8 @@ func (a *Configuration) Equal(b *Configuration) bool {
 			return false
 		}
 	}
-	for fingerprint := range b.fingerprints {
-		if _, found := a.fingerprints[fingerprint]; !found {
+	for fingerprint, bAccess := range b.fingerprints {
+		if aAccess, found := a.fingerprints[fingerprint]; !found || !aAccess.Equal(bAccess) {
 			return false
 		}
 	}
@@ -308,11 +564,37 @@ func (a *Configuration) Equal(b *Configuration) bool {
 }
 
 func (config *Configuration) String() string {
-	return fmt.Sprintf("Configuration{ClusterId: %v, Version: %v, Hosts: %v, F: %v, MaxRMCount: %v, NoSync: %v, RMs: %v, Removed: %v}",
-		config.ClusterId, config.Version, config.Hosts, config.F, config.MaxRMCount, config.NoSync, config.rms, config.rmsRemoved)
+	return fmt.Sprintf("Configuration{ClusterId: %v, Version: %v, Hosts: %v, Zones: %v, Weights: %v, RootNames: %v, F: %v, MaxRMCount: %v, NoSync: %v, HistoryRetentionSeconds: %v, RMs: %v, Removed: %v}",
+		config.ClusterId, config.Version, config.Hosts, config.Zones, config.Weights, config.RootNames, config.F, config.MaxRMCount, config.NoSync, config.HistoryRetentionSeconds, config.rms, config.rmsRemoved)
+}
+
+// RootsRemoved returns the names of the roots of config which next
+// does not name. Roots can be added, but never removed or renamed, as
+// everything reachable only from them would be lost.
+func (config *Configuration) RootsRemoved(next *Configuration) []string {
+	removed := []string{}
+	for _, name := range config.RootNames {
+		if !next.HasRoot(name) {
+			removed = append(removed, name)
+		}
+	}
+	return removed
+}
+
+// HasRoot is true if name is DefaultRootName or is in RootNames.
+func (config *Configuration) HasRoot(name string) bool {
+	if name == DefaultRootName {
+		return true
+	}
+	for _, rootName := range config.RootNames {
+		if rootName == name {
+			return true
+		}
+	}
+	return false
 }
 
-func (config *Configuration) Fingerprints() map[[sha256.Size]byte]server.EmptyStruct {
+func (config *Configuration) Fingerprints() map[[sha256.Size]byte]*ClientAccess {
 	return config.fingerprints
 }
 
@@ -354,6 +636,43 @@ func (config *Configuration) SetRMs(rms common.RMIds) {
 	config.rms = rms
 }
 
+// RMZones returns the zone of every RM which has one, or nil if no
+// zones are configured. Once the empties are removed, RMs are in the
+// same order as Hosts.
+func (config *Configuration) RMZones() map[common.RMId]string {
+	if len(config.Zones) == 0 {
+		return nil
+	}
+	zones := make(map[common.RMId]string, len(config.Zones))
+	for idx, rmId := range config.rms.NonEmpty() {
+		if idx == len(config.Hosts) {
+			break
+		} else if zone, found := config.Zones[config.Hosts[idx]]; found {
+			zones[rmId] = zone
+		}
+	}
+	return zones
+}
+
+// RMWeights returns the weight of every RM, or nil if no weights are
+// configured. Hosts not given a weight have a weight of 1.
+func (config *Configuration) RMWeights() map[common.RMId]uint16 {
+	if len(config.Weights) == 0 {
+		return nil
+	}
+	weights := make(map[common.RMId]uint16, len(config.Hosts))
+	for idx, rmId := range config.rms.NonEmpty() {
+		if idx == len(config.Hosts) {
+			break
+		} else if weight, found := config.Weights[config.Hosts[idx]]; found {
+			weights[rmId] = weight
+		} else {
+			weights[rmId] = 1
+		}
+	}
+	return weights
+}
+
 func (config *Configuration) RMsRemoved() map[common.RMId]server.EmptyStruct {
 	return config.rmsRemoved
 }
@@ -370,15 +689,43 @@ func (config *Configuration) Clone() *Configuration {
 		F:          config.F,
 		MaxRMCount: config.MaxRMCount,
 		NoSync:     config.NoSync,
+		HistoryRetentionSeconds: config.HistoryRetentionSeconds,
 		ClientCertificateFingerprints: make([]string, len(config.ClientCertificateFingerprints)),
 		rms:               make([]common.RMId, len(config.rms)),
 		rmsRemoved:        make(map[common.RMId]server.EmptyStruct, len(config.rmsRemoved)),
-		fingerprints:      make(map[[sha256.Size]byte]server.EmptyStruct, len(config.fingerprints)),
+		fingerprints:      make(map[[sha256.Size]byte]*ClientAccess, len(config.fingerprints)),
 		nextConfiguration: config.nextConfiguration.Clone(),
 	}
 
 	copy(clone.Hosts, config.Hosts)
+	if config.Zones != nil {
+		clone.Zones = make(map[string]string, len(config.Zones))
+		for k, v := range config.Zones {
+			clone.Zones[k] = v
+		}
+	}
+	if config.Weights != nil {
+		clone.Weights = make(map[string]uint16, len(config.Weights))
+		for k, v := range config.Weights {
+			clone.Weights[k] = v
+		}
+	}
+	if config.RootNames != nil {
+		clone.RootNames = make([]string, len(config.RootNames))
+		copy(clone.RootNames, config.RootNames)
+	}
 	copy(clone.ClientCertificateFingerprints, config.ClientCertificateFingerprints)
+	if config.ClientCertificateRoles != nil {
+		clone.ClientCertificateRoles = make(map[string]*ClientCertificateRole, len(config.ClientCertificateRoles))
+		for k, v := range config.ClientCertificateRoles {
+			role := &ClientCertificateRole{Role: v.Role}
+			if v.Roots != nil {
+				role.Roots = make([]string, len(v.Roots))
+				copy(role.Roots, v.Roots)
+			}
+			clone.ClientCertificateRoles[k] = role
+		}
+	}
 	copy(clone.rms, config.rms)
 	for k, v := range config.rmsRemoved {
 		clone.rmsRemoved[k] = v
@@ -400,9 +747,38 @@ func (config *Configuration) AddToSegAutoRoot(seg *capn.Segment) msgs.Configurat
 		hosts.Set(idx, host)
 	}
 
+	if len(config.Zones) != 0 {
+		zones := seg.NewTextList(len(config.Hosts))
+		cap.SetZones(zones)
+		for idx, host := range config.Hosts {
+			zones.Set(idx, config.Zones[host])
+		}
+	}
+
+	if len(config.Weights) != 0 {
+		weights := seg.NewUInt16List(len(config.Hosts))
+		cap.SetWeights(weights)
+		for idx, host := range config.Hosts {
+			weight, found := config.Weights[host]
+			if !found {
+				weight = 1
+			}
+			weights.Set(idx, weight)
+		}
+	}
+
+	if len(config.RootNames) != 0 {
+		rootNames := seg.NewTextList(len(config.RootNames))
+		cap.SetRootNames(rootNames)
+		for idx, name := range config.RootNames {
+			rootNames.Set(idx, name)
+		}
+	}
+
 	cap.SetF(config.F)
 	cap.SetMaxRMCount(config.MaxRMCount)
 	cap.SetNoSync(config.NoSync)
+	cap.SetHistoryRetentionSeconds(config.HistoryRetentionSeconds)
 
 	rms := seg.NewUInt32List(len(config.rms))
 	cap.SetRms(rms)
@@ -418,13 +794,39 @@ func (config *Configuration) AddToSegAutoRoot(seg *capn.Segment) msgs.Configurat
 		idx++
 	}
 
+	// Fingerprints with no role are kept apart from those with roles,
+	// as they were before roles existed.
 	fingerprintsMap := config.fingerprints
-	fingerprints := seg.NewDataList(len(fingerprintsMap))
+	unrestricted := 0
+	for _, access := range fingerprintsMap {
+		if access.unrestricted() {
+			unrestricted++
+		}
+	}
+	fingerprints := seg.NewDataList(unrestricted)
 	cap.SetFingerprints(fingerprints)
+	clientRoles := msgs.NewClientRoleList(seg, len(fingerprintsMap)-unrestricted)
+	cap.SetClientRoles(clientRoles)
 	idx = 0
-	for fingerprint := range fingerprintsMap {
-		fingerprints.Set(idx, fingerprint[:])
-		idx++
+	idy := 0
+	for fingerprint, access := range fingerprintsMap {
+		if access.unrestricted() {
+			fingerprints.Set(idx, fingerprint[:])
+			idx++
+			continue
+		}
+		clientRole := msgs.NewClientRole(seg)
+		clientRole.SetFingerprint(fingerprint[:])
+		clientRole.SetReadOnly(access.ReadOnly)
+		roots := seg.NewTextList(len(access.Roots))
+		clientRole.SetRoots(roots)
+		idz := 0
+		for name := range access.Roots {
+			roots.Set(idz, name)
+			idz++
+		}
+		clientRoles.Set(idy, clientRole)
+		idy++
 	}
 
 	if config.nextConfiguration == nil {
@@ -780,13 +1182,17 @@ func (g *Generator) String() string {
 func (g *Generator) SatisfiedBy(topology *Topology, positions *common.Positions) (bool, error) {
 	rms := topology.RMs()
 	twoFInc := topology.TwoFInc
+	zones := topology.RMZones()
+	weights := topology.RMWeights()
 	if g.UseNext {
 		next := topology.Next()
 		rms = next.RMs()
 		twoFInc = (uint16(next.F) * 2) + 1
+		zones = next.RMZones()
+		weights = next.RMWeights()
 	}
-	server.Log("Generator:SatisfiedBy:NewResolver:", rms, twoFInc)
-	resolver := ch.NewResolver(rms, twoFInc)
+	server.Log("Generator:SatisfiedBy:NewResolver:", rms, twoFInc, zones, weights)
+	resolver := ch.NewResolver(rms, twoFInc, zones, weights)
 	perm, err := resolver.ResolveHashCodes((*capn.UInt8List)(positions).ToArray())
 	if err != nil {
 		return false, err
@@ -18,6 +18,9 @@ type Topology struct {
 	TwoFInc   uint16
 	DBVersion *common.TxnId
 	Root
+	// Roots holds the roots named in RootNames which have been
+	// created. Root is the DefaultRootName root.
+	Roots map[string]Root
 }
 
 type Root struct {
@@ -45,47 +48,157 @@ func BlankTopology(clusterId string) *Topology {
 	}
 }
 
-func NewTopology(txnId *common.TxnId, root *msgs.VarIdPos, config *Configuration) *Topology {
+// NewTopology takes the roots from the references of the topology
+// var: the DefaultRootName root first, and then the roots in the
+// order of config.RootNames. refs may be nil.
+func NewTopology(txnId *common.TxnId, refs *msgs.VarIdPos_List, config *Configuration) *Topology {
 	t := &Topology{
 		Configuration: config,
 		FInc:          config.F + 1,
 		TwoFInc:       (2 * uint16(config.F)) + 1,
 		DBVersion:     txnId,
 	}
-	if root != nil {
-		positions := root.Positions()
-		t.Root = Root{
-			VarUUId:   common.MakeVarUUId(root.Id()),
+	if refs == nil {
+		return t
+	}
+	for idx, l := 0, refs.Len(); idx < l && idx <= len(config.RootNames); idx++ {
+		ref := refs.At(idx)
+		positions := ref.Positions()
+		root := Root{
+			VarUUId:   common.MakeVarUUId(ref.Id()),
 			Positions: (*common.Positions)(&positions),
 		}
+		if idx == 0 {
+			t.SetRoot(DefaultRootName, root)
+		} else {
+			t.SetRoot(config.RootNames[idx-1], root)
+		}
 	}
 	return t
 }
 
 func (t *Topology) Clone() *Topology {
-	return &Topology{
+	clone := &Topology{
 		Configuration: t.Configuration.Clone(),
 		FInc:          t.FInc,
 		TwoFInc:       t.TwoFInc,
 		DBVersion:     t.DBVersion,
 		Root:          t.Root,
 	}
+	if t.Roots != nil {
+		clone.Roots = make(map[string]Root, len(t.Roots))
+		for name, root := range t.Roots {
+			clone.Roots[name] = root
+		}
+	}
+	return clone
 }
 
+// SetConfiguration keeps the roots of the topology: config must name
+// every one of them (see Configuration.RootsRemoved).
 func (t *Topology) SetConfiguration(config *Configuration) {
 	t.Configuration = config
 	t.FInc = config.F + 1
 	t.TwoFInc = (2 * uint16(config.F)) + 1
 }
 
-func TopologyFromCap(txnId *common.TxnId, root *msgs.VarIdPos, data []byte) (*Topology, error) {
+// NamedRoot returns the root with the given name, or nil if it does
+// not exist or has not yet been created.
+func (t *Topology) NamedRoot(name string) *Root {
+	if name == DefaultRootName {
+		if t.Root.VarUUId == nil {
+			return nil
+		}
+		return &t.Root
+	}
+	if root, found := t.Roots[name]; found {
+		return &root
+	}
+	return nil
+}
+
+func (t *Topology) SetRoot(name string, root Root) {
+	if name == DefaultRootName {
+		t.Root = root
+		return
+	}
+	if t.Roots == nil {
+		t.Roots = make(map[string]Root, len(t.RootNames))
+	}
+	t.Roots[name] = root
+}
+
+// ClientRoot returns the root a client with the given access is
+// handed when it connects: the DefaultRootName root if it is
+// permitted, and otherwise the first permitted root in RootNames. It
+// returns nil if there is no such root, or it has not been created.
+// The client hello has room for only one root, so a client can not
+// reach the other roots it is permitted until the client protocol
+// can name several.
+func (t *Topology) ClientRoot(access *ClientAccess) *Root {
+	if access.PermitsRoot(DefaultRootName) {
+		return t.NamedRoot(DefaultRootName)
+	}
+	for _, name := range t.RootNames {
+		if access.PermitsRoot(name) {
+			return t.NamedRoot(name)
+		}
+	}
+	return nil
+}
+
+// AllRoots returns every root which has been created, by name.
+func (t *Topology) AllRoots() map[string]Root {
+	roots := make(map[string]Root, 1+len(t.Roots))
+	if t.Root.VarUUId != nil {
+		roots[DefaultRootName] = t.Root
+	}
+	for name, root := range t.Roots {
+		roots[name] = root
+	}
+	return roots
+}
+
+// MissingRoots returns the names of the roots which have not yet
+// been created, with DefaultRootName first.
+func (t *Topology) MissingRoots() []string {
+	missing := []string{}
+	if t.Root.VarUUId == nil {
+		missing = append(missing, DefaultRootName)
+	}
+	for _, name := range t.RootNames {
+		if _, found := t.Roots[name]; !found {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// RootsToSeg creates the references of the topology var to its
+// roots, in the order NewTopology expects. Every root must have
+// been created.
+func (t *Topology) RootsToSeg(seg *capn.Segment) msgs.VarIdPos_List {
+	refs := msgs.NewVarIdPosList(seg, 1+len(t.RootNames))
+	for idx, l := 0, refs.Len(); idx < l; idx++ {
+		root := t.Root
+		if idx > 0 {
+			root = t.Roots[t.RootNames[idx-1]]
+		}
+		varIdPos := refs.At(idx)
+		varIdPos.SetId(root.VarUUId[:])
+		varIdPos.SetPositions((capn.UInt8List)(*root.Positions))
+	}
+	return refs
+}
+
+func TopologyFromCap(txnId *common.TxnId, refs *msgs.VarIdPos_List, data []byte) (*Topology, error) {
 	seg, _, err := capn.ReadFromMemoryZeroCopy(data)
 	if err != nil {
 		return nil, err
 	}
 	configCap := msgs.ReadRootConfiguration(seg)
 	config := ConfigurationFromCap(&configCap)
-	return NewTopology(txnId, root, config), nil
+	return NewTopology(txnId, refs, config), nil
 }
 
 func (t *Topology) String() string {
@@ -96,8 +209,14 @@ func (t *Topology) String() string {
 	if t.Root.VarUUId != nil {
 		root = fmt.Sprintf("%v@%v", t.Root.VarUUId, (*capn.UInt8List)(t.Root.Positions).ToArray())
 	}
-	return fmt.Sprintf("Topology{%v, F+1: %v, 2F+1: %v, DBVersion: %v, Root: %v}",
-		t.Configuration, t.FInc, t.TwoFInc, t.DBVersion, root)
+	roots := make([]string, 0, len(t.Roots))
+	for _, name := range t.RootNames {
+		if r, found := t.Roots[name]; found {
+			roots = append(roots, fmt.Sprintf("%v: %v", name, r.VarUUId))
+		}
+	}
+	return fmt.Sprintf("Topology{%v, F+1: %v, 2F+1: %v, DBVersion: %v, Root: %v, Roots: %v}",
+		t.Configuration, t.FInc, t.TwoFInc, t.DBVersion, root, roots)
 }
 
 func (t *Topology) IsBlank() bool {
@@ -80,7 +80,9 @@ func (chc *ConsistentHashCache) Remove(vUUId *common.VarUUId) {
 }
 
 // In here, we don't actually add to the cache because we don't know
-// if the corresponding txn is going to commit or not.
+// if the corresponding txn is going to commit or not. The positions
+// are always uniformly random: any weights are applied by the
+// resolver, so that they can be changed for existing vars too.
 func (chc *ConsistentHashCache) CreatePositions(vUUId *common.VarUUId, positionsLength int) (*common.Positions, []common.RMId, error) {
 	positionsCap := capn.NewBuffer(nil).NewUInt8List(positionsLength)
 	positionsSlice := make([]uint8, positionsLength)
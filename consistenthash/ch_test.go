@@ -1,6 +1,7 @@
 package consistenthash
 
 import (
+	"fmt"
 	"goshawkdb.io/common"
 	"math/rand"
 	"os"
@@ -32,7 +33,7 @@ func TestPerms(t *testing.T) {
 		consumer := func(positions []uint8) {
 			for l := 1; l < permLen; l++ {
 				legalHashCodes := workingHashCodes[:permLen]
-				res := NewResolver(legalHashCodes, uint16(l))
+				res := NewResolver(legalHashCodes, uint16(l), nil, nil)
 				perm, err := res.ResolveHashCodes(positions)
 				// t.Logf("NewResolver(%v, %v, %v) => %v", legalHashCodes[:permLen], uint8(l), perm)
 				if err != nil {
@@ -47,6 +48,148 @@ func TestPerms(t *testing.T) {
 	}
 }
 
+func TestZonedPerms(t *testing.T) {
+	permLen := 6
+	legalHashCodes := hashcodes[:permLen]
+	positions := make([]uint8, permLen)
+
+	for zoneCount := 1; zoneCount <= permLen; zoneCount++ {
+		zones := make(map[common.RMId]string, permLen)
+		for idx, rmId := range legalHashCodes {
+			zones[rmId] = fmt.Sprint(idx % zoneCount)
+		}
+
+		consumer := func(positions []uint8) {
+			for l := 1; l <= permLen; l++ {
+				res := NewResolver(legalHashCodes, uint16(l), zones, nil)
+				perm, err := res.ResolveHashCodes(positions)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !isPermutationPrefixOf(perm, legalHashCodes, l) {
+					t.Fatal("Not a valid permutation", perm, legalHashCodes, positions, l)
+				}
+				// every prefix should use the zones as evenly as possible
+				counts := make(map[string]int)
+				for idx, rmId := range perm {
+					zone := zones[rmId]
+					counts[zone]++
+					if limit := (idx / zoneCount) + 1; counts[zone] > limit {
+						t.Fatal("Zones not spread", perm, zones, positions, l)
+					}
+				}
+				for idx, rmId := range legalHashCodes {
+					hasVar, err := res.RMIdHasVar(idx, positions)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if hasVar != isPermutationPrefixOf([]common.RMId{rmId}, perm, 1) {
+						t.Fatal("RMIdHasVar disagrees with permutation", rmId, hasVar, perm, positions, l)
+					}
+				}
+			}
+		}
+		forEachPositions(consumer, positions, 0)
+	}
+}
+
+func TestWeightedPerms(t *testing.T) {
+	permLen := 8
+	workingHashCodes := make([]common.RMId, permLen)
+	copy(workingHashCodes, hashcodes[:permLen])
+	workingHashCodes[2] = common.RMIdEmpty
+	weights := make(map[common.RMId]uint16, permLen)
+	for idx, rmId := range workingHashCodes {
+		weights[rmId] = uint16(idx + 1)
+	}
+
+	for l := 1; l < permLen; l++ {
+		res := NewResolver(workingHashCodes, uint16(l), nil, weights)
+		for _, positions := range randomPositions[:100] {
+			perm, err := res.ResolveHashCodes(positions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !isPermutationPrefixOf(perm, workingHashCodes, l) {
+				t.Fatal("Not a valid permutation", perm, workingHashCodes, positions, l)
+			}
+			for idx, rmId := range workingHashCodes {
+				if rmId == common.RMIdEmpty {
+					continue
+				}
+				hasVar, err := res.RMIdHasVar(idx, positions)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if hasVar != isPermutationPrefixOf([]common.RMId{rmId}, perm, 1) {
+					t.Fatal("RMIdHasVar disagrees with permutation", rmId, hasVar, perm, positions, l)
+				}
+			}
+		}
+	}
+}
+
+func TestWeightedBias(t *testing.T) {
+	legalHashCodes := hashcodes[:4]
+	weights := map[common.RMId]uint16{legalHashCodes[0]: 3}
+	res := NewResolver(legalHashCodes, 1, nil, weights)
+	heavy := 0
+	for _, positions := range randomPositions {
+		perm, err := res.ResolveHashCodes(positions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm[0] == legalHashCodes[0] {
+			heavy++
+		}
+	}
+	// weight 3 of a total of 6, so expect half.
+	if share := float64(heavy) / float64(len(randomPositions)); share < 0.45 || share > 0.55 {
+		t.Fatalf("Expected %v to be first in half the permutations, but it was in %v", legalHashCodes[0], share)
+	}
+
+	// Increasing the weight of one rmId should only move vars to it.
+	heavier := NewResolver(legalHashCodes, 2, nil, map[common.RMId]uint16{legalHashCodes[0]: 3, legalHashCodes[1]: 5})
+	res = NewResolver(legalHashCodes, 2, nil, weights)
+	for _, positions := range randomPositions {
+		before, _ := res.ResolveHashCodes(positions)
+		after, _ := heavier.ResolveHashCodes(positions)
+		for _, rmId := range after {
+			if rmId != legalHashCodes[1] && !isPermutationPrefixOf([]common.RMId{rmId}, before, 1) {
+				t.Fatal("Var moved to", rmId, "rather than", legalHashCodes[1], before, after)
+			}
+		}
+	}
+}
+
+// Weights switch the resolver to a different algorithm, so enabling
+// them moves almost every var, whereas changing a weight once enabled
+// moves few.
+func TestWeightedMigration(t *testing.T) {
+	legalHashCodes := hashcodes[:8]
+	unweighted := NewResolver(legalHashCodes, 3, nil, nil)
+	weighted := NewResolver(legalHashCodes, 3, nil, map[common.RMId]uint16{legalHashCodes[0]: 2})
+	heavier := NewResolver(legalHashCodes, 3, nil, map[common.RMId]uint16{legalHashCodes[0]: 3})
+	enabledMoves, changedMoves := 0, 0
+	for _, positions := range randomPositions {
+		before, _ := unweighted.ResolveHashCodes(positions)
+		enabled, _ := weighted.ResolveHashCodes(positions)
+		changed, _ := heavier.ResolveHashCodes(positions)
+		if !isPermutationPrefixOf(before, enabled, len(enabled)) {
+			enabledMoves++
+		}
+		if !isPermutationPrefixOf(enabled, changed, len(changed)) {
+			changedMoves++
+		}
+	}
+	if share := float64(enabledMoves) / float64(len(randomPositions)); share < 0.9 {
+		t.Fatalf("Enabling weights moved %v of vars; expected almost all", share)
+	}
+	if share := float64(changedMoves) / float64(len(randomPositions)); share > 0.25 {
+		t.Fatalf("Changing a weight moved %v of vars; expected few", share)
+	}
+}
+
 // NB, I could not be bothered to make this non-recursive. Beware
 // stack explosions with big permutations
 func forEachPositions(f func([]uint8), positions []uint8, idx int) {
@@ -79,37 +222,37 @@ func isPermutationPrefixOf(perm, hashcodes []common.RMId, l int) bool {
 }
 
 func BenchmarkHash4_4(b *testing.B) {
-	benchmarkHash(NewResolver(hashcodes[:4], 4), b)
+	benchmarkHash(NewResolver(hashcodes[:4], 4, nil, nil), b)
 }
 
 func BenchmarkHash8_4(b *testing.B) {
-	benchmarkHash(NewResolver(hashcodes[:8], 4), b)
+	benchmarkHash(NewResolver(hashcodes[:8], 4, nil, nil), b)
 }
 func BenchmarkHash8_8(b *testing.B) {
-	benchmarkHash(NewResolver(hashcodes[:8], 8), b)
+	benchmarkHash(NewResolver(hashcodes[:8], 8, nil, nil), b)
 }
 
 func BenchmarkHash16_4(b *testing.B) {
-	benchmarkHash(NewResolver(hashcodes[:16], 4), b)
+	benchmarkHash(NewResolver(hashcodes[:16], 4, nil, nil), b)
 }
 func BenchmarkHash16_8(b *testing.B) {
-	benchmarkHash(NewResolver(hashcodes[:16], 8), b)
+	benchmarkHash(NewResolver(hashcodes[:16], 8, nil, nil), b)
 }
 func BenchmarkHash16_16(b *testing.B) {
-	benchmarkHash(NewResolver(hashcodes[:16], 16), b)
+	benchmarkHash(NewResolver(hashcodes[:16], 16, nil, nil), b)
 }
 
 func BenchmarkHash32_4(b *testing.B) {
-	benchmarkHash(NewResolver(hashcodes[:32], 4), b)
+	benchmarkHash(NewResolver(hashcodes[:32], 4, nil, nil), b)
 }
 func BenchmarkHash32_8(b *testing.B) {
-	benchmarkHash(NewResolver(hashcodes[:32], 8), b)
+	benchmarkHash(NewResolver(hashcodes[:32], 8, nil, nil), b)
 }
 func BenchmarkHash32_16(b *testing.B) {
-	benchmarkHash(NewResolver(hashcodes[:32], 16), b)
+	benchmarkHash(NewResolver(hashcodes[:32], 16, nil, nil), b)
 }
 func BenchmarkHash32_32(b *testing.B) {
-	benchmarkHash(NewResolver(hashcodes[:32], 32), b)
+	benchmarkHash(NewResolver(hashcodes[:32], 32, nil, nil), b)
 }
 
 func benchmarkHash(res *Resolver, b *testing.B) {
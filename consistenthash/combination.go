@@ -26,6 +26,14 @@ appear in the result), then we do so.
 OverProvision represents the number of RMIds of a permutation included
 in the result above the desiredLength.
 
+If the picker has zones, then additionally we only remove an RMId if,
+for every permutation that includes it, either another RMId from the
+same zone remains in the result, or the permutation still has at least
+min(desiredLength, number of zones available to the permutation)
+distinct zones in the result without it. As we never remove an RMId
+which would break that, the included RMIds of each permutation are
+spread across as many zones as possible.
+
 We do a few tricks to make this as fast as possible:
 - in maps, where the value would normally be a list, we use a pointer
   to a list so that we can append to the list (and perhaps change the
@@ -49,46 +57,73 @@ func (tmdhc *tooManyDisabledHashCodes) Error() string {
 
 type CombinationPicker struct {
 	desiredLen          int
-	rmIdToOverProvision map[common.RMId]*[]*int
+	rmIdToOverProvision map[common.RMId]*[]*provision
 	disabledHashCodes   map[common.RMId]bool
+	zones               map[common.RMId]string
 	excluded            common.RMIds
 	errored             bool
 }
 
-// Here, you want desiredLen to be FInc
-func NewCombinationPicker(desiredLen int, disabledHashCodes map[common.RMId]server.EmptyStruct) *CombinationPicker {
+// provision is per permutation. zoneCounts is the number of RMIds of
+// each zone in the result, and zoneSpare is the number of distinct
+// zones in the result beyond those needed. Both are unused if the
+// picker has no zones.
+type provision struct {
+	overProvision int
+	zoneCounts    map[zoneKey]int
+	zoneSpare     int
+}
+
+// Here, you want desiredLen to be FInc. zones is from
+// topology.RMZones() and may be nil.
+func NewCombinationPicker(desiredLen int, disabledHashCodes map[common.RMId]server.EmptyStruct, zones map[common.RMId]string) *CombinationPicker {
 	dhc := make(map[common.RMId]bool, len(disabledHashCodes))
 	for hc := range disabledHashCodes {
 		dhc[hc] = false
 	}
+	if len(zones) == 0 {
+		zones = nil
+	}
 	return &CombinationPicker{
 		desiredLen:          desiredLen,
-		rmIdToOverProvision: make(map[common.RMId]*[]*int),
+		rmIdToOverProvision: make(map[common.RMId]*[]*provision),
 		disabledHashCodes:   dhc,
+		zones:               zones,
 		excluded:            make([]common.RMId, 0, desiredLen),
 	}
 }
 
 func (cp *CombinationPicker) AddPermutation(perm common.RMIds) {
-	op := len(perm) - cp.desiredLen
+	op := &provision{overProvision: len(perm) - cp.desiredLen}
+	if cp.zones != nil {
+		op.zoneCounts = make(map[zoneKey]int, len(perm))
+	}
 	for _, rmId := range perm {
 		if excluded, found := cp.disabledHashCodes[rmId]; found {
-			op--
+			op.overProvision--
 			if !excluded {
 				cp.excluded = append(cp.excluded, rmId)
 				cp.disabledHashCodes[rmId] = true
 			}
-		} else if listPtr, found := cp.rmIdToOverProvision[rmId]; found {
-			*listPtr = append(*listPtr, &op)
+			continue
+		}
+		if listPtr, found := cp.rmIdToOverProvision[rmId]; found {
+			*listPtr = append(*listPtr, op)
 		} else {
-			opL := make([]*int, 1, cp.desiredLen)
-			opL[0] = &op
+			opL := make([]*provision, 1, cp.desiredLen)
+			opL[0] = op
 			cp.rmIdToOverProvision[rmId] = &opL
 		}
+		if cp.zones != nil {
+			op.zoneCounts[zoneOf(cp.zones, rmId)]++
+		}
 	}
-	if op < 0 {
+	if op.overProvision < 0 {
 		cp.errored = true
 	}
+	if zoneCount := len(op.zoneCounts); zoneCount > cp.desiredLen {
+		op.zoneSpare = zoneCount - cp.desiredLen
+	}
 }
 
 // Consider that the lists here will always be the same length, and
@@ -96,7 +131,7 @@ func (cp *CombinationPicker) AddPermutation(perm common.RMIds) {
 // way of doing a map in which we never need to do random lookups.
 type rmToOPLs struct {
 	rmIds           common.RMIds
-	overProvisionsL []*[]*int
+	overProvisionsL []*[]*provision
 }
 
 func (cp *CombinationPicker) freqAnalysis() ([]int, map[int]*rmToOPLs) {
@@ -113,7 +148,7 @@ func (cp *CombinationPicker) freqAnalysis() ([]int, map[int]*rmToOPLs) {
 		} else {
 			rmIds := make([]common.RMId, 1, cp.desiredLen)
 			rmIds[0] = rmId
-			overProvisionsL := make([]*[]*int, 1, cp.desiredLen)
+			overProvisionsL := make([]*[]*provision, 1, cp.desiredLen)
 			overProvisionsL[0] = overProvisions
 			r2opls := &rmToOPLs{
 				rmIds:           rmIds,
@@ -146,12 +181,16 @@ func (cp *CombinationPicker) Choose() (common.RMIds, common.RMIds, error) {
 		for idx := 0; idx < len(rmIds); idx++ {
 			rmId := rmIds[idx]
 			overProvisions := overProvisionsL[idx]
+			var zone zoneKey
+			if cp.zones != nil {
+				zone = zoneOf(cp.zones, rmId)
+			}
 			zeroEncountered := false
 			for _, op := range *overProvisions {
-				if *op < 0 {
+				if op.overProvision < 0 {
 					return nil, nil, TooManyDisabledHashCodes
 				}
-				if *op == 0 {
+				if op.overProvision == 0 || (cp.zones != nil && op.zoneSpare == 0 && op.zoneCounts[zone] == 1) {
 					zeroEncountered = true
 					break
 				}
@@ -161,7 +200,13 @@ func (cp *CombinationPicker) Choose() (common.RMIds, common.RMIds, error) {
 			} else {
 				excluded = append(excluded, rmId)
 				for _, op := range *overProvisions {
-					(*op)--
+					op.overProvision--
+					if cp.zones != nil {
+						op.zoneCounts[zone]--
+						if op.zoneCounts[zone] == 0 {
+							op.zoneSpare--
+						}
+					}
 				}
 			}
 		}
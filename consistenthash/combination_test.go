@@ -250,6 +250,70 @@ func TestCombinationDisabled(t *testing.T) {
 	}
 }
 
+func TestCombinationZones(t *testing.T) {
+	zones := map[common.RMId]string{
+		hashcodes[0]: "a", hashcodes[1]: "a",
+		hashcodes[2]: "b", hashcodes[3]: "b",
+		hashcodes[4]: "c",
+	}
+
+	permA := []common.RMId{hashcodes[0], hashcodes[1], hashcodes[2], hashcodes[3], hashcodes[4]}
+	inc, _, err := chooseZonedComb(3, nil, zones, permA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectZones(t, inc, zones, 3, permA)
+
+	// Without zones, [0,1] is enough for both.
+	permA = []common.RMId{hashcodes[0], hashcodes[1], hashcodes[2]}
+	permB := []common.RMId{hashcodes[0], hashcodes[1], hashcodes[3]}
+	inc, _, err = chooseZonedComb(2, nil, zones, permA, permB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectZones(t, inc, zones, 2, permA, permB)
+
+	// With 2 disabled, permA only has zone a available.
+	disabled := map[common.RMId]server.EmptyStruct{hashcodes[2]: server.EmptyStructVal}
+	inc, exc, err := chooseZonedComb(2, disabled, zones, permA, permB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectZones(t, inc, zones, 1, permA)
+	expectZones(t, inc, zones, 2, permB)
+	for _, rmId := range inc {
+		if rmId == hashcodes[2] {
+			t.Errorf("Disabled %v included: %v (excluded %v)", rmId, inc, exc)
+		}
+	}
+}
+
+func chooseZonedComb(desiredLen int, disabled map[common.RMId]server.EmptyStruct, zones map[common.RMId]string, perms ...[]common.RMId) ([]common.RMId, []common.RMId, error) {
+	cp := NewCombinationPicker(desiredLen, disabled, zones)
+	for _, perm := range perms {
+		cp.AddPermutation(perm)
+	}
+	return cp.Choose()
+}
+
+// expectZones checks that, for each perm, the included members of it
+// come from at least zoneCount zones.
+func expectZones(t *testing.T, inc []common.RMId, zones map[common.RMId]string, zoneCount int, perms ...[]common.RMId) {
+	for _, perm := range perms {
+		found := make(map[string]bool)
+		for _, rmId := range inc {
+			for _, hc := range perm {
+				if hc == rmId {
+					found[zones[rmId]] = true
+				}
+			}
+		}
+		if len(found) < zoneCount {
+			t.Errorf("Expecting members of %v in %v to span %v zones, but found %v", perm, inc, zoneCount, found)
+		}
+	}
+}
+
 func isPermutationOf(perm, hashcodes []common.RMId) bool {
 	if len(perm) != len(hashcodes) {
 		return false
@@ -307,7 +371,7 @@ func benchmarkPicker(drawLimit, permLen, permCount, desiredLen int, b *testing.B
 	}
 	b.ResetTimer()
 	for idx := 0; idx < b.N; idx++ {
-		cp := NewCombinationPicker(desiredLen, nil)
+		cp := NewCombinationPicker(desiredLen, nil, nil)
 		for _, perm := range perms {
 			cp.AddPermutation(perm)
 		}
@@ -324,7 +388,7 @@ func randPerm(lim, length int) []common.RMId {
 }
 
 func chooseComb(desiredLen int, disabled map[common.RMId]server.EmptyStruct, perms ...[]common.RMId) ([]common.RMId, []common.RMId, error) {
-	cp := NewCombinationPicker(desiredLen, disabled)
+	cp := NewCombinationPicker(desiredLen, disabled, nil)
 	for _, perm := range perms {
 		cp.AddPermutation(perm)
 	}
@@ -21,20 +21,39 @@ type Resolver struct {
 	desiredLength int
 	permLen       uint16
 	indices       []uint16
+	zones         map[common.RMId]string
+	weights       map[common.RMId]uint16
 }
 
 // hashCodes is the rmIds from topology - i.e. it can contain
 // RMIdEmpty, and those RMIdEmpties do not contibute to the
-// desiredLength. Here, you want desiredLength to be TwoFInc
-func NewResolver(hashCodes common.RMIds, desiredLength uint16) *Resolver {
+// desiredLength. Here, you want desiredLength to be TwoFInc. zones is
+// from topology.RMZones() and may be nil. If it's not, the result is
+// spread across as many zones as possible. weights is from
+// topology.RMWeights() and may be nil. If it's not, the permutation is
+// biased towards the heavier rmIds.
+func NewResolver(hashCodes common.RMIds, desiredLength uint16, zones map[common.RMId]string, weights map[common.RMId]uint16) *Resolver {
 	if hashCodes.NonEmptyLen() < int(desiredLength) {
 		panic(fmt.Sprintf("Too few non-empty hashcodes: %v but need at least %v", hashCodes, desiredLength))
 	}
+	permLen := desiredLength + uint16(hashCodes.EmptyLen())
+	if len(weights) == 0 {
+		weights = nil
+	}
+	if len(zones) == 0 {
+		zones = nil
+	} else {
+		// we may have to go all the way to the end of the permutation
+		// to find enough zones.
+		permLen = uint16(len(hashCodes))
+	}
 	return &Resolver{
 		hashCodes:     hashCodes,
 		desiredLength: int(desiredLength),
-		permLen:       desiredLength + uint16(hashCodes.EmptyLen()),
+		permLen:       permLen,
 		indices:       straightIndices(len(hashCodes)),
+		zones:         zones,
+		weights:       weights,
 	}
 }
 
@@ -52,6 +71,14 @@ func (r *Resolver) ResolveHashCodes(positions []uint8) (common.RMIds, error) {
 		return nil, InsufficientPositionsError
 	}
 
+	if r.weights != nil {
+		perm := r.weightedPermutation(positions)
+		if r.zones != nil {
+			return spreadZones(perm, r.zones, r.desiredLength), nil
+		}
+		return perm[:r.desiredLength], nil
+	}
+
 	permLen := r.permLen
 	empties := make([]int, 0, permLen)
 	result := make([]common.RMId, permLen)
@@ -70,6 +97,10 @@ func (r *Resolver) ResolveHashCodes(positions []uint8) (common.RMIds, error) {
 		}
 	}
 
+	if r.zones != nil {
+		return spreadZones(result, r.zones, r.desiredLength), nil
+	}
+
 	if len(empties) != 0 {
 		sort.Ints(empties)
 		for idx, idy := range empties {
@@ -86,6 +117,14 @@ func (r *Resolver) ResolveHashCodes(positions []uint8) (common.RMIds, error) {
 // rmIdIdx is the index of the rmId in question within the
 // topology.RMs() slice.
 func (r *Resolver) RMIdHasVar(rmIdIdx int, positions []uint8) (bool, error) {
+	if r.zones != nil || r.weights != nil {
+		// With zones, any rmId can be skipped over in favour of one
+		// from a zone not yet in the result, and with weights the
+		// permutation is not built from positions in the same way, so
+		// none of the shortcuts below hold.
+		return r.resolvedHasRMId(rmIdIdx, positions)
+	}
+
 	// We do a bunch of cheap checks first of all to avoid calculating
 	// the permutation if we can avoid it.
 	position := int(positions[rmIdIdx])
@@ -112,6 +151,10 @@ func (r *Resolver) RMIdHasVar(rmIdIdx int, positions []uint8) (bool, error) {
 		return false, nil
 	}
 
+	return r.resolvedHasRMId(rmIdIdx, positions)
+}
+
+func (r *Resolver) resolvedHasRMId(rmIdIdx int, positions []uint8) (bool, error) {
 	perm, err := r.ResolveHashCodes(positions)
 	if err != nil {
 		return false, err
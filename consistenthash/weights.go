@@ -0,0 +1,70 @@
+package consistenthash
+
+import (
+	"goshawkdb.io/common"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// weightedPermutation orders the non-empty hashCodes by weighted
+// rendezvous hashing. The positions of a var are random and never
+// change, so we use them as the seed for a uniform draw u for each
+// rmId, and then order by -ln(u)/weight. This means each rmId is first
+// with probability proportional to its weight. As each rmId's draw
+// depends only on the var and the rmId's index in hashCodes, without
+// zones, changing the weight of, or adding or removing, one rmId only
+// moves vars to or from that rmId.
+func (r *Resolver) weightedPermutation(positions []uint8) common.RMIds {
+	hash := fnv.New64a()
+	hash.Write(positions)
+	seed := hash.Sum64()
+
+	keys := make(weightedKeys, 0, len(r.hashCodes))
+	for idx, rmId := range r.hashCodes {
+		if rmId == common.RMIdEmpty {
+			continue
+		}
+		weight, found := r.weights[rmId]
+		if !found {
+			weight = 1
+		}
+		// 53 bits for the mantissa, and never 0.
+		u := (float64(mix64(seed^uint64(idx))>>11) + 0.5) / (1 << 53)
+		keys = append(keys, weightedKey{
+			rmId: rmId,
+			key:  -math.Log(u) / float64(weight),
+		})
+	}
+	sort.Sort(keys)
+
+	perm := make([]common.RMId, len(keys))
+	for idx, wk := range keys {
+		perm[idx] = wk.rmId
+	}
+	return perm
+}
+
+// mix64 is the finalizer from splitmix64.
+func mix64(z uint64) uint64 {
+	z += 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+type weightedKey struct {
+	rmId common.RMId
+	key  float64
+}
+
+type weightedKeys []weightedKey
+
+func (wks weightedKeys) Len() int      { return len(wks) }
+func (wks weightedKeys) Swap(i, j int) { wks[i], wks[j] = wks[j], wks[i] }
+func (wks weightedKeys) Less(i, j int) bool {
+	if wks[i].key == wks[j].key {
+		return wks[i].rmId < wks[j].rmId
+	}
+	return wks[i].key < wks[j].key
+}
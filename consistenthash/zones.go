@@ -0,0 +1,71 @@
+package consistenthash
+
+import (
+	"goshawkdb.io/common"
+)
+
+// zoneKey identifies a failure domain. RMIds with a zone share the
+// zoneKey of that zone; RMIds without one are each their own failure
+// domain.
+type zoneKey struct {
+	zone string
+	rmId common.RMId
+}
+
+func zoneOf(zones map[common.RMId]string, rmId common.RMId) zoneKey {
+	if zone, found := zones[rmId]; found {
+		return zoneKey{zone: zone}
+	}
+	return zoneKey{rmId: rmId}
+}
+
+// spreadZones picks desiredLength RMIds from perm, ignoring
+// RMIdEmpty. Each RMId is given a round: the number of RMIds from the
+// same zone which come before it in perm. We take every RMId of round
+// 0 (i.e. the first of each zone), then every RMId of round 1 and so
+// on, until we have enough. Within each round, RMIds are taken in perm
+// order. So if there are at least desiredLength zones, every RMId in
+// the result is from a different zone, and otherwise the zones are
+// used as evenly as possible. The result is ordered by round, so the
+// same is true of every prefix of it.
+func spreadZones(perm common.RMIds, zones map[common.RMId]string, desiredLength int) common.RMIds {
+	rounds := make([]int, len(perm))
+	roundSizes := make([]int, 0, len(perm))
+	zoneCounts := make(map[zoneKey]int, len(perm))
+	for idx, rmId := range perm {
+		if rmId == common.RMIdEmpty {
+			rounds[idx] = -1
+			continue
+		}
+		key := zoneOf(zones, rmId)
+		round := zoneCounts[key]
+		zoneCounts[key] = round + 1
+		rounds[idx] = round
+		if round == len(roundSizes) {
+			roundSizes = append(roundSizes, 1)
+		} else {
+			roundSizes[round]++
+		}
+	}
+
+	lastRound, remaining := 0, desiredLength
+	for ; lastRound < len(roundSizes) && remaining > roundSizes[lastRound]; lastRound++ {
+		remaining -= roundSizes[lastRound]
+	}
+
+	result := make([]common.RMId, 0, desiredLength)
+	for round := 0; round <= lastRound; round++ {
+		for idx, rmId := range perm {
+			if rounds[idx] != round {
+				continue
+			} else if round == lastRound {
+				if remaining == 0 {
+					break
+				}
+				remaining--
+			}
+			result = append(result, rmId)
+		}
+	}
+	return result
+}
@@ -19,4 +19,12 @@ const (
 	ConnectionRestartDelayMin     = 3 * time.Second
 	MostRandomByteIndex           = 7 // will be the lsb of a big-endian client-n in the txnid.
 	MigrationBatchElemCount       = 64
+	AdminStatusTimeout            = 10 * time.Second
+	ImportBatchElemCount          = 64
+	GCBatchElemCount              = 64
+	HistoryPruneInterval          = time.Minute
+	TopologyEventCount            = 256
+	ChangeLogMaxRecordSize        = 64 * 1048576
+	ChangeLogDependencyTimeout    = time.Minute
+	ChangeLogServeLimit           = 1024
 )
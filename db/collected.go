@@ -0,0 +1,87 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	"time"
+)
+
+func init() {
+	DB.CollectedVars = &mdbs.DBISettings{Flags: mdb.CREATE}
+}
+
+// When the garbage collector collects a var, the var is deleted from
+// Vars and the txn of its current version is released, so the disk is
+// reclaimed. All that is left is a tombstone in CollectedVars, keyed
+// by the var id. The value is the big-endian unix nanos at which the
+// var was collected, followed by the Var root bytes at the version
+// written by the collecting txn. The collecting txn itself is not
+// kept, so tombstones hold no txn references. Should the var be
+// referenced again whilst its tombstone remains, it is restored from
+// here, so that txns which use it fail rather than silently re-create
+// it empty. Tombstones are removed once they are older than the
+// garbage collector's tombstone retention.
+
+// WriteCollectedVar writes the tombstone of the var, which was
+// collected at collectedAt.
+func (db *Databases) WriteCollectedVar(rwtxn *mdbs.RWTxn, vUUId *common.VarUUId, collectedAt time.Time, varBites []byte) error {
+	value := make([]byte, 8+len(varBites))
+	binary.BigEndian.PutUint64(value, uint64(collectedAt.UnixNano()))
+	copy(value[8:], varBites)
+	return rwtxn.Put(db.CollectedVars, vUUId[:], value, 0)
+}
+
+// CollectedVarFromData splits the value of a tombstone into the time
+// at which the var was collected and its Var root bytes.
+func CollectedVarFromData(value []byte) (time.Time, []byte, error) {
+	if len(value) < 8 {
+		return time.Time{}, nil, fmt.Errorf("Malformed collected var (value length %v)", len(value))
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value))), value[8:], nil
+}
+
+// PruneCollectedVars removes every tombstone of a var collected
+// before cutoff. It returns the number of tombstones removed. As
+// tombstones hold no txn references, nothing else is released. Every
+// tombstone is visited, but there are only as many as were collected
+// within the retention.
+func (db *Databases) PruneCollectedVars(cutoff time.Time) (int, error) {
+	res, err := db.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
+		// copy as we delete whilst iterating.
+		expired := [][]byte{}
+		_, err := rwtxn.WithCursor(db.CollectedVars, func(cursor *mdbs.Cursor) interface{} {
+			key, value, err := cursor.Get(nil, nil, mdb.FIRST)
+			for ; err == nil; key, value, err = cursor.Get(nil, nil, mdb.NEXT) {
+				collectedAt, _, err := CollectedVarFromData(value)
+				if err != nil {
+					cursor.Error(err)
+					return nil
+				} else if collectedAt.Before(cutoff) {
+					expired = append(expired, append([]byte{}, key...))
+				}
+			}
+			if err != nil && err != mdb.NotFound {
+				cursor.Error(err)
+			}
+			return nil
+		})
+		if err != nil {
+			rwtxn.Error(err)
+			return nil
+		}
+		for _, key := range expired {
+			if err := rwtxn.Del(db.CollectedVars, key, nil); err != nil {
+				rwtxn.Error(err)
+				return nil
+			}
+		}
+		return len(expired)
+	}).ResultError()
+	if err != nil || res == nil {
+		return 0, err
+	}
+	return res.(int), nil
+}
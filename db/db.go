@@ -1,16 +1,20 @@
 package db
 
 import (
+	mdb "github.com/msackman/gomdb"
 	mdbs "github.com/msackman/gomdb/server"
 )
 
 type Databases struct {
 	*mdbs.MDBServer
-	Vars            *mdbs.DBISettings
-	Proposers       *mdbs.DBISettings
-	BallotOutcomes  *mdbs.DBISettings
-	Transactions    *mdbs.DBISettings
-	TransactionRefs *mdbs.DBISettings
+	Vars             *mdbs.DBISettings
+	Proposers        *mdbs.DBISettings
+	BallotOutcomes   *mdbs.DBISettings
+	Transactions     *mdbs.DBISettings
+	TransactionRefs  *mdbs.DBISettings
+	VarHistory       *mdbs.DBISettings
+	VarHistoryExpiry *mdbs.DBISettings
+	CollectedVars    *mdbs.DBISettings
 }
 
 var (
@@ -19,14 +23,28 @@ var (
 
 func (db *Databases) Clone() mdbs.DBIsInterface {
 	return &Databases{
-		Vars:            db.Vars.Clone(),
-		Proposers:       db.Proposers.Clone(),
-		BallotOutcomes:  db.BallotOutcomes.Clone(),
-		Transactions:    db.Transactions.Clone(),
-		TransactionRefs: db.TransactionRefs.Clone(),
+		Vars:             db.Vars.Clone(),
+		Proposers:        db.Proposers.Clone(),
+		BallotOutcomes:   db.BallotOutcomes.Clone(),
+		Transactions:     db.Transactions.Clone(),
+		TransactionRefs:  db.TransactionRefs.Clone(),
+		VarHistory:       db.VarHistory.Clone(),
+		VarHistoryExpiry: db.VarHistoryExpiry.Clone(),
+		CollectedVars:    db.CollectedVars.Clone(),
 	}
 }
 
+// ReadOnly returns databases which, passed to mdbs.NewMDBServer with
+// mdb.RDONLY, open an existing data directory without creating or
+// changing anything in it.
+func ReadOnly() *Databases {
+	ro := DB.Clone().(*Databases)
+	for _, dbi := range []*mdbs.DBISettings{ro.Vars, ro.Proposers, ro.BallotOutcomes, ro.Transactions, ro.TransactionRefs, ro.VarHistory, ro.VarHistoryExpiry, ro.CollectedVars} {
+		dbi.Flags &^= mdb.CREATE
+	}
+	return ro
+}
+
 func (db *Databases) SetServer(server *mdbs.MDBServer) {
 	db.MDBServer = server
 }
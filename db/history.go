@@ -0,0 +1,181 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"time"
+)
+
+func init() {
+	DB.VarHistory = &mdbs.DBISettings{Flags: mdb.CREATE}
+	DB.VarHistoryExpiry = &mdbs.DBISettings{Flags: mdb.CREATE}
+}
+
+// When the cluster is configured to retain history, a var which is
+// written does not release the txn of the version it supersedes.
+// Instead, the superseded version is kept in VarHistory until the
+// retention window has passed. Entries are keyed by the var id
+// followed by the big-endian clock elem of the version, so the
+// history of a var is contiguous and in order. The value is the
+// big-endian unix nanos at which the version was superseded,
+// followed by the Var root bytes of the version.
+//
+// So that pruning need not scan every entry, VarHistoryExpiry indexes
+// the entries by when they were superseded: its keys are the
+// big-endian unix nanos followed by the VarHistory key, and its values
+// are the id of the txn which wrote the version.
+type VarHistoryEntry struct {
+	ClockElem    uint64
+	SupersededAt time.Time
+	Var          *msgs.Var
+}
+
+func varHistoryKey(vUUId *common.VarUUId, clockElem uint64) []byte {
+	key := make([]byte, common.KeyLen+8)
+	copy(key, vUUId[:])
+	binary.BigEndian.PutUint64(key[common.KeyLen:], clockElem)
+	return key
+}
+
+func varHistoryExpiryKey(supersededAt uint64, historyKey []byte) []byte {
+	key := make([]byte, 8+len(historyKey))
+	binary.BigEndian.PutUint64(key, supersededAt)
+	copy(key[8:], historyKey)
+	return key
+}
+
+// WriteVarHistory retains the version of the var written by txnId,
+// which must already be on disk. The caller's reference to the txn
+// passes to the history.
+func (db *Databases) WriteVarHistory(rwtxn *mdbs.RWTxn, vUUId *common.VarUUId, clockElem uint64, supersededAt time.Time, txnId *common.TxnId, varBites []byte) error {
+	nanos := uint64(supersededAt.UnixNano())
+	key := varHistoryKey(vUUId, clockElem)
+	value := make([]byte, 8+len(varBites))
+	binary.BigEndian.PutUint64(value, nanos)
+	copy(value[8:], varBites)
+	if err := rwtxn.Put(db.VarHistory, key, value, 0); err != nil {
+		return err
+	}
+	return rwtxn.Put(db.VarHistoryExpiry, varHistoryExpiryKey(nanos, key), txnId[:], 0)
+}
+
+// ImmigrateVarHistory retains a version of a var received from
+// another node during a topology change, along with the txn which
+// wrote it. Versions already retained are ignored, so migrations can
+// be received more than once.
+func (db *Databases) ImmigrateVarHistory(rwtxn *mdbs.RWTxn, vUUId *common.VarUUId, clockElem uint64, supersededAt time.Time, varBites []byte, txnId *common.TxnId, txnBites []byte) error {
+	if _, err := rwtxn.Get(db.VarHistory, varHistoryKey(vUUId, clockElem)); err == nil {
+		return nil
+	} else if err != mdb.NotFound {
+		return err
+	}
+	if err := db.WriteTxnToDisk(rwtxn, txnId, txnBites); err != nil {
+		return err
+	}
+	return db.WriteVarHistory(rwtxn, vUUId, clockElem, supersededAt, txnId, varBites)
+}
+
+// VarToRootBytes copies varCap into a segment of its own, as is
+// stored on disk.
+func VarToRootBytes(varCap *msgs.Var) []byte {
+	seg := capn.NewBuffer(nil)
+	rootCap := msgs.NewRootVar(seg)
+	rootCap.SetId(varCap.Id())
+	rootCap.SetPositions(varCap.Positions())
+	rootCap.SetWriteTxnId(varCap.WriteTxnId())
+	rootCap.SetWriteTxnClock(varCap.WriteTxnClock())
+	rootCap.SetWritesClock(varCap.WritesClock())
+	return server.SegToBytes(seg)
+}
+
+func varHistoryEntryFromData(key, value []byte) (*VarHistoryEntry, error) {
+	if len(key) != common.KeyLen+8 || len(value) < 8 {
+		return nil, fmt.Errorf("Malformed var history entry (key length %v; value length %v)", len(key), len(value))
+	}
+	seg, _, err := capn.ReadFromMemoryZeroCopy(value[8:])
+	if err != nil {
+		return nil, err
+	}
+	varCap := msgs.ReadRootVar(seg)
+	return &VarHistoryEntry{
+		ClockElem:    binary.BigEndian.Uint64(key[common.KeyLen:]),
+		SupersededAt: time.Unix(0, int64(binary.BigEndian.Uint64(value))),
+		Var:          &varCap,
+	}, nil
+}
+
+// ReadVarHistory returns every past version of the var which is
+// retained on disk, oldest first. The Vars of the entries are only
+// valid within rtxn.
+func (db *Databases) ReadVarHistory(rtxn *mdbs.RTxn, vUUId *common.VarUUId) ([]*VarHistoryEntry, error) {
+	res, err := rtxn.WithCursor(db.VarHistory, func(cursor *mdbs.Cursor) interface{} {
+		entries := []*VarHistoryEntry{}
+		key, value, err := cursor.Get(varHistoryKey(vUUId, 0), nil, mdb.SET_RANGE)
+		for ; err == nil && bytes.HasPrefix(key, vUUId[:]); key, value, err = cursor.Get(nil, nil, mdb.NEXT) {
+			entry, err := varHistoryEntryFromData(key, value)
+			if err != nil {
+				cursor.Error(err)
+				return nil
+			}
+			entries = append(entries, entry)
+		}
+		if err != nil && err != mdb.NotFound {
+			cursor.Error(err)
+			return nil
+		}
+		return entries
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.([]*VarHistoryEntry), nil
+}
+
+// PruneVarHistory removes every entry superseded before cutoff, and
+// releases the txns which wrote them. It returns the number of
+// entries removed. Only the expired entries are visited.
+func (db *Databases) PruneVarHistory(cutoff time.Time) (int, error) {
+	cutoffNanos := uint64(cutoff.UnixNano())
+	res, err := db.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
+		// copy as we delete whilst iterating.
+		expired := make(map[string]*common.TxnId)
+		_, err := rwtxn.WithCursor(db.VarHistoryExpiry, func(cursor *mdbs.Cursor) interface{} {
+			key, value, err := cursor.Get(nil, nil, mdb.FIRST)
+			for ; err == nil && binary.BigEndian.Uint64(key) < cutoffNanos; key, value, err = cursor.Get(nil, nil, mdb.NEXT) {
+				expired[string(key)] = common.MakeTxnId(append([]byte{}, value...))
+			}
+			if err != nil && err != mdb.NotFound {
+				cursor.Error(err)
+			}
+			return nil
+		})
+		if err != nil {
+			rwtxn.Error(err)
+			return nil
+		}
+		for key, txnId := range expired {
+			if err := rwtxn.Del(db.VarHistoryExpiry, []byte(key), nil); err != nil {
+				rwtxn.Error(err)
+				return nil
+			} else if err = rwtxn.Del(db.VarHistory, []byte(key[8:]), nil); err != nil && err != mdb.NotFound {
+				rwtxn.Error(err)
+				return nil
+			} else if err = db.DeleteTxnFromDisk(rwtxn, txnId); err != nil {
+				rwtxn.Error(err)
+				return nil
+			}
+		}
+		return len(expired)
+	}).ResultError()
+	if err != nil || res == nil {
+		return 0, err
+	}
+	return res.(int), nil
+}
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const dataDirLockFile = "server.lock"
+
+// LockDataDir takes an exclusive lock on the data directory, which is
+// held until the returned file is closed or the process exits. The
+// server holds it for as long as it runs, so that tools which write
+// to the data directory can refuse to do so underneath it.
+func LockDataDir(dir string) (*os.File, error) {
+	file, err := os.OpenFile(filepath.Join(dir, dataDirLockFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("%v is in use by another process", dir)
+		}
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLockDataDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lock, err := LockDataDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The lock is exclusive, even within the same process.
+	if second, err := LockDataDir(dir); err == nil {
+		second.Close()
+		t.Fatal("Locked a data dir which was already locked")
+	}
+	if err = lock.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Once released, it can be taken again.
+	if lock, err = LockDataDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	lock.Close()
+}
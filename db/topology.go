@@ -0,0 +1,79 @@
+package db
+
+import (
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+)
+
+// LoadTopology reads the topology directly from disk, for tools which
+// run without the rest of the server. It returns nil, nil if there is
+// no topology in the database.
+func (db *Databases) LoadTopology() (*configuration.Topology, error) {
+	res, err := db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		bites, err := rtxn.Get(db.Vars, configuration.TopologyVarUUId[:])
+		if err == mdb.NotFound {
+			return nil
+		} else if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		seg, _, err := capn.ReadFromMemoryZeroCopy(bites)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		varCap := msgs.ReadRootVar(seg)
+		txnId := common.MakeTxnId(varCap.WriteTxnId())
+		bites = db.ReadTxnBytesFromDisk(rtxn, txnId)
+		if bites == nil {
+			rtxn.Error(fmt.Errorf("Unable to find txn for topology: %v", txnId))
+			return nil
+		}
+		seg, _, err = capn.ReadFromMemoryZeroCopy(bites)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		txnCap := msgs.ReadRootTxn(seg)
+		actions := txnCap.Actions()
+		if actions.Len() != 1 {
+			rtxn.Error(fmt.Errorf("Topology txn has %v actions; expected 1", actions.Len()))
+			return nil
+		}
+		action := actions.At(0)
+		var refs msgs.VarIdPos_List
+		switch action.Which() {
+		case msgs.ACTION_WRITE:
+			w := action.Write()
+			bites = w.Value()
+			refs = w.References()
+		case msgs.ACTION_READWRITE:
+			rw := action.Readwrite()
+			bites = rw.Value()
+			refs = rw.References()
+		case msgs.ACTION_CREATE:
+			c := action.Create()
+			bites = c.Value()
+			refs = c.References()
+		default:
+			rtxn.Error(fmt.Errorf("Expected topology txn action to be w, rw, or c; found %v", action.Which()))
+			return nil
+		}
+
+		topology, err := configuration.TopologyFromCap(txnId, &refs, bites)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		return topology
+	}).ResultError()
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.(*configuration.Topology), nil
+}
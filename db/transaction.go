@@ -27,6 +27,8 @@ func TxnToRootBytes(txn *msgs.Txn) []byte {
 	txnCap.SetAllocations(txn.Allocations())
 	txnCap.SetFInc(txn.FInc())
 	txnCap.SetTopologyVersion(txn.TopologyVersion())
+	txnCap.SetCollect(txn.Collect())
+	txnCap.SetReadOnly(txn.ReadOnly())
 
 	return server.SegToBytes(seg)
 }
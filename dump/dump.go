@@ -0,0 +1,139 @@
+package dump
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	msgs "goshawkdb.io/server/capnp"
+	"io"
+)
+
+// Dump is the JSON form of the object graph reachable from the
+// roots, as written by goshawkdb-export and read by goshawkdb
+// -import. Ids are hex encoded; values are base64 encoded by
+// encoding/json. Root is the default root; Roots holds any further
+// named roots, by name.
+type Dump struct {
+	ClusterId       string
+	TopologyVersion uint32
+	Root            string
+	Roots           map[string]string `json:",omitempty"`
+	Vars            []*Var
+}
+
+type Var struct {
+	Id         string
+	Positions  []int
+	WriteTxnId string
+	Value      []byte
+	References []*Reference
+}
+
+type Reference struct {
+	Id        string
+	Positions []int
+}
+
+func Read(r io.Reader) (*Dump, error) {
+	d := &Dump{}
+	if err := json.NewDecoder(r).Decode(d); err != nil {
+		return nil, err
+	}
+	if _, err := ParseVarUUId(d.Root); err != nil {
+		return nil, fmt.Errorf("Dump has illegal root: %v", err)
+	}
+	for name, root := range d.Roots {
+		if _, err := ParseVarUUId(root); err != nil {
+			return nil, fmt.Errorf("Dump has illegal root %v: %v", name, err)
+		}
+	}
+	return d, nil
+}
+
+func (d *Dump) Write(w io.Writer) error {
+	bites, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bites)
+	return err
+}
+
+func FormatVarUUId(vUUId *common.VarUUId) string {
+	return hex.EncodeToString(vUUId[:])
+}
+
+func ParseVarUUId(str string) (*common.VarUUId, error) {
+	bites, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+	if len(bites) != common.KeyLen {
+		return nil, fmt.Errorf("Var id %v has wrong length: %v (expected %v)", str, len(bites), common.KeyLen)
+	}
+	return common.MakeVarUUId(bites), nil
+}
+
+func FormatPositions(positions capn.UInt8List) []int {
+	result := make([]int, positions.Len())
+	for idx := range result {
+		result[idx] = int(positions.At(idx))
+	}
+	return result
+}
+
+// MakeVar extracts the value and references of the var from its
+// action within txnCap, which must be the txn that wrote the version
+// of the var in varCap.
+func MakeVar(vUUId *common.VarUUId, varCap *msgs.Var, txnCap *msgs.Txn) (*Var, msgs.VarIdPos_List, error) {
+	txnId := common.MakeTxnId(txnCap.Id())
+	actions := txnCap.Actions()
+	for idx, l := 0, actions.Len(); idx < l; idx++ {
+		action := actions.At(idx)
+		if !bytes.Equal(action.VarId(), vUUId[:]) {
+			continue
+		}
+		var value []byte
+		var refs msgs.VarIdPos_List
+		switch action.Which() {
+		case msgs.ACTION_WRITE:
+			w := action.Write()
+			value, refs = w.Value(), w.References()
+		case msgs.ACTION_READWRITE:
+			rw := action.Readwrite()
+			value, refs = rw.Value(), rw.References()
+		case msgs.ACTION_CREATE:
+			c := action.Create()
+			value, refs = c.Value(), c.References()
+		case msgs.ACTION_ROLL:
+			r := action.Roll()
+			value, refs = r.Value(), r.References()
+		default:
+			return nil, msgs.VarIdPos_List{}, fmt.Errorf("%v last written by %v, but the action is %v", vUUId, txnId, action.Which())
+		}
+		v := &Var{
+			Id:         FormatVarUUId(vUUId),
+			Positions:  FormatPositions(varCap.Positions()),
+			WriteTxnId: txnId.String(),
+			Value:      value,
+			References: MakeReferences(refs),
+		}
+		return v, refs, nil
+	}
+	return nil, msgs.VarIdPos_List{}, fmt.Errorf("%v last written by %v, but the txn has no action for it", vUUId, txnId)
+}
+
+func MakeReferences(refs msgs.VarIdPos_List) []*Reference {
+	result := make([]*Reference, refs.Len())
+	for idx := range result {
+		ref := refs.At(idx)
+		result[idx] = &Reference{
+			Id:        FormatVarUUId(common.MakeVarUUId(ref.Id())),
+			Positions: FormatPositions(ref.Positions()),
+		}
+	}
+	return result
+}
@@ -0,0 +1,261 @@
+package dump
+
+import (
+	"encoding/binary"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	ch "goshawkdb.io/server/consistenthash"
+	"goshawkdb.io/server/db"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// Store is a data dir opened for export. The data dir must not be in
+// use by a running server: either stop the server first, or export
+// from a backup.
+type Store struct {
+	Dir      string
+	RMId     common.RMId
+	Topology *configuration.Topology
+	db       *db.Databases
+}
+
+type Stores []*Store
+
+// OpenStore loads the rmid, starts the disk and loads the topology of
+// the data dir.
+func OpenStore(dir string) (*Store, error) {
+	s := &Store{Dir: dir}
+	err := s.loadRMId()
+	if err == nil {
+		if err = s.startDisk(); err == nil {
+			err = s.loadTopology()
+		}
+	}
+	if err != nil {
+		s.Shutdown()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (ss Stores) CheckEqualTopology() error {
+	var first *Store
+	for idx, s := range ss {
+		if idx == 0 {
+			first = s
+		} else if !first.Topology.Configuration.Equal(s.Topology.Configuration) {
+			return fmt.Errorf("Unequal topologies: %v has %v; %v has %v",
+				first, first.Topology, s, s.Topology)
+		}
+	}
+	return nil
+}
+
+func (ss Stores) Shutdown() {
+	for _, s := range ss {
+		s.Shutdown()
+	}
+}
+
+func (s *Store) Shutdown() {
+	if s.db == nil {
+		return
+	}
+	s.db.Shutdown()
+	s.db = nil
+}
+
+func (s *Store) String() string {
+	return fmt.Sprintf("%v(%v)", s.RMId, s.Dir)
+}
+
+func (s *Store) loadRMId() error {
+	rmIdBytes, err := ioutil.ReadFile(filepath.Join(s.Dir, "rmid"))
+	if err != nil {
+		return err
+	}
+	s.RMId = common.RMId(binary.BigEndian.Uint32(rmIdBytes))
+	return nil
+}
+
+func (s *Store) startDisk() error {
+	log.Printf("Starting disk server on %v", s.Dir)
+	disk, err := mdbs.NewMDBServer(s.Dir, 0, 0600, server.MDBInitialSize, 2, 10*time.Millisecond, db.DB)
+	if err != nil {
+		return err
+	}
+	s.db = disk.(*db.Databases)
+	return nil
+}
+
+func (s *Store) loadTopology() error {
+	topology, err := s.db.LoadTopology()
+	if err != nil {
+		return err
+	} else if topology == nil || topology.Root.VarUUId == nil {
+		return fmt.Errorf("%v: no topology with a root found", s)
+	}
+	s.Topology = topology
+	return nil
+}
+
+// LoadVar returns nil, nil if the var is not in this store.
+func (s *Store) LoadVar(vUUId *common.VarUUId) (*msgs.Var, error) {
+	res, err := s.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		bites, err := rtxn.Get(s.db.Vars, vUUId[:])
+		if err == mdb.NotFound {
+			return nil
+		} else if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		seg, _, err := capn.ReadFromMemoryZeroCopy(bites)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		varCap := msgs.ReadRootVar(seg)
+		return &varCap
+	}).ResultError()
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.(*msgs.Var), nil
+}
+
+// LoadVarValue finds the txn which last wrote to the var and extracts
+// the value and references from the var's action within it.
+func (s *Store) LoadVarValue(vUUId *common.VarUUId, varCap *msgs.Var) (*Var, msgs.VarIdPos_List, error) {
+	txnId := common.MakeTxnId(varCap.WriteTxnId())
+	res, err := s.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		return s.db.ReadTxnBytesFromDisk(rtxn, txnId)
+	}).ResultError()
+	if err != nil {
+		return nil, msgs.VarIdPos_List{}, err
+	}
+	txnBites, ok := res.([]byte)
+	if res == nil || (ok && txnBites == nil) {
+		return nil, msgs.VarIdPos_List{}, fmt.Errorf("Failed to find %v from %v in %v", txnId, vUUId, s)
+	}
+	seg, _, err := capn.ReadFromMemoryZeroCopy(txnBites)
+	if err != nil {
+		return nil, msgs.VarIdPos_List{}, err
+	}
+	txnCap := msgs.ReadRootTxn(seg)
+	v, refs, err := MakeVar(vUUId, varCap, &txnCap)
+	if err != nil {
+		return nil, msgs.VarIdPos_List{}, fmt.Errorf("%v: %v", s, err)
+	}
+	return v, refs, nil
+}
+
+type exporter struct {
+	stores   Stores
+	topology *configuration.Topology
+	resolver *ch.Resolver
+}
+
+// Export walks the graph breadth first from the roots. Each var is
+// loaded from a store which, according to the topology, should hold
+// it. Stores which have since had the var emigrated away may hold a
+// stale copy, so those are only used as a last resort. Every node's
+// store should be supplied so that every reachable var can be found,
+// and the stores must have equal topologies.
+func Export(stores Stores) (*Dump, error) {
+	if len(stores) == 0 {
+		return nil, fmt.Errorf("No stores supplied")
+	}
+	topology := stores[0].Topology
+	e := &exporter{
+		stores:   stores,
+		topology: topology,
+		resolver: ch.NewResolver(topology.RMs(), topology.TwoFInc, topology.RMZones(), topology.RMWeights()),
+	}
+	return e.export()
+}
+
+func (e *exporter) export() (*Dump, error) {
+	root := e.topology.Root.VarUUId
+	if root == nil {
+		return nil, fmt.Errorf("Topology has no root var: %v", e.topology)
+	}
+	d := &Dump{
+		ClusterId:       e.topology.ClusterId,
+		TopologyVersion: e.topology.Version,
+		Root:            FormatVarUUId(root),
+	}
+
+	seen := map[common.VarUUId]server.EmptyStruct{*root: server.EmptyStructVal}
+	queue := []*common.VarUUId{root}
+	for _, name := range e.topology.RootNames {
+		namedRoot := e.topology.NamedRoot(name)
+		if namedRoot == nil {
+			return nil, fmt.Errorf("Topology has no root var for %v: %v", name, e.topology)
+		}
+		if d.Roots == nil {
+			d.Roots = make(map[string]string, len(e.topology.RootNames))
+		}
+		d.Roots[name] = FormatVarUUId(namedRoot.VarUUId)
+		seen[*namedRoot.VarUUId] = server.EmptyStructVal
+		queue = append(queue, namedRoot.VarUUId)
+	}
+	for len(queue) > 0 {
+		vUUId := queue[0]
+		queue = queue[1:]
+		v, refs, err := e.loadVar(vUUId)
+		if err != nil {
+			return nil, err
+		}
+		d.Vars = append(d.Vars, v)
+		for idx, l := 0, refs.Len(); idx < l; idx++ {
+			refId := common.MakeVarUUId(refs.At(idx).Id())
+			if _, found := seen[*refId]; !found {
+				seen[*refId] = server.EmptyStructVal
+				queue = append(queue, refId)
+			}
+		}
+	}
+	return d, nil
+}
+
+func (e *exporter) loadVar(vUUId *common.VarUUId) (*Var, msgs.VarIdPos_List, error) {
+	var fallback *Store
+	for _, s := range e.stores {
+		varCap, err := s.LoadVar(vUUId)
+		if err != nil {
+			return nil, msgs.VarIdPos_List{}, err
+		} else if varCap == nil {
+			continue
+		}
+		rmIds, err := e.resolver.ResolveHashCodes(varCap.Positions().ToArray())
+		if err != nil {
+			return nil, msgs.VarIdPos_List{}, err
+		}
+		for _, rmId := range rmIds {
+			if rmId == s.RMId {
+				return s.LoadVarValue(vUUId, varCap)
+			}
+		}
+		if fallback == nil {
+			fallback = s
+		}
+	}
+	if fallback == nil {
+		return nil, msgs.VarIdPos_List{}, fmt.Errorf("Failed to find %v in any store", vUUId)
+	}
+	log.Printf("Warning: %v only found in %v which should not hold it.", vUUId, fallback)
+	varCap, err := fallback.LoadVar(vUUId)
+	if err != nil {
+		return nil, msgs.VarIdPos_List{}, err
+	}
+	return fallback.LoadVarValue(vUUId, varCap)
+}
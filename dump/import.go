@@ -0,0 +1,278 @@
+package dump
+
+import (
+	"bytes"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/client"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/network"
+	eng "goshawkdb.io/server/txnengine"
+)
+
+// Import loads the dump into the cluster of the connection manager,
+// waiting first for the cluster to form. The cluster must be fresh:
+// the roots must have never been written to. Vars keep their ids but
+// are given new positions. Each of the dump's roots is mapped onto the
+// cluster's root of the same name.
+//
+// Because the graph may contain cycles, the import happens in two
+// passes: first every var other than the roots is created empty, and
+// then every var (including the roots) is written with its value and
+// references.
+func Import(d *Dump, cm *network.ConnectionManager) error {
+	i := &importer{
+		dump:         d,
+		lc:           cm.LocalConnection,
+		cm:           cm,
+		topologyChan: make(chan *configuration.Topology, 1),
+		roots:        make(map[common.VarUUId]*common.VarUUId, 1+len(d.Roots)),
+		positions:    make(map[common.VarUUId]*common.Positions, len(d.Vars)),
+	}
+	return i.run()
+}
+
+type importer struct {
+	dump         *Dump
+	lc           *client.LocalConnection
+	cm           *network.ConnectionManager
+	topologyChan chan *configuration.Topology
+	// roots maps the roots of the dump to the roots of the cluster
+	roots     map[common.VarUUId]*common.VarUUId
+	positions map[common.VarUUId]*common.Positions
+}
+
+// We subscribe in the same way as client connections do, so we never
+// hold up topology changes.
+func (i *importer) TopologyChanged(topology *configuration.Topology, done func(bool)) {
+	select {
+	case <-i.topologyChan:
+	default:
+	}
+	if topology != nil {
+		i.topologyChan <- topology
+	}
+	done(true)
+}
+
+func (i *importer) run() error {
+	defer i.cm.RemoveTopologySubscriberAsync(eng.ConnectionSubscriber, i)
+	if topology := i.cm.AddTopologySubscriber(eng.ConnectionSubscriber, i); topology != nil {
+		i.TopologyChanged(topology, func(bool) {})
+	}
+	var topology *configuration.Topology
+	for {
+		topology = <-i.topologyChan
+		if topology.Root.VarUUId != nil && topology.Next() == nil {
+			break
+		}
+	}
+
+	if err := i.mapRoots(topology); err != nil {
+		return err
+	}
+	for _, root := range i.roots {
+		if err := i.checkRootEmpty(root); err != nil {
+			return err
+		}
+	}
+	if err := i.createVars(); err != nil {
+		return err
+	}
+	return i.writeVars()
+}
+
+// mapRoots maps each root of the dump to the root of the cluster with
+// the same name.
+func (i *importer) mapRoots(topology *configuration.Topology) error {
+	names := map[string]string{configuration.DefaultRootName: i.dump.Root}
+	for name, root := range i.dump.Roots {
+		names[name] = root
+	}
+	for name, root := range names {
+		dumpRoot, err := ParseVarUUId(root)
+		if err != nil {
+			return err
+		}
+		clusterRoot := topology.NamedRoot(name)
+		if clusterRoot == nil {
+			return fmt.Errorf("Cluster has no root named %v", name)
+		}
+		i.roots[*dumpRoot] = clusterRoot.VarUUId
+		i.positions[*clusterRoot.VarUUId] = clusterRoot.Positions
+	}
+	return nil
+}
+
+func (i *importer) mapVarUUId(str string) (*common.VarUUId, error) {
+	vUUId, err := ParseVarUUId(str)
+	if err != nil {
+		return nil, err
+	}
+	if root, found := i.roots[*vUUId]; found {
+		return root, nil
+	}
+	return vUUId, nil
+}
+
+// checkRootEmpty reads the root at version zero. That must abort,
+// and the rerun tells us the current value of the root.
+func (i *importer) checkRootEmpty(root *common.VarUUId) error {
+	for {
+		seg := capn.NewBuffer(nil)
+		ctxn := cmsgs.NewClientTxn(seg)
+		ctxn.SetRetry(false)
+		actions := cmsgs.NewClientActionList(seg, 1)
+		ctxn.SetActions(actions)
+		action := actions.At(0)
+		action.SetVarId(root[:])
+		action.SetRead()
+		action.Read().SetVersion(common.VersionZero[:])
+
+		outcome, err := i.lc.RunClientTransaction(&ctxn, i.positions, true)
+		if err != nil {
+			return err
+		} else if outcome == nil {
+			return fmt.Errorf("Shutting down")
+		} else if outcome.Which() == msgs.OUTCOME_COMMIT {
+			return fmt.Errorf("Internal error: read of root at version zero failed to abort")
+		}
+		abort := outcome.Abort()
+		if abort.Which() == msgs.OUTCOMEABORT_RESUBMIT {
+			continue
+		}
+		updates := abort.Rerun()
+		for idx, l := 0, updates.Len(); idx < l; idx++ {
+			updateActions := updates.At(idx).Actions()
+			for idy, m := 0, updateActions.Len(); idy < m; idy++ {
+				updateAction := updateActions.At(idy)
+				if !bytes.Equal(updateAction.VarId(), root[:]) || updateAction.Which() != msgs.ACTION_WRITE {
+					continue
+				}
+				write := updateAction.Write()
+				if len(write.Value()) != 0 || write.References().Len() != 0 {
+					return fmt.Errorf("Root %v is not empty: import requires a fresh cluster", root)
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("Internal error: read of root at version zero gave no write of root")
+	}
+}
+
+func (i *importer) createVars() error {
+	vUUIds := make([]*common.VarUUId, 0, len(i.dump.Vars))
+	for _, v := range i.dump.Vars {
+		vUUId, err := i.mapVarUUId(v.Id)
+		if err != nil {
+			return err
+		}
+		// so far, only the roots have positions
+		if _, found := i.positions[*vUUId]; !found {
+			vUUIds = append(vUUIds, vUUId)
+		}
+	}
+	for len(vUUIds) > 0 {
+		batch := vUUIds
+		if len(batch) > server.ImportBatchElemCount {
+			batch = batch[:server.ImportBatchElemCount]
+		}
+		vUUIds = vUUIds[len(batch):]
+
+		seg := capn.NewBuffer(nil)
+		ctxn := cmsgs.NewClientTxn(seg)
+		ctxn.SetRetry(false)
+		actions := cmsgs.NewClientActionList(seg, len(batch))
+		ctxn.SetActions(actions)
+		for idx, vUUId := range batch {
+			action := actions.At(idx)
+			action.SetVarId(vUUId[:])
+			action.SetCreate()
+			create := action.Create()
+			create.SetValue([]byte{})
+			create.SetReferences(seg.NewDataList(0))
+		}
+		outcome, err := i.runTxn(&ctxn, nil)
+		if err != nil {
+			return err
+		}
+		// The positions are chosen by the submitter, so we have to
+		// find them from the txn that committed.
+		txnActions := outcome.Txn().Actions()
+		for idx, l := 0, txnActions.Len(); idx < l; idx++ {
+			txnAction := txnActions.At(idx)
+			positions := common.Positions(txnAction.Create().Positions())
+			i.positions[*common.MakeVarUUId(txnAction.VarId())] = &positions
+		}
+	}
+	return nil
+}
+
+func (i *importer) writeVars() error {
+	vars := i.dump.Vars
+	for len(vars) > 0 {
+		batch := vars
+		if len(batch) > server.ImportBatchElemCount {
+			batch = batch[:server.ImportBatchElemCount]
+		}
+		vars = vars[len(batch):]
+
+		varPosMap := make(map[common.VarUUId]*common.Positions)
+		seg := capn.NewBuffer(nil)
+		ctxn := cmsgs.NewClientTxn(seg)
+		ctxn.SetRetry(false)
+		actions := cmsgs.NewClientActionList(seg, len(batch))
+		ctxn.SetActions(actions)
+		for idx, v := range batch {
+			vUUId, err := i.mapVarUUId(v.Id)
+			if err != nil {
+				return err
+			}
+			varPosMap[*vUUId] = i.positions[*vUUId]
+			action := actions.At(idx)
+			action.SetVarId(vUUId[:])
+			action.SetWrite()
+			write := action.Write()
+			write.SetValue(v.Value)
+			refs := seg.NewDataList(len(v.References))
+			write.SetReferences(refs)
+			for idy, ref := range v.References {
+				refId, err := i.mapVarUUId(ref.Id)
+				if err != nil {
+					return err
+				}
+				positions, found := i.positions[*refId]
+				if !found {
+					return fmt.Errorf("%v references %v which is not in the dump", v.Id, ref.Id)
+				}
+				varPosMap[*refId] = positions
+				refs.Set(idy, refId[:])
+			}
+		}
+		if _, err := i.runTxn(&ctxn, varPosMap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *importer) runTxn(ctxn *cmsgs.ClientTxn, varPosMap map[common.VarUUId]*common.Positions) (*msgs.Outcome, error) {
+	for {
+		outcome, err := i.lc.RunClientTransaction(ctxn, varPosMap, true)
+		if err != nil {
+			return nil, err
+		} else if outcome == nil {
+			return nil, fmt.Errorf("Shutting down")
+		} else if outcome.Which() == msgs.OUTCOME_COMMIT {
+			return outcome, nil
+		} else if outcome.Abort().Which() == msgs.OUTCOMEABORT_RESUBMIT {
+			continue
+		} else {
+			return nil, fmt.Errorf("Import txn %v unexpectedly required rerun", common.MakeTxnId(outcome.Txn().Id()))
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package harness
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"net"
+	"sync"
+	"time"
+)
+
+// Client is a connection to a node over the client protocol, made
+// exactly as an external client makes it: an exchange of hellos, a
+// TLS handshake presenting a client certificate, and then heartbeats
+// and txn submissions and outcomes until the connection is closed. A
+// client runs one txn at a time.
+//
+// Client txn outcomes carry only the final id of the txn: unlike the
+// outcomes of Node.RunClientTransaction, they have no commit clock.
+type Client struct {
+	node            *Node
+	Root            *common.VarUUId
+	socket          net.Conn
+	namespace       []byte
+	writeLock       sync.Mutex
+	txnLock         sync.Mutex
+	nextTxnNumber   uint64
+	lock            sync.Mutex
+	nextVarNumber   uint64
+	nextWatchNumber uint64
+	watches         map[common.TxnId]*Watch
+	curTxnId        *common.TxnId
+	err             error
+	outcomes        chan *cmsgs.ClientTxnOutcome
+	closed          chan struct{}
+	terminated      sync.WaitGroup
+}
+
+// Watch is a watch registered by a Client. Every change to the
+// watched vars that the client has not yet seen arrives on Updates
+// as the abort of a ClientTxnOutcome with the id of the watch. If
+// the watch fails, an outcome with the error arrives instead.
+type Watch struct {
+	client  *Client
+	Id      *common.TxnId
+	Updates chan *cmsgs.ClientTxnOutcome
+}
+
+// The node submits the txns of a watch under ids bumped from the
+// watch id, which must not collide with the client's txn ids. So
+// watch ids are taken from the top half of the txn number space,
+// spaced well apart.
+const (
+	firstWatchNumber = 1 << 63
+	watchNumberGap   = 1 << 32
+)
+
+// Connect connects to the node as the client with the given PEM
+// encoded certificate and private key, such as
+// Cluster.ClientCertificate. Root is the root given to the client in
+// the node's hello.
+func (n *Node) Connect(clientCertificate []byte) (*Client, error) {
+	if !n.IsRunning() {
+		return nil, fmt.Errorf("%v is not running", n)
+	}
+	cert, err := tls.X509KeyPair(clientCertificate, clientCertificate)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(n.cluster.ClusterCertificate) {
+		return nil, errors.New("No cluster certificate found")
+	}
+	for _, clusterCert := range n.cluster.TrustedClusterCertificates {
+		roots.AddCert(clusterCert)
+	}
+
+	socket, err := net.Dial("tcp", n.Host())
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		node:            n,
+		socket:          socket,
+		outcomes:        make(chan *cmsgs.ClientTxnOutcome, 1),
+		closed:          make(chan struct{}),
+		nextWatchNumber: firstWatchNumber,
+		watches:         make(map[common.TxnId]*Watch),
+	}
+	if err = c.handshake(&cert, roots); err != nil {
+		socket.Close()
+		return nil, err
+	}
+	c.terminated.Add(2)
+	go c.read()
+	go c.beat()
+	return c, nil
+}
+
+func (c *Client) String() string {
+	return fmt.Sprintf("Client of %v", c.node)
+}
+
+func (c *Client) handshake(cert *tls.Certificate, roots *x509.CertPool) error {
+	seg, err := capn.ReadFromStream(c.socket, nil)
+	if err != nil {
+		return err
+	}
+	hello := cmsgs.ReadRootHello(seg)
+	if hello.Product() != common.ProductName || hello.Version() != common.ProductVersion {
+		return fmt.Errorf("Received erroneous hello from %v", c.node)
+	}
+	seg = capn.NewBuffer(nil)
+	hello = cmsgs.NewRootHello(seg)
+	hello.SetProduct(common.ProductName)
+	hello.SetVersion(common.ProductVersion)
+	hello.SetIsClient(true)
+	if err = c.send(server.SegToBytes(seg)); err != nil {
+		return err
+	}
+
+	// Node certificates have no server name, so, as between nodes, we
+	// have to verify the node's certificate ourself.
+	socket := tls.Client(c.socket, &tls.Config{
+		Certificates:       []tls.Certificate{*cert},
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS12,
+	})
+	c.socket = socket
+	if err = socket.Handshake(); err != nil {
+		return err
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	certs := socket.ConnectionState().PeerCertificates
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err = certs[0].Verify(opts); err != nil {
+		return err
+	}
+
+	if seg, err = capn.ReadFromStream(c.socket, nil); err != nil {
+		return err
+	}
+	helloFromServer := cmsgs.ReadRootHelloClientFromServer(seg)
+	c.namespace = append([]byte{}, helloFromServer.Namespace()...)
+	if len(c.namespace) != common.KeyLen-8 {
+		return fmt.Errorf("Received namespace of %v bytes from %v", len(c.namespace), c.node)
+	} else if rootId := helloFromServer.RootId(); len(rootId) == common.KeyLen {
+		c.Root = common.MakeVarUUId(rootId)
+	}
+	return nil
+}
+
+func (c *Client) send(msg []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.socket.Write(msg)
+	return err
+}
+
+// read receives messages until the connection fails. As with the
+// node, missing two heartbeats in a row counts as a failure.
+func (c *Client) read() {
+	defer c.terminated.Done()
+	for {
+		c.socket.SetReadDeadline(time.Now().Add(3 * common.HeartbeatInterval))
+		seg, err := capn.ReadFromStream(c.socket, nil)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		msg := cmsgs.ReadRootClientMessage(seg)
+		switch which := msg.Which(); which {
+		case cmsgs.CLIENTMESSAGE_HEARTBEAT:
+		case cmsgs.CLIENTMESSAGE_CLIENTTXNOUTCOME:
+			outcome := msg.ClientTxnOutcome()
+			if w := c.watch(common.MakeTxnId(outcome.Id())); w != nil {
+				select {
+				case w.Updates <- &outcome:
+					continue
+				default:
+					c.fail(fmt.Errorf("Too many unconsumed updates for watch %v from %v", w.Id, c.node))
+					return
+				}
+			}
+			select {
+			case c.outcomes <- &outcome:
+			default:
+				c.fail(fmt.Errorf("Received unexpected txn outcome from %v", c.node))
+				return
+			}
+		default:
+			c.fail(fmt.Errorf("Received unexpected message type from %v: %v", c.node, which))
+			return
+		}
+	}
+}
+
+func (c *Client) beat() {
+	defer c.terminated.Done()
+	seg := capn.NewBuffer(nil)
+	msg := cmsgs.NewRootClientMessage(seg)
+	msg.SetHeartbeat()
+	beatBytes := server.SegToBytes(seg)
+	ticker := time.NewTicker(common.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			if err := c.send(beatBytes); err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// fail records the first error and closes the connection.
+func (c *Client) fail(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err == nil {
+		c.err = err
+		c.socket.Close()
+		close(c.closed)
+	}
+}
+
+// Closed is closed once the connection has failed or been closed.
+func (c *Client) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// Err returns the reason the connection failed, or nil if it has
+// not.
+func (c *Client) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+// Close closes the connection and waits for it to shut down.
+func (c *Client) Close() {
+	c.fail(errors.New("Client closed"))
+	c.terminated.Wait()
+}
+
+// NextVarUUId returns a new var id from the connection's namespace,
+// for creating vars.
+func (c *Client) NextVarUUId() *common.VarUUId {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	bites := make([]byte, common.KeyLen)
+	binary.BigEndian.PutUint64(bites[:8], c.nextVarNumber)
+	copy(bites[8:], c.namespace)
+	c.nextVarNumber++
+	return common.MakeVarUUId(bites)
+}
+
+// RunClientTransaction gives the txn the next txn id from the
+// connection's namespace, submits it, and waits for its outcome,
+// which is either a commit or an abort with updates. If the node
+// could not run the txn, the error it sent is returned.
+func (c *Client) RunClientTransaction(ctxn *cmsgs.ClientTxn) (*cmsgs.ClientTxnOutcome, error) {
+	return c.runClientTransaction(ctxn, 0)
+}
+
+// RunClientTransactionWithTimeout is as RunClientTransaction, but
+// the node gives up on the txn once timeout has passed, in which case
+// the error contains TxnDeadlineExceeded.
+func (c *Client) RunClientTransactionWithTimeout(ctxn *cmsgs.ClientTxn, timeout time.Duration) (*cmsgs.ClientTxnOutcome, error) {
+	return c.runClientTransaction(ctxn, timeout)
+}
+
+func (c *Client) runClientTransaction(ctxn *cmsgs.ClientTxn, timeout time.Duration) (*cmsgs.ClientTxnOutcome, error) {
+	c.txnLock.Lock()
+	defer c.txnLock.Unlock()
+	bites := make([]byte, common.KeyLen)
+	binary.BigEndian.PutUint64(bites[:8], c.nextTxnNumber)
+	copy(bites[8:], c.namespace)
+	ctxn.SetId(bites)
+
+	seg := capn.NewBuffer(nil)
+	if timeout == 0 {
+		msg := cmsgs.NewRootClientMessage(seg)
+		msg.SetClientTxnSubmission(*ctxn)
+	} else {
+		msg := msgs.NewRootClientMessage(seg)
+		submission := msgs.NewClientTxnDeadline(seg)
+		submission.SetTxn(*ctxn)
+		submission.SetTimeout(uint64(timeout))
+		msg.SetDeadlineSubmission(submission)
+	}
+	if err := c.send(server.SegToBytes(seg)); err != nil {
+		c.fail(err)
+		return nil, err
+	}
+	c.lock.Lock()
+	c.curTxnId = common.MakeTxnId(bites)
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		c.curTxnId = nil
+		c.lock.Unlock()
+	}()
+
+	select {
+	case outcome := <-c.outcomes:
+		if !bytes.Equal(outcome.Id(), bites) {
+			err := fmt.Errorf("Received outcome for txn %v from %v; expected %v", common.MakeTxnId(outcome.Id()), c.node, common.MakeTxnId(bites))
+			c.fail(err)
+			return nil, err
+		}
+		// The node may have resubmitted the txn under later ids,
+		// which we must not reuse.
+		c.nextTxnNumber++
+		if final := outcome.FinalId(); len(final) == common.KeyLen {
+			if next := binary.BigEndian.Uint64(final[:8]) + 1; next > c.nextTxnNumber {
+				c.nextTxnNumber = next
+			}
+		}
+		if outcome.Which() == cmsgs.CLIENTTXNOUTCOME_ERROR {
+			return nil, fmt.Errorf("%v: %v", c.node, outcome.Error())
+		}
+		return outcome, nil
+	case <-c.closed:
+		return nil, c.Err()
+	}
+}
+
+// ReadRoot returns the current value of the client's root, and the
+// id of the txn which wrote it. As with any client, this is how the
+// connection learns the positions of the vars the root refers to,
+// which it must know before they can be used.
+func (c *Client) ReadRoot() ([]byte, *common.TxnId, error) {
+	if c.Root == nil {
+		return nil, nil, fmt.Errorf("%v has no root", c)
+	}
+	// Reading at version zero must abort, unless the root has never
+	// been written, and the updates tell us the current value.
+	outcome, err := c.RunClientTransaction(readRootTxn(c.Root, common.VersionZero))
+	if err != nil {
+		return nil, nil, err
+	} else if outcome.Which() == cmsgs.CLIENTTXNOUTCOME_COMMIT {
+		return nil, common.VersionZero, nil
+	}
+	updates := outcome.Abort()
+	for idx, l := 0, updates.Len(); idx < l; idx++ {
+		update := updates.At(idx)
+		updateActions := update.Actions()
+		for idy, m := 0, updateActions.Len(); idy < m; idy++ {
+			updateAction := updateActions.At(idy)
+			if updateAction.Which() == cmsgs.CLIENTACTION_WRITE && common.MakeVarUUId(updateAction.VarId()).Compare(c.Root) == common.EQ {
+				return updateAction.Write().Value(), common.MakeTxnId(update.Version()), nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("%v read of root aborted without an update for the root", c)
+}
+
+func (c *Client) watch(id *common.TxnId) *Watch {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.watches[*id]
+}
+
+// Watch registers a watch on the vars, which must be known to the
+// connection. The current value of each var which has been written
+// arrives on Updates straight away.
+func (c *Client) Watch(vUUIds ...*common.VarUUId) (*Watch, error) {
+	c.lock.Lock()
+	bites := make([]byte, common.KeyLen)
+	binary.BigEndian.PutUint64(bites[:8], c.nextWatchNumber)
+	copy(bites[8:], c.namespace)
+	c.nextWatchNumber += watchNumberGap
+	w := &Watch{
+		client:  c,
+		Id:      common.MakeTxnId(bites),
+		Updates: make(chan *cmsgs.ClientTxnOutcome, 16),
+	}
+	c.watches[*w.Id] = w
+	c.lock.Unlock()
+
+	seg := capn.NewBuffer(nil)
+	msg := msgs.NewRootClientMessage(seg)
+	watch := msgs.NewClientWatch(seg)
+	watch.SetId(w.Id[:])
+	varIds := seg.NewDataList(len(vUUIds))
+	for idx, vUUId := range vUUIds {
+		varIds.Set(idx, vUUId[:])
+	}
+	watch.SetVarIds(varIds)
+	msg.SetWatch(watch)
+	if err := c.send(server.SegToBytes(seg)); err != nil {
+		c.fail(err)
+		return nil, err
+	}
+	return w, nil
+}
+
+// Unwatch removes the watch. Updates which the node sent before it
+// received the removal may still arrive on Updates.
+func (w *Watch) Unwatch() error {
+	seg := capn.NewBuffer(nil)
+	msg := msgs.NewRootClientMessage(seg)
+	msg.SetUnwatch(w.Id[:])
+	err := w.client.send(server.SegToBytes(seg))
+	if err != nil {
+		w.client.fail(err)
+	}
+	return err
+}
+
+// CancelClientTransaction asks the node to give up on the txn
+// currently being run, which then fails with an error containing
+// TxnCancelled, unless it completes first. It returns false if no
+// txn is being run.
+func (c *Client) CancelClientTransaction() (bool, error) {
+	c.lock.Lock()
+	txnId := c.curTxnId
+	c.lock.Unlock()
+	if txnId == nil {
+		return false, nil
+	}
+	seg := capn.NewBuffer(nil)
+	msg := msgs.NewRootClientMessage(seg)
+	msg.SetCancel(txnId[:])
+	if err := c.send(server.SegToBytes(seg)); err != nil {
+		c.fail(err)
+		return false, err
+	}
+	return true, nil
+}
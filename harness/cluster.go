@@ -0,0 +1,230 @@
+package harness
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/common/certs"
+	"goshawkdb.io/server/configuration"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Cluster runs several full server instances within one process, for
+// integration tests. Each node has its own data directory, RMId and
+// port on localhost, and is wired together as the goshawkdb command
+// does. Note that metrics are shared between all the nodes of the
+// process.
+type Cluster struct {
+	Dir                string
+	ClusterCertificate []byte
+	ClientCertificate  []byte
+	ClientFingerprint  string
+	// TrustedClusterCertificates are trusted by nodes as they start,
+	// in addition to ClusterCertificate, as with -trust-cert.
+	TrustedClusterCertificates []*x509.Certificate
+	Nodes                      []*Node
+	config                     *configuration.Configuration
+	fingerprints               []string
+	roles                      map[string]*configuration.ClientCertificateRole
+	rng                        *rand.Rand
+	rmIds                      map[common.RMId]*Node
+}
+
+// NewCluster creates and starts a cluster of count nodes which can
+// tolerate f failures. Use AwaitStable to wait for the cluster to
+// form.
+func NewCluster(count int, f uint8) (*Cluster, error) {
+	dir, err := ioutil.TempDir("", common.ProductName+"_Cluster_")
+	if err != nil {
+		return nil, err
+	}
+	clusterCert, err := certs.NewClusterCertificate()
+	if err != nil {
+		return nil, err
+	}
+	clusterCertificate := []byte(fmt.Sprintf("%s%s", clusterCert.CertificatePEM, clusterCert.PrivateKeyPEM))
+	clientCert, err := certs.NewClientCertificate(clusterCertificate)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := sha256.Sum256(clientCert.Certificate)
+
+	c := &Cluster{
+		Dir:                dir,
+		ClusterCertificate: clusterCertificate,
+		ClientCertificate:  []byte(fmt.Sprintf("%s%s", clientCert.CertificatePEM, clientCert.PrivateKeyPEM)),
+		ClientFingerprint:  hex.EncodeToString(fingerprint[:]),
+		fingerprints:       []string{hex.EncodeToString(fingerprint[:])},
+		rng:                rand.New(rand.NewSource(time.Now().UnixNano())),
+		rmIds:              make(map[common.RMId]*Node),
+	}
+
+	hosts := make([]string, count)
+	for idx := 0; idx < count; idx++ {
+		node, err := c.NewNode()
+		if err != nil {
+			c.Shutdown()
+			return nil, err
+		}
+		hosts[idx] = node.Host()
+	}
+
+	config := &configuration.Configuration{
+		ClusterId:                     fmt.Sprintf("%v_Cluster_%v", common.ProductName, c.rng.Uint32()),
+		Version:                       1,
+		Hosts:                         hosts,
+		F:                             f,
+		MaxRMCount:                    uint16(2 * count),
+		NoSync:                        true,
+		ClientCertificateFingerprints: c.fingerprints,
+	}
+	if c.config, err = c.loadConfiguration(config); err != nil {
+		c.Shutdown()
+		return nil, err
+	}
+
+	for _, node := range c.Nodes {
+		if err := node.Start(); err != nil {
+			c.Shutdown()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// NewClientCertificate returns a new client certificate and private
+// key, PEM encoded, signed by the cluster certificate, and its
+// fingerprint. Clients can only connect with it once the fingerprint
+// is added to the configuration.
+func (c *Cluster) NewClientCertificate() ([]byte, string, error) {
+	clientCert, err := certs.NewClientCertificate(c.ClusterCertificate)
+	if err != nil {
+		return nil, "", err
+	}
+	fingerprint := sha256.Sum256(clientCert.Certificate)
+	return []byte(fmt.Sprintf("%s%s", clientCert.CertificatePEM, clientCert.PrivateKeyPEM)), hex.EncodeToString(fingerprint[:]), nil
+}
+
+// NewNode adds a new node to the cluster, with its own data directory
+// and a free port, but does not start it. To bring it into the
+// cluster, change the configuration to include its Host, and then
+// Start it.
+func (c *Cluster) NewNode() (*Node, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	rmId := common.RMIdEmpty
+	for {
+		rmId = common.RMId(c.rng.Uint32())
+		if _, found := c.rmIds[rmId]; !found && rmId != common.RMIdEmpty {
+			break
+		}
+	}
+	dataDir := filepath.Join(c.Dir, fmt.Sprintf("node_%v", len(c.Nodes)))
+	if err := os.MkdirAll(dataDir, 0750); err != nil {
+		return nil, err
+	}
+	node := newNode(c, rmId, port, dataDir)
+	c.rmIds[rmId] = node
+	c.Nodes = append(c.Nodes, node)
+	return node, nil
+}
+
+// Configuration returns a copy of the latest configuration given to
+// the cluster.
+func (c *Cluster) Configuration() *configuration.Configuration {
+	return c.config.Clone()
+}
+
+// ChangeConfiguration applies mutate to a copy of the latest
+// configuration, with its version incremented, and requests every
+// running node change to it. Nodes which are started later are given
+// the new configuration. Use AwaitStable to wait for the change to
+// complete.
+func (c *Cluster) ChangeConfiguration(mutate func(*configuration.Configuration)) error {
+	config := c.config.Clone()
+	config.Version++
+	// Loading a configuration consumes its fingerprints and roles, so
+	// we keep them ourselves.
+	config.ClientCertificateFingerprints = append([]string{}, c.fingerprints...)
+	config.ClientCertificateRoles = make(map[string]*configuration.ClientCertificateRole, len(c.roles))
+	for fingerprint, role := range c.roles {
+		config.ClientCertificateRoles[fingerprint] = role
+	}
+	mutate(config)
+	fingerprints, roles := config.ClientCertificateFingerprints, config.ClientCertificateRoles
+	config, err := c.loadConfiguration(config)
+	if err != nil {
+		return err
+	}
+	c.config = config
+	c.fingerprints, c.roles = fingerprints, roles
+	for _, node := range c.Nodes {
+		if node.IsRunning() {
+			node.transmogrifier.RequestConfigurationChange(config.Clone())
+		}
+	}
+	return nil
+}
+
+// loadConfiguration round-trips the configuration through a file so
+// that it is validated and processed exactly as for a real server.
+func (c *Cluster) loadConfiguration(config *configuration.Configuration) (*configuration.Configuration, error) {
+	bites, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(c.Dir, fmt.Sprintf("config_%v.json", config.Version))
+	if err = ioutil.WriteFile(path, bites, 0600); err != nil {
+		return nil, err
+	}
+	return configuration.LoadConfigurationFromPath(path)
+}
+
+// AwaitStable waits until every running node has installed the
+// latest configuration and is not part way through a topology
+// change.
+func (c *Cluster) AwaitStable(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	version := c.config.Version
+	for _, node := range c.Nodes {
+		if !node.IsRunning() {
+			continue
+		}
+		err := node.AwaitTopology(deadline.Sub(time.Now()), func(topology *configuration.Topology) bool {
+			return topology.Version >= version && topology.Next() == nil && topology.Root.VarUUId != nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown stops every node and removes all the data directories.
+func (c *Cluster) Shutdown() {
+	for _, node := range c.Nodes {
+		if node.IsRunning() {
+			node.Stop()
+		}
+	}
+	os.RemoveAll(c.Dir)
+}
+
+func freePort() (uint16, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return uint16(ln.Addr().(*net.TCPAddr).Port), nil
+}
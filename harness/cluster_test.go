@@ -0,0 +1,1115 @@
+package harness
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/common/certs"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/dump"
+	"goshawkdb.io/server/network"
+	eng "goshawkdb.io/server/txnengine"
+	"strings"
+	"testing"
+	"time"
+)
+
+const awaitTimeout = time.Minute
+
+// newStableCluster starts a cluster of three nodes with F=1 and waits
+// for it to become stable. The caller must shut the cluster down.
+func newStableCluster(tb testing.TB) *Cluster {
+	c, err := NewCluster(3, 1)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err = c.AwaitStable(awaitTimeout); err != nil {
+		c.Shutdown()
+		tb.Fatal(err)
+	}
+	return c
+}
+
+func TestClusterWriteReadRestart(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	value := []byte("Hello")
+	txnId, err := c.Nodes[0].WriteRoot(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range c.Nodes {
+		read, readTxnId, err := node.ReadRoot()
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(read, value) || readTxnId.Compare(txnId) != common.EQ {
+			t.Fatalf("%v read %q@%v; expected %q@%v", node, read, readTxnId, value, txnId)
+		}
+	}
+
+	// With F=1, the cluster must remain available with one node down.
+	c.Nodes[2].Stop()
+	value = []byte("World")
+	if txnId, err = c.Nodes[1].WriteRoot(value); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Nodes[2].Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.AwaitStable(awaitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	read, readTxnId, err := c.Nodes[2].ReadRoot()
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(read, value) || readTxnId.Compare(txnId) != common.EQ {
+		t.Fatalf("%v read %q@%v after restart; expected %q@%v", c.Nodes[2], read, readTxnId, value, txnId)
+	}
+}
+
+func TestClusterPartition(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	// Cut node 2 off from the others, in both directions.
+	isolated := c.Nodes[2]
+	isolated.Faults().SetRule(common.RMIdEmpty, &network.FaultRule{Partition: true})
+	for _, node := range c.Nodes[:2] {
+		node.Faults().SetRule(isolated.RMId, &network.FaultRule{Partition: true})
+	}
+
+	// With F=1, the majority side must remain available.
+	value := []byte("Partitioned")
+	txnId, err := c.Nodes[0].WriteRoot(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, node := range c.Nodes {
+		node.Faults().Clear()
+	}
+	read, readTxnId, err := isolated.ReadRoot()
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(read, value) || readTxnId.Compare(txnId) != common.EQ {
+		t.Fatalf("%v read %q@%v after partition healed; expected %q@%v", isolated, read, readTxnId, value, txnId)
+	}
+}
+
+func TestClusterWorkloadSerializable(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	w := &Workload{Vars: 4, Clients: 4, Txns: 25, MaxActions: 3, Seed: time.Now().UnixNano()}
+	history, err := w.Run(c)
+	if err != nil {
+		t.Fatalf("Seed %v: %v", w.Seed, err)
+	}
+	if anomalies := history.Check(); len(anomalies) != 0 {
+		t.Fatalf("Seed %v: %v", w.Seed, anomalies)
+	}
+}
+
+func TestClusterAbortConfigurationChange(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	// Add a node which never comes up, so the change can't proceed.
+	absent, err := c.NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.ChangeConfiguration(func(config *configuration.Configuration) {
+		config.Hosts = append(config.Hosts, absent.Host())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := c.Configuration().Version
+
+	tt := c.Nodes[0].transmogrifier
+	deadline := time.Now().Add(awaitTimeout)
+	for {
+		if progress, _ := tt.Progress(0); progress.TargetVersion == version {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for change to version %v to start; progress: %+v", version, progress)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if aborting, err := tt.AbortConfigurationChange(); err != nil {
+		t.Fatal(err)
+	} else if aborting != version {
+		t.Fatalf("Aborting version %v; expected %v", aborting, version)
+	}
+	if err = c.AwaitStable(awaitTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, node := range c.Nodes[:3] {
+		if hosts := node.Topology().Hosts; len(hosts) != 3 {
+			t.Fatalf("%v has hosts %v after abort; expected the original 3", node, hosts)
+		}
+	}
+	aborted := false
+	for deadline = time.Now().Add(awaitTimeout); !aborted && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		_, events := tt.Progress(0)
+		for _, event := range events {
+			aborted = aborted || event.Kind == network.TopologyEventChangeAborted
+		}
+	}
+	if !aborted {
+		t.Fatal("No ChangeAborted event recorded")
+	}
+	if _, err = tt.AbortConfigurationChange(); err == nil {
+		t.Fatal("Abort succeeded with no change in progress")
+	}
+
+	value := []byte("Aborted")
+	if _, err = c.Nodes[1].WriteRoot(value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClusterNamedRoots(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	err := c.ChangeConfiguration(func(config *configuration.Configuration) {
+		config.RootNames = []string{"analytics"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.AwaitStable(awaitTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	var analytics *common.VarUUId
+	for _, node := range c.Nodes {
+		topology := node.Topology()
+		root := topology.NamedRoot("analytics")
+		switch {
+		case root == nil:
+			t.Fatalf("%v has no analytics root: %v", node, topology)
+		case root.VarUUId.Compare(topology.Root.VarUUId) == common.EQ:
+			t.Fatalf("%v has the same var for the analytics and default roots: %v", node, topology)
+		case analytics == nil:
+			analytics = root.VarUUId
+		case analytics.Compare(root.VarUUId) != common.EQ:
+			t.Fatalf("%v has analytics root %v; expected %v", node, root.VarUUId, analytics)
+		}
+	}
+
+	// Restarting must find the named root in the stored topology.
+	if err = c.Nodes[0].Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.AwaitStable(awaitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if root := c.Nodes[0].Topology().NamedRoot("analytics"); root == nil || root.VarUUId.Compare(analytics) != common.EQ {
+		t.Fatalf("%v has analytics root %v after restart; expected %v", c.Nodes[0], root, analytics)
+	}
+	if _, err = c.Nodes[1].WriteRoot([]byte("Named")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClusterReadOnlyClient(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	clientCertificate, fingerprint, err := c.NewClientCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.ChangeConfiguration(func(config *configuration.Configuration) {
+		config.ClientCertificateRoles[fingerprint] = &configuration.ClientCertificateRole{Role: configuration.ClientRoleReadOnly}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.AwaitStable(awaitTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	node := c.Nodes[0]
+	value := []byte("ReadOnly")
+	txnId, err := node.WriteRoot(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := node.Connect(clientCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	read, readTxnId, err := conn.ReadRoot()
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(read, value) || readTxnId.Compare(txnId) != common.EQ {
+		t.Fatalf("%v read %q@%v; expected %q@%v", conn, read, readTxnId, value, txnId)
+	}
+	if _, err = conn.RunClientTransaction(writeRootTxn(conn.Root, []byte("Denied"))); err == nil {
+		t.Fatalf("%v wrote the root as a read-only client", conn)
+	}
+	if read, readTxnId, err = node.ReadRoot(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(read, value) || readTxnId.Compare(txnId) != common.EQ {
+		t.Fatalf("%v read %q@%v after the denied write; expected %q@%v", node, read, readTxnId, value, txnId)
+	}
+}
+
+func TestClusterRotateClusterCertificate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	block, _ := pem.Decode(c.ClusterCertificate)
+	oldCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCertPrivKeyPair, err := certs.NewClusterCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCert, err := x509.ParseCertificate(newCertPrivKeyPair.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restartAll := func() {
+		for _, node := range c.Nodes {
+			if err := node.Restart(); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.AwaitStable(awaitTimeout); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Phase one: every node trusts the new cluster certificate too.
+	c.TrustedClusterCertificates = []*x509.Certificate{newCert}
+	restartAll()
+
+	// Phase two: every node switches to the new cluster certificate,
+	// whilst still trusting the old.
+	c.ClusterCertificate = []byte(fmt.Sprintf("%s%s", newCertPrivKeyPair.CertificatePEM, newCertPrivKeyPair.PrivateKeyPEM))
+	c.TrustedClusterCertificates = []*x509.Certificate{oldCert}
+	restartAll()
+
+	c.TrustedClusterCertificates = nil
+	if _, err = c.Nodes[1].WriteRoot([]byte("Rotated")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClusterRotateClusterCertificateHot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	block, _ := pem.Decode(c.ClusterCertificate)
+	oldCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCertPrivKeyPair, err := certs.NewClusterCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCert, err := x509.ParseCertificate(newCertPrivKeyPair.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newClusterCertificate := []byte(fmt.Sprintf("%s%s", newCertPrivKeyPair.CertificatePEM, newCertPrivKeyPair.PrivateKeyPEM))
+
+	// Phase one: every node trusts the new cluster certificate too.
+	for _, node := range c.Nodes {
+		if err = node.TrustClusterCertificate(newCert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Phase two: every node switches to the new cluster certificate,
+	// and then the old is untrusted everywhere.
+	for _, node := range c.Nodes {
+		if err = node.SwitchClusterCertificate(newClusterCertificate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, node := range c.Nodes {
+		if err = node.UntrustClusterCertificate(oldCert); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Clients which trust only the new cluster certificate can
+	// connect to every node.
+	c.ClusterCertificate = newClusterCertificate
+	for _, node := range c.Nodes {
+		conn, err := node.Connect(c.ClientCertificate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+
+	// Established links between nodes are unaffected by the switch, so
+	// drop node 2's links for long enough to miss heartbeats: it can
+	// only rejoin with new links using the new certificates.
+	isolated := c.Nodes[2]
+	isolated.Faults().SetRule(common.RMIdEmpty, &network.FaultRule{Partition: true})
+	for _, node := range c.Nodes[:2] {
+		node.Faults().SetRule(isolated.RMId, &network.FaultRule{Partition: true})
+	}
+	time.Sleep(4 * common.HeartbeatInterval)
+	for _, node := range c.Nodes {
+		node.Faults().Clear()
+	}
+
+	value := []byte("Rotated")
+	txnId, err := isolated.WriteRoot(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, readTxnId, err := c.Nodes[0].ReadRoot()
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(read, value) || readTxnId.Compare(txnId) != common.EQ {
+		t.Fatalf("%v read %q@%v after rotation; expected %q@%v", c.Nodes[0], read, readTxnId, value, txnId)
+	}
+}
+
+func TestClusterRevokeClient(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	node := c.Nodes[0]
+	conn, err := node.Connect(c.ClientCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, _, err = conn.ReadRoot(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = node.RevokeClientCertificate(c.ClientFingerprint); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-conn.Closed():
+	case <-time.After(awaitTimeout):
+		t.Fatalf("%v still connected %v after revocation", node, conn)
+	}
+	if conn, err := node.Connect(c.ClientCertificate); err == nil {
+		conn.Close()
+		t.Fatalf("%v accepted a revoked client", node)
+	}
+
+	// Revocation is local to the node.
+	other, err := c.Nodes[1].Connect(c.ClientCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, _, err = other.ReadRoot(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClusterReadOnlyTxnClock(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	node := c.Nodes[0]
+	txnId, err := node.WriteRoot([]byte("ReadOnly"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, varPosMap, err := node.root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcome, err := node.RunReadOnlyTransaction(readRootTxn(root, txnId), varPosMap)
+	if err != nil {
+		t.Fatal(err)
+	} else if outcome.Which() != msgs.OUTCOME_COMMIT {
+		t.Fatalf("Read-only read of the current version of the root did not commit: %v", outcome.Which())
+	}
+	// The commit must carry the clock of the read, just as a normal
+	// commit would, or clients' caches can't order it.
+	if clock := eng.VectorClockFromCap(outcome.Commit()); clock.Clock[*root] == 0 {
+		t.Fatalf("Read-only commit has no clock element for the root: %v", clock)
+	}
+}
+
+func TestClusterWatch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	value := []byte("Before")
+	txnId, err := c.Nodes[0].WriteRoot(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := c.Nodes[0].Connect(c.ClientCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	writer, err := c.Nodes[1].Connect(c.ClientCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	w, err := watcher.Watch(watcher.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The current value arrives straight away.
+	awaitWatchUpdate(t, w, watcher.Root, value, txnId)
+
+	// Writes through another node are pushed.
+	for _, value = range [][]byte{[]byte("First"), []byte("Second")} {
+		outcome, err := writer.RunClientTransaction(writeRootTxn(writer.Root, value))
+		if err != nil {
+			t.Fatal(err)
+		} else if outcome.Which() != cmsgs.CLIENTTXNOUTCOME_COMMIT {
+			t.Fatalf("%v write of the root did not commit: %v", writer, outcome.Which())
+		}
+		awaitWatchUpdate(t, w, watcher.Root, value, common.MakeTxnId(outcome.FinalId()))
+	}
+
+	if err = w.Unwatch(); err != nil {
+		t.Fatal(err)
+	}
+	// The node handles the client's messages in order, so once this
+	// read completes, the removal has been handled too.
+	if _, _, err = watcher.ReadRoot(); err != nil {
+		t.Fatal(err)
+	}
+	if outcome, err := writer.RunClientTransaction(writeRootTxn(writer.Root, []byte("Unwatched"))); err != nil {
+		t.Fatal(err)
+	} else if outcome.Which() != cmsgs.CLIENTTXNOUTCOME_COMMIT {
+		t.Fatalf("%v write of the root did not commit: %v", writer, outcome.Which())
+	}
+	select {
+	case outcome := <-w.Updates:
+		t.Fatalf("Received %v for watch %v after it was removed", outcome.Which(), w.Id)
+	case <-time.After(2 * time.Second):
+	}
+
+	// A watch on a var the client does not know of fails.
+	if w, err = watcher.Watch(watcher.NextVarUUId()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case outcome := <-w.Updates:
+		if outcome.Which() != cmsgs.CLIENTTXNOUTCOME_ERROR {
+			t.Fatalf("Watch %v of an unknown var gave %v; expected an error", w.Id, outcome.Which())
+		}
+	case <-time.After(awaitTimeout):
+		t.Fatalf("Watch %v of an unknown var did not fail", w.Id)
+	}
+}
+
+// awaitWatchUpdate waits for the watch to be sent the write of value
+// to vUUId by txnId.
+func awaitWatchUpdate(t *testing.T, w *Watch, vUUId *common.VarUUId, value []byte, txnId *common.TxnId) {
+	var outcome *cmsgs.ClientTxnOutcome
+	select {
+	case outcome = <-w.Updates:
+	case <-time.After(awaitTimeout):
+		t.Fatalf("Watch %v was not sent %q@%v", w.Id, value, txnId)
+	}
+	if outcome.Which() == cmsgs.CLIENTTXNOUTCOME_ERROR {
+		t.Fatalf("Watch %v failed: %v", w.Id, outcome.Error())
+	} else if outcome.Which() != cmsgs.CLIENTTXNOUTCOME_ABORT {
+		t.Fatalf("Watch %v was sent %v; expected updates", w.Id, outcome.Which())
+	}
+	updates := outcome.Abort()
+	for idx, l := 0, updates.Len(); idx < l; idx++ {
+		update := updates.At(idx)
+		actions := update.Actions()
+		for idy, m := 0, actions.Len(); idy < m; idy++ {
+			action := actions.At(idy)
+			if action.Which() == cmsgs.CLIENTACTION_WRITE && common.MakeVarUUId(action.VarId()).Compare(vUUId) == common.EQ {
+				if read := action.Write().Value(); !bytes.Equal(read, value) || common.MakeTxnId(update.Version()).Compare(txnId) != common.EQ {
+					t.Fatalf("Watch %v was sent %q@%v; expected %q@%v", w.Id, read, common.MakeTxnId(update.Version()), value, txnId)
+				}
+				return
+			}
+		}
+	}
+	t.Fatalf("Watch %v was sent updates without a write of %v", w.Id, vUUId)
+}
+
+func TestClusterClientTxnDeadlineAndCancel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	if _, err := c.Nodes[0].WriteRoot([]byte("Unchanging")); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Nodes[0].Connect(c.ClientCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, txnId, err := conn.ReadRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A retry txn reading the current version of the root waits until
+	// the root changes, which it never does.
+	retryTxn := func() *cmsgs.ClientTxn {
+		ctxn := readRootTxn(conn.Root, txnId)
+		ctxn.SetRetry(true)
+		return ctxn
+	}
+
+	timeout := 500 * time.Millisecond
+	start := time.Now()
+	if _, err = conn.RunClientTransactionWithTimeout(retryTxn(), timeout); err == nil || !strings.Contains(err.Error(), "TxnDeadlineExceeded") {
+		t.Fatalf("Retry txn with a deadline gave %v; expected TxnDeadlineExceeded", err)
+	} else if elapsed := time.Since(start); elapsed < timeout {
+		t.Fatalf("Retry txn exceeded its deadline after only %v", elapsed)
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := conn.RunClientTransaction(retryTxn())
+		errChan <- err
+	}()
+	for {
+		if cancelled, err := conn.CancelClientTransaction(); err != nil {
+			t.Fatal(err)
+		} else if cancelled {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err = <-errChan:
+		if err == nil || !strings.Contains(err.Error(), "TxnCancelled") {
+			t.Fatalf("Cancelled retry txn gave %v; expected TxnCancelled", err)
+		}
+	case <-time.After(awaitTimeout):
+		t.Fatal("Cancelled retry txn did not complete")
+	}
+
+	// A txn which completes before its deadline is unaffected.
+	if outcome, err := conn.RunClientTransactionWithTimeout(writeRootTxn(conn.Root, []byte("Changed")), awaitTimeout); err != nil {
+		t.Fatal(err)
+	} else if outcome.Which() != cmsgs.CLIENTTXNOUTCOME_COMMIT {
+		t.Fatalf("%v write of the root with a deadline did not commit: %v", conn, outcome.Which())
+	}
+}
+
+func TestClusterExportImport(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	conn, err := c.Nodes[0].Connect(c.ClientCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a and b refer to each other, and the root refers to a. The
+	// orphan is unreachable, so must not be exported.
+	a, b, orphan := conn.NextVarUUId(), conn.NextVarUUId(), conn.NextVarUUId()
+	seg := capn.NewBuffer(nil)
+	ctxn := cmsgs.NewClientTxn(seg)
+	ctxn.SetRetry(false)
+	actions := cmsgs.NewClientActionList(seg, 4)
+	ctxn.SetActions(actions)
+	for idx, create := range []struct {
+		vUUId *common.VarUUId
+		value string
+		refs  []*common.VarUUId
+	}{{a, "a", []*common.VarUUId{b}}, {b, "b", []*common.VarUUId{a}}, {orphan, "orphan", nil}} {
+		action := actions.At(idx)
+		action.SetVarId(create.vUUId[:])
+		action.SetCreate()
+		refs := seg.NewDataList(len(create.refs))
+		for idy, ref := range create.refs {
+			refs.Set(idy, ref[:])
+		}
+		action.Create().SetValue([]byte(create.value))
+		action.Create().SetReferences(refs)
+	}
+	action := actions.At(3)
+	action.SetVarId(conn.Root[:])
+	action.SetWrite()
+	refs := seg.NewDataList(1)
+	refs.Set(0, a[:])
+	action.Write().SetValue([]byte("root"))
+	action.Write().SetReferences(refs)
+	outcome, err := conn.RunClientTransaction(&ctxn)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if outcome.Which() != cmsgs.CLIENTTXNOUTCOME_COMMIT {
+		t.Fatalf("Creation of the vars did not commit: %v", outcome.Which())
+	}
+
+	exported := exportCluster(t, c)
+	if len(exported.Vars) != 3 {
+		t.Fatalf("Exported %v vars; expected the root, a and b", len(exported.Vars))
+	}
+
+	fresh, err := NewCluster(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Shutdown()
+	if err = fresh.AwaitStable(awaitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if err = dump.Import(exported, fresh.Nodes[0].connectionManager); err != nil {
+		t.Fatal(err)
+	}
+	imported := exportCluster(t, fresh)
+
+	// Ids are kept, except for the root, which is mapped onto the
+	// fresh cluster's root. Positions are chosen afresh.
+	mapId := func(id string) string {
+		if id == exported.Root {
+			return imported.Root
+		}
+		return id
+	}
+	importedVars := make(map[string]*dump.Var, len(imported.Vars))
+	for _, v := range imported.Vars {
+		importedVars[v.Id] = v
+	}
+	if len(importedVars) != len(exported.Vars) {
+		t.Fatalf("Imported %v vars; expected %v", len(importedVars), len(exported.Vars))
+	}
+	for _, v := range exported.Vars {
+		iv, found := importedVars[mapId(v.Id)]
+		if !found {
+			t.Fatalf("%v was not imported", v.Id)
+		} else if !bytes.Equal(iv.Value, v.Value) {
+			t.Fatalf("%v has value %q after import; expected %q", v.Id, iv.Value, v.Value)
+		} else if len(iv.References) != len(v.References) {
+			t.Fatalf("%v has %v references after import; expected %v", v.Id, len(iv.References), len(v.References))
+		}
+		for idx, ref := range v.References {
+			if iv.References[idx].Id != mapId(ref.Id) {
+				t.Fatalf("%v has reference %v to %v after import; expected %v", v.Id, idx, iv.References[idx].Id, mapId(ref.Id))
+			}
+		}
+	}
+	if _, found := importedVars[dump.FormatVarUUId(orphan)]; found {
+		t.Fatal("The unreachable var was imported")
+	}
+}
+
+// exportCluster stops every node of the cluster, as export requires,
+// and exports from their data dirs.
+func exportCluster(t *testing.T, c *Cluster) *dump.Dump {
+	stores := make(dump.Stores, 0, len(c.Nodes))
+	defer stores.Shutdown()
+	for _, node := range c.Nodes {
+		node.Stop()
+		store, err := dump.OpenStore(node.DataDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores = append(stores, store)
+	}
+	if err := stores.CheckEqualTopology(); err != nil {
+		t.Fatal(err)
+	}
+	d, err := dump.Export(stores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestClusterGarbageCollection(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	conn, err := c.Nodes[0].Connect(c.ClientCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The root refers to kept. The orphan is unreachable from the
+	// start, but the connection knows where it is as it created it.
+	kept, orphan := conn.NextVarUUId(), conn.NextVarUUId()
+	seg := capn.NewBuffer(nil)
+	ctxn := cmsgs.NewClientTxn(seg)
+	ctxn.SetRetry(false)
+	actions := cmsgs.NewClientActionList(seg, 3)
+	ctxn.SetActions(actions)
+	for idx, vUUId := range []*common.VarUUId{kept, orphan} {
+		action := actions.At(idx)
+		action.SetVarId(vUUId[:])
+		action.SetCreate()
+		action.Create().SetValue([]byte("Created"))
+		action.Create().SetReferences(seg.NewDataList(0))
+	}
+	action := actions.At(2)
+	action.SetVarId(conn.Root[:])
+	action.SetWrite()
+	refs := seg.NewDataList(1)
+	refs.Set(0, kept[:])
+	action.Write().SetValue([]byte{})
+	action.Write().SetReferences(refs)
+	if outcome, err := conn.RunClientTransaction(&ctxn); err != nil {
+		t.Fatal(err)
+	} else if outcome.Which() != cmsgs.CLIENTTXNOUTCOME_COMMIT {
+		t.Fatalf("Creation of the vars did not commit: %v", outcome.Which())
+	}
+
+	for _, node := range c.Nodes {
+		gc := network.NewGarbageCollector(node.connectionManager, node.disk, 100*time.Millisecond, time.Hour)
+		defer gc.Shutdown()
+	}
+
+	// Every node holds every var, so every node must delete the
+	// orphan and leave its tombstone.
+	deadline := time.Now().Add(awaitTimeout)
+	for collected := false; !collected; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v was not collected within %v", orphan, awaitTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+		collected = true
+		for _, node := range c.Nodes {
+			inVars, tombstone, err := varOnDisk(node, orphan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			collected = collected && !inVars && tombstone != nil
+		}
+	}
+
+	for _, node := range c.Nodes {
+		_, tombstone, err := varOnDisk(node, orphan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The collecting txn is not kept, so the tombstone pins
+		// nothing.
+		collectTxnId := common.MakeTxnId(tombstone.WriteTxnId())
+		res, err := node.disk.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+			return node.disk.ReadTxnBytesFromDisk(rtxn, collectTxnId)
+		}).ResultError()
+		if err != nil {
+			t.Fatal(err)
+		} else if bites, ok := res.([]byte); ok && bites != nil {
+			t.Fatalf("%v kept collecting txn %v on disk", node, collectTxnId)
+		}
+
+		inVars, tombstone, err := varOnDisk(node, kept)
+		if err != nil {
+			t.Fatal(err)
+		} else if !inVars || tombstone != nil {
+			t.Fatalf("%v has %v in Vars: %v; with tombstone: %v; expected it to be retained", node, kept, inVars, tombstone != nil)
+		}
+	}
+
+	if outcome, err := conn.RunClientTransaction(writeRootTxn(kept, []byte("Kept"))); err != nil {
+		t.Fatal(err)
+	} else if outcome.Which() != cmsgs.CLIENTTXNOUTCOME_COMMIT {
+		t.Fatalf("Write to %v did not commit: %v", kept, outcome.Which())
+	}
+	// The client held on to the orphan for too long: it must be told.
+	if _, err = conn.RunClientTransaction(writeRootTxn(orphan, []byte("Resurrected"))); err == nil {
+		t.Fatalf("Write to collected %v did not fail", orphan)
+	} else if !strings.Contains(err.Error(), "garbage collected") {
+		t.Fatalf("Write to collected %v failed with %v; expected it to have been garbage collected", orphan, err)
+	}
+
+	// Tombstones only last for the retention.
+	for _, node := range c.Nodes {
+		if pruned, err := node.disk.PruneCollectedVars(time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		} else if pruned != 1 {
+			t.Fatalf("%v pruned %v tombstones; expected 1", node, pruned)
+		} else if _, tombstone, err := varOnDisk(node, orphan); err != nil {
+			t.Fatal(err)
+		} else if tombstone != nil {
+			t.Fatalf("%v kept the tombstone of %v after pruning", node, orphan)
+		}
+	}
+}
+
+// varOnDisk reports whether the node has the var in Vars, and returns
+// the var of its tombstone, if it has one.
+func varOnDisk(node *Node, vUUId *common.VarUUId) (bool, *msgs.Var, error) {
+	disk := node.disk
+	res, err := disk.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		_, err := rtxn.Get(disk.Vars, vUUId[:])
+		inVars := err == nil
+		value, err := rtxn.Get(disk.CollectedVars, vUUId[:])
+		if err != nil {
+			return []interface{}{inVars, nil}
+		}
+		_, varBites, err := db.CollectedVarFromData(value)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		return []interface{}{inVars, varBites}
+	}).ResultError()
+	if err != nil {
+		return false, nil, err
+	}
+	results := res.([]interface{})
+	varBites, _ := results[1].([]byte)
+	if varBites == nil {
+		return results[0].(bool), nil, nil
+	}
+	seg, _, err := capn.ReadFromMemoryZeroCopy(varBites)
+	if err != nil {
+		return false, nil, err
+	}
+	varCap := msgs.ReadRootVar(seg)
+	return results[0].(bool), &varCap, nil
+}
+
+func TestClusterHistoryRetention(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping cluster test in short mode.")
+	}
+	c := newStableCluster(t)
+	defer c.Shutdown()
+
+	err := c.ChangeConfiguration(func(config *configuration.Configuration) {
+		config.HistoryRetentionSeconds = 3600
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.AwaitStable(awaitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	node := c.Nodes[0]
+	root, _, err := node.root()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each write supersedes the previous version of the root, which
+	// is retained along with the txn which wrote it.
+	first, err := node.WriteRoot([]byte("First"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := node.WriteRoot([]byte("Second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	awaitRootHistory(t, c, root, func(history []*common.TxnId) bool { return containsTxnId(history, first) })
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	if _, err = node.WriteRoot([]byte("Third")); err != nil {
+		t.Fatal(err)
+	}
+	awaitRootHistory(t, c, root, func(history []*common.TxnId) bool { return containsTxnId(history, second) })
+	for _, node := range c.Nodes {
+		if !txnOnDisk(t, node, first) {
+			t.Fatalf("%v released %v whilst it is retained", node, first)
+		}
+	}
+
+	// Only the versions superseded before the cutoff are pruned, and
+	// their txns released.
+	for _, node := range c.Nodes {
+		if pruned, err := node.disk.PruneVarHistory(cutoff); err != nil {
+			t.Fatal(err)
+		} else if pruned == 0 {
+			t.Fatalf("%v pruned nothing superseded before %v", node, cutoff)
+		}
+		history := rootHistory(t, node, root)
+		if containsTxnId(history, first) || !containsTxnId(history, second) {
+			t.Fatalf("%v has history %v after pruning; expected only %v", node, history, second)
+		} else if txnOnDisk(t, node, first) {
+			t.Fatalf("%v kept %v after pruning its version", node, first)
+		} else if !txnOnDisk(t, node, second) {
+			t.Fatalf("%v released %v whilst it is retained", node, second)
+		}
+	}
+
+	// Within the retention window, the pruners leave the history be.
+	for _, node := range c.Nodes {
+		hp := network.NewHistoryPruner(node.connectionManager, node.disk, 100*time.Millisecond)
+		defer hp.Shutdown()
+	}
+	time.Sleep(500 * time.Millisecond)
+	for _, node := range c.Nodes {
+		if history := rootHistory(t, node, root); !containsTxnId(history, second) {
+			t.Fatalf("%v pruned %v within the retention window", node, second)
+		}
+	}
+
+	// Reducing the retention to zero removes all history.
+	err = c.ChangeConfiguration(func(config *configuration.Configuration) {
+		config.HistoryRetentionSeconds = 0
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.AwaitStable(awaitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	awaitRootHistory(t, c, root, func(history []*common.TxnId) bool { return len(history) == 0 })
+	for _, node := range c.Nodes {
+		if txnOnDisk(t, node, second) {
+			t.Fatalf("%v kept %v after all history was pruned", node, second)
+		}
+	}
+}
+
+// awaitRootHistory waits until the history of the root on every node
+// satisfies pred.
+func awaitRootHistory(t *testing.T, c *Cluster, root *common.VarUUId, pred func([]*common.TxnId) bool) {
+	deadline := time.Now().Add(awaitTimeout)
+	for _, node := range c.Nodes {
+		for history := rootHistory(t, node, root); !pred(history); history = rootHistory(t, node, root) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%v has root history %v after %v", node, history, awaitTimeout)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
+// rootHistory returns the ids of the txns which wrote the versions of
+// the root retained by the node, oldest first.
+func rootHistory(t *testing.T, node *Node, root *common.VarUUId) []*common.TxnId {
+	res, err := node.disk.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		entries, err := node.disk.ReadVarHistory(rtxn, root)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		txnIds := make([]*common.TxnId, len(entries))
+		for idx, entry := range entries {
+			// copy as the entries are only valid within the txn.
+			txnIds[idx] = common.MakeTxnId(append([]byte{}, entry.Var.WriteTxnId()...))
+		}
+		return txnIds
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.([]*common.TxnId)
+}
+
+func containsTxnId(txnIds []*common.TxnId, txnId *common.TxnId) bool {
+	for _, other := range txnIds {
+		if other.Compare(txnId) == common.EQ {
+			return true
+		}
+	}
+	return false
+}
+
+func txnOnDisk(t *testing.T, node *Node, txnId *common.TxnId) bool {
+	res, err := node.disk.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		return node.disk.ReadTxnBytesFromDisk(rtxn, txnId)
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bites, ok := res.([]byte)
+	return ok && bites != nil
+}
+
+func BenchmarkClusterReadRoot(b *testing.B) {
+	benchmarkClusterReadRoot(b, false)
+}
+
+func BenchmarkClusterReadRootReadOnly(b *testing.B) {
+	benchmarkClusterReadRoot(b, true)
+}
+
+// benchmarkClusterReadRoot measures the latency of reading the
+// current version of the root, either as a normal txn, which goes
+// through paxos, or as a read-only txn.
+func benchmarkClusterReadRoot(b *testing.B, readOnly bool) {
+	c := newStableCluster(b)
+	defer c.Shutdown()
+
+	node := c.Nodes[0]
+	txnId, err := node.WriteRoot([]byte("Benchmark"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	root, varPosMap, err := node.root()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		var outcome *msgs.Outcome
+		if readOnly {
+			outcome, err = node.RunReadOnlyTransaction(readRootTxn(root, txnId), varPosMap)
+		} else {
+			outcome, err = node.RunClientTransaction(readRootTxn(root, txnId), varPosMap)
+		}
+		if err != nil {
+			b.Fatal(err)
+		} else if outcome.Which() != msgs.OUTCOME_COMMIT {
+			b.Fatalf("Read of the current version of the root did not commit: %v", outcome.Which())
+		}
+	}
+}
@@ -0,0 +1,499 @@
+package harness
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server/dump"
+	eng "goshawkdb.io/server/txnengine"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistoryTxn is a committed txn as observed by the client which
+// submitted it. Values are tags which must be unique for each var:
+// every write to a var writes a different value, which allows reads
+// to be matched to writes even when the version read was written by
+// a roll rather than by a client txn. Clock is the commit clock, if
+// the client was told it: clients of the client protocol are not.
+type HistoryTxn struct {
+	Id        *common.TxnId
+	Client    int
+	Invoked   time.Time
+	Completed time.Time
+	Reads     map[common.VarUUId]string
+	Writes    map[common.VarUUId]string
+	Clock     *eng.VectorClock
+}
+
+func (ht *HistoryTxn) String() string {
+	return fmt.Sprintf("%v (client %v)", ht.Id, ht.Client)
+}
+
+// History is the set of committed txns from a workload. Txns may be
+// added concurrently.
+type History struct {
+	lock sync.Mutex
+	Txns []*HistoryTxn
+}
+
+func NewHistory() *History {
+	return &History{}
+}
+
+func (h *History) Add(txn *HistoryTxn) {
+	h.lock.Lock()
+	h.Txns = append(h.Txns, txn)
+	h.lock.Unlock()
+}
+
+type historyTxnJSON struct {
+	Id        string
+	Client    int
+	Invoked   time.Time
+	Completed time.Time
+	Reads     map[string]string
+	Writes    map[string]string
+	Clock     map[string]uint64 `json:",omitempty"`
+}
+
+// Write writes the history as JSON, one txn per line.
+func (h *History) Write(w io.Writer) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	encoder := json.NewEncoder(w)
+	for _, txn := range h.Txns {
+		tj := &historyTxnJSON{
+			Id:        hex.EncodeToString(txn.Id[:]),
+			Client:    txn.Client,
+			Invoked:   txn.Invoked,
+			Completed: txn.Completed,
+			Reads:     make(map[string]string, len(txn.Reads)),
+			Writes:    make(map[string]string, len(txn.Writes)),
+		}
+		for vUUId, value := range txn.Reads {
+			tj.Reads[dump.FormatVarUUId(&vUUId)] = value
+		}
+		for vUUId, value := range txn.Writes {
+			tj.Writes[dump.FormatVarUUId(&vUUId)] = value
+		}
+		if txn.Clock != nil {
+			tj.Clock = make(map[string]uint64, len(txn.Clock.Clock))
+			for vUUId, elem := range txn.Clock.Clock {
+				tj.Clock[dump.FormatVarUUId(&vUUId)] = elem
+			}
+		}
+		if err := encoder.Encode(tj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Anomaly is a violation of strict serializability found in a
+// History.
+type Anomaly struct {
+	Kind        string
+	Txns        []*common.TxnId
+	Description string
+}
+
+func (a *Anomaly) String() string {
+	return fmt.Sprintf("%v: %v", a.Kind, a.Description)
+}
+
+const (
+	AnomalyUnknownRead  = "UnknownRead"
+	AnomalyClock        = "Clock"
+	AnomalyVersionOrder = "VersionOrder"
+	AnomalyCycle        = "Cycle"
+)
+
+// Check verifies that the history is strictly serializable. The
+// version order of each var is taken from the commit clocks: writes
+// are ordered by their clock elem for the var, and then by txn
+// id. From that we build the dependency graph of write-write,
+// write-read and read-write (anti-) dependencies, plus the real-time
+// order of txns which did not overlap, and look for cycles. The
+// commit clocks are also checked directly: a reader must have a
+// greater clock elem than the write it read, and no greater a clock
+// elem than the write which overwrote it.
+//
+// If any writer of a var has no clock, the version order of the var
+// is instead taken from what its writers read: each write follows the
+// write of the value it read. That requires every write to the var
+// but the first to be a read-write, and no two of them to have read
+// the same value, as happens with a lost update; otherwise the
+// version order is an anomaly in itself. Txns without clocks are not
+// checked against the clocks of others.
+func (h *History) Check() []*Anomaly {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	g := newHistoryGraph(h.Txns)
+	anomalies := g.addDependencies()
+	g.addRealTime()
+	return append(anomalies, g.cycles()...)
+}
+
+type historyEdge struct {
+	to    int
+	label string
+}
+
+// historyGraph has a node for each txn, followed by a node for each
+// txn's completion. The completion nodes form a chain in completion
+// order, so that real-time order needs only a linear number of
+// edges.
+type historyGraph struct {
+	txns  []*HistoryTxn
+	edges [][]historyEdge
+}
+
+func newHistoryGraph(txns []*HistoryTxn) *historyGraph {
+	return &historyGraph{
+		txns:  txns,
+		edges: make([][]historyEdge, 2*len(txns)),
+	}
+}
+
+func (g *historyGraph) addEdge(from, to int, label string) {
+	if from != to {
+		g.edges[from] = append(g.edges[from], historyEdge{to: to, label: label})
+	}
+}
+
+func (g *historyGraph) addDependencies() []*Anomaly {
+	anomalies := []*Anomaly{}
+	clockElem := func(idx int, vUUId *common.VarUUId) (uint64, bool) {
+		elem, found := g.clockElem(idx, vUUId)
+		if !found && g.txns[idx].Clock != nil {
+			anomalies = append(anomalies, &Anomaly{
+				Kind:        AnomalyClock,
+				Txns:        []*common.TxnId{g.txns[idx].Id},
+				Description: fmt.Sprintf("%v has no clock elem for %v which it accessed", g.txns[idx], vUUId),
+			})
+		}
+		return elem, found
+	}
+
+	writers := make(map[common.VarUUId][]int)
+	for idx, txn := range g.txns {
+		for vUUId := range txn.Writes {
+			writers[vUUId] = append(writers[vUUId], idx)
+		}
+	}
+	// For each var, the version order, and the position of each
+	// value within it.
+	positions := make(map[common.VarUUId]map[string]int, len(writers))
+	for vUUId, idxs := range writers {
+		vUUIdCopy := vUUId
+		clocked := true
+		for _, idx := range idxs {
+			clocked = clocked && g.txns[idx].Clock != nil
+		}
+		if clocked {
+			elems := make(map[int]uint64, len(idxs))
+			for _, idx := range idxs {
+				elems[idx], _ = clockElem(idx, &vUUIdCopy)
+			}
+			sort.Sort(&txnIndices{indices: idxs, less: func(a, b int) bool {
+				if elems[a] != elems[b] {
+					return elems[a] < elems[b]
+				}
+				return g.txns[a].Id.Compare(g.txns[b].Id) == common.LT
+			}})
+		} else {
+			anomalies = append(anomalies, g.readOrder(&vUUIdCopy, idxs)...)
+		}
+		valuePositions := make(map[string]int, len(idxs))
+		for pos, idx := range idxs {
+			valuePositions[g.txns[idx].Writes[vUUId]] = pos
+			if pos > 0 {
+				g.addEdge(idxs[pos-1], idx, fmt.Sprintf("ww(%v)", &vUUIdCopy))
+			}
+		}
+		positions[vUUId] = valuePositions
+	}
+
+	for idx, txn := range g.txns {
+		for vUUId, value := range txn.Reads {
+			vUUIdCopy := vUUId
+			pos, found := positions[vUUId][value]
+			if !found {
+				anomalies = append(anomalies, &Anomaly{
+					Kind:        AnomalyUnknownRead,
+					Txns:        []*common.TxnId{txn.Id},
+					Description: fmt.Sprintf("%v read %q from %v which no committed txn wrote", txn, value, &vUUIdCopy),
+				})
+				continue
+			}
+			readElem, ok := clockElem(idx, &vUUIdCopy)
+			varWriters := writers[vUUId]
+			writer := varWriters[pos]
+			g.addEdge(writer, idx, fmt.Sprintf("wr(%v)", &vUUIdCopy))
+			if writeElem, found := g.clockElem(writer, &vUUIdCopy); ok && found && readElem <= writeElem && writer != idx {
+				anomalies = append(anomalies, &Anomaly{
+					Kind:        AnomalyClock,
+					Txns:        []*common.TxnId{g.txns[writer].Id, txn.Id},
+					Description: fmt.Sprintf("%v read %v from %v but its clock elem %v is not greater than the writer's %v", txn, &vUUIdCopy, g.txns[writer], readElem, writeElem),
+				})
+			}
+			if pos+1 < len(varWriters) {
+				next := varWriters[pos+1]
+				g.addEdge(idx, next, fmt.Sprintf("rw(%v)", &vUUIdCopy))
+				if nextElem, found := g.clockElem(next, &vUUIdCopy); ok && found && readElem > nextElem && next != idx {
+					anomalies = append(anomalies, &Anomaly{
+						Kind:        AnomalyClock,
+						Txns:        []*common.TxnId{txn.Id, g.txns[next].Id},
+						Description: fmt.Sprintf("%v read %v before %v overwrote it but its clock elem %v is greater than the overwriter's %v", txn, &vUUIdCopy, g.txns[next], readElem, nextElem),
+					})
+				}
+			}
+		}
+	}
+	return anomalies
+}
+
+func (g *historyGraph) clockElem(idx int, vUUId *common.VarUUId) (uint64, bool) {
+	if clock := g.txns[idx].Clock; clock != nil {
+		elem, found := clock.Clock[*vUUId]
+		return elem, found
+	}
+	return 0, false
+}
+
+// readOrder sorts idxs, the writers of vUUId, into version order by
+// following the value each read back to the first write: a write's
+// position is the number of writes before it in that chain.
+func (g *historyGraph) readOrder(vUUId *common.VarUUId, idxs []int) []*Anomaly {
+	anomalies := []*Anomaly{}
+	writerOf := make(map[string]int, len(idxs))
+	for _, idx := range idxs {
+		writerOf[g.txns[idx].Writes[*vUUId]] = idx
+	}
+	prev := make(map[int]int, len(idxs))
+	next := make(map[int][]*common.TxnId, len(idxs))
+	firsts := []*common.TxnId{}
+	for _, idx := range idxs {
+		value, read := g.txns[idx].Reads[*vUUId]
+		if writer, found := writerOf[value]; read && found && writer != idx {
+			prev[idx] = writer
+			next[writer] = append(next[writer], g.txns[idx].Id)
+		} else {
+			firsts = append(firsts, g.txns[idx].Id)
+		}
+	}
+	if len(firsts) > 1 {
+		anomalies = append(anomalies, &Anomaly{
+			Kind:        AnomalyVersionOrder,
+			Txns:        firsts,
+			Description: fmt.Sprintf("%v writes to %v did not read the version they overwrote, so the version order is unknown", len(firsts), vUUId),
+		})
+	}
+	for _, idx := range idxs {
+		if len(next[idx]) > 1 {
+			anomalies = append(anomalies, &Anomaly{
+				Kind:        AnomalyVersionOrder,
+				Txns:        append([]*common.TxnId{g.txns[idx].Id}, next[idx]...),
+				Description: fmt.Sprintf("%v writes to %v overwrote the version written by %v", len(next[idx]), vUUId, g.txns[idx]),
+			})
+		}
+	}
+
+	depths := make(map[int]int, len(idxs))
+	var depth func(idx, steps int) int
+	depth = func(idx, steps int) int {
+		if d, found := depths[idx]; found {
+			return d
+		}
+		d := 0
+		// A chain longer than idxs is a cycle, which will be found
+		// from the wr edges.
+		if writer, found := prev[idx]; found && steps < len(idxs) {
+			d = 1 + depth(writer, steps+1)
+		}
+		depths[idx] = d
+		return d
+	}
+	for _, idx := range idxs {
+		depth(idx, 0)
+	}
+	sort.Sort(&txnIndices{indices: idxs, less: func(a, b int) bool {
+		if depths[a] != depths[b] {
+			return depths[a] < depths[b]
+		}
+		return g.txns[a].Id.Compare(g.txns[b].Id) == common.LT
+	}})
+	return anomalies
+}
+
+// addRealTime adds edges so that every txn which completed before
+// another was invoked precedes it.
+func (g *historyGraph) addRealTime() {
+	n := len(g.txns)
+	byCompleted := make([]int, n)
+	for idx := range byCompleted {
+		byCompleted[idx] = idx
+	}
+	sort.Sort(&txnIndices{indices: byCompleted, less: func(a, b int) bool {
+		return g.txns[a].Completed.Before(g.txns[b].Completed)
+	}})
+	for pos, idx := range byCompleted {
+		g.addEdge(idx, n+pos, "")
+		if pos > 0 {
+			g.addEdge(n+pos-1, n+pos, "")
+		}
+	}
+	for idx, txn := range g.txns {
+		// the number of txns which completed before txn was invoked
+		pos := sort.Search(n, func(i int) bool {
+			return !g.txns[byCompleted[i]].Completed.Before(txn.Invoked)
+		})
+		if pos > 0 {
+			g.addEdge(n+pos-1, idx, "")
+		}
+	}
+}
+
+// cycles finds the strongly connected components of the graph with
+// more than one node, and reports a cycle from each.
+func (g *historyGraph) cycles() []*Anomaly {
+	anomalies := []*Anomaly{}
+	for _, component := range g.components() {
+		if len(component) < 2 {
+			continue
+		}
+		members := make(map[int]bool, len(component))
+		start := -1
+		for _, node := range component {
+			members[node] = true
+			if node < len(g.txns) && start == -1 {
+				start = node
+			}
+		}
+		anomalies = append(anomalies, g.describeCycle(start, g.findCycle(start, members)))
+	}
+	return anomalies
+}
+
+// components is Tarjan's algorithm.
+func (g *historyGraph) components() [][]int {
+	index, lowLink := make([]int, len(g.edges)), make([]int, len(g.edges))
+	onStack := make([]bool, len(g.edges))
+	for idx := range index {
+		index[idx] = -1
+	}
+	stack := []int{}
+	components := [][]int{}
+	nextIndex := 0
+	var strongConnect func(int)
+	strongConnect = func(node int) {
+		index[node], lowLink[node] = nextIndex, nextIndex
+		nextIndex++
+		stack = append(stack, node)
+		onStack[node] = true
+		for _, edge := range g.edges[node] {
+			if index[edge.to] == -1 {
+				strongConnect(edge.to)
+				if lowLink[edge.to] < lowLink[node] {
+					lowLink[node] = lowLink[edge.to]
+				}
+			} else if onStack[edge.to] && index[edge.to] < lowLink[node] {
+				lowLink[node] = index[edge.to]
+			}
+		}
+		if lowLink[node] == index[node] {
+			component := []int{}
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == node {
+					break
+				}
+			}
+			components = append(components, component)
+		}
+	}
+	for node := range g.edges {
+		if index[node] == -1 {
+			strongConnect(node)
+		}
+	}
+	return components
+}
+
+// findCycle does a breadth first search within members for the
+// shortest path from start back to itself.
+func (g *historyGraph) findCycle(start int, members map[int]bool) []historyEdge {
+	type step struct {
+		prev int
+		edge historyEdge
+	}
+	visited := make(map[int]step)
+	queue := []int{start}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, edge := range g.edges[node] {
+			if !members[edge.to] {
+				continue
+			}
+			if _, found := visited[edge.to]; found {
+				continue
+			}
+			visited[edge.to] = step{prev: node, edge: edge}
+			if edge.to == start {
+				path := []historyEdge{}
+				for cur := start; ; {
+					s := visited[cur]
+					path = append([]historyEdge{s.edge}, path...)
+					if cur = s.prev; cur == start {
+						return path
+					}
+				}
+			}
+			queue = append(queue, edge.to)
+		}
+	}
+	panic(fmt.Sprintf("No cycle found through %v within its component", start))
+}
+
+// describeCycle names each txn on the cycle, collapsing runs of
+// completion nodes into a single real-time edge.
+func (g *historyGraph) describeCycle(start int, path []historyEdge) *Anomaly {
+	anomaly := &Anomaly{Kind: AnomalyCycle}
+	desc := []string{fmt.Sprint(g.txns[start])}
+	label := ""
+	for _, edge := range path {
+		if edge.label != "" {
+			label = edge.label
+		} else if label == "" {
+			label = "rt"
+		}
+		if edge.to >= len(g.txns) {
+			continue
+		}
+		txn := g.txns[edge.to]
+		anomaly.Txns = append(anomaly.Txns, txn.Id)
+		desc = append(desc, fmt.Sprintf("-%v-> %v", label, txn))
+		label = ""
+	}
+	anomaly.Description = strings.Join(desc, " ")
+	return anomaly
+}
+
+// txnIndices sorts indices of txns.
+type txnIndices struct {
+	indices []int
+	less    func(a, b int) bool
+}
+
+func (ti *txnIndices) Len() int           { return len(ti.indices) }
+func (ti *txnIndices) Less(i, j int) bool { return ti.less(ti.indices[i], ti.indices[j]) }
+func (ti *txnIndices) Swap(i, j int)      { ti.indices[i], ti.indices[j] = ti.indices[j], ti.indices[i] }
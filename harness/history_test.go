@@ -0,0 +1,145 @@
+package harness
+
+import (
+	"goshawkdb.io/common"
+	eng "goshawkdb.io/server/txnengine"
+	"testing"
+	"time"
+)
+
+var (
+	varX = common.VarUUId{1}
+	varY = common.VarUUId{2}
+)
+
+type testHistory struct {
+	*History
+	epoch     time.Time
+	unclocked bool
+}
+
+func newTestHistory() *testHistory {
+	th := &testHistory{History: NewHistory(), epoch: time.Now()}
+	th.add(0, 0, 1, nil, map[common.VarUUId]string{varX: "x0", varY: "y0"}, 1, 1)
+	return th
+}
+
+// newUnclockedTestHistory is as newTestHistory, but no txn has a
+// clock, as with clients of the client protocol.
+func newUnclockedTestHistory() *testHistory {
+	th := &testHistory{History: NewHistory(), epoch: time.Now(), unclocked: true}
+	th.add(0, 0, 1, nil, map[common.VarUUId]string{varX: "x0", varY: "y0"}, 0, 0)
+	return th
+}
+
+// add adds a txn running from invoked to completed (in ms), with the
+// given clock elems for x and y (0 for none).
+func (th *testHistory) add(n byte, invoked, completed int, reads, writes map[common.VarUUId]string, x, y uint64) {
+	clock := eng.NewVectorClock()
+	if x != 0 {
+		clock.SetVarIdMax(varX, x)
+	}
+	if y != 0 {
+		clock.SetVarIdMax(varY, y)
+	}
+	if th.unclocked {
+		clock = nil
+	}
+	if reads == nil {
+		reads = make(map[common.VarUUId]string)
+	}
+	if writes == nil {
+		writes = make(map[common.VarUUId]string)
+	}
+	txnId := &common.TxnId{}
+	txnId[0] = n
+	th.Add(&HistoryTxn{
+		Id:        txnId,
+		Client:    int(n),
+		Invoked:   th.epoch.Add(time.Duration(invoked) * time.Millisecond),
+		Completed: th.epoch.Add(time.Duration(completed) * time.Millisecond),
+		Reads:     reads,
+		Writes:    writes,
+		Clock:     clock,
+	})
+}
+
+func expectAnomalies(t *testing.T, th *testHistory, kinds ...string) {
+	anomalies := th.Check()
+	if len(anomalies) != len(kinds) {
+		t.Fatalf("Expected anomalies %v; got %v", kinds, anomalies)
+	}
+	for idx, anomaly := range anomalies {
+		if anomaly.Kind != kinds[idx] {
+			t.Fatalf("Expected anomalies %v; got %v", kinds, anomalies)
+		}
+	}
+}
+
+func TestHistorySerializable(t *testing.T) {
+	th := newTestHistory()
+	th.add(1, 2, 3, map[common.VarUUId]string{varX: "x0"}, map[common.VarUUId]string{varX: "x1"}, 2, 0)
+	th.add(2, 2, 5, map[common.VarUUId]string{varX: "x0", varY: "y0"}, nil, 2, 2)
+	th.add(3, 4, 6, map[common.VarUUId]string{varX: "x1", varY: "y0"}, map[common.VarUUId]string{varY: "y1"}, 3, 2)
+	expectAnomalies(t, th)
+}
+
+func TestHistoryLostUpdate(t *testing.T) {
+	th := newTestHistory()
+	// Both read x0 and both overwrite it. The clocks order 2 after
+	// 1, so 2 cannot have read x0.
+	th.add(1, 2, 4, map[common.VarUUId]string{varX: "x0"}, map[common.VarUUId]string{varX: "x1"}, 2, 0)
+	th.add(2, 2, 4, map[common.VarUUId]string{varX: "x0"}, map[common.VarUUId]string{varX: "x2"}, 3, 0)
+	expectAnomalies(t, th, AnomalyClock, AnomalyCycle)
+}
+
+func TestHistoryWriteSkew(t *testing.T) {
+	th := newTestHistory()
+	th.add(1, 2, 4, map[common.VarUUId]string{varX: "x0", varY: "y0"}, map[common.VarUUId]string{varX: "x1"}, 2, 2)
+	th.add(2, 2, 4, map[common.VarUUId]string{varX: "x0", varY: "y0"}, map[common.VarUUId]string{varY: "y1"}, 2, 2)
+	expectAnomalies(t, th, AnomalyCycle)
+}
+
+func TestHistoryStaleRead(t *testing.T) {
+	th := newTestHistory()
+	// 2 starts after 1 completes, but does not see its write.
+	th.add(1, 2, 3, map[common.VarUUId]string{varX: "x0"}, map[common.VarUUId]string{varX: "x1"}, 2, 0)
+	th.add(2, 4, 5, map[common.VarUUId]string{varX: "x0"}, nil, 2, 0)
+	expectAnomalies(t, th, AnomalyCycle)
+}
+
+func TestHistoryUnknownRead(t *testing.T) {
+	th := newTestHistory()
+	th.add(1, 2, 3, map[common.VarUUId]string{varX: "aborted"}, nil, 2, 0)
+	expectAnomalies(t, th, AnomalyUnknownRead)
+}
+
+func TestHistoryClock(t *testing.T) {
+	th := newTestHistory()
+	// The read's clock elem should be greater than the write's.
+	th.add(1, 2, 3, map[common.VarUUId]string{varX: "x0"}, nil, 1, 0)
+	expectAnomalies(t, th, AnomalyClock)
+}
+
+func TestHistoryUnclockedSerializable(t *testing.T) {
+	th := newUnclockedTestHistory()
+	th.add(1, 2, 3, map[common.VarUUId]string{varX: "x0"}, map[common.VarUUId]string{varX: "x1"}, 0, 0)
+	th.add(2, 2, 5, map[common.VarUUId]string{varX: "x0", varY: "y0"}, nil, 0, 0)
+	th.add(3, 4, 6, map[common.VarUUId]string{varX: "x1", varY: "y0"}, map[common.VarUUId]string{varY: "y1"}, 0, 0)
+	th.add(4, 7, 8, map[common.VarUUId]string{varX: "x1", varY: "y1"}, map[common.VarUUId]string{varX: "x2", varY: "y2"}, 0, 0)
+	expectAnomalies(t, th)
+}
+
+func TestHistoryUnclockedLostUpdate(t *testing.T) {
+	th := newUnclockedTestHistory()
+	th.add(1, 2, 4, map[common.VarUUId]string{varX: "x0"}, map[common.VarUUId]string{varX: "x1"}, 0, 0)
+	th.add(2, 2, 4, map[common.VarUUId]string{varX: "x0"}, map[common.VarUUId]string{varX: "x2"}, 0, 0)
+	expectAnomalies(t, th, AnomalyVersionOrder, AnomalyCycle)
+}
+
+func TestHistoryUnclockedStaleRead(t *testing.T) {
+	th := newUnclockedTestHistory()
+	th.add(1, 2, 3, map[common.VarUUId]string{varX: "x0"}, map[common.VarUUId]string{varX: "x1"}, 0, 0)
+	th.add(2, 4, 5, map[common.VarUUId]string{varX: "x0"}, nil, 0, 0)
+	expectAnomalies(t, th, AnomalyCycle)
+}
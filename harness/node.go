@@ -0,0 +1,414 @@
+package harness
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/common/certs"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/network"
+	"goshawkdb.io/server/paxos"
+	eng "goshawkdb.io/server/txnengine"
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Node is one server instance of a Cluster. A node can be stopped
+// and started again any number of times: its data directory and
+// RMId are kept, and its boot count is incremented each time it
+// starts.
+type Node struct {
+	cluster           *Cluster
+	RMId              common.RMId
+	BootCount         uint32
+	Port              uint16
+	DataDir           string
+	disk              *db.Databases
+	connectionManager *network.ConnectionManager
+	transmogrifier    *network.TopologyTransmogrifier
+	listener          *network.Listener
+	lock              sync.Mutex
+	topology          *configuration.Topology
+	topologyChanged   chan struct{}
+	shutdownSignalled bool
+}
+
+func newNode(cluster *Cluster, rmId common.RMId, port uint16, dataDir string) *Node {
+	return &Node{
+		cluster:         cluster,
+		RMId:            rmId,
+		Port:            port,
+		DataDir:         dataDir,
+		topologyChanged: make(chan struct{}),
+	}
+}
+
+func (n *Node) String() string {
+	return fmt.Sprintf("Node %v (%v)", n.RMId, n.Host())
+}
+
+// Host is the entry for this node in the hosts of a configuration.
+func (n *Node) Host() string {
+	return net.JoinHostPort("localhost", fmt.Sprint(n.Port))
+}
+
+func (n *Node) IsRunning() bool {
+	return n.connectionManager != nil
+}
+
+// Start starts the node with the latest configuration of the
+// cluster, wiring it together in the same way as the goshawkdb
+// command.
+func (n *Node) Start() error {
+	if n.IsRunning() {
+		return fmt.Errorf("%v is already running", n)
+	}
+	n.BootCount++
+	if err := n.writeIds(); err != nil {
+		return err
+	}
+	nodeCertPrivKeyPair, err := certs.GenerateNodeCertificatePrivateKeyPair(n.cluster.ClusterCertificate)
+	if err != nil {
+		return err
+	}
+	disk, err := mdbs.NewMDBServer(n.DataDir, 0, 0600, server.MDBInitialSize, 1, time.Millisecond, db.DB)
+	if err != nil {
+		return err
+	}
+	n.disk = disk.(*db.Databases)
+	n.shutdownSignalled = false
+
+	n.connectionManager, n.transmogrifier = network.NewConnectionManager(n.RMId, n.BootCount, 2, n.disk, nodeCertPrivKeyPair, n.Port, n, n.cluster.config.Clone(), nil, nil)
+	for _, cert := range n.cluster.TrustedClusterCertificates {
+		n.connectionManager.TrustClusterCertificate(cert)
+	}
+	if topology := n.connectionManager.AddTopologySubscriber(eng.ConnectionSubscriber, n); topology != nil {
+		n.TopologyChanged(topology, func(bool) {})
+	}
+
+	n.listener, err = network.NewListener(n.Port, n.connectionManager)
+	if err != nil {
+		n.Stop()
+		return err
+	}
+	return nil
+}
+
+// writeIds writes the rmid and bootcount files to the data directory
+// just as the server does, so that tools which open data directories,
+// such as backup and export, work on those of the harness too.
+func (n *Node) writeIds() error {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(n.RMId))
+	if err := ioutil.WriteFile(filepath.Join(n.DataDir, "rmid"), b, 0600); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(b, n.BootCount)
+	return ioutil.WriteFile(filepath.Join(n.DataDir, "bootcount"), b, 0600)
+}
+
+// Stop shuts the node down. It is as abrupt as the node allows: no
+// attempt is made to wait for in-flight txns.
+func (n *Node) Stop() {
+	if !n.IsRunning() {
+		return
+	}
+	if n.listener != nil {
+		n.listener.Shutdown()
+		n.listener = nil
+	}
+	n.connectionManager.RemoveTopologySubscriberAsync(eng.ConnectionSubscriber, n)
+	n.transmogrifier.Shutdown()
+	n.connectionManager.Shutdown(paxos.Sync)
+	n.disk.Shutdown()
+	n.connectionManager, n.transmogrifier, n.disk = nil, nil, nil
+	n.lock.Lock()
+	n.topology = nil
+	n.lock.Unlock()
+}
+
+// Restart stops the node, if it is running, and starts it again.
+func (n *Node) Restart() error {
+	n.Stop()
+	return n.Start()
+}
+
+// SignalShutdown is called by the node if it encounters a fatal
+// error.
+func (n *Node) SignalShutdown() {
+	log.Printf("%v has signalled shutdown.", n)
+	n.lock.Lock()
+	n.shutdownSignalled = true
+	n.lock.Unlock()
+}
+
+func (n *Node) ShutdownSignalled() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.shutdownSignalled
+}
+
+func (n *Node) TopologyChanged(topology *configuration.Topology, done func(bool)) {
+	n.lock.Lock()
+	if topology != nil {
+		n.topology = topology
+	}
+	close(n.topologyChanged)
+	n.topologyChanged = make(chan struct{})
+	n.lock.Unlock()
+	done(true)
+}
+
+// Topology returns the latest topology the node has installed, or
+// nil if it has none.
+func (n *Node) Topology() *configuration.Topology {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.topology
+}
+
+// AwaitTopology waits until the topology installed by the node
+// satisfies pred.
+func (n *Node) AwaitTopology(timeout time.Duration, pred func(*configuration.Topology) bool) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		n.lock.Lock()
+		topology, changed := n.topology, n.topologyChanged
+		n.lock.Unlock()
+		if topology != nil && pred(topology) {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return fmt.Errorf("%v timed out waiting for topology; currently %v", n, topology)
+		}
+	}
+}
+
+// Faults returns the fault injector for the node's links to other
+// nodes, or nil if the node is not running. The rules are lost when
+// the node stops.
+func (n *Node) Faults() *network.FaultInjector {
+	if !n.IsRunning() {
+		return nil
+	}
+	return n.connectionManager.Faults
+}
+
+// Disk returns the node's database, or nil if the node is not
+// running.
+func (n *Node) Disk() *db.Databases {
+	if !n.IsRunning() {
+		return nil
+	}
+	return n.disk
+}
+
+// TrustClusterCertificate makes the node accept peers with node
+// certificates signed by cert, in addition to those it already
+// trusts, as the cluster-certs admin endpoint does with phase=trust.
+func (n *Node) TrustClusterCertificate(cert *x509.Certificate) error {
+	if !n.IsRunning() {
+		return fmt.Errorf("%v is not running", n)
+	}
+	n.connectionManager.TrustClusterCertificate(cert)
+	return nil
+}
+
+// SwitchClusterCertificate makes the node present a node certificate
+// signed by the given PEM encoded cluster certificate and key, as the
+// cluster-certs admin endpoint does with phase=switch. Established
+// connections are unaffected. The node forgets the switch if it is
+// restarted.
+func (n *Node) SwitchClusterCertificate(clusterCertificate []byte) error {
+	if !n.IsRunning() {
+		return fmt.Errorf("%v is not running", n)
+	}
+	nodeCertPrivKeyPair, err := certs.GenerateNodeCertificatePrivateKeyPair(clusterCertificate)
+	if err != nil {
+		return err
+	}
+	n.connectionManager.SetNodeCertificatePrivateKeyPair(nodeCertPrivKeyPair)
+	return nil
+}
+
+// UntrustClusterCertificate makes the node stop accepting peers with
+// node certificates signed by cert, as DELETE on the cluster-certs
+// admin endpoint does.
+func (n *Node) UntrustClusterCertificate(cert *x509.Certificate) error {
+	if !n.IsRunning() {
+		return fmt.Errorf("%v is not running", n)
+	}
+	return n.connectionManager.UntrustClusterCertificate(sha256.Sum256(cert.Raw))
+}
+
+// RevokeClientCertificate revokes the client certificate with the
+// given hex fingerprint on this node only, closing any connections
+// from clients using it, as POST on the revoked-clients admin
+// endpoint does.
+func (n *Node) RevokeClientCertificate(fingerprint string) error {
+	if !n.IsRunning() {
+		return fmt.Errorf("%v is not running", n)
+	}
+	bites, err := hex.DecodeString(fingerprint)
+	if err != nil {
+		return err
+	} else if len(bites) != sha256.Size {
+		return fmt.Errorf("Illegal fingerprint %v: must be %v bytes long", fingerprint, sha256.Size)
+	}
+	hashsum := [sha256.Size]byte{}
+	copy(hashsum[:], bites)
+	n.connectionManager.RevokeClientCertificates(hashsum)
+	return nil
+}
+
+// Status returns the status of the node, as logged on SIGUSR1.
+func (n *Node) Status() string {
+	if !n.IsRunning() {
+		return fmt.Sprintf("%v is not running", n)
+	}
+	strChan := make(chan string, 1)
+	sc := server.NewStatusConsumer()
+	go sc.Consume(func(str string) { strChan <- str })
+	sc.EmitKV("RMId", n.RMId)
+	sc.EmitKV("Data Directory", n.DataDir)
+	sc.EmitKV("Port", n.Port)
+	n.connectionManager.Status(sc)
+	return <-strChan
+}
+
+// RunClientTransaction runs the txn through the node's local
+// connection, which assigns the txn id. varPosMap must contain the
+// positions of every var the txn uses which is not created by it.
+func (n *Node) RunClientTransaction(ctxn *cmsgs.ClientTxn, varPosMap map[common.VarUUId]*common.Positions) (*msgs.Outcome, error) {
+	if !n.IsRunning() {
+		return nil, fmt.Errorf("%v is not running", n)
+	}
+	outcome, err := n.connectionManager.LocalConnection.RunClientTransaction(ctxn, varPosMap, true)
+	if err == nil && outcome == nil {
+		err = fmt.Errorf("%v is shutting down", n)
+	}
+	return outcome, err
+}
+
+// RunReadOnlyTransaction is as RunClientTransaction, but the txn,
+// which must contain only reads, is submitted as a read-only txn.
+func (n *Node) RunReadOnlyTransaction(ctxn *cmsgs.ClientTxn, varPosMap map[common.VarUUId]*common.Positions) (*msgs.Outcome, error) {
+	if !n.IsRunning() {
+		return nil, fmt.Errorf("%v is not running", n)
+	}
+	outcome, err := n.connectionManager.LocalConnection.RunReadOnlyTransaction(ctxn, varPosMap)
+	if err == nil && outcome == nil {
+		err = fmt.Errorf("%v is shutting down", n)
+	}
+	return outcome, err
+}
+
+func (n *Node) root() (*common.VarUUId, map[common.VarUUId]*common.Positions, error) {
+	topology := n.Topology()
+	if topology == nil || topology.Root.VarUUId == nil {
+		return nil, nil, fmt.Errorf("%v has no root", n)
+	}
+	root := topology.Root.VarUUId
+	return root, map[common.VarUUId]*common.Positions{*root: topology.Root.Positions}, nil
+}
+
+// ReadRoot returns the current value of the root, and the id of the
+// txn which wrote it.
+func (n *Node) ReadRoot() ([]byte, *common.TxnId, error) {
+	root, varPosMap, err := n.root()
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		// Reading at version zero must abort, unless the root has
+		// never been written, and the rerun tells us the current
+		// value.
+		outcome, err := n.RunClientTransaction(readRootTxn(root, common.VersionZero), varPosMap)
+		if err != nil {
+			return nil, nil, err
+		} else if outcome.Which() == msgs.OUTCOME_COMMIT {
+			return nil, common.VersionZero, nil
+		}
+		abort := outcome.Abort()
+		if abort.Which() == msgs.OUTCOMEABORT_RESUBMIT {
+			continue
+		}
+		updates := abort.Rerun()
+		for idx, l := 0, updates.Len(); idx < l; idx++ {
+			update := updates.At(idx)
+			updateActions := update.Actions()
+			for idy, m := 0, updateActions.Len(); idy < m; idy++ {
+				updateAction := updateActions.At(idy)
+				if updateAction.Which() == msgs.ACTION_WRITE && common.MakeVarUUId(updateAction.VarId()).Compare(root) == common.EQ {
+					return updateAction.Write().Value(), common.MakeTxnId(update.TxnId()), nil
+				}
+			}
+		}
+		return nil, nil, fmt.Errorf("%v read of root aborted without an update for the root", n)
+	}
+}
+
+// readRootTxn returns a client txn which reads the root at version.
+func readRootTxn(root *common.VarUUId, version *common.TxnId) *cmsgs.ClientTxn {
+	seg := capn.NewBuffer(nil)
+	ctxn := cmsgs.NewClientTxn(seg)
+	ctxn.SetRetry(false)
+	actions := cmsgs.NewClientActionList(seg, 1)
+	ctxn.SetActions(actions)
+	action := actions.At(0)
+	action.SetVarId(root[:])
+	action.SetRead()
+	action.Read().SetVersion(version[:])
+	return &ctxn
+}
+
+// WriteRoot writes value to the root, with no references, and
+// returns the id of the txn which wrote it.
+func (n *Node) WriteRoot(value []byte) (*common.TxnId, error) {
+	root, varPosMap, err := n.root()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		outcome, err := n.RunClientTransaction(writeRootTxn(root, value), varPosMap)
+		if err != nil {
+			return nil, err
+		} else if outcome.Which() == msgs.OUTCOME_COMMIT {
+			txn := outcome.Txn()
+			return common.MakeTxnId(txn.Id()), nil
+		}
+	}
+}
+
+// writeRootTxn returns a client txn which writes value to the root,
+// with no references.
+func writeRootTxn(root *common.VarUUId, value []byte) *cmsgs.ClientTxn {
+	seg := capn.NewBuffer(nil)
+	ctxn := cmsgs.NewClientTxn(seg)
+	ctxn.SetRetry(false)
+	actions := cmsgs.NewClientActionList(seg, 1)
+	ctxn.SetActions(actions)
+	action := actions.At(0)
+	action.SetVarId(root[:])
+	action.SetWrite()
+	write := action.Write()
+	write.SetValue(value)
+	write.SetReferences(seg.NewDataList(0))
+	return &ctxn
+}
@@ -0,0 +1,268 @@
+package harness
+
+import (
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Workload drives concurrent client txns against a cluster and
+// records the History of those which commit. Each client connects to
+// every node over the client protocol, as an external client would,
+// and runs Txns txns one after another, through its connections in
+// turn; each txn reads or read-writes between 1 and MaxActions of
+// Vars vars. Every write writes a unique value. Client txn outcomes
+// have no commit clocks, so neither do the txns of the History: as
+// every write but the creation of each var is a read-write, the
+// History's version order comes from the reads instead.
+type Workload struct {
+	Vars       int
+	Clients    int
+	Txns       int
+	MaxActions int
+	Seed       int64
+}
+
+type workloadVersion struct {
+	txnId *common.TxnId
+	value string
+}
+
+// Run creates the vars, referenced from the root so that they are
+// not collected, and then runs the clients. It returns once every
+// client has finished, or on the first error.
+func (w *Workload) Run(c *Cluster) (*History, error) {
+	nodes := []*Node{}
+	for _, node := range c.Nodes {
+		if node.IsRunning() {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("No nodes are running")
+	}
+	if w.Vars < 1 || w.Clients < 1 || w.MaxActions < 1 {
+		return nil, fmt.Errorf("Workload requires at least one var, client and action: %#v", w)
+	}
+
+	history := NewHistory()
+	vUUIds, initial, err := w.createVars(nodes[0], history)
+	if err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, w.Clients)
+	for client := 0; client < w.Clients; client++ {
+		wc := &workloadClient{
+			Workload: w,
+			client:   client,
+			nodes:    nodes,
+			history:  history,
+			vUUIds:   vUUIds,
+			cache:    make(map[common.VarUUId]*workloadVersion, len(vUUIds)),
+			rng:      rand.New(rand.NewSource(w.Seed + int64(client))),
+		}
+		for _, vUUId := range vUUIds {
+			wc.cache[*vUUId] = initial
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := wc.run(); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	select {
+	case err := <-errs:
+		return history, err
+	default:
+		return history, nil
+	}
+}
+
+// createVars creates the vars and writes the root to refer to them
+// in a single txn, which is recorded as the first txn of the history.
+func (w *Workload) createVars(node *Node, history *History) ([]*common.VarUUId, *workloadVersion, error) {
+	client, err := node.Connect(node.cluster.ClientCertificate)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer client.Close()
+	if client.Root == nil {
+		return nil, nil, fmt.Errorf("%v has no root", client)
+	}
+	vUUIds := make([]*common.VarUUId, w.Vars)
+	for idx := range vUUIds {
+		vUUIds[idx] = client.NextVarUUId()
+	}
+	const initialValue = "initial"
+	for {
+		seg := capn.NewBuffer(nil)
+		ctxn := cmsgs.NewClientTxn(seg)
+		ctxn.SetRetry(false)
+		actions := cmsgs.NewClientActionList(seg, len(vUUIds)+1)
+		ctxn.SetActions(actions)
+		refs := seg.NewDataList(len(vUUIds))
+		for idx, vUUId := range vUUIds {
+			action := actions.At(idx)
+			action.SetVarId(vUUId[:])
+			action.SetCreate()
+			create := action.Create()
+			create.SetValue([]byte(initialValue))
+			create.SetReferences(seg.NewDataList(0))
+			refs.Set(idx, vUUId[:])
+		}
+		action := actions.At(len(vUUIds))
+		action.SetVarId(client.Root[:])
+		action.SetWrite()
+		write := action.Write()
+		write.SetValue([]byte{})
+		write.SetReferences(refs)
+
+		invoked := time.Now()
+		outcome, err := client.RunClientTransaction(&ctxn)
+		if err != nil {
+			return nil, nil, err
+		} else if outcome.Which() != cmsgs.CLIENTTXNOUTCOME_COMMIT {
+			continue
+		}
+
+		txnId := common.MakeTxnId(outcome.FinalId())
+		ht := &HistoryTxn{
+			Id:        txnId,
+			Client:    -1,
+			Invoked:   invoked,
+			Completed: time.Now(),
+			Reads:     make(map[common.VarUUId]string),
+			Writes:    make(map[common.VarUUId]string, len(vUUIds)),
+		}
+		for _, vUUId := range vUUIds {
+			ht.Writes[*vUUId] = initialValue
+		}
+		history.Add(ht)
+		return vUUIds, &workloadVersion{txnId: txnId, value: initialValue}, nil
+	}
+}
+
+type workloadClient struct {
+	*Workload
+	client  int
+	nodes   []*Node
+	history *History
+	vUUIds  []*common.VarUUId
+	cache   map[common.VarUUId]*workloadVersion
+	rng     *rand.Rand
+}
+
+func (wc *workloadClient) run() error {
+	// Each connection must read the root to learn where the vars
+	// are.
+	conns := make([]*Client, 0, len(wc.nodes))
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for _, node := range wc.nodes {
+		conn, err := node.Connect(node.cluster.ClientCertificate)
+		if err != nil {
+			return fmt.Errorf("Client %v: %v", wc.client, err)
+		}
+		conns = append(conns, conn)
+		if _, _, err = conn.ReadRoot(); err != nil {
+			return fmt.Errorf("Client %v: %v", wc.client, err)
+		}
+	}
+
+	for seq := 0; seq < wc.Txns; seq++ {
+		conn := conns[(wc.client+seq)%len(conns)]
+		count := 1 + wc.rng.Intn(wc.MaxActions)
+		if count > len(wc.vUUIds) {
+			count = len(wc.vUUIds)
+		}
+		perm := wc.rng.Perm(len(wc.vUUIds))[:count]
+		writes := make(map[common.VarUUId]string, count)
+		for idx, vIdx := range perm {
+			if wc.rng.Intn(2) == 0 {
+				writes[*wc.vUUIds[vIdx]] = fmt.Sprintf("%v-%v-%v", wc.client, seq, idx)
+			}
+		}
+		if err := wc.runTxn(conn, perm, writes); err != nil {
+			return fmt.Errorf("Client %v: %v", wc.client, err)
+		}
+	}
+	return nil
+}
+
+// runTxn reruns the txn with updated versions until it commits.
+func (wc *workloadClient) runTxn(conn *Client, perm []int, writes map[common.VarUUId]string) error {
+	for {
+		seg := capn.NewBuffer(nil)
+		ctxn := cmsgs.NewClientTxn(seg)
+		ctxn.SetRetry(false)
+		actions := cmsgs.NewClientActionList(seg, len(perm))
+		ctxn.SetActions(actions)
+		reads := make(map[common.VarUUId]string, len(perm))
+		for idx, vIdx := range perm {
+			vUUId := wc.vUUIds[vIdx]
+			cached := wc.cache[*vUUId]
+			reads[*vUUId] = cached.value
+			action := actions.At(idx)
+			action.SetVarId(vUUId[:])
+			if value, found := writes[*vUUId]; found {
+				action.SetReadwrite()
+				rw := action.Readwrite()
+				rw.SetVersion(cached.txnId[:])
+				rw.SetValue([]byte(value))
+				rw.SetReferences(seg.NewDataList(0))
+			} else {
+				action.SetRead()
+				action.Read().SetVersion(cached.txnId[:])
+			}
+		}
+
+		invoked := time.Now()
+		outcome, err := conn.RunClientTransaction(&ctxn)
+		completed := time.Now()
+		if err != nil {
+			return err
+		}
+
+		if outcome.Which() == cmsgs.CLIENTTXNOUTCOME_COMMIT {
+			txnId := common.MakeTxnId(outcome.FinalId())
+			wc.history.Add(&HistoryTxn{
+				Id:        txnId,
+				Client:    wc.client,
+				Invoked:   invoked,
+				Completed: completed,
+				Reads:     reads,
+				Writes:    writes,
+			})
+			for vUUId, value := range writes {
+				wc.cache[vUUId] = &workloadVersion{txnId: txnId, value: value}
+			}
+			return nil
+		}
+
+		updates := outcome.Abort()
+		for idx, l := 0, updates.Len(); idx < l; idx++ {
+			update := updates.At(idx)
+			txnId := common.MakeTxnId(update.Version())
+			updateActions := update.Actions()
+			for idy, m := 0, updateActions.Len(); idy < m; idy++ {
+				updateAction := updateActions.At(idy)
+				vUUId := common.MakeVarUUId(updateAction.VarId())
+				if _, found := wc.cache[*vUUId]; found && updateAction.Which() == cmsgs.CLIENTACTION_WRITE {
+					wc.cache[*vUUId] = &workloadVersion{txnId: txnId, value: string(updateAction.Write().Value())}
+				}
+			}
+		}
+	}
+}
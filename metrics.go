@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics is the registry that all packages record into. It is
+// rendered in the Prometheus text exposition format by WriteTo.
+var Metrics = NewMetricsRegistry()
+
+var DefaultLatencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+type MetricsRegistry struct {
+	sync.Mutex
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	metrics map[string]metric
+}
+
+type metric interface {
+	write(w *bytes.Buffer, name, labels string)
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		families: make(map[string]*metricFamily),
+	}
+}
+
+// Counter returns the counter with the given name and label pairs,
+// creating it if necessary. labelPairs must alternate label names and
+// label values.
+func (mr *MetricsRegistry) Counter(name, help string, labelPairs ...string) *Counter {
+	return mr.getOrCreate(name, help, "counter", labelPairs, func() metric { return new(Counter) }).(*Counter)
+}
+
+func (mr *MetricsRegistry) Gauge(name, help string, labelPairs ...string) *Gauge {
+	return mr.getOrCreate(name, help, "gauge", labelPairs, func() metric { return new(Gauge) }).(*Gauge)
+}
+
+// Histogram returns the histogram with the given name and label
+// pairs, creating it if necessary. Buckets are upper bounds and must
+// be sorted in increasing order.
+func (mr *MetricsRegistry) Histogram(name, help string, buckets []float64, labelPairs ...string) *Histogram {
+	return mr.getOrCreate(name, help, "histogram", labelPairs, func() metric {
+		return &Histogram{
+			buckets: buckets,
+			counts:  make([]uint64, len(buckets)),
+		}
+	}).(*Histogram)
+}
+
+func (mr *MetricsRegistry) getOrCreate(name, help, kind string, labelPairs []string, ctor func() metric) metric {
+	if len(labelPairs)%2 != 0 {
+		panic(fmt.Sprintf("Metric %v: odd number of label name/value strings: %v", name, labelPairs))
+	}
+	labels := formatLabels(labelPairs)
+
+	mr.Lock()
+	defer mr.Unlock()
+	family, found := mr.families[name]
+	if !found {
+		family = &metricFamily{
+			name:    name,
+			help:    help,
+			kind:    kind,
+			metrics: make(map[string]metric),
+		}
+		mr.families[name] = family
+	} else if family.kind != kind {
+		panic(fmt.Sprintf("Metric %v registered as both %v and %v", name, family.kind, kind))
+	}
+	m, found := family.metrics[labels]
+	if !found {
+		m = ctor()
+		family.metrics[labels] = m
+		family.labels = append(family.labels, labels)
+		sort.Strings(family.labels)
+	}
+	return m
+}
+
+func formatLabels(labelPairs []string) string {
+	if len(labelPairs) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labelPairs)>>1)
+	for idx := 0; idx < len(labelPairs); idx += 2 {
+		pairs = append(pairs, labelPairs[idx]+`="`+labelValueEscaper.Replace(labelPairs[idx+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// The text exposition format only escapes backslash, double quote and
+// newline in label values; everything else is written verbatim.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteTo writes out every registered metric in the text exposition
+// format.
+func (mr *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	mr.Lock()
+	names := make([]string, 0, len(mr.families))
+	families := make([]*metricFamily, 0, len(mr.families))
+	for name := range mr.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		family := mr.families[name]
+		// copy so that we can release the lock whilst writing.
+		familyCopy := *family
+		familyCopy.labels = append([]string(nil), family.labels...)
+		familyCopy.metrics = make(map[string]metric, len(family.metrics))
+		for labels, m := range family.metrics {
+			familyCopy.metrics[labels] = m
+		}
+		families = append(families, &familyCopy)
+	}
+	mr.Unlock()
+
+	buf := new(bytes.Buffer)
+	for _, family := range families {
+		fmt.Fprintf(buf, "# HELP %s %s\n", family.name, family.help)
+		fmt.Fprintf(buf, "# TYPE %s %s\n", family.name, family.kind)
+		for _, labels := range family.labels {
+			family.metrics[labels].write(buf, family.name, labels)
+		}
+	}
+	return buf.WriteTo(w)
+}
+
+type Counter struct {
+	value uint64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+func (c *Counter) write(w *bytes.Buffer, name, labels string) {
+	fmt.Fprintf(w, "%s%s %d\n", name, labels, c.Value())
+}
+
+type Gauge struct {
+	value int64
+}
+
+func (g *Gauge) Inc() {
+	atomic.AddInt64(&g.value, 1)
+}
+
+func (g *Gauge) Dec() {
+	atomic.AddInt64(&g.value, -1)
+}
+
+func (g *Gauge) Add(n int64) {
+	atomic.AddInt64(&g.value, n)
+}
+
+func (g *Gauge) Set(n int64) {
+	atomic.StoreInt64(&g.value, n)
+}
+
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+func (g *Gauge) write(w *bytes.Buffer, name, labels string) {
+	fmt.Fprintf(w, "%s%s %d\n", name, labels, g.Value())
+}
+
+type Histogram struct {
+	sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(value float64) {
+	idx := sort.SearchFloat64s(h.buckets, value)
+	h.Lock()
+	if idx < len(h.counts) {
+		h.counts[idx]++
+	}
+	h.count++
+	h.sum += value
+	h.Unlock()
+}
+
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) write(w *bytes.Buffer, name, labels string) {
+	h.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	count, sum := h.count, h.sum
+	h.Unlock()
+
+	prefix := "{"
+	if len(labels) > 0 {
+		prefix = labels[:len(labels)-1] + ","
+	}
+	cumulative := uint64(0)
+	for idx, bound := range h.buckets {
+		cumulative += counts[idx]
+		fmt.Fprintf(w, "%s_bucket%sle=%q} %d\n", name, prefix, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%sle=%q} %d\n", name, prefix, "+Inf", count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, strconv.FormatFloat(sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, count)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMetricsWriteTo(t *testing.T) {
+	mr := NewMetricsRegistry()
+	mr.Counter("txns_total", "Txns submitted.", "outcome", "commit").Add(3)
+	mr.Counter("txns_total", "Txns submitted.", "outcome", "abort").Inc()
+	mr.Counter("txns_total", "Txns submitted.", "outcome", "a\\b \"c\"\nd").Inc()
+	gauge := mr.Gauge("conns", "Open connections.")
+	gauge.Set(5)
+	gauge.Dec()
+	hist := mr.Histogram("latency_seconds", "Latency.", []float64{0.1, 0.5, 1}, "op", "read")
+	for _, value := range []float64{0.05, 0.1, 0.3, 0.4, 2} {
+		hist.Observe(value)
+	}
+
+	expected := `# HELP conns Open connections.
+# TYPE conns gauge
+conns 4
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="read",le="0.1"} 2
+latency_seconds_bucket{op="read",le="0.5"} 4
+latency_seconds_bucket{op="read",le="1"} 4
+latency_seconds_bucket{op="read",le="+Inf"} 5
+latency_seconds_sum{op="read"} 2.85
+latency_seconds_count{op="read"} 5
+# HELP txns_total Txns submitted.
+# TYPE txns_total counter
+txns_total{outcome="a\\b \"c\"\nd"} 1
+txns_total{outcome="abort"} 1
+txns_total{outcome="commit"} 3
+`
+	buf := new(bytes.Buffer)
+	if _, err := mr.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected {
+		t.Fatalf("Expected:\n%v\nGot:\n%v", expected, buf.String())
+	}
+}
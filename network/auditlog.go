@@ -0,0 +1,212 @@
+package network
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditLog appends a record of every txn submitted by clients
+// connected to this node to a local file, as one JSON AuditRecord per
+// line. Each txn gets a Submitted record, naming the fingerprint of
+// the client's certificate and the vars the txn touches, and then,
+// unless the node shuts down first, a record of whether it Committed,
+// Aborted or Failed. Committed and Aborted records carry the id of
+// the Txn which finally committed or aborted, which may differ from
+// the client's id if the txn was resubmitted. Values written by the
+// client are only recorded if includeValues is set.
+//
+// Every record is synced to disk before Submitted or Completed
+// returns, so a record of a txn's submission is durable before the
+// txn is run, and of its outcome before the client is told.
+//
+// Once appending a record would take the file past maxSize, the file
+// is renamed with the current time appended, and a new file started.
+// Rotated files are never removed.
+type AuditLog struct {
+	lock          sync.Mutex
+	path          string
+	maxSize       int64
+	includeValues bool
+	file          *os.File
+	size          int64
+}
+
+const (
+	AuditSubmitted = "Submitted"
+	AuditCommitted = "Committed"
+	AuditAborted   = "Aborted"
+	AuditFailed    = "Failed"
+)
+
+type AuditRecord struct {
+	Time        time.Time
+	Event       string
+	Fingerprint string
+	Connection  uint32
+	TxnId       string
+	FinalTxnId  string         `json:",omitempty"`
+	Actions     []*AuditAction `json:",omitempty"`
+	Error       string         `json:",omitempty"`
+}
+
+type AuditAction struct {
+	VarId  string
+	Action string
+	Value  []byte `json:",omitempty"`
+}
+
+func NewAuditLog(path string, maxSize int64, includeValues bool) (*AuditLog, error) {
+	if maxSize <= 0 {
+		return nil, errors.New("Audit log size must be > 0")
+	}
+	al := &AuditLog{
+		path:          path,
+		maxSize:       maxSize,
+		includeValues: includeValues,
+	}
+	if err := al.open(); err != nil {
+		return nil, err
+	}
+	return al, nil
+}
+
+func (al *AuditLog) open() error {
+	file, err := os.OpenFile(al.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	al.file = file
+	al.size = info.Size()
+	return nil
+}
+
+func (al *AuditLog) Close() error {
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	if al.file == nil {
+		return nil
+	}
+	err := al.file.Close()
+	al.file = nil
+	return err
+}
+
+// Submitted records the submission of ctxn by the client with the
+// given fingerprint. It does nothing if al is nil.
+func (al *AuditLog) Submitted(fingerprint string, connNumber uint32, ctxn *cmsgs.ClientTxn) {
+	if al == nil {
+		return
+	}
+	clientActions := ctxn.Actions()
+	actions := make([]*AuditAction, clientActions.Len())
+	for idx := range actions {
+		clientAction := clientActions.At(idx)
+		action := &AuditAction{VarId: hex.EncodeToString(clientAction.VarId())}
+		var value []byte
+		switch clientAction.Which() {
+		case cmsgs.CLIENTACTION_READ:
+			action.Action = "Read"
+		case cmsgs.CLIENTACTION_WRITE:
+			action.Action = "Write"
+			value = clientAction.Write().Value()
+		case cmsgs.CLIENTACTION_READWRITE:
+			action.Action = "ReadWrite"
+			value = clientAction.Readwrite().Value()
+		case cmsgs.CLIENTACTION_CREATE:
+			action.Action = "Create"
+			value = clientAction.Create().Value()
+		case cmsgs.CLIENTACTION_ROLL:
+			action.Action = "Roll"
+		}
+		if al.includeValues && value != nil {
+			action.Value = append([]byte{}, value...)
+		}
+		actions[idx] = action
+	}
+	al.write(&AuditRecord{
+		Event:       AuditSubmitted,
+		Fingerprint: fingerprint,
+		Connection:  connNumber,
+		TxnId:       hex.EncodeToString(ctxn.Id()),
+		Actions:     actions,
+	})
+}
+
+// Completed records the outcome of the client's txn origTxnId: either
+// clientOutcome, or err if the txn could not be run. It does nothing
+// if al is nil.
+func (al *AuditLog) Completed(fingerprint string, connNumber uint32, origTxnId *common.TxnId, clientOutcome *cmsgs.ClientTxnOutcome, err error) {
+	if al == nil {
+		return
+	}
+	record := &AuditRecord{
+		Fingerprint: fingerprint,
+		Connection:  connNumber,
+		TxnId:       hex.EncodeToString(origTxnId[:]),
+	}
+	switch {
+	case err != nil:
+		record.Event = AuditFailed
+		record.Error = err.Error()
+	case clientOutcome.Which() == cmsgs.CLIENTTXNOUTCOME_COMMIT:
+		record.Event = AuditCommitted
+		record.FinalTxnId = hex.EncodeToString(clientOutcome.FinalId())
+	case clientOutcome.Which() == cmsgs.CLIENTTXNOUTCOME_ABORT:
+		record.Event = AuditAborted
+		record.FinalTxnId = hex.EncodeToString(clientOutcome.FinalId())
+	default:
+		record.Event = AuditFailed
+		record.Error = clientOutcome.Error()
+	}
+	al.write(record)
+}
+
+func (al *AuditLog) write(record *AuditRecord) {
+	record.Time = time.Now()
+	bites, err := json.Marshal(record)
+	if server.CheckWarn(err) {
+		return
+	}
+	bites = append(bites, '\n')
+
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	if al.file == nil {
+		return
+	}
+	if al.size > 0 && al.size+int64(len(bites)) > al.maxSize {
+		server.CheckWarn(al.rotate())
+		if al.file == nil {
+			return
+		}
+	}
+	n, err := al.file.Write(bites)
+	al.size += int64(n)
+	if !server.CheckWarn(err) {
+		server.CheckWarn(al.file.Sync())
+	}
+}
+
+func (al *AuditLog) rotate() error {
+	server.CheckWarn(al.file.Close())
+	al.file = nil
+	// If the rename fails we carry on appending to the same file.
+	rotated := al.path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	renameErr := os.Rename(al.path, rotated)
+	if err := al.open(); err != nil {
+		return err
+	}
+	return renameErr
+}
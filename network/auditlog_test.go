@@ -0,0 +1,194 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const auditFingerprint = "0123456789abcdef"
+
+func newAuditLogTest(t *testing.T, maxSize int64, includeValues bool) (*AuditLog, string) {
+	dir, err := ioutil.TempDir("", "auditlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "audit.log")
+	al, err := NewAuditLog(path, maxSize, includeValues)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return al, path
+}
+
+func auditTxn(txnNumber byte, value []byte) *cmsgs.ClientTxn {
+	seg := capn.NewBuffer(nil)
+	ctxn := cmsgs.NewClientTxn(seg)
+	id := make([]byte, common.KeyLen)
+	id[0] = txnNumber
+	ctxn.SetId(id)
+	ctxn.SetRetry(false)
+	actions := cmsgs.NewClientActionList(seg, 2)
+	ctxn.SetActions(actions)
+	action := actions.At(0)
+	action.SetVarId(bytes.Repeat([]byte{1}, common.KeyLen))
+	action.SetRead()
+	action.Read().SetVersion(common.VersionZero[:])
+	action = actions.At(1)
+	action.SetVarId(bytes.Repeat([]byte{2}, common.KeyLen))
+	action.SetWrite()
+	write := action.Write()
+	write.SetValue(value)
+	write.SetReferences(seg.NewDataList(0))
+	return &ctxn
+}
+
+func auditCommit(ctxn *cmsgs.ClientTxn, finalId []byte) *cmsgs.ClientTxnOutcome {
+	seg := capn.NewBuffer(nil)
+	outcome := cmsgs.NewClientTxnOutcome(seg)
+	outcome.SetId(ctxn.Id())
+	outcome.SetFinalId(finalId)
+	outcome.SetCommit()
+	return &outcome
+}
+
+func readAuditRecords(t *testing.T, path string) []*AuditRecord {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records := []*AuditRecord{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := &AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestAuditLogSubmittedCompleted(t *testing.T) {
+	al, path := newAuditLogTest(t, 1<<20, false)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	committed := auditTxn(1, []byte("secret"))
+	finalId := make([]byte, common.KeyLen)
+	finalId[0] = 2
+	failed := auditTxn(3, []byte("secret"))
+	al.Submitted(auditFingerprint, 7, committed)
+	al.Submitted(auditFingerprint, 7, failed)
+	al.Completed(auditFingerprint, 7, common.MakeTxnId(failed.Id()), nil, errors.New("Boom"))
+	al.Completed(auditFingerprint, 7, common.MakeTxnId(committed.Id()), auditCommit(committed, finalId), nil)
+	if err := al.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readAuditRecords(t, path)
+	if len(records) != 4 {
+		t.Fatalf("Found %v records; expected 4", len(records))
+	}
+	for _, record := range records {
+		if record.Fingerprint != auditFingerprint || record.Connection != 7 {
+			t.Fatalf("Record %v has fingerprint %v and connection %v; expected %v and 7", record.Event, record.Fingerprint, record.Connection, auditFingerprint)
+		}
+	}
+
+	// Each Completed record pairs with its Submitted record by TxnId.
+	submitted := make(map[string]*AuditRecord)
+	for _, record := range records[:2] {
+		if record.Event != AuditSubmitted {
+			t.Fatalf("Found %v record; expected %v", record.Event, AuditSubmitted)
+		} else if len(record.Actions) != 2 || record.Actions[0].Action != "Read" || record.Actions[1].Action != "Write" {
+			t.Fatalf("Submitted %v has actions %v; expected Read and Write", record.TxnId, record.Actions)
+		} else if record.Actions[1].VarId != hex.EncodeToString(bytes.Repeat([]byte{2}, common.KeyLen)) {
+			t.Fatalf("Submitted %v writes %v", record.TxnId, record.Actions[1].VarId)
+		}
+		for _, action := range record.Actions {
+			if action.Value != nil {
+				t.Fatalf("Submitted %v recorded value %q without includeValues", record.TxnId, action.Value)
+			}
+		}
+		submitted[record.TxnId] = record
+	}
+	failedRecord, committedRecord := records[2], records[3]
+	if failedRecord.Event != AuditFailed || failedRecord.Error != "Boom" || failedRecord.TxnId != hex.EncodeToString(failed.Id()) {
+		t.Fatalf("Found %v record for %v with error %q; expected %v for %v with error Boom", failedRecord.Event, failedRecord.TxnId, failedRecord.Error, AuditFailed, hex.EncodeToString(failed.Id()))
+	}
+	if committedRecord.Event != AuditCommitted || committedRecord.TxnId != hex.EncodeToString(committed.Id()) || committedRecord.FinalTxnId != hex.EncodeToString(finalId) {
+		t.Fatalf("Found %v record for %v, final %v; expected %v for %v, final %v", committedRecord.Event, committedRecord.TxnId, committedRecord.FinalTxnId, AuditCommitted, hex.EncodeToString(committed.Id()), hex.EncodeToString(finalId))
+	}
+	for _, record := range records[2:] {
+		if _, found := submitted[record.TxnId]; !found {
+			t.Fatalf("%v record for %v has no Submitted record", record.Event, record.TxnId)
+		}
+	}
+}
+
+func TestAuditLogIncludeValues(t *testing.T) {
+	al, path := newAuditLogTest(t, 1<<20, true)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	al.Submitted(auditFingerprint, 1, auditTxn(1, []byte("secret")))
+	if err := al.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readAuditRecords(t, path)
+	if len(records) != 1 {
+		t.Fatalf("Found %v records; expected 1", len(records))
+	}
+	actions := records[0].Actions
+	if actions[0].Value != nil {
+		t.Fatalf("Read recorded value %q", actions[0].Value)
+	} else if !bytes.Equal(actions[1].Value, []byte("secret")) {
+		t.Fatalf("Write recorded value %q; expected %q", actions[1].Value, "secret")
+	}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	// Every record is bigger than this, so each record but the first
+	// goes into a new file.
+	al, path := newAuditLogTest(t, 64, false)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	for idx := byte(0); idx < 3; idx++ {
+		al.Submitted(auditFingerprint, 1, auditTxn(idx, nil))
+	}
+	if err := al.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rotated) != 2 {
+		t.Fatalf("Found %v rotated files; expected 2", len(rotated))
+	}
+	// Glob sorts, and the rotated files are named by time, so this
+	// is the order in which they were written.
+	paths := append(rotated, path)
+	for idx, p := range paths {
+		records := readAuditRecords(t, p)
+		if len(records) != 1 {
+			t.Fatalf("Found %v records in %v; expected 1", len(records), p)
+		} else if expected := hex.EncodeToString(auditTxn(byte(idx), nil).Id()); records[0].TxnId != expected {
+			t.Fatalf("Found %v in %v; expected %v", records[0].TxnId, p, expected)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package network
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"goshawkdb.io/common/certs"
+	"goshawkdb.io/server"
+	"log"
+	"sort"
+)
+
+// The cluster certificate is rotated in two phases. First, every node
+// is told to trust the new cluster certificate in addition to the
+// old. Then each node is switched to present a node certificate
+// signed by the new cluster certificate: as every node already trusts
+// both, connections can be (re)established throughout. Once every
+// node has switched, the old cluster certificate can be untrusted.
+
+// TLSConfig returns the TLS configuration for connections to peers
+// and clients: the current node certificate is presented, and peers
+// presenting node certificates signed by any trusted cluster
+// certificate are accepted.
+func (cm *ConnectionManager) TLSConfig() *tls.Config {
+	cm.RLock()
+	defer cm.RUnlock()
+	roots := x509.NewCertPool()
+	for _, cert := range cm.trustedClusterCerts {
+		roots.AddCert(cert)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{
+			tls.Certificate{
+				Certificate: [][]byte{cm.nodeCertPrivKeyPair.Certificate},
+				PrivateKey:  cm.nodeCertPrivKeyPair.PrivateKey,
+			},
+		},
+		CipherSuites:             []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: true,
+		ClientCAs:                roots,
+		RootCAs:                  roots,
+	}
+}
+
+// TrustClusterCertificate accepts peers with node certificates signed
+// by cert, in addition to those already trusted.
+func (cm *ConnectionManager) TrustClusterCertificate(cert *x509.Certificate) {
+	fingerprint := sha256.Sum256(cert.Raw)
+	cm.Lock()
+	cm.trustedClusterCerts[fingerprint] = cert
+	cm.Unlock()
+	log.Printf("Trusting cluster certificate %v", hex.EncodeToString(fingerprint[:]))
+}
+
+// UntrustClusterCertificate stops accepting peers with node
+// certificates signed by the cluster certificate with the given
+// fingerprint. Existing connections are unaffected. The cluster
+// certificate of the node certificate currently presented can not be
+// untrusted.
+func (cm *ConnectionManager) UntrustClusterCertificate(fingerprint [sha256.Size]byte) error {
+	cm.Lock()
+	defer cm.Unlock()
+	if fingerprint == sha256.Sum256(cm.nodeCertPrivKeyPair.CertificateRoot.Raw) {
+		return errors.New("Cannot untrust the cluster certificate of the current node certificate")
+	} else if _, found := cm.trustedClusterCerts[fingerprint]; !found {
+		return errors.New("Cluster certificate is not trusted")
+	}
+	delete(cm.trustedClusterCerts, fingerprint)
+	log.Printf("Untrusting cluster certificate %v", hex.EncodeToString(fingerprint[:]))
+	return nil
+}
+
+// SetNodeCertificatePrivateKeyPair changes the node certificate
+// presented by this node, and trusts the cluster certificate which
+// signed it. This must only be done once every node trusts that
+// cluster certificate. Existing connections are unaffected.
+func (cm *ConnectionManager) SetNodeCertificatePrivateKeyPair(nodeCertPrivKeyPair *certs.NodeCertificatePrivateKeyPair) {
+	root := nodeCertPrivKeyPair.CertificateRoot
+	fingerprint := sha256.Sum256(root.Raw)
+	cm.Lock()
+	cm.nodeCertPrivKeyPair = nodeCertPrivKeyPair
+	cm.trustedClusterCerts[fingerprint] = root
+	cm.Unlock()
+	log.Printf("Presenting node certificate signed by cluster certificate %v", hex.EncodeToString(fingerprint[:]))
+}
+
+// ClusterCertificates returns the fingerprint of the cluster
+// certificate of the node certificate currently presented, and the
+// fingerprints of all the trusted cluster certificates.
+func (cm *ConnectionManager) ClusterCertificates() (string, []string) {
+	cm.RLock()
+	defer cm.RUnlock()
+	presented := sha256.Sum256(cm.nodeCertPrivKeyPair.CertificateRoot.Raw)
+	trusted := make([]string, 0, len(cm.trustedClusterCerts))
+	for fingerprint := range cm.trustedClusterCerts {
+		trusted = append(trusted, hex.EncodeToString(fingerprint[:]))
+	}
+	sort.Strings(trusted)
+	return hex.EncodeToString(presented[:]), trusted
+}
+
+// RevokeClientCertificates prevents clients presenting certificates
+// with any of the given fingerprints from connecting to this node,
+// regardless of the topology, and closes any such connections which
+// are established. Revocation is local to this node.
+func (cm *ConnectionManager) RevokeClientCertificates(fingerprints ...[sha256.Size]byte) {
+	cm.Lock()
+	for _, fingerprint := range fingerprints {
+		cm.revokedClientCerts[fingerprint] = server.EmptyStructVal
+	}
+	conns := make([]*Connection, 0, len(cm.connCountToClient))
+	for _, conn := range cm.connCountToClient {
+		if c, ok := conn.(*Connection); ok {
+			conns = append(conns, c)
+		}
+	}
+	cm.Unlock()
+	for _, fingerprint := range fingerprints {
+		log.Printf("Revoked client certificate %v", hex.EncodeToString(fingerprint[:]))
+	}
+	for _, conn := range conns {
+		conn.ClientCertificatesRevoked()
+	}
+}
+
+// UnrevokeClientCertificates removes the given fingerprints from the
+// revocation list. The topology must still know the fingerprints for
+// clients to be able to connect with them.
+func (cm *ConnectionManager) UnrevokeClientCertificates(fingerprints ...[sha256.Size]byte) {
+	cm.Lock()
+	for _, fingerprint := range fingerprints {
+		delete(cm.revokedClientCerts, fingerprint)
+	}
+	cm.Unlock()
+	for _, fingerprint := range fingerprints {
+		log.Printf("Unrevoked client certificate %v", hex.EncodeToString(fingerprint[:]))
+	}
+}
+
+// RevokedClientCertificates returns the fingerprints of the revoked
+// client certificates, sorted.
+func (cm *ConnectionManager) RevokedClientCertificates() []string {
+	cm.RLock()
+	defer cm.RUnlock()
+	revoked := make([]string, 0, len(cm.revokedClientCerts))
+	for fingerprint := range cm.revokedClientCerts {
+		revoked = append(revoked, hex.EncodeToString(fingerprint[:]))
+	}
+	sort.Strings(revoked)
+	return revoked
+}
+
+func (cm *ConnectionManager) isClientCertificateRevoked(fingerprint [sha256.Size]byte) bool {
+	cm.RLock()
+	defer cm.RUnlock()
+	_, found := cm.revokedClientCerts[fingerprint]
+	return found
+}
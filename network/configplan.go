@@ -0,0 +1,257 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	ch "goshawkdb.io/server/consistenthash"
+	"goshawkdb.io/server/db"
+	"time"
+)
+
+// ConfigurationPlan describes the target topology which the
+// TopologyTransmogrifier would calculate in order to change from the
+// active topology to a new configuration. Added hosts whose RMIds are
+// not yet known are given placeholder RMIds, listed in UnknownRMIds.
+type ConfigurationPlan struct {
+	ClusterId      string
+	FromVersion    uint32
+	ToVersion      uint32
+	Hosts          []string
+	RMs            common.RMIds
+	NewRMIds       common.RMIds
+	SurvivingRMIds common.RMIds
+	LostRMIds      common.RMIds
+	UnknownRMIds   map[common.RMId]string `json:",omitempty"`
+	Pending        map[common.RMId]*PlannedCondition
+	Vars           *PlannedVarMoves `json:",omitempty"`
+	active         *configuration.Topology
+	next           *configuration.NextConfiguration
+}
+
+// PlannedCondition is the condition a var must satisfy for the RM to
+// receive it during migration, and the RMs which will supply such
+// vars.
+type PlannedCondition struct {
+	Condition string
+	Suppliers common.RMIds
+}
+
+// PlannedVarMoves estimates migration from the vars held in one
+// store. Held is the number of vars in the store, and Moving the
+// number of those whose RMs change. Gained and Lost count, for each
+// RM, the held vars it would gain and lose.
+type PlannedVarMoves struct {
+	Held   int
+	Moving int
+	Gained map[common.RMId]int
+	Lost   map[common.RMId]int
+}
+
+// PlanConfigurationChange calculates the change from active to config
+// without changing anything. rmIdOf should return the current RMId of
+// a host if it is known.
+func PlanConfigurationChange(active *configuration.Topology, config *configuration.Configuration, localHost string, rmIdOf func(host string) (common.RMId, bool)) (*ConfigurationPlan, error) {
+	switch {
+	case active.IsBlank():
+		return nil, errors.New("No active topology: the cluster has not yet formed.")
+	case active.ClusterId != config.ClusterId:
+		return nil, fmt.Errorf("ClusterId would change from '%s' to '%s', which is illegal.", active.ClusterId, config.ClusterId)
+	case config.Version <= active.Version:
+		return nil, fmt.Errorf("Version %v would be ignored: the active topology is at version %v.", config.Version, active.Version)
+	case active.Next() != nil:
+		return nil, fmt.Errorf("A topology change to version %v is already in progress.", active.Next().Version)
+	case len(active.RootsRemoved(config)) != 0:
+		return nil, fmt.Errorf("Roots %v would be removed, which is illegal: roots can not be removed or renamed.", active.RootsRemoved(config))
+	}
+
+	change := newTopologyChange(active, config, localHost)
+
+	used := make(map[common.RMId]server.EmptyStruct)
+	for _, rmId := range active.RMs() {
+		used[rmId] = server.EmptyStructVal
+	}
+	for rmId := range active.RMsRemoved() {
+		used[rmId] = server.EmptyStructVal
+	}
+	for _, host := range config.Hosts {
+		if rmId, found := rmIdOf(host); found {
+			used[rmId] = server.EmptyStructVal
+		}
+	}
+	unknown := make(map[string]common.RMId)
+	placeholder := common.RMId(^uint32(0))
+	for _, host := range config.Hosts {
+		if _, found := change.hostsAdded[host]; !found {
+			continue
+		} else if _, found = rmIdOf(host); found {
+			continue
+		}
+		for _, found := used[placeholder]; found; _, found = used[placeholder] {
+			placeholder--
+		}
+		unknown[host] = placeholder
+		placeholder--
+	}
+
+	next := change.next(func(host string) (common.RMId, bool) {
+		if rmId, found := unknown[host]; found {
+			return rmId, true
+		}
+		return rmIdOf(host)
+	})
+
+	plan := &ConfigurationPlan{
+		ClusterId:      config.ClusterId,
+		FromVersion:    active.Version,
+		ToVersion:      config.Version,
+		Hosts:          next.Hosts,
+		RMs:            next.RMs(),
+		NewRMIds:       next.NewRMIds,
+		SurvivingRMIds: next.SurvivingRMIds,
+		LostRMIds:      next.LostRMIds,
+		Pending:        make(map[common.RMId]*PlannedCondition, len(next.Pending)),
+		active:         active,
+		next:           next,
+	}
+	if len(unknown) != 0 {
+		plan.UnknownRMIds = make(map[common.RMId]string, len(unknown))
+		for host, rmId := range unknown {
+			plan.UnknownRMIds[rmId] = host
+		}
+	}
+	for rmId, cs := range next.Pending {
+		plan.Pending[rmId] = &PlannedCondition{
+			Condition: cs.Cond.String(),
+			Suppliers: cs.Suppliers,
+		}
+	}
+	return plan, nil
+}
+
+// CountVarMoves sets plan.Vars from the vars held in disk.
+func (plan *ConfigurationPlan) CountVarMoves(disk *db.Databases) error {
+	active, next := plan.active, plan.next
+	resolverOld := ch.NewResolver(active.RMs(), active.TwoFInc, active.RMZones(), active.RMWeights())
+	resolverNew := ch.NewResolver(next.RMs(), (2*uint16(next.F))+1, next.RMZones(), next.RMWeights())
+	moves := &PlannedVarMoves{
+		Gained: make(map[common.RMId]int),
+		Lost:   make(map[common.RMId]int),
+	}
+
+	_, err := disk.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		rtxn.WithCursor(disk.Vars, func(cursor *mdbs.Cursor) interface{} {
+			key, value, err := cursor.Get(nil, nil, mdb.FIRST)
+			for ; err == nil; key, value, err = cursor.Get(nil, nil, mdb.NEXT) {
+				if bytes.Equal(key, configuration.TopologyVarUUId[:]) {
+					continue
+				}
+				seg, _, err := capn.ReadFromMemoryZeroCopy(value)
+				if err != nil {
+					cursor.Error(err)
+					return nil
+				}
+				positions := msgs.ReadRootVar(seg).Positions()
+				if positions.Len() == 0 {
+					continue
+				}
+				rmIdsOld, err := resolverOld.ResolveHashCodes(positions.ToArray())
+				if err != nil {
+					cursor.Error(err)
+					return nil
+				}
+				rmIdsNew, err := resolverNew.ResolveHashCodes(positions.ToArray())
+				if err != nil {
+					cursor.Error(err)
+					return nil
+				}
+				moves.Held++
+				if moves.add(rmIdsOld, rmIdsNew) {
+					moves.Moving++
+				}
+			}
+			if err != nil && err != mdb.NotFound {
+				cursor.Error(err)
+			}
+			return nil
+		})
+		return nil
+	}).ResultError()
+	if err != nil {
+		return err
+	}
+	plan.Vars = moves
+	return nil
+}
+
+// add returns true if the var moves at all.
+func (moves *PlannedVarMoves) add(rmIdsOld, rmIdsNew common.RMIds) bool {
+	old := make(map[common.RMId]bool, len(rmIdsOld))
+	for _, rmId := range rmIdsOld {
+		old[rmId] = true
+	}
+	moved := false
+	for _, rmId := range rmIdsNew {
+		if old[rmId] {
+			delete(old, rmId)
+		} else {
+			moves.Gained[rmId]++
+			moved = true
+		}
+	}
+	for rmId := range old {
+		moves.Lost[rmId]++
+		moved = true
+	}
+	return moved
+}
+
+// PlanConfigurationChange calculates, but does not make, the change
+// from the active topology to config, using the RMIds of currently
+// connected hosts. The vars moves are estimated from the local store.
+func (tt *TopologyTransmogrifier) PlanConfigurationChange(config *configuration.Configuration) (*ConfigurationPlan, error) {
+	type planResult struct {
+		plan *ConfigurationPlan
+		err  error
+	}
+	resultChan := make(chan planResult, 1)
+	enqueued := tt.enqueueQuery(topologyTransmogrifierMsgExe(func() error {
+		if tt.active == nil {
+			resultChan <- planResult{err: errors.New("No active topology: the cluster has not yet formed.")}
+			return nil
+		}
+		localHost, _, err := tt.active.LocalRemoteHosts(tt.listenPort)
+		if err != nil {
+			resultChan <- planResult{err: err}
+			return nil
+		}
+		plan, err := PlanConfigurationChange(tt.active, config, localHost, func(host string) (common.RMId, bool) {
+			if cd, found := tt.hostToConnection[host]; found {
+				return cd.RMId(), true
+			}
+			return common.RMIdEmpty, false
+		})
+		resultChan <- planResult{plan: plan, err: err}
+		return nil
+	}))
+	if !enqueued {
+		return nil, errors.New("Topology: shutting down.")
+	}
+
+	select {
+	case result := <-resultChan:
+		if result.err != nil {
+			return nil, result.err
+		}
+		return result.plan, result.plan.CountVarMoves(tt.db)
+	case <-time.After(server.AdminStatusTimeout):
+		return nil, errors.New("Topology: timed out calculating plan.")
+	}
+}
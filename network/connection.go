@@ -69,6 +69,12 @@ type connectionMsgOutcomeReceived struct {
 	outcome *msgs.Outcome
 }
 
+type connectionMsgClientTxnDeadline struct {
+	connectionMsgBasic
+	submitter *client.ClientTxnSubmitter
+	txnId     *common.TxnId
+}
+
 type connectionMsgTopologyChanged struct {
 	connectionMsgBasic
 	topology   *configuration.Topology
@@ -79,11 +85,20 @@ func (cmtc *connectionMsgTopologyChanged) Done() {
 	close(cmtc.resultChan)
 }
 
+type connectionMsgClientCertificatesRevoked struct{ connectionMsgBasic }
+
 type connectionMsgStatus struct {
 	connectionMsgBasic
 	*server.StatusConsumer
 }
 
+type connectionMsgFaultDelayed struct {
+	connectionMsgBasic
+	msg    []byte
+	copies int
+	epoch  uint32
+}
+
 func (conn *Connection) Shutdown(sync paxos.Blocking) {
 	if conn.enqueueQuery(connectionMsgShutdown{}) && sync == paxos.Sync {
 		conn.cellTail.Wait()
@@ -120,6 +135,12 @@ func (conn *Connection) TopologyChanged(topology *configuration.Topology, done f
 	}
 }
 
+// ClientCertificatesRevoked closes the connection if it is from a
+// client whose certificate has been revoked.
+func (conn *Connection) ClientCertificatesRevoked() {
+	conn.enqueueQuery(connectionMsgClientCertificatesRevoked{})
+}
+
 func (conn *Connection) Status(sc *server.StatusConsumer) {
 	conn.enqueueQuery(connectionMsgStatus{StatusConsumer: sc})
 }
@@ -258,12 +279,18 @@ func (conn *Connection) handleMsg(msg connectionMsg) (terminate bool, err error)
 		err = conn.sendMessage(msgT)
 	case connectionMsgOutcomeReceived:
 		conn.outcomeReceived(msgT)
+	case connectionMsgClientTxnDeadline:
+		conn.clientTxnDeadline(msgT)
 	case *connectionMsgTopologyChanged:
 		err = conn.topologyChanged(msgT)
 	case connectionMsgServerConnectionsChanged:
 		conn.serverConnectionsChanged(msgT)
+	case connectionMsgClientCertificatesRevoked:
+		err = conn.clientCertificatesRevoked()
 	case connectionMsgStatus:
 		conn.status(msgT.StatusConsumer)
+	case connectionMsgFaultDelayed:
+		err = conn.faultDelayed(msgT)
 	default:
 		err = fmt.Errorf("Fatal to Connection: Received unexpected message: %#v", msgT)
 	}
@@ -316,9 +343,9 @@ func (conn *Connection) nextState(requestedState connectionStateMachineComponent
 
 func (conn *Connection) status(sc *server.StatusConsumer) {
 	sc.Emit(fmt.Sprintf("Connection to %v (%v, %v)", conn.remoteHost, conn.remoteRMId, conn.remoteBootCount))
-	sc.Emit(fmt.Sprintf("- Current State: %v", conn.currentState))
-	sc.Emit(fmt.Sprintf("- IsServer? %v", conn.isServer))
-	sc.Emit(fmt.Sprintf("- IsClient? %v", conn.isClient))
+	sc.EmitKV("Current State", conn.currentState)
+	sc.EmitKV("IsServer", conn.isServer)
+	sc.EmitKV("IsClient", conn.isClient)
 	if conn.submitter != nil {
 		conn.submitter.Status(sc.Fork())
 	}
@@ -346,6 +373,8 @@ func (cd *connectionDelay) start() (bool, error) {
 	cd.isServer = false
 	cd.isClient = false
 	cd.peerCerts = nil
+	cd.access = nil
+	cd.fingerprint = ""
 	if cd.delay == nil {
 		delay := server.ConnectionRestartDelayMin + time.Duration(cd.rng.Intn(server.ConnectionRestartDelayRangeMS))*time.Millisecond
 		cd.delay = time.AfterFunc(delay, func() {
@@ -399,9 +428,10 @@ func (cc *connectionDial) start() (bool, error) {
 
 type connectionAwaitHandshake struct {
 	*Connection
-	isServer bool
-	isClient bool
-	topology *configuration.Topology
+	isServer  bool
+	isClient  bool
+	bytesSent *server.Counter
+	topology  *configuration.Topology
 }
 
 func (cah *connectionAwaitHandshake) connectionStateMachineComponentWitness() {}
@@ -449,7 +479,10 @@ func (cah *connectionAwaitHandshake) makeHello() *capn.Segment {
 }
 
 func (cah *connectionAwaitHandshake) send(msg []byte) error {
-	_, err := cah.socket.Write(msg)
+	n, err := cah.socket.Write(msg)
+	if cah.bytesSent != nil {
+		cah.bytesSent.Add(uint64(n))
+	}
 	return err
 }
 
@@ -473,26 +506,6 @@ func (cah *connectionAwaitHandshake) maybeRestartConnection(err error) (bool, er
 	}
 }
 
-func (cah *connectionAwaitHandshake) commonTLSConfig() *tls.Config {
-	nodeCertPrivKeyPair := cah.connectionManager.NodeCertificatePrivateKeyPair
-	roots := x509.NewCertPool()
-	roots.AddCert(nodeCertPrivKeyPair.CertificateRoot)
-
-	return &tls.Config{
-		Certificates: []tls.Certificate{
-			tls.Certificate{
-				Certificate: [][]byte{nodeCertPrivKeyPair.Certificate},
-				PrivateKey:  nodeCertPrivKeyPair.PrivateKey,
-			},
-		},
-		CipherSuites:             []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-		MinVersion:               tls.VersionTLS12,
-		PreferServerCipherSuites: true,
-		ClientCAs:                roots,
-		RootCAs:                  roots,
-	}
-}
-
 // Await Server Handshake
 
 type connectionAwaitServerHandshake struct {
@@ -510,7 +523,7 @@ func (cash *connectionAwaitServerHandshake) start() (bool, error) {
 	// TLS seems to require us to pick one end as the client and one
 	// end as the server even though in a server-server connection we
 	// really don't care which is which.
-	config := cash.commonTLSConfig()
+	config := cash.connectionManager.TLSConfig()
 	if cash.remoteHost == "" {
 		// We came from the listener, so we're going to act as the server.
 		config.ClientAuth = tls.RequireAndVerifyClientCert
@@ -606,7 +619,9 @@ func (cash *connectionAwaitServerHandshake) makeHelloServerFromServer(topology *
 
 type connectionAwaitClientHandshake struct {
 	*Connection
-	peerCerts []*x509.Certificate
+	peerCerts   []*x509.Certificate
+	access      *configuration.ClientAccess
+	fingerprint string
 }
 
 func (cach *connectionAwaitClientHandshake) connectionStateMachineComponentWitness() {}
@@ -617,7 +632,7 @@ func (cach *connectionAwaitClientHandshake) init(conn *Connection) {
 }
 
 func (cach *connectionAwaitClientHandshake) start() (bool, error) {
-	config := cach.commonTLSConfig()
+	config := cach.connectionManager.TLSConfig()
 	config.ClientAuth = tls.RequireAnyClientCert
 	socket := tls.Server(cach.socket, config)
 	cach.socket = socket
@@ -630,12 +645,17 @@ func (cach *connectionAwaitClientHandshake) start() (bool, error) {
 	}
 
 	peerCerts := socket.ConnectionState().PeerCertificates
-	if authenticated, hashsum := cach.verifyPeerCerts(cach.topology, peerCerts); authenticated {
+	if access, hashsum := cach.verifyPeerCerts(cach.topology, peerCerts); access != nil {
 		cach.peerCerts = peerCerts
-		log.Printf("User '%s' authenticated", hex.EncodeToString(hashsum[:]))
+		cach.access = access
+		cach.fingerprint = hex.EncodeToString(hashsum[:])
+		log.Printf("User '%s' authenticated (%v)", cach.fingerprint, access)
 	} else {
 		return false, errors.New("Client connection rejected: No client certificate known")
 	}
+	if cach.topology.ClientRoot(cach.access) == nil {
+		return false, errors.New("Client connection rejected: No permitted root known")
+	}
 
 	helloFromServer := cach.makeHelloClientFromServer(cach.topology)
 	if err := cach.send(server.SegToBytes(helloFromServer)); err != nil {
@@ -646,15 +666,20 @@ func (cach *connectionAwaitClientHandshake) start() (bool, error) {
 	return false, nil
 }
 
-func (cach *connectionAwaitClientHandshake) verifyPeerCerts(topology *configuration.Topology, peerCerts []*x509.Certificate) (authenticated bool, hashsum [sha256.Size]byte) {
+// verifyPeerCerts returns the rights of the first of the peerCerts
+// which the topology knows, or nil if it knows none of them or any of
+// them has been revoked.
+func (cach *connectionAwaitClientHandshake) verifyPeerCerts(topology *configuration.Topology, peerCerts []*x509.Certificate) (access *configuration.ClientAccess, hashsum [sha256.Size]byte) {
 	fingerprints := topology.Fingerprints()
 	for _, cert := range peerCerts {
 		hashsum = sha256.Sum256(cert.Raw)
-		if _, found := fingerprints[hashsum]; found {
-			return true, hashsum
+		if cach.connectionManager.isClientCertificateRevoked(hashsum) {
+			return nil, hashsum
+		} else if access, found := fingerprints[hashsum]; found {
+			return access, hashsum
 		}
 	}
-	return false, hashsum
+	return nil, hashsum
 }
 
 func (cach *connectionAwaitClientHandshake) makeHelloClientFromServer(topology *configuration.Topology) *capn.Segment {
@@ -665,8 +690,8 @@ func (cach *connectionAwaitClientHandshake) makeHelloClientFromServer(topology *
 	binary.BigEndian.PutUint32(namespace[4:8], cach.connectionManager.BootCount)
 	binary.BigEndian.PutUint32(namespace[8:], uint32(cach.connectionManager.RMId))
 	hello.SetNamespace(namespace)
-	if topology.Root.VarUUId != nil {
-		hello.SetRootId(topology.Root.VarUUId[:])
+	if root := topology.ClientRoot(cach.access); root != nil {
+		hello.SetRootId(root.VarUUId[:])
 	}
 	return seg
 }
@@ -682,6 +707,9 @@ type connectionRun struct {
 	beatBytes     []byte
 	restart       bool
 	submitterIdle *connectionMsgTopologyChanged
+	faults        *faultLink
+	faultHeld     []byte
+	faultEpoch    uint32
 }
 
 func (cr *connectionRun) connectionStateMachineComponentWitness() {}
@@ -719,12 +747,26 @@ func (cr *connectionRun) start() (bool, error) {
 	}
 	cr.beatBytes = server.SegToBytes(seg)
 
+	if cr.isClient {
+		cr.bytesSent = server.Metrics.Counter("goshawkdb_connection_bytes_sent_total", "Bytes sent on connections.", "peer", "client", "remote", "")
+	} else {
+		cr.bytesSent = server.Metrics.Counter("goshawkdb_connection_bytes_sent_total", "Bytes sent on connections.", "peer", "server", "remote", cr.remoteHost)
+	}
+
+	// Delayed messages from earlier runs are lost with the old socket.
+	cr.faultEpoch++
+	cr.faultHeld = nil
 	if cr.isServer {
+		cr.faults = cr.connectionManager.Faults.link(cr.remoteRMId)
 		cr.connectionManager.ServerEstablished(cr.Connection, cr.remoteHost, cr.remoteRMId, cr.remoteBootCount, cr.combinedTieBreak, cr.remoteRootId)
 	}
 	if cr.isClient {
 		servers := cr.connectionManager.ClientEstablished(cr.ConnectionNumber, cr.Connection)
-		cr.submitter = client.NewClientTxnSubmitter(cr.connectionManager.RMId, cr.connectionManager.BootCount, cr.connectionManager)
+		// A revocation between the handshake and now would have missed us.
+		if cr.topology != nil && cr.isClientCertificateRevoked() {
+			return false, errors.New("Client connection closed: Client certificate revoked")
+		}
+		cr.submitter = client.NewClientTxnSubmitter(cr.connectionManager.RMId, cr.connectionManager.BootCount, cr.connectionManager, cr.access)
 		cr.submitter.TopologyChanged(cr.topology)
 		cr.submitter.ServerConnectionsChanged(servers)
 	}
@@ -759,11 +801,15 @@ func (cr *connectionRun) topologyChanged(tc *connectionMsgTopologyChanged) error
 	}
 	if cr.isClient {
 		if topology != nil {
-			if authenticated, _ := cr.verifyPeerCerts(topology, cr.peerCerts); !authenticated {
+			access, hashsum := cr.verifyPeerCerts(topology, cr.peerCerts)
+			if access == nil {
 				server.Log("Connection", cr.Connection, "topologyChanged", tc, "(client unauthed)")
 				tc.Done()
 				return errors.New("Client connection closed: No client certificate known")
 			}
+			cr.access = access
+			cr.fingerprint = hex.EncodeToString(hashsum[:])
+			cr.submitter.SetAccess(access)
 		}
 		cr.submitter.TopologyChanged(topology)
 		if cr.submitter.IsIdle() {
@@ -786,6 +832,21 @@ func (cr *connectionRun) topologyChanged(tc *connectionMsgTopologyChanged) error
 	return nil
 }
 
+func (cr *connectionRun) clientCertificatesRevoked() error {
+	if cr.currentState != cr || !cr.isClient || cr.topology == nil {
+		return nil
+	}
+	if cr.isClientCertificateRevoked() {
+		return errors.New("Client connection closed: Client certificate revoked")
+	}
+	return nil
+}
+
+func (cr *connectionRun) isClientCertificateRevoked() bool {
+	access, _ := cr.verifyPeerCerts(cr.topology, cr.peerCerts)
+	return access == nil
+}
+
 func (cr *connectionRun) serverConnectionsChanged(servers map[common.RMId]paxos.Connection) {
 	if cr.submitter != nil {
 		cr.submitter.ServerConnectionsChanged(servers)
@@ -803,30 +864,123 @@ func (cr *connectionRun) handleMsgFromClient(msg *cmsgs.ClientMessage) error {
 		// do nothing
 	case cmsgs.CLIENTMESSAGE_CLIENTTXNSUBMISSION:
 		ctxn := msg.ClientTxnSubmission()
-		origTxnId := common.MakeTxnId(ctxn.Id())
-		cr.submitter.SubmitClientTransaction(&ctxn, func(clientOutcome *cmsgs.ClientTxnOutcome, err error) {
-			switch {
-			case err != nil:
-				cr.clientTxnError(&ctxn, err, origTxnId)
-			case clientOutcome == nil: // shutdown
-				return
-			default:
-				seg := capn.NewBuffer(nil)
-				msg := cmsgs.NewRootClientMessage(seg)
-				msg.SetClientTxnOutcome(*clientOutcome)
-				cr.sendMessage(server.SegToBytes(msg.Segment))
-			}
-		})
+		cr.submitClientTxn(&ctxn, time.Time{})
+	default:
+		return cr.handleExtendedMsgFromClient((*msgs.ClientMessage)(msg))
+	}
+	return nil
+}
+
+// Clients which know of the members of msgs.ClientMessage beyond
+// those of cmsgs.ClientMessage may send them.
+func (cr *connectionRun) handleExtendedMsgFromClient(msg *msgs.ClientMessage) error {
+	switch which := msg.Which(); which {
+	case msgs.CLIENTMESSAGE_WATCH:
+		watch := msg.Watch()
+		watchId := common.MakeTxnId(watch.Id())
+		varIds := watch.VarIds()
+		vUUIds := make([]*common.VarUUId, varIds.Len())
+		for idx := range vUUIds {
+			vUUIds[idx] = common.MakeVarUUId(varIds.At(idx))
+		}
+		if err := cr.addWatch(watchId, vUUIds); err != nil {
+			return cr.clientWatchError(watchId, err)
+		}
+	case msgs.CLIENTMESSAGE_UNWATCH:
+		cr.submitter.RemoveWatch(common.MakeTxnId(msg.Unwatch()))
+	case msgs.CLIENTMESSAGE_DEADLINESUBMISSION:
+		submission := msg.DeadlineSubmission()
+		ctxn := submission.Txn()
+		cr.submitClientTxn(&ctxn, time.Now().Add(time.Duration(submission.Timeout())))
+	case msgs.CLIENTMESSAGE_CANCEL:
+		cr.submitter.CancelClientTransaction(common.MakeTxnId(msg.Cancel()), client.TxnCancelled)
 	default:
 		return cr.maybeRestartConnection(fmt.Errorf("Unexpected message type received from client: %v", which))
 	}
 	return nil
 }
 
+// addWatch registers a long-lived watch on behalf of the client. Every
+// change to the vars is pushed to the client as a ClientTxnOutcome
+// with the watch id. Watches are removed when the connection closes.
+func (cr *connectionRun) addWatch(watchId *common.TxnId, vUUIds []*common.VarUUId) error {
+	return cr.submitter.AddWatch(watchId, vUUIds, func(clientOutcome *cmsgs.ClientTxnOutcome, err error) {
+		switch {
+		case err != nil:
+			cr.clientWatchError(watchId, err)
+		case clientOutcome == nil: // shutdown
+			return
+		default:
+			seg := capn.NewBuffer(nil)
+			msg := cmsgs.NewRootClientMessage(seg)
+			msg.SetClientTxnOutcome(*clientOutcome)
+			cr.sendMessage(server.SegToBytes(msg.Segment))
+		}
+	})
+}
+
+// submitClientTxn submits the client txn. If deadline is not zero,
+// then once it passes the txn is cancelled and the client is sent an
+// error outcome of TxnDeadlineExceeded, unless the txn has already
+// completed.
+func (cr *connectionRun) submitClientTxn(ctxn *cmsgs.ClientTxn, deadline time.Time) {
+	cr.connectionManager.auditLog.Submitted(cr.fingerprint, cr.ConnectionNumber, ctxn)
+	origTxnId := common.MakeTxnId(ctxn.Id())
+	consumer := cr.clientTxnConsumer(ctxn, origTxnId)
+	if !deadline.IsZero() {
+		submitter := cr.submitter
+		timer := time.AfterFunc(deadline.Sub(time.Now()), func() {
+			cr.enqueueQuery(connectionMsgClientTxnDeadline{
+				submitter: submitter,
+				txnId:     origTxnId,
+			})
+		})
+		consumerInner := consumer
+		consumer = func(clientOutcome *cmsgs.ClientTxnOutcome, err error) {
+			timer.Stop()
+			consumerInner(clientOutcome, err)
+		}
+	}
+	cr.submitter.SubmitClientTransaction(ctxn, deadline, consumer)
+}
+
+func (cr *connectionRun) clientTxnDeadline(deadline connectionMsgClientTxnDeadline) {
+	// the submitter is replaced if the connection restarts
+	if cr.currentState != cr || cr.submitter != deadline.submitter {
+		return
+	}
+	cr.submitter.CancelClientTransaction(deadline.txnId, client.TxnDeadlineExceeded)
+}
+
+// The client may have several txns in flight at once, and their
+// outcomes can arrive in any order. Each outcome is routed back to
+// the client under the original id of its txn, even though the txn
+// may have been resubmitted under different ids.
+func (cr *connectionRun) clientTxnConsumer(ctxn *cmsgs.ClientTxn, origTxnId *common.TxnId) client.ClientTxnCompletionConsumer {
+	return func(clientOutcome *cmsgs.ClientTxnOutcome, err error) {
+		switch {
+		case err != nil:
+			cr.connectionManager.auditLog.Completed(cr.fingerprint, cr.ConnectionNumber, origTxnId, nil, err)
+			cr.clientTxnError(ctxn, err, origTxnId)
+		case clientOutcome == nil: // shutdown
+			return
+		default:
+			cr.connectionManager.auditLog.Completed(cr.fingerprint, cr.ConnectionNumber, origTxnId, clientOutcome, nil)
+			seg := capn.NewBuffer(nil)
+			msg := cmsgs.NewRootClientMessage(seg)
+			msg.SetClientTxnOutcome(*clientOutcome)
+			cr.sendMessage(server.SegToBytes(msg.Segment))
+		}
+	}
+}
+
 func (cr *connectionRun) handleMsgFromServer(msg *msgs.Message) error {
 	if cr.currentState != cr {
 		// probably just draining the queue from the reader after a restart
 		return nil
+	} else if cr.faults.partitioned() {
+		// heartbeats go missing too, so the connection will restart.
+		return nil
 	}
 	cr.missingBeats = 0
 	switch which := msg.Which(); which {
@@ -859,6 +1013,19 @@ func (cr *connectionRun) clientTxnError(ctxn *cmsgs.ClientTxn, err error, origTx
 	return cr.sendMessage(server.SegToBytes(seg))
 }
 
+// clientWatchError tells the client that the watch has failed, and
+// so has been removed.
+func (cr *connectionRun) clientWatchError(watchId *common.TxnId, err error) error {
+	seg := capn.NewBuffer(nil)
+	msg := cmsgs.NewRootClientMessage(seg)
+	outcome := cmsgs.NewClientTxnOutcome(seg)
+	msg.SetClientTxnOutcome(outcome)
+	outcome.SetId(watchId[:])
+	outcome.SetFinalId(watchId[:])
+	outcome.SetError(err.Error())
+	return cr.sendMessage(server.SegToBytes(seg))
+}
+
 func (cr *connectionRun) serverError(err error) error {
 	seg := capn.NewBuffer(nil)
 	msg := msgs.NewRootMessage(seg)
@@ -895,7 +1062,56 @@ func (cr *connectionRun) maybeRestartConnection(err error) error {
 func (cr *connectionRun) sendMessage(msg []byte) error {
 	if cr.currentState == cr {
 		cr.mustSendBeat = false
-		return cr.maybeRestartConnection(cr.send(msg))
+		return cr.maybeRestartConnection(cr.sendFaulty(msg))
+	}
+	return nil
+}
+
+// sendFaulty sends msg subject to any faults injected on the link to
+// the remote node.
+func (cr *connectionRun) sendFaulty(msg []byte) error {
+	fd := cr.faults.decide()
+	switch {
+	case fd == nil:
+		return cr.sendCopies(msg, 1)
+	case fd.drop:
+		return nil
+	}
+	copies := 1
+	if fd.duplicate {
+		copies = 2
+	}
+	if fd.reorder && cr.faultHeld == nil {
+		// held until after the next message is sent.
+		cr.faultHeld = msg
+		return nil
+	}
+	if fd.delay > 0 {
+		conn, epoch := cr.Connection, cr.faultEpoch
+		time.AfterFunc(fd.delay, func() {
+			conn.enqueueQuery(connectionMsgFaultDelayed{msg: msg, copies: copies, epoch: epoch})
+		})
+		return nil
+	}
+	return cr.sendCopies(msg, copies)
+}
+
+func (cr *connectionRun) sendCopies(msg []byte, copies int) error {
+	for ; copies > 0; copies-- {
+		if err := cr.send(msg); err != nil {
+			return err
+		}
+	}
+	if held := cr.faultHeld; held != nil {
+		cr.faultHeld = nil
+		return cr.send(held)
+	}
+	return nil
+}
+
+func (cr *connectionRun) faultDelayed(fd connectionMsgFaultDelayed) error {
+	if cr.currentState == cr && fd.epoch == cr.faultEpoch {
+		return cr.maybeRestartConnection(cr.sendCopies(fd.msg, fd.copies))
 	}
 	return nil
 }
@@ -908,16 +1124,9 @@ func (cr *connectionRun) beat() error {
 		return cr.maybeRestartConnection(
 			fmt.Errorf("Missed too many connection heartbeats. Restarting connection."))
 	}
-	// Useful for testing recovery from network brownouts
-	/*
-		if cr.rng.Intn(15) == 0 && cr.isServer {
-			return cr.maybeRestartConnection(
-				fmt.Errorf("Random death. Restarting connection."))
-		}
-	*/
 	cr.missingBeats++
 	if cr.mustSendBeat {
-		return cr.maybeRestartConnection(cr.send(cr.beatBytes))
+		return cr.maybeRestartConnection(cr.sendFaulty(cr.beatBytes))
 	} else {
 		cr.mustSendBeat = true
 	}
@@ -1,6 +1,8 @@
 package network
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/binary"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
@@ -27,8 +29,12 @@ type ConnectionManager struct {
 	localHost                     string
 	RMId                          common.RMId
 	BootCount                     uint32
-	NodeCertificatePrivateKeyPair *certs.NodeCertificatePrivateKeyPair
+	nodeCertPrivKeyPair           *certs.NodeCertificatePrivateKeyPair
+	trustedClusterCerts           map[[sha256.Size]byte]*x509.Certificate
+	revokedClientCerts            map[[sha256.Size]byte]server.EmptyStruct
+	auditLog                      *AuditLog
 	Transmogrifier                *TopologyTransmogrifier
+	LocalConnection               *client.LocalConnection
 	topology                      *configuration.Topology
 	cellTail                      *cc.ChanCellTail
 	enqueueQueryInner             func(connectionManagerMsg, *cc.ChanCell, cc.CurCellConsumer) (bool, cc.CurCellConsumer)
@@ -40,6 +46,7 @@ type ConnectionManager struct {
 	serverConnSubscribers         serverConnSubscribers
 	topologySubscribers           topologySubscribers
 	Dispatchers                   *paxos.Dispatchers
+	Faults                        *FaultInjector
 }
 
 type serverConnSubscribers struct {
@@ -328,15 +335,21 @@ func (cm *ConnectionManager) enqueueSyncQuery(msg connectionManagerMsg, resultCh
 	}
 }
 
-func NewConnectionManager(rmId common.RMId, bootCount uint32, procs int, db *db.Databases, nodeCertPrivKeyPair *certs.NodeCertificatePrivateKeyPair, port uint16, ss ShutdownSignaller, config *configuration.Configuration) (*ConnectionManager, *TopologyTransmogrifier) {
+func NewConnectionManager(rmId common.RMId, bootCount uint32, procs int, db *db.Databases, nodeCertPrivKeyPair *certs.NodeCertificatePrivateKeyPair, port uint16, ss ShutdownSignaller, config *configuration.Configuration, changeLog *eng.ChangeLog, auditLog *AuditLog) (*ConnectionManager, *TopologyTransmogrifier) {
 	cm := &ConnectionManager{
 		RMId:                          rmId,
 		BootCount:                     bootCount,
-		NodeCertificatePrivateKeyPair: nodeCertPrivKeyPair,
+		nodeCertPrivKeyPair:           nodeCertPrivKeyPair,
+		trustedClusterCerts: map[[sha256.Size]byte]*x509.Certificate{
+			sha256.Sum256(nodeCertPrivKeyPair.CertificateRoot.Raw): nodeCertPrivKeyPair.CertificateRoot,
+		},
+		revokedClientCerts: make(map[[sha256.Size]byte]server.EmptyStruct),
+		auditLog:           auditLog,
 		servers:           make(map[string]*connectionManagerMsgServerEstablished),
 		rmToServer:        make(map[common.RMId]*connectionManagerMsgServerEstablished),
 		connCountToClient: make(map[uint32]paxos.ClientConnection),
 		desired:           nil,
+		Faults:            NewFaultInjector(),
 	}
 	cm.serverConnSubscribers.subscribers = make(map[paxos.ServerConnectionSubscriber]server.EmptyStruct)
 	cm.serverConnSubscribers.ConnectionManager = cm
@@ -377,7 +390,8 @@ func NewConnectionManager(rmId common.RMId, bootCount uint32, procs int, db *db.
 	cm.rmToServer[cd.rmId] = cd
 	cm.servers[cd.host] = cd
 	lc := client.NewLocalConnection(rmId, bootCount, cm)
-	cm.Dispatchers = paxos.NewDispatchers(cm, rmId, uint8(procs), db, lc)
+	cm.LocalConnection = lc
+	cm.Dispatchers = paxos.NewDispatchers(cm, rmId, uint8(procs), db, lc, changeLog)
 	transmogrifier, localEstablished := NewTopologyTransmogrifier(db, cm, lc, port, ss, config)
 	cm.Transmogrifier = transmogrifier
 	go cm.actorLoop(head)
@@ -622,36 +636,37 @@ func (cm *ConnectionManager) cloneRMToServer() map[common.RMId]paxos.Connection
 }
 
 func (cm *ConnectionManager) status(sc *server.StatusConsumer) {
-	sc.Emit(fmt.Sprintf("Address: %v", cm.localHost))
-	sc.Emit(fmt.Sprintf("Boot Count: %v", cm.BootCount))
-	sc.Emit(fmt.Sprintf("Current Topology: %v", cm.topology))
+	sc.EmitKV("Address", cm.localHost)
+	sc.EmitKV("Boot Count", cm.BootCount)
+	sc.EmitKV("Current Topology", cm.topology)
 	if cm.topology != nil && cm.topology.Next() != nil {
-		sc.Emit(fmt.Sprintf("Next Topology: %v", cm.topology.Next()))
+		sc.EmitKV("Next Topology", cm.topology.Next())
 	}
 	serverConnections := make([]string, 0, len(cm.servers))
 	for server := range cm.servers {
 		serverConnections = append(serverConnections, server)
 	}
-	sc.Emit(fmt.Sprintf("ServerConnectionSubscribers: %v", len(cm.serverConnSubscribers.subscribers)))
+	sc.EmitKV("ServerConnectionSubscribers", len(cm.serverConnSubscribers.subscribers))
 	topSubs := make([]int, eng.TopologyChangeSubscriberTypeLimit)
 	for idx, subs := range cm.topologySubscribers.subscribers {
 		topSubs[idx] = len(subs)
 	}
-	sc.Emit(fmt.Sprintf("TopologySubscribers: %v", topSubs))
+	sc.EmitKV("TopologySubscribers", topSubs)
 	rms := make([]common.RMId, 0, len(cm.rmToServer))
 	for rmId := range cm.rmToServer {
 		rms = append(rms, rmId)
 	}
-	sc.Emit(fmt.Sprintf("Active Server RMIds: %v", rms))
-	sc.Emit(fmt.Sprintf("Active Server Connections: %v", serverConnections))
-	sc.Emit(fmt.Sprintf("Desired Server Connections: %v", cm.desired))
+	sc.EmitKV("Active Server RMIds", rms)
+	sc.EmitKV("Active Server Connections", serverConnections)
+	sc.EmitKV("Desired Server Connections", cm.desired)
 	for _, conn := range cm.servers {
 		if conn.Connection != nil {
 			conn.Connection.Status(sc.Fork())
 		}
 	}
 	cm.RLock()
-	sc.Emit(fmt.Sprintf("Client Connection Count: %v", len(cm.connCountToClient)))
+	sc.EmitKV("Client Connection Count", len(cm.connCountToClient))
+	sc.EmitKV("Revoked Client Certificates", len(cm.revokedClientCerts))
 	cm.connCountToClient[0].(*client.LocalConnection).Status(sc.Fork())
 	for _, conn := range cm.connCountToClient {
 		if c, ok := conn.(*Connection); ok {
@@ -662,6 +677,7 @@ func (cm *ConnectionManager) status(sc *server.StatusConsumer) {
 	cm.Dispatchers.VarDispatcher.Status(sc.Fork())
 	cm.Dispatchers.ProposerDispatcher.Status(sc.Fork())
 	cm.Dispatchers.AcceptorDispatcher.Status(sc.Fork())
+	cm.Transmogrifier.Status(sc.Fork())
 	sc.Join()
 }
 
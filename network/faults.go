@@ -0,0 +1,184 @@
+package network
+
+import (
+	"goshawkdb.io/common"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FaultInjector injects faults into the messages sent between this
+// node and other nodes, for testing recovery. Faults are given as
+// rules per remote RMId: the rule for RMIdEmpty applies to every
+// remote node which has no rule of its own. Client connections are
+// never affected.
+//
+// Every link to a remote node has its own random number generator,
+// seeded from the injector's seed and the remote RMId. So for a given
+// seed, the faults injected on a link depend only on the sequence of
+// messages sent on that link, and not on the traffic of other links.
+// Setting the rules reseeds every link.
+type FaultInjector struct {
+	lock       sync.Mutex
+	active     int32
+	generation uint64
+	config     FaultConfig
+	links      map[common.RMId]*faultLink
+}
+
+type FaultConfig struct {
+	Seed  int64
+	Rules map[common.RMId]*FaultRule
+}
+
+// FaultRule describes the faults on a link. Probabilities are in the
+// range [0,1] and are applied to each message sent on the link,
+// including heartbeats.
+type FaultRule struct {
+	// Partition drops every message in both directions. Handshakes
+	// are not affected, so connections are still established, but are
+	// then lost as heartbeats go missing.
+	Partition bool
+	Drop      float64
+	Duplicate float64
+	// Reorder is the probability that a message is held back and
+	// sent after the next message.
+	Reorder float64
+	// Each message is delayed by a random duration up to MaxDelay.
+	MaxDelay time.Duration
+}
+
+type faultLink struct {
+	injector   *FaultInjector
+	rmId       common.RMId
+	generation uint64
+	rng        *rand.Rand
+}
+
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{
+		config: FaultConfig{Rules: make(map[common.RMId]*FaultRule)},
+		links:  make(map[common.RMId]*faultLink),
+	}
+}
+
+// SetConfig replaces the seed and all the rules.
+func (fi *FaultInjector) SetConfig(config FaultConfig) {
+	rules := make(map[common.RMId]*FaultRule, len(config.Rules))
+	for rmId, rule := range config.Rules {
+		if rule != nil {
+			ruleCopy := *rule
+			rules[rmId] = &ruleCopy
+		}
+	}
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+	fi.generation++
+	fi.config = FaultConfig{Seed: config.Seed, Rules: rules}
+	if len(rules) == 0 {
+		atomic.StoreInt32(&fi.active, 0)
+	} else {
+		atomic.StoreInt32(&fi.active, 1)
+	}
+}
+
+func (fi *FaultInjector) Config() FaultConfig {
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+	rules := make(map[common.RMId]*FaultRule, len(fi.config.Rules))
+	for rmId, rule := range fi.config.Rules {
+		ruleCopy := *rule
+		rules[rmId] = &ruleCopy
+	}
+	return FaultConfig{Seed: fi.config.Seed, Rules: rules}
+}
+
+// SetRule sets the rule for one remote RMId, keeping the seed and
+// all other rules. A nil rule removes the rule for rmId.
+func (fi *FaultInjector) SetRule(rmId common.RMId, rule *FaultRule) {
+	config := fi.Config()
+	if rule == nil {
+		delete(config.Rules, rmId)
+	} else {
+		config.Rules[rmId] = rule
+	}
+	fi.SetConfig(config)
+}
+
+// Clear removes all the rules.
+func (fi *FaultInjector) Clear() {
+	fi.SetConfig(FaultConfig{Seed: fi.Config().Seed})
+}
+
+func (fi *FaultInjector) link(rmId common.RMId) *faultLink {
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+	fl, found := fi.links[rmId]
+	if !found {
+		fl = &faultLink{injector: fi, rmId: rmId}
+		fi.links[rmId] = fl
+	}
+	return fl
+}
+
+// faultDecision is the fate of a single outbound message.
+type faultDecision struct {
+	drop      bool
+	duplicate bool
+	reorder   bool
+	delay     time.Duration
+}
+
+// decide returns the fate of the next message to be sent on the
+// link, or nil if the message should be sent untouched. The link may
+// be nil.
+func (fl *faultLink) decide() *faultDecision {
+	if fl == nil || atomic.LoadInt32(&fl.injector.active) == 0 {
+		return nil
+	}
+	fi := fl.injector
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+	rule := fl.rule()
+	if rule == nil {
+		return nil
+	} else if rule.Partition {
+		return &faultDecision{drop: true}
+	}
+	if fl.rng == nil || fl.generation != fi.generation {
+		fl.generation = fi.generation
+		fl.rng = rand.New(rand.NewSource(fi.config.Seed ^ int64(fl.rmId)))
+	}
+	// Always draw the same number of values so that the decisions
+	// for later messages do not depend on the decisions for earlier
+	// ones.
+	drop, duplicate, reorder, delay := fl.rng.Float64(), fl.rng.Float64(), fl.rng.Float64(), fl.rng.Float64()
+	return &faultDecision{
+		drop:      drop < rule.Drop,
+		duplicate: duplicate < rule.Duplicate,
+		reorder:   reorder < rule.Reorder,
+		delay:     time.Duration(delay * float64(rule.MaxDelay)),
+	}
+}
+
+// partitioned returns true if messages received on the link must be
+// discarded. The link may be nil.
+func (fl *faultLink) partitioned() bool {
+	if fl == nil || atomic.LoadInt32(&fl.injector.active) == 0 {
+		return false
+	}
+	fl.injector.lock.Lock()
+	defer fl.injector.lock.Unlock()
+	rule := fl.rule()
+	return rule != nil && rule.Partition
+}
+
+// rule must be called with the injector's lock held.
+func (fl *faultLink) rule() *FaultRule {
+	rules := fl.injector.config.Rules
+	if rule, found := rules[fl.rmId]; found {
+		return rule
+	}
+	return rules[common.RMIdEmpty]
+}
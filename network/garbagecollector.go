@@ -0,0 +1,454 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/client"
+	"goshawkdb.io/server/configuration"
+	ch "goshawkdb.io/server/consistenthash"
+	"goshawkdb.io/server/db"
+	eng "goshawkdb.io/server/txnengine"
+	"log"
+	"time"
+)
+
+// GarbageCollector periodically removes vars which are unreachable
+// from the roots. Every node marks from the roots by reading the
+// latest version of each reachable var through normal txns. Each
+// node then sweeps only those of its own vars for which it is first
+// in the var's hash codes, so every var is swept by exactly one
+// node. To be swept, a var must have been unreachable, and unchanged,
+// in two consecutive passes.
+//
+// The mark is not a snapshot, as concurrent writers may move
+// references around whilst it proceeds. So once the mark is done, and
+// the candidates have been found on disk, every marked var is read
+// again, in batches, at the version marked. If none has changed, then
+// at the instant between the mark and this validation every marked
+// var was at the version marked, and those versions, whose references
+// were all followed, are exactly the vars reachable from the roots at
+// that instant. No candidate was amongst them, and no txn can make a
+// var reachable again unless it already holds a reference to it. If a
+// marked var has changed, the pass is abandoned. Each collecting txn
+// then only readwrites a batch of candidates at the versions found, so
+// its size is bounded whatever the size of the graph.
+//
+// Collected vars are deleted from disk, leaving only a small
+// tombstone, which is kept for the tombstone retention. Clients must
+// not retain references to vars they can no longer reach for longer
+// than the interval. If one does, a txn which uses the var fails with
+// an error saying that it has been collected, for as long as the
+// tombstone is kept. After that, the var is seen to be empty.
+// Tombstones are not migrated by topology changes.
+//
+// Collection is suspended whilst a topology change is in progress.
+type GarbageCollector struct {
+	connectionManager  *ConnectionManager
+	localConnection    *client.LocalConnection
+	db                 *db.Databases
+	interval           time.Duration
+	tombstoneRetention time.Duration
+	topologyChan       chan *configuration.Topology
+	shutdownChan       chan struct{}
+	topology           *configuration.Topology
+	candidates         map[common.VarUUId]*gcCandidate
+}
+
+type gcCandidate struct {
+	vUUId     *common.VarUUId
+	version   *common.TxnId
+	positions *common.Positions
+}
+
+type gcMarked struct {
+	version   *common.TxnId
+	positions *common.Positions
+	collected bool
+}
+
+func NewGarbageCollector(cm *ConnectionManager, db *db.Databases, interval, tombstoneRetention time.Duration) *GarbageCollector {
+	gc := &GarbageCollector{
+		connectionManager:  cm,
+		localConnection:    cm.LocalConnection,
+		db:                 db,
+		interval:           interval,
+		tombstoneRetention: tombstoneRetention,
+		topologyChan:       make(chan *configuration.Topology, 1),
+		shutdownChan:       make(chan struct{}),
+	}
+	go gc.run()
+	return gc
+}
+
+func (gc *GarbageCollector) Shutdown() {
+	close(gc.shutdownChan)
+}
+
+// We subscribe in the same way as client connections do, so we never
+// hold up topology changes.
+func (gc *GarbageCollector) TopologyChanged(topology *configuration.Topology, done func(bool)) {
+	select {
+	case <-gc.topologyChan:
+	default:
+	}
+	if topology != nil {
+		gc.topologyChan <- topology
+	}
+	done(true)
+}
+
+func (gc *GarbageCollector) run() {
+	defer gc.connectionManager.RemoveTopologySubscriberAsync(eng.ConnectionSubscriber, gc)
+	gc.topology = gc.connectionManager.AddTopologySubscriber(eng.ConnectionSubscriber, gc)
+	log.Printf("Garbage collection every %v", gc.interval)
+
+	ticker := time.NewTicker(gc.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gc.shutdownChan:
+			return
+		case topology := <-gc.topologyChan:
+			gc.topology = topology
+			gc.candidates = nil
+		case <-ticker.C:
+			if err := gc.collect(); err != nil {
+				log.Println("Garbage collection error:", err)
+				gc.candidates = nil
+			}
+		}
+	}
+}
+
+func (gc *GarbageCollector) collect() error {
+	topology := gc.topology
+	if topology == nil || topology.Root.VarUUId == nil || topology.Next() != nil {
+		gc.candidates = nil
+		return nil
+	}
+
+	marked, err := gc.mark(topology)
+	if err != nil {
+		return err
+	}
+	candidates, err := gc.findCandidates(topology, marked)
+	if err != nil {
+		return err
+	}
+	if stale, err := gc.validate(marked); err != nil {
+		return err
+	} else if stale {
+		server.Log("GC: graph changed whilst marking; abandoning pass")
+		return nil
+	}
+
+	sweep := []*gcCandidate{}
+	for vUUId, c := range candidates {
+		if prev, found := gc.candidates[vUUId]; found && prev.version.Compare(c.version) == common.EQ {
+			sweep = append(sweep, c)
+		}
+	}
+	gc.candidates = candidates
+	server.Log("GC: marked", len(marked), "; candidates", len(candidates), "; sweeping", len(sweep))
+
+	collected := 0
+	for len(sweep) > 0 {
+		batch := sweep
+		if len(batch) > server.GCBatchElemCount {
+			batch = batch[:server.GCBatchElemCount]
+		}
+		sweep = sweep[len(batch):]
+		n, err := gc.sweep(batch)
+		collected += n
+		if err != nil {
+			return err
+		}
+	}
+	if collected > 0 {
+		log.Printf("Garbage collection: collected %v vars.", collected)
+	}
+
+	pruned, err := gc.db.PruneCollectedVars(time.Now().Add(-gc.tombstoneRetention))
+	if err != nil {
+		return err
+	} else if pruned > 0 {
+		collectedVarTombstonesPruned.Add(uint64(pruned))
+		server.Log("GC: pruned", pruned, "tombstones")
+	}
+	return nil
+}
+
+// mark walks the graph from the roots, in batches, recording the
+// latest version of each var reached.
+func (gc *GarbageCollector) mark(topology *configuration.Topology) (map[common.VarUUId]*gcMarked, error) {
+	roots := topology.AllRoots()
+	marked := make(map[common.VarUUId]*gcMarked, len(roots))
+	queue := make([]*common.VarUUId, 0, len(roots))
+	for _, root := range roots {
+		marked[*root.VarUUId] = &gcMarked{positions: root.Positions}
+		queue = append(queue, root.VarUUId)
+	}
+	for len(queue) > 0 {
+		batch := queue
+		if len(batch) > server.GCBatchElemCount {
+			batch = batch[:server.GCBatchElemCount]
+		}
+		queue = queue[len(batch):]
+
+		refs, err := gc.readLatest(batch, marked)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			vUUId := common.MakeVarUUId(ref.Id())
+			if _, found := marked[*vUUId]; !found {
+				refPositions := ref.Positions()
+				marked[*vUUId] = &gcMarked{positions: (*common.Positions)(&refPositions)}
+				queue = append(queue, vUUId)
+			}
+		}
+	}
+	return marked, nil
+}
+
+// readLatest reads the vars at version zero. No var which exists is
+// at version zero, so that must abort, and the rerun gives us the
+// latest version of every var, which we record in marked, and from
+// which we return all the references.
+func (gc *GarbageCollector) readLatest(vUUIds []*common.VarUUId, marked map[common.VarUUId]*gcMarked) ([]msgs.VarIdPos, error) {
+	for {
+		seg := capn.NewBuffer(nil)
+		ctxn := cmsgs.NewClientTxn(seg)
+		ctxn.SetRetry(false)
+		actions := cmsgs.NewClientActionList(seg, len(vUUIds))
+		ctxn.SetActions(actions)
+		varPosMap := make(map[common.VarUUId]*common.Positions, len(vUUIds))
+		for idx, vUUId := range vUUIds {
+			action := actions.At(idx)
+			action.SetVarId(vUUId[:])
+			action.SetRead()
+			action.Read().SetVersion(common.VersionZero[:])
+			varPosMap[*vUUId] = marked[*vUUId].positions
+		}
+
+		outcome, err := gc.localConnection.RunClientTransaction(&ctxn, varPosMap, true)
+		if err != nil {
+			return nil, err
+		} else if outcome == nil {
+			return nil, fmt.Errorf("Shutting down")
+		} else if outcome.Which() == msgs.OUTCOME_COMMIT {
+			return nil, fmt.Errorf("Internal error: read of %v vars at version zero committed", len(vUUIds))
+		}
+		abort := outcome.Abort()
+		if abort.Which() == msgs.OUTCOMEABORT_RESUBMIT {
+			continue
+		}
+		refs := []msgs.VarIdPos{}
+		updates := abort.Rerun()
+		for idx, l := 0, updates.Len(); idx < l; idx++ {
+			update := updates.At(idx)
+			txnId := common.MakeTxnId(update.TxnId())
+			updateActions := update.Actions()
+			for idy, m := 0, updateActions.Len(); idy < m; idy++ {
+				updateAction := updateActions.At(idy)
+				vUUId := common.MakeVarUUId(updateAction.VarId())
+				if _, found := varPosMap[*vUUId]; !found {
+					continue
+				}
+				marked[*vUUId].version = txnId
+				if update.Collected() {
+					// A client retained a reference to the var for
+					// too long, and has written it back into the
+					// graph. There is nothing to follow.
+					marked[*vUUId].collected = true
+					log.Printf("Warning: %v is reachable, but has been garbage collected.", vUUId)
+					continue
+				} else if updateAction.Which() != msgs.ACTION_WRITE {
+					continue
+				}
+				updateRefs := updateAction.Write().References()
+				for idz, n := 0, updateRefs.Len(); idz < n; idz++ {
+					refs = append(refs, updateRefs.At(idz))
+				}
+			}
+		}
+		for _, vUUId := range vUUIds {
+			if marked[*vUUId].version == nil {
+				return nil, fmt.Errorf("Internal error: read of %v at version zero gave no rerun", vUUId)
+			}
+		}
+		return refs, nil
+	}
+}
+
+// findCandidates returns every var on local disk which is not marked
+// and for which we are first in its hash codes.
+func (gc *GarbageCollector) findCandidates(topology *configuration.Topology, marked map[common.VarUUId]*gcMarked) (map[common.VarUUId]*gcCandidate, error) {
+	resolver := ch.NewResolver(topology.RMs(), topology.TwoFInc, topology.RMZones(), topology.RMWeights())
+	rmId := gc.connectionManager.RMId
+	res, err := gc.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		candidates := make(map[common.VarUUId]*gcCandidate)
+		rtxn.WithCursor(gc.db.Vars, func(cursor *mdbs.Cursor) interface{} {
+			vUUIdBytes, varBytes, err := cursor.Get(nil, nil, mdb.FIRST)
+			for ; err == nil; vUUIdBytes, varBytes, err = cursor.Get(nil, nil, mdb.NEXT) {
+				if bytes.Equal(vUUIdBytes, configuration.TopologyVarUUId[:]) {
+					continue
+				}
+				vUUId := common.MakeVarUUId(vUUIdBytes)
+				if _, found := marked[*vUUId]; found {
+					continue
+				}
+				seg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
+				if err != nil {
+					cursor.Error(fmt.Errorf("Err on decoding %v: %v", vUUId, err))
+					return nil
+				}
+				varCap := msgs.ReadRootVar(seg)
+				positions := varCap.Positions()
+				if positions.Len() == 0 {
+					continue
+				}
+				hashCodes, err := resolver.ResolveHashCodes(positions.ToArray())
+				if err != nil {
+					cursor.Error(err)
+					return nil
+				} else if hashCodes[0] != rmId {
+					continue
+				}
+				// copy as we're outside the txn by the time we use these.
+				positionsCopy := capn.NewBuffer(nil).NewUInt8List(positions.Len())
+				for idx, l := 0, positions.Len(); idx < l; idx++ {
+					positionsCopy.Set(idx, positions.At(idx))
+				}
+				candidates[*vUUId] = &gcCandidate{
+					vUUId:     vUUId,
+					version:   common.MakeTxnId(varCap.WriteTxnId()),
+					positions: (*common.Positions)(&positionsCopy),
+				}
+			}
+			if err != nil && err != mdb.NotFound {
+				cursor.Error(err)
+			}
+			return nil
+		})
+		return candidates
+	}).ResultError()
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return res.(map[common.VarUUId]*gcCandidate), nil
+}
+
+// validate reads every marked var, in batches, at the version
+// marked. It reports the mark as stale if any has changed.
+func (gc *GarbageCollector) validate(marked map[common.VarUUId]*gcMarked) (bool, error) {
+	vUUIds := make([]common.VarUUId, 0, len(marked))
+	for vUUId, m := range marked {
+		// Collected vars never change.
+		if !m.collected {
+			vUUIds = append(vUUIds, vUUId)
+		}
+	}
+	for len(vUUIds) > 0 {
+		batch := vUUIds
+		if len(batch) > server.GCBatchElemCount {
+			batch = batch[:server.GCBatchElemCount]
+		}
+		vUUIds = vUUIds[len(batch):]
+
+		for {
+			seg := capn.NewBuffer(nil)
+			ctxn := cmsgs.NewClientTxn(seg)
+			ctxn.SetRetry(false)
+			actions := cmsgs.NewClientActionList(seg, len(batch))
+			ctxn.SetActions(actions)
+			varPosMap := make(map[common.VarUUId]*common.Positions, len(batch))
+			for idx, vUUId := range batch {
+				m := marked[vUUId]
+				action := actions.At(idx)
+				action.SetVarId(vUUId[:])
+				action.SetRead()
+				action.Read().SetVersion(m.version[:])
+				varPosMap[vUUId] = m.positions
+			}
+
+			outcome, err := gc.localConnection.RunClientTransaction(&ctxn, varPosMap, true)
+			if err != nil {
+				return false, err
+			} else if outcome == nil {
+				return false, fmt.Errorf("Shutting down")
+			} else if outcome.Which() == msgs.OUTCOME_COMMIT {
+				break
+			} else if outcome.Abort().Which() == msgs.OUTCOMEABORT_RESUBMIT {
+				continue
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// sweep submits a collecting txn for the batch. Any candidates which
+// have been written to since we found them are dropped from the batch
+// and the rest are resubmitted.
+func (gc *GarbageCollector) sweep(batch []*gcCandidate) (int, error) {
+	for len(batch) > 0 {
+		seg := capn.NewBuffer(nil)
+		ctxn := cmsgs.NewClientTxn(seg)
+		ctxn.SetRetry(false)
+		actions := cmsgs.NewClientActionList(seg, len(batch))
+		ctxn.SetActions(actions)
+		varPosMap := make(map[common.VarUUId]*common.Positions, len(batch))
+		for idx, c := range batch {
+			action := actions.At(idx)
+			action.SetVarId(c.vUUId[:])
+			action.SetReadwrite()
+			rw := action.Readwrite()
+			rw.SetVersion(c.version[:])
+			rw.SetValue([]byte{})
+			rw.SetReferences(seg.NewDataList(0))
+			varPosMap[*c.vUUId] = c.positions
+		}
+
+		outcome, err := gc.localConnection.RunCollectTransaction(&ctxn, varPosMap)
+		if err != nil {
+			return 0, err
+		} else if outcome == nil {
+			return 0, fmt.Errorf("Shutting down")
+		} else if outcome.Which() == msgs.OUTCOME_COMMIT {
+			return len(batch), nil
+		}
+		abort := outcome.Abort()
+		if abort.Which() == msgs.OUTCOMEABORT_RESUBMIT {
+			continue
+		}
+		changed := make(map[common.VarUUId]server.EmptyStruct)
+		updates := abort.Rerun()
+		for idx, l := 0, updates.Len(); idx < l; idx++ {
+			updateActions := updates.At(idx).Actions()
+			for idy, m := 0, updateActions.Len(); idy < m; idy++ {
+				changed[*common.MakeVarUUId(updateActions.At(idy).VarId())] = server.EmptyStructVal
+			}
+		}
+		remaining := batch[:0]
+		for _, c := range batch {
+			if _, found := changed[*c.vUUId]; found {
+				delete(gc.candidates, *c.vUUId)
+			} else {
+				remaining = append(remaining, c)
+			}
+		}
+		if len(remaining) == len(batch) {
+			return 0, fmt.Errorf("Internal error: collecting txn required rerun, but no var has changed")
+		}
+		batch = remaining
+	}
+	return 0, nil
+}
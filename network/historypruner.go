@@ -0,0 +1,84 @@
+package network
+
+import (
+	"goshawkdb.io/server"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/db"
+	eng "goshawkdb.io/server/txnengine"
+	"log"
+	"time"
+)
+
+// HistoryPruner removes, every interval, past versions of vars which
+// were superseded longer ago than the retention window of the
+// current topology. Each node prunes only its own disk. If the
+// retention window is reduced to zero, all history is removed.
+type HistoryPruner struct {
+	connectionManager *ConnectionManager
+	db                *db.Databases
+	interval          time.Duration
+	topologyChan      chan *configuration.Topology
+	shutdownChan      chan struct{}
+	topology          *configuration.Topology
+}
+
+func NewHistoryPruner(cm *ConnectionManager, db *db.Databases, interval time.Duration) *HistoryPruner {
+	hp := &HistoryPruner{
+		connectionManager: cm,
+		db:                db,
+		interval:          interval,
+		topologyChan:      make(chan *configuration.Topology, 1),
+		shutdownChan:      make(chan struct{}),
+	}
+	go hp.run()
+	return hp
+}
+
+func (hp *HistoryPruner) Shutdown() {
+	close(hp.shutdownChan)
+}
+
+func (hp *HistoryPruner) TopologyChanged(topology *configuration.Topology, done func(bool)) {
+	select {
+	case <-hp.topologyChan:
+	default:
+	}
+	if topology != nil {
+		hp.topologyChan <- topology
+	}
+	done(true)
+}
+
+func (hp *HistoryPruner) run() {
+	defer hp.connectionManager.RemoveTopologySubscriberAsync(eng.ConnectionSubscriber, hp)
+	hp.topology = hp.connectionManager.AddTopologySubscriber(eng.ConnectionSubscriber, hp)
+
+	ticker := time.NewTicker(hp.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hp.shutdownChan:
+			return
+		case topology := <-hp.topologyChan:
+			hp.topology = topology
+		case <-ticker.C:
+			hp.prune()
+		}
+	}
+}
+
+func (hp *HistoryPruner) prune() {
+	topology := hp.topology
+	if topology == nil {
+		return
+	}
+	retention := time.Duration(topology.HistoryRetentionSeconds) * time.Second
+	start := time.Now()
+	pruned, err := hp.db.PruneVarHistory(start.Add(-retention))
+	if err != nil {
+		log.Println("History pruning error:", err)
+	} else if pruned > 0 {
+		varHistoryPruned.Add(uint64(pruned))
+		server.Log("History: pruned", pruned, "versions in", time.Now().Sub(start))
+	}
+}
@@ -0,0 +1,16 @@
+package network
+
+import (
+	"goshawkdb.io/server"
+)
+
+var (
+	varHistoryPruned             = server.Metrics.Counter("goshawkdb_var_history_pruned_total", "Past versions of vars removed from local disk.")
+	collectedVarTombstonesPruned = server.Metrics.Counter("goshawkdb_collected_var_tombstones_pruned_total", "Tombstones of collected vars removed from local disk.")
+	migrationTxnsSent            = server.Metrics.Counter("goshawkdb_topology_migration_txns_sent_total", "Txns sent to other nodes in migration batches.")
+	migrationTxnsReceived        = server.Metrics.Counter("goshawkdb_topology_migration_txns_received_total", "Txns received from other nodes in migration batches.")
+)
+
+func topologyEvents(kind string) *server.Counter {
+	return server.Metrics.Counter("goshawkdb_topology_events_total", "Steps of topology changes recorded.", "kind", kind)
+}
@@ -0,0 +1,385 @@
+package network
+
+import (
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	"goshawkdb.io/server/configuration"
+	"sync"
+	"time"
+)
+
+const (
+	TopologyEventTaskStarted               = "TaskStarted"
+	TopologyEventTaskCompleted             = "TaskCompleted"
+	TopologyEventTaskAbandoned             = "TaskAbandoned"
+	TopologyEventTaskFailed                = "TaskFailed"
+	TopologyEventTargetInstalled           = "TargetInstalled"
+	TopologyEventInstalledOnNew            = "InstalledOnNew"
+	TopologyEventBarrier1Reached           = "Barrier1Reached"
+	TopologyEventBarrier2Reached           = "Barrier2Reached"
+	TopologyEventMigrationCompleteSent     = "MigrationCompleteSent"
+	TopologyEventMigrationCompleteReceived = "MigrationCompleteReceived"
+	TopologyEventImmigrationCompleted      = "ImmigrationCompleted"
+	TopologyEventChangeCompleted           = "ChangeCompleted"
+	TopologyEventChangeAborted             = "ChangeAborted"
+)
+
+// TopologyProgress describes how far the current topology change has
+// got. The barriers and pending immigrations are as recorded in the
+// active topology, and so are the same on every node. The task and
+// migration counts are this node's own.
+//
+// RemainingSteps is the number of steps still to be recorded in the
+// topology before the change can complete: a barrier for each RM of
+// the next configuration which has not reached it, and an
+// immigration for each RM still pending. It says nothing of how many
+// vars are still to move: each immigration is one step however large
+// it is, so use the plan of the change for that. LastProgress is when
+// an event was last recorded, so a change which has stopped making
+// progress can be spotted.
+type TopologyProgress struct {
+	ActiveVersion    uint32
+	TargetVersion    uint32 `json:",omitempty"`
+	NextVersion      uint32 `json:",omitempty"`
+	Task             string `json:",omitempty"`
+	TaskStarted      time.Time
+	LastProgress     time.Time
+	InstalledOnNew   bool
+	BarrierReached1  common.RMIds
+	BarrierReached2  common.RMIds
+	AwaitingBarrier1 common.RMIds
+	AwaitingBarrier2 common.RMIds
+	Pending          common.RMIds
+	Migration        map[common.RMId]*MigrationProgress
+	RemainingSteps   int
+}
+
+// MigrationProgress counts the migration batches, and the txns in
+// them, sent to and received from one RM for the current change.
+type MigrationProgress struct {
+	BatchesSent      int
+	TxnsSent         int
+	BatchesReceived  int
+	TxnsReceived     int
+	CompleteSent     bool
+	CompleteReceived bool
+}
+
+// TopologyEvent is a step of a topology change. Seq increases by one
+// for each event recorded by this node.
+type TopologyEvent struct {
+	Seq     uint64
+	Time    time.Time
+	Version uint32
+	Kind    string
+	RMId    common.RMId `json:",omitempty"`
+	Detail  string      `json:",omitempty"`
+}
+
+// topologyProgress is updated from the transmogrifier's actor, and
+// also from the emigrator's iterators as they send batches, so it
+// has its own lock. Only the most recent server.TopologyEventCount
+// events are kept.
+type topologyProgress struct {
+	lock       sync.Mutex
+	progress   TopologyProgress
+	active     *configuration.Configuration
+	nextConfig *configuration.Configuration
+	events     []*TopologyEvent
+	seq        uint64
+}
+
+func newTopologyProgress() *topologyProgress {
+	return &topologyProgress{
+		progress: TopologyProgress{
+			Migration: make(map[common.RMId]*MigrationProgress),
+		},
+		events: make([]*TopologyEvent, 0, server.TopologyEventCount),
+	}
+}
+
+// event must be called with the lock held.
+func (tp *topologyProgress) event(version uint32, kind string, rmId common.RMId, detail string) {
+	now := time.Now()
+	tp.seq++
+	event := &TopologyEvent{
+		Seq:     tp.seq,
+		Time:    now,
+		Version: version,
+		Kind:    kind,
+		RMId:    rmId,
+		Detail:  detail,
+	}
+	if len(tp.events) == server.TopologyEventCount {
+		copy(tp.events, tp.events[1:])
+		tp.events[len(tp.events)-1] = event
+	} else {
+		tp.events = append(tp.events, event)
+	}
+	tp.progress.LastProgress = now
+	topologyEvents(kind).Inc()
+	server.Log("Topology: Event:", kind, version, rmId, detail)
+}
+
+func (tp *topologyProgress) taskStarted(task topologyTask, version uint32) {
+	name := topologyTaskName(task)
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	tp.progress.Task = name
+	tp.progress.TaskStarted = time.Now()
+	tp.progress.TargetVersion = version
+	tp.event(version, TopologyEventTaskStarted, common.RMIdEmpty, name)
+}
+
+func (tp *topologyProgress) taskEnded(kind string, err error) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	if tp.progress.Task == "" {
+		return
+	}
+	detail := tp.progress.Task
+	if err != nil {
+		detail = fmt.Sprintf("%v: %v", detail, err)
+	}
+	tp.event(tp.progress.TargetVersion, kind, common.RMIdEmpty, detail)
+	tp.progress.Task = ""
+}
+
+// topologyObserved records the barriers and immigrations which have
+// been reached since the previous topology was observed.
+func (tp *topologyProgress) topologyObserved(topology *configuration.Topology) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	p := &tp.progress
+	switch {
+	case topology.Version <= p.ActiveVersion:
+	case p.NextVersion != 0 && topology.Version >= p.NextVersion:
+		// An aborted change also reaches the version of the change,
+		// but with the previous configuration.
+		if tp.nextConfig.Equal(topology.Configuration) {
+			tp.event(topology.Version, TopologyEventChangeCompleted, common.RMIdEmpty, "")
+		} else {
+			tp.event(topology.Version, TopologyEventChangeAborted, common.RMIdEmpty, "")
+		}
+	case p.NextVersion == 0 && tp.active != nil && topology.Next() == nil:
+		// A change aborted before it was adopted just moves the
+		// version on.
+		unchanged := tp.active.Clone()
+		unchanged.Version = topology.Version
+		if unchanged.Equal(topology.Configuration) {
+			tp.event(topology.Version, TopologyEventChangeAborted, common.RMIdEmpty, "")
+		}
+	}
+	tp.active = topology.Configuration
+	p.ActiveVersion = topology.Version
+
+	next := topology.Next()
+	if next == nil {
+		tp.nextConfig = nil
+		p.NextVersion = 0
+		p.InstalledOnNew = false
+		p.BarrierReached1, p.BarrierReached2, p.Pending = nil, nil, nil
+		p.AwaitingBarrier1, p.AwaitingBarrier2 = nil, nil
+		p.Migration = make(map[common.RMId]*MigrationProgress)
+		p.RemainingSteps = 0
+		return
+	}
+
+	if next.Version != p.NextVersion {
+		tp.event(next.Version, TopologyEventTargetInstalled, common.RMIdEmpty, "")
+		p.NextVersion = next.Version
+		tp.nextConfig = next.Configuration
+		p.InstalledOnNew = false
+		p.BarrierReached1, p.BarrierReached2 = nil, nil
+		p.Pending = nil
+		p.Migration = make(map[common.RMId]*MigrationProgress)
+	}
+	if next.InstalledOnNew && !p.InstalledOnNew {
+		tp.event(next.Version, TopologyEventInstalledOnNew, common.RMIdEmpty, "")
+	}
+	p.InstalledOnNew = next.InstalledOnNew
+	for _, rmId := range next.BarrierReached1 {
+		if !containsRMId(p.BarrierReached1, rmId) {
+			tp.event(next.Version, TopologyEventBarrier1Reached, rmId, "")
+		}
+	}
+	for _, rmId := range next.BarrierReached2 {
+		if !containsRMId(p.BarrierReached2, rmId) {
+			tp.event(next.Version, TopologyEventBarrier2Reached, rmId, "")
+		}
+	}
+	pending := make(common.RMIds, 0, len(next.Pending))
+	for rmId := range next.Pending {
+		pending = append(pending, rmId)
+	}
+	// Pending is only recorded once barrier 2 has been reached, so
+	// don't report immigrations completed before then.
+	if len(p.Pending) != 0 {
+		for _, rmId := range p.Pending {
+			if _, found := next.Pending[rmId]; !found {
+				tp.event(next.Version, TopologyEventImmigrationCompleted, rmId, "")
+			}
+		}
+	}
+
+	p.BarrierReached1 = append(common.RMIds{}, next.BarrierReached1...)
+	p.BarrierReached2 = append(common.RMIds{}, next.BarrierReached2...)
+	p.Pending = pending
+	p.AwaitingBarrier1, p.AwaitingBarrier2 = common.RMIds{}, common.RMIds{}
+	for _, rmId := range next.RMs().NonEmpty() {
+		if !containsRMId(p.BarrierReached1, rmId) {
+			p.AwaitingBarrier1 = append(p.AwaitingBarrier1, rmId)
+		}
+		if !containsRMId(p.BarrierReached2, rmId) {
+			p.AwaitingBarrier2 = append(p.AwaitingBarrier2, rmId)
+		}
+	}
+	p.RemainingSteps = len(p.AwaitingBarrier1) + len(p.AwaitingBarrier2) + len(p.Pending)
+}
+
+// migration must be called with the lock held.
+func (tp *topologyProgress) migration(rmId common.RMId) *MigrationProgress {
+	mp, found := tp.progress.Migration[rmId]
+	if !found {
+		mp = &MigrationProgress{}
+		tp.progress.Migration[rmId] = mp
+	}
+	return mp
+}
+
+func (tp *topologyProgress) batchSent(version uint32, rmId common.RMId, txnCount int) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	if version != tp.progress.NextVersion {
+		return
+	}
+	mp := tp.migration(rmId)
+	mp.BatchesSent++
+	mp.TxnsSent += txnCount
+	tp.progress.LastProgress = time.Now()
+	migrationTxnsSent.Add(uint64(txnCount))
+}
+
+func (tp *topologyProgress) batchReceived(version uint32, rmId common.RMId, txnCount int) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	if version != tp.progress.NextVersion {
+		return
+	}
+	mp := tp.migration(rmId)
+	mp.BatchesReceived++
+	mp.TxnsReceived += txnCount
+	tp.progress.LastProgress = time.Now()
+	migrationTxnsReceived.Add(uint64(txnCount))
+}
+
+func (tp *topologyProgress) completeSent(version uint32, rmId common.RMId) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	if version != tp.progress.NextVersion {
+		return
+	}
+	tp.migration(rmId).CompleteSent = true
+	tp.event(version, TopologyEventMigrationCompleteSent, rmId, "")
+}
+
+func (tp *topologyProgress) completeReceived(version uint32, rmId common.RMId) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	if version != tp.progress.NextVersion {
+		return
+	}
+	tp.migration(rmId).CompleteReceived = true
+	tp.event(version, TopologyEventMigrationCompleteReceived, rmId, "")
+}
+
+// snapshot returns a copy of the progress, and of the events with
+// Seq greater than from.
+func (tp *topologyProgress) snapshot(from uint64) (*TopologyProgress, []*TopologyEvent) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	p := tp.progress
+	p.Migration = make(map[common.RMId]*MigrationProgress, len(tp.progress.Migration))
+	for rmId, mp := range tp.progress.Migration {
+		mpCopy := *mp
+		p.Migration[rmId] = &mpCopy
+	}
+	events := []*TopologyEvent{}
+	for _, event := range tp.events {
+		if event.Seq > from {
+			events = append(events, event)
+		}
+	}
+	return &p, events
+}
+
+func (tp *topologyProgress) status(sc *server.StatusConsumer) {
+	p, _ := tp.snapshot(0)
+	sc.Emit("Topology Change")
+	sc.EmitKV("Active Version", p.ActiveVersion)
+	if p.Task != "" {
+		sc.EmitKV("Task", p.Task)
+		sc.EmitKV("Task Started", p.TaskStarted)
+		sc.EmitKV("Target Version", p.TargetVersion)
+	}
+	if p.NextVersion != 0 {
+		sc.EmitKV("Next Version", p.NextVersion)
+		sc.EmitKV("Installed On New", p.InstalledOnNew)
+		sc.EmitKV("Awaiting Barrier1", p.AwaitingBarrier1)
+		sc.EmitKV("Awaiting Barrier2", p.AwaitingBarrier2)
+		sc.EmitKV("Pending", p.Pending)
+		sc.EmitKV("Remaining Steps", p.RemainingSteps)
+		for rmId, mp := range p.Migration {
+			sc.EmitKV(fmt.Sprintf("Migration %v", rmId), fmt.Sprintf("%+v", *mp))
+		}
+	}
+	if !p.LastProgress.IsZero() {
+		sc.EmitKV("Last Progress", p.LastProgress)
+	}
+	sc.Join()
+}
+
+func topologyTaskName(task topologyTask) string {
+	switch task.(type) {
+	case *ensureLocalTopology:
+		return "EnsureLocalTopology"
+	case *joinCluster:
+		return "JoinCluster"
+	case *installTargetOld:
+		return "InstallTargetOld"
+	case *installTargetNew:
+		return "InstallTargetNew"
+	case *awaitBarrier1:
+		return "AwaitBarrier1"
+	case *awaitBarrier2:
+		return "AwaitBarrier2"
+	case *migrate:
+		return "Migrate"
+	case *installCompletion:
+		return "InstallCompletion"
+	case *abortChange:
+		return "AbortChange"
+	default:
+		return fmt.Sprintf("%T", task)
+	}
+}
+
+func containsRMId(rmIds common.RMIds, rmId common.RMId) bool {
+	for _, r := range rmIds {
+		if r == rmId {
+			return true
+		}
+	}
+	return false
+}
+
+// Progress returns the progress of the current topology change, and
+// the events with Seq greater than from which are still retained.
+func (tt *TopologyTransmogrifier) Progress(from uint64) (*TopologyProgress, []*TopologyEvent) {
+	return tt.progress.snapshot(from)
+}
+
+func (tt *TopologyTransmogrifier) Status(sc *server.StatusConsumer) {
+	tt.progress.status(sc)
+}
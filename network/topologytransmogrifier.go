@@ -13,6 +13,7 @@ import (
 	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/client"
 	"goshawkdb.io/server/configuration"
+	ch "goshawkdb.io/server/consistenthash"
 	"goshawkdb.io/server/db"
 	"goshawkdb.io/server/paxos"
 	eng "goshawkdb.io/server/txnengine"
@@ -32,6 +33,7 @@ type TopologyTransmogrifier struct {
 	activeConnections    map[common.RMId]paxos.Connection
 	migrations           map[uint32]map[common.RMId]*int32
 	task                 topologyTask
+	progress             *topologyProgress
 	cellTail             *cc.ChanCellTail
 	enqueueQueryInner    func(topologyTransmogrifierMsg, *cc.ChanCell, cc.CurCellConsumer) (bool, cc.CurCellConsumer)
 	queryChan            <-chan topologyTransmogrifierMsg
@@ -121,6 +123,7 @@ func NewTopologyTransmogrifier(db *db.Databases, cm *ConnectionManager, lc *clie
 		connectionManager: cm,
 		localConnection:   lc,
 		migrations:        make(map[uint32]map[common.RMId]*int32),
+		progress:          newTopologyProgress(),
 		listenPort:        listenPort,
 		rng:               rand.New(rand.NewSource(time.Now().UnixNano())),
 		shutdownSignaller: ss,
@@ -178,12 +181,7 @@ func (tt *TopologyTransmogrifier) actorLoop(head *cc.ChanCellHead, config *confi
 		v.AddWriteSubscriber(configuration.VersionOne,
 			&eng.VarWriteSubscriber{
 				Observe: func(v *eng.Var, value []byte, refs *msgs.VarIdPos_List, txn *eng.Txn) {
-					var rootVarPosPtr *msgs.VarIdPos
-					if refs.Len() > 0 {
-						root := refs.At(0)
-						rootVarPosPtr = &root
-					}
-					topology, err := configuration.TopologyFromCap(txn.Id, rootVarPosPtr, value)
+					topology, err := configuration.TopologyFromCap(txn.Id, refs, value)
 					if err != nil {
 						panic(fmt.Errorf("Unable to deserialize new topology: %v", err))
 					}
@@ -288,6 +286,7 @@ func (tt *TopologyTransmogrifier) setActive(topology *configuration.Topology) er
 		return errors.New("We have been removed from the cluster. Shutting down.")
 	}
 	tt.active = topology
+	tt.progress.topologyObserved(topology)
 
 	if tt.task != nil {
 		if err := tt.task.tick(); err != nil {
@@ -368,6 +367,11 @@ func (tt *TopologyTransmogrifier) selectGoal(goal *configuration.NextConfigurati
 			log.Printf("Topology: Illegal config change: Currently changes to MaxRMCount are not supported, sorry.")
 			return
 
+		case len(tt.active.RootsRemoved(goal.Configuration)) != 0:
+			log.Printf("Topology: Illegal config change: Roots %v would be removed. Roots can not be removed or renamed.",
+				tt.active.RootsRemoved(goal.Configuration))
+			return
+
 		case goal.Version < tt.active.Version:
 			log.Printf("Topology: Ignoring config with version %v as newer version already active (%v).",
 				goal.Version, tt.active.Version)
@@ -399,6 +403,7 @@ func (tt *TopologyTransmogrifier) selectGoal(goal *configuration.NextConfigurati
 		default:
 			server.Log("Topology: Abandoning old task")
 			tt.task.abandon()
+			tt.progress.taskEnded(TopologyEventTaskAbandoned, nil)
 			tt.task = nil
 		}
 	}
@@ -457,11 +462,58 @@ func (tt *TopologyTransmogrifier) migrationReceived(migration topologyTransmogri
 		senders[sender] = inprogressPtr
 	}
 	txnCount := int32(migration.migration.Elems().Len())
-	lsc := tt.newTxnLSC(txnCount, inprogressPtr)
+	tt.progress.batchReceived(version, sender, int(txnCount))
+	history := migrationHistories(migration.migration)
+	if len(history) == 0 {
+		lsc := tt.newTxnLSC(txnCount, inprogressPtr)
+		tt.connectionManager.Dispatchers.ProposerDispatcher.ImmigrationReceived(migration.migration, lsc)
+		return nil
+	}
+	// Writing the history to disk must complete too before we
+	// consider the batch done.
+	lsc := tt.newTxnLSC(txnCount+1, inprogressPtr)
 	tt.connectionManager.Dispatchers.ProposerDispatcher.ImmigrationReceived(migration.migration, lsc)
+	tt.immigrateHistory(history, lsc)
 	return nil
 }
 
+func migrationHistories(migration *msgs.Migration) []msgs.MigrationHistory {
+	var result []msgs.MigrationHistory
+	elems := migration.Elems()
+	for idx, l := 0, elems.Len(); idx < l; idx++ {
+		result = append(result, elems.At(idx).History().ToArray()...)
+	}
+	return result
+}
+
+// immigrateHistory retains the past versions of vars which have moved
+// to us, along with the txns which wrote them.
+func (tt *TopologyTransmogrifier) immigrateHistory(history []msgs.MigrationHistory, lsc *migrationTxnLocalStateChange) {
+	future := tt.db.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
+		for _, historyCap := range history {
+			txnCap := historyCap.Txn()
+			varCap := historyCap.Var()
+			err := tt.db.ImmigrateVarHistory(rwtxn, common.MakeVarUUId(historyCap.VarId()), historyCap.ClockElem(),
+				time.Unix(0, int64(historyCap.SupersededAt())), db.VarToRootBytes(&varCap),
+				common.MakeTxnId(txnCap.Id()), db.TxnToRootBytes(&txnCap))
+			if err != nil {
+				return err
+			}
+		}
+		return true
+	})
+	go func() {
+		ran, err := future.ResultError()
+		if ranErr, ok := ran.(error); ok {
+			err = ranErr
+		}
+		if err != nil {
+			panic(fmt.Sprintf("Error when writing immigrated history to disk: %v", err))
+		}
+		lsc.completed()
+	}()
+}
+
 func (tt *TopologyTransmogrifier) migrationCompleteReceived(migrationComplete topologyTransmogrifierMsgMigrationComplete) error {
 	version := migrationComplete.complete.Version()
 	sender := migrationComplete.sender
@@ -475,6 +527,7 @@ func (tt *TopologyTransmogrifier) migrationCompleteReceived(migrationComplete to
 			return nil
 		}
 	}
+	tt.progress.completeReceived(version, sender)
 	inprogress := int32(0)
 	if inprogressPtr, found := senders[sender]; found {
 		inprogress = atomic.AddInt32(inprogressPtr, -1)
@@ -489,7 +542,7 @@ func (tt *TopologyTransmogrifier) migrationCompleteReceived(migrationComplete to
 	return nil
 }
 
-func (tt *TopologyTransmogrifier) newTxnLSC(txnCount int32, inprogressPtr *int32) eng.TxnLocalStateChange {
+func (tt *TopologyTransmogrifier) newTxnLSC(txnCount int32, inprogressPtr *int32) *migrationTxnLocalStateChange {
 	return &migrationTxnLocalStateChange{
 		TopologyTransmogrifier: tt,
 		pendingLocallyComplete: txnCount,
@@ -510,6 +563,10 @@ func (mtlsc *migrationTxnLocalStateChange) TxnBallotsComplete(*eng.Txn, ...*eng.
 // Careful: we're in the proposer dispatcher go routine here!
 func (mtlsc *migrationTxnLocalStateChange) TxnLocallyComplete(txn *eng.Txn) {
 	txn.CompletionReceived()
+	mtlsc.completed()
+}
+
+func (mtlsc *migrationTxnLocalStateChange) completed() {
 	if atomic.AddInt32(&mtlsc.pendingLocallyComplete, -1) == 0 &&
 		atomic.AddInt32(mtlsc.inprogressPtr, -1) == 0 {
 		mtlsc.enqueueQuery(topologyTransmogrifierMsgExe(func() error {
@@ -580,6 +637,7 @@ func (task *targetConfig) tick() error {
 		return fmt.Errorf("Topology: Confused about what to do. Active topology is: %v; goal is %v",
 			task.active, task.config)
 	}
+	task.progress.taskStarted(task.task, task.config.Version)
 	return nil
 }
 
@@ -608,6 +666,7 @@ func (task *targetConfig) witness() topologyTask                  { return task
 func (task *targetConfig) fatal(err error) error {
 	task.ensureRemoveTaskSender()
 	task.task = nil
+	task.progress.taskEnded(TopologyEventTaskFailed, err)
 	log.Printf("Topology: fatal error: %v", err)
 	return err
 }
@@ -615,6 +674,7 @@ func (task *targetConfig) fatal(err error) error {
 func (task *targetConfig) error(err error) error {
 	task.ensureRemoveTaskSender()
 	task.task = nil
+	task.progress.taskEnded(TopologyEventTaskFailed, err)
 	log.Printf("Topology: error: %v", err)
 	return nil
 }
@@ -623,6 +683,7 @@ func (task *targetConfig) completed() error {
 	task.ensureRemoveTaskSender()
 	log.Printf("Topology: task completed.")
 	task.task = nil
+	task.progress.taskEnded(TopologyEventTaskCompleted, nil)
 	return nil
 }
 
@@ -831,14 +892,14 @@ func (task *joinCluster) allJoining(allRMIds common.RMIds) error {
 	// txn's topology version is acceptable to our proposers.
 	task.installTopology(activeWithNext, nil)
 
-	switch resubmit, err := task.attemptCreateRoot(targetTopology); {
+	switch resubmit, err := task.attemptCreateRoots(targetTopology); {
 	case err != nil:
 		return task.fatal(err)
 	case resubmit:
 		server.Log("Topology: Root creation needs resubmit")
 		task.enqueueTick(task)
 		return nil
-	case targetTopology.Root.VarUUId == nil:
+	case len(targetTopology.MissingRoots()) != 0:
 		// We failed; likely we need to wait for connections to change
 		server.Log("Topology: Root creation failed")
 		return nil
@@ -852,10 +913,11 @@ func (task *joinCluster) allJoining(allRMIds common.RMIds) error {
 	// sure that all peers are empty and moving to the same topology
 	// is to have all peers as active.
 
-	// If we got this far then attemptCreateRoot will have modified
-	// targetTopology to include the updated root. We should install
+	// If we got this far then attemptCreateRoots will have modified
+	// targetTopology to include the updated roots. We should install
 	// this to the connectionManager.
 	activeWithNext.Root = targetTopology.Root
+	activeWithNext.Roots = targetTopology.Roots
 	task.installTopology(activeWithNext, nil)
 
 	result, resubmit, err := task.rewriteTopology(task.active, targetTopology, allRMIds, nil)
@@ -886,6 +948,9 @@ type installTargetOld struct {
 func (task *installTargetOld) tick() error {
 	if next := task.active.Next(); !(next == nil || next.Version < task.config.Version) {
 		return task.completed()
+	} else if task.active.Version >= task.config.Version {
+		// The change has been aborted.
+		return task.completed()
 	}
 
 	if !task.isInRMs(task.active.RMs()) {
@@ -937,79 +1002,126 @@ func (task *installTargetOld) calculateTargetTopology() (*configuration.Topology
 		return nil, task.fatal(err)
 	}
 
-	hostsSurvived, hostsRemoved, hostsAdded :=
-		make(map[string]common.RMId),
-		make(map[string]common.RMId),
-		make(map[string]paxos.Connection)
+	change := newTopologyChange(task.active, task.config.Configuration, localHost)
+
+	task.installTopology(task.active, nil)
+	task.connectionManager.SetDesiredServers(localHost, change.allRemoteHosts)
+
+	allAddedFound, err := task.verifyRoots(task.active.Root.VarUUId, change.hostsAddedList())
+	if err != nil {
+		return nil, task.error(err)
+	} else if !allAddedFound {
+		return nil, nil
+	}
+
+	next := change.next(func(host string) (common.RMId, bool) {
+		if cd, found := task.hostToConnection[host]; found {
+			return cd.RMId(), true
+		}
+		return common.RMIdEmpty, false
+	})
+	if next == nil {
+		return nil, nil
+	}
+	targetTopology := task.active.Clone()
+	targetTopology.SetNext(next)
+	return targetTopology, nil
+}
+
+// topologyChange works out how the hosts of the active topology
+// change to reach the target configuration. It has no side effects,
+// so it can be used for planning too.
+type topologyChange struct {
+	active         *configuration.Topology
+	config         *configuration.Configuration
+	localHost      string
+	hostsSurvived  map[string]common.RMId
+	hostsRemoved   map[string]common.RMId
+	hostsAdded     map[string]common.RMId
+	allRemoteHosts []string
+}
 
-	allRemoteHosts := make([]string, 0, len(task.active.Hosts)+len(task.config.Hosts))
+func newTopologyChange(active *configuration.Topology, config *configuration.Configuration, localHost string) *topologyChange {
+	tc := &topologyChange{
+		active:         active,
+		config:         config,
+		localHost:      localHost,
+		hostsSurvived:  make(map[string]common.RMId),
+		hostsRemoved:   make(map[string]common.RMId),
+		hostsAdded:     make(map[string]common.RMId),
+		allRemoteHosts: make([]string, 0, len(active.Hosts)+len(config.Hosts)),
+	}
 
 	// 1. Start by assuming all old hosts have been removed
-	rmIdsOld := task.active.RMs().NonEmpty()
+	rmIdsOld := active.RMs().NonEmpty()
 	// rely on hosts and rms being in the same order.
-	hostsOld := task.active.Hosts
-	for idx, host := range hostsOld {
-		hostsRemoved[host] = rmIdsOld[idx]
+	for idx, host := range active.Hosts {
+		tc.hostsRemoved[host] = rmIdsOld[idx]
 		if host != localHost {
-			allRemoteHosts = append(allRemoteHosts, host)
+			tc.allRemoteHosts = append(tc.allRemoteHosts, host)
 		}
 	}
 
 	// 2. For each new host, if it is in the removed set, it's
 	// "survived". Else it's new. Don't care about correcting
 	// hostsRemoved.
-	for _, host := range task.config.Hosts {
-		if rmId, found := hostsRemoved[host]; found {
-			hostsSurvived[host] = rmId
+	for _, host := range config.Hosts {
+		if rmId, found := tc.hostsRemoved[host]; found {
+			tc.hostsSurvived[host] = rmId
 		} else {
-			hostsAdded[host] = nil
+			tc.hostsAdded[host] = common.RMIdEmpty
 			if host != localHost {
-				allRemoteHosts = append(allRemoteHosts, host)
+				tc.allRemoteHosts = append(tc.allRemoteHosts, host)
 			}
 		}
 	}
+	return tc
+}
 
-	task.installTopology(task.active, nil)
-	task.connectionManager.SetDesiredServers(localHost, allRemoteHosts)
-
+func (tc *topologyChange) hostsAddedList() []string {
 	// the -1 is because allRemoteHosts will not include localHost
-	hostsAddedList := allRemoteHosts[len(hostsOld)-1:]
-	allAddedFound, err := task.verifyRoots(task.active.Root.VarUUId, hostsAddedList)
-	if err != nil {
-		return nil, task.error(err)
-	} else if !allAddedFound {
-		return nil, nil
-	}
+	return tc.allRemoteHosts[len(tc.active.Hosts)-1:]
+}
+
+// next calculates the target configuration, using rmIdOf to find the
+// current RMId of each host. It returns nil if the RMId of any added
+// host is not known.
+func (tc *topologyChange) next(rmIdOf func(host string) (common.RMId, bool)) *configuration.NextConfiguration {
+	active := tc.active
+	hostsOld := active.Hosts
+	rmIdsOld := active.RMs().NonEmpty()
+	hostsAdded := make(map[string]common.RMId, len(tc.hostsAdded))
 
 	// map(old -> new)
 	rmIdsTranslation := make(map[common.RMId]common.RMId)
-	connsAdded := make([]paxos.Connection, 0, len(hostsAdded))
-	rmIdsSurvived := make([]common.RMId, 0, len(hostsSurvived))
-	rmIdsLost := make([]common.RMId, 0, len(hostsRemoved))
+	rmsAdded := make([]hostRMId, 0, len(tc.hostsAdded))
+	rmIdsSurvived := make([]common.RMId, 0, len(tc.hostsSurvived))
+	rmIdsLost := make([]common.RMId, 0, len(tc.hostsRemoved))
 
 	// 3. Assume all old RMIds have been removed (so map to RMIdEmpty)
 	for _, rmId := range rmIdsOld {
 		rmIdsTranslation[rmId] = common.RMIdEmpty
 	}
-	// 4. All new hosts must have new RMIds, and we must be connected
-	// to them.
-	for host := range hostsAdded {
-		cd, found := task.hostToConnection[host]
+	// 4. All new hosts must have new RMIds, and we must know them.
+	for _, host := range tc.config.Hosts {
+		if _, found := tc.hostsAdded[host]; !found {
+			continue
+		}
+		rmId, found := rmIdOf(host)
 		if !found {
-			return nil, nil
+			return nil
 		}
-		hostsAdded[host] = cd
-		connsAdded = append(connsAdded, cd)
+		hostsAdded[host] = rmId
+		rmsAdded = append(rmsAdded, hostRMId{host: host, rmId: rmId})
 	}
 	// 5. Problem is that hostsAdded may be missing entries for hosts
 	// that have been wiped and thus changed RMId
-	for host, rmIdOld := range hostsSurvived {
-		cd, found := task.hostToConnection[host]
-		if found && rmIdOld != cd.RMId() {
+	for host, rmIdOld := range tc.hostsSurvived {
+		rmIdNew, found := rmIdOf(host)
+		if found && rmIdOld != rmIdNew {
 			// We have evidence the RMId has changed!
-			rmIdNew := cd.RMId()
 			rmIdsTranslation[rmIdOld] = rmIdNew
-			hostsAdded[host] = cd
+			hostsAdded[host] = rmIdNew
 		} else {
 			// No evidence it's changed RMId, so it maps to itself.
 			rmIdsTranslation[rmIdOld] = rmIdOld
@@ -1017,21 +1129,21 @@ func (task *installTargetOld) calculateTargetTopology() (*configuration.Topology
 		}
 	}
 
-	connsAddedCopy := connsAdded
+	rmsAddedCopy := rmsAdded
 
 	// Now construct the new RMId list.
-	rmIdsNew := make([]common.RMId, 0, len(allRemoteHosts)+1)
-	hostsNew := make([]string, 0, len(allRemoteHosts)+1)
+	rmIdsNew := make([]common.RMId, 0, len(tc.allRemoteHosts)+1)
+	hostsNew := make([]string, 0, len(tc.allRemoteHosts)+1)
 	hostIdx := 0
-	for _, rmIdOld := range task.active.RMs() { // need the gaps!
+	for _, rmIdOld := range active.RMs() { // need the gaps!
 		rmIdNew := rmIdsTranslation[rmIdOld]
 		switch {
-		case rmIdNew == common.RMIdEmpty && len(connsAddedCopy) > 0:
-			cd := connsAddedCopy[0]
-			connsAddedCopy = connsAddedCopy[1:]
-			rmIdNew = cd.RMId()
+		case rmIdNew == common.RMIdEmpty && len(rmsAddedCopy) > 0:
+			added := rmsAddedCopy[0]
+			rmsAddedCopy = rmsAddedCopy[1:]
+			rmIdNew = added.rmId
 			rmIdsNew = append(rmIdsNew, rmIdNew)
-			hostsNew = append(hostsNew, cd.Host())
+			hostsNew = append(hostsNew, added.host)
 			if rmIdOld != common.RMIdEmpty {
 				hostIdx++
 				rmIdsLost = append(rmIdsLost, rmIdOld)
@@ -1049,7 +1161,7 @@ func (task *installTargetOld) calculateTargetTopology() (*configuration.Topology
 			hostsNew = append(hostsNew, host)
 			hostIdx++
 			rmIdsLost = append(rmIdsLost, rmIdOld)
-			connsAdded = append(connsAdded, hostsAdded[host]) // will not affect connsAddedCopy
+			rmsAdded = append(rmsAdded, hostRMId{host: host, rmId: hostsAdded[host]}) // will not affect rmsAddedCopy
 		default:
 			rmIdsNew = append(rmIdsNew, rmIdNew)
 			hostsNew = append(hostsNew, hostsOld[hostIdx])
@@ -1058,20 +1170,18 @@ func (task *installTargetOld) calculateTargetTopology() (*configuration.Topology
 	}
 	// Finally, we may still have some new RMIds we never found space
 	// for.
-	for _, cd := range connsAddedCopy {
-		rmIdsNew = append(rmIdsNew, cd.RMId())
-		hostsNew = append(hostsNew, cd.Host())
+	for _, added := range rmsAddedCopy {
+		rmIdsNew = append(rmIdsNew, added.rmId)
+		hostsNew = append(hostsNew, added.host)
 	}
 
-	targetTopology := task.active.Clone()
-	next := task.config.Configuration.Clone()
+	next := tc.config.Clone()
 	next.SetRMs(rmIdsNew)
 	next.Hosts = hostsNew
 
 	// Pointer semantics, so we need to copy into our new set
 	removed := make(map[common.RMId]server.EmptyStruct)
-	alreadyRemoved := targetTopology.RMsRemoved()
-	for rmId := range alreadyRemoved {
+	for rmId := range active.RMsRemoved() {
 		removed[rmId] = server.EmptyStructVal
 	}
 	for _, rmId := range rmIdsLost {
@@ -1079,23 +1189,28 @@ func (task *installTargetOld) calculateTargetTopology() (*configuration.Topology
 	}
 	next.SetRMsRemoved(removed)
 
-	rmIdsAdded := make([]common.RMId, len(connsAdded))
-	for idx, cd := range connsAdded {
-		rmIdsAdded[idx] = cd.RMId()
+	rmIdsAdded := make([]common.RMId, len(rmsAdded))
+	for idx, added := range rmsAdded {
+		rmIdsAdded[idx] = added.rmId
 	}
-	conds := calculateMigrationConditions(rmIdsAdded, rmIdsLost, rmIdsSurvived, task.active.Configuration, next)
+	conds := calculateMigrationConditions(rmIdsAdded, rmIdsLost, rmIdsSurvived, active.Configuration, next)
 
-	targetTopology.SetNext(&configuration.NextConfiguration{
+	allHosts := make([]string, len(tc.allRemoteHosts), len(tc.allRemoteHosts)+1)
+	copy(allHosts, tc.allRemoteHosts)
+	return &configuration.NextConfiguration{
 		Configuration:  next,
-		AllHosts:       append(allRemoteHosts, localHost),
+		AllHosts:       append(allHosts, tc.localHost),
 		NewRMIds:       rmIdsAdded,
 		SurvivingRMIds: rmIdsSurvived,
 		LostRMIds:      rmIdsLost,
 		InstalledOnNew: len(rmIdsAdded) == 0,
 		Pending:        conds,
-	})
+	}
+}
 
-	return targetTopology, nil
+type hostRMId struct {
+	host string
+	rmId common.RMId
 }
 
 func calculateMigrationConditions(added, lost, survived []common.RMId, from, to *configuration.Configuration) configuration.Conds {
@@ -1111,7 +1226,11 @@ func calculateMigrationConditions(added, lost, survived []common.RMId, from, to
 	}
 
 	if int(twoFIncOld) < from.RMs().NonEmptyLen() {
-		if from.F < to.F || len(lost) > len(added) {
+		// With zones or weights, any change can move a var onto a
+		// surviving RM.
+		zoned := len(from.Zones) != 0 || len(to.Zones) != 0
+		weighted := len(from.Weights) != 0 || len(to.Weights) != 0
+		if from.F < to.F || len(lost) > len(added) || zoned || weighted {
 			for _, rmId := range survived {
 				conditions.DisjoinWith(rmId, &configuration.Conjunction{
 					Left: &configuration.Generator{
@@ -1547,6 +1666,94 @@ func (task *installCompletion) tick() error {
 	topology := task.active.Clone()
 	topology.SetConfiguration(next.Configuration)
 
+	// Roots newly named by the configuration are created under the
+	// new topology, just before it becomes active.
+	switch resubmit, err := task.attemptCreateRoots(topology); {
+	case err != nil:
+		return task.fatal(err)
+	case resubmit:
+		task.enqueueTick(task)
+		return nil
+	case len(topology.MissingRoots()) != 0:
+		log.Printf("Topology: Can not create roots %v at this time due to failures.", topology.MissingRoots())
+		return nil
+	}
+
+	_, resubmit, err := task.rewriteTopology(task.active, topology, active, passive)
+	if err != nil {
+		return task.fatal(err)
+	}
+	if resubmit {
+		task.enqueueTick(task)
+		return nil
+	}
+	// Must be badread, which means again we should receive the
+	// updated topology through the subscriber.
+	return nil
+}
+
+// abortChange
+
+// abortChange reverts the active topology to the configuration it had
+// before the change began. The change may not yet have been adopted
+// by the topology at all (e.g. if an added host has never come up),
+// or it may have been adopted but no RM has yet reached barrier 1:
+// after that, txns may have been started under the next topology, so
+// it's no longer safe to go back. The reverted configuration takes
+// the version of the aborted change, so that the goal of the change,
+// which other RMs may still be sharing, is seen as already reached
+// and dropped. The new RMs of an adopted change are marked as
+// removed, so they take no further part and must be wiped before
+// they can be added again.
+type abortChange struct {
+	*targetConfig
+}
+
+func (task *abortChange) witness() topologyTask { return task }
+
+func (task *abortChange) tick() error {
+	if task.active.Version >= task.config.Version {
+		log.Printf("Topology: Change to version %v no longer in progress.", task.config.Version)
+		return task.completed()
+	}
+	next := task.active.Next()
+	if next != nil {
+		if next.Version != task.config.Version {
+			return task.error(fmt.Errorf("Change to version %v has been superseded by a change to version %v.",
+				task.config.Version, next.Version))
+		} else if err := abortable(next); err != nil {
+			return task.error(err)
+		}
+	}
+
+	active, passive := task.partitionByActiveConnection(task.active.RMs())
+	if len(active) <= len(passive) {
+		log.Printf("Topology: Can not make progress at this time due to too many failures (failures: %v)",
+			passive)
+		return nil
+	}
+	fInc := ((len(active) + len(passive)) >> 1) + 1
+	active, passive = active[:fInc], append(active[fInc:], passive...)
+
+	config := task.active.Configuration.Clone()
+	config.SetNext(nil)
+	config.Version = task.config.Version
+	if next != nil {
+		passive = append(passive, next.NewRMIds...)
+		removed := make(map[common.RMId]server.EmptyStruct)
+		for rmId := range config.RMsRemoved() {
+			removed[rmId] = server.EmptyStructVal
+		}
+		for _, rmId := range next.NewRMIds {
+			removed[rmId] = server.EmptyStructVal
+		}
+		config.SetRMsRemoved(removed)
+	}
+	topology := task.active.Clone()
+	topology.SetConfiguration(config)
+
+	log.Printf("Topology: Aborting change to version %v. Active: %v, Passive: %v", task.config.Version, active, passive)
+
 	_, resubmit, err := task.rewriteTopology(task.active, topology, active, passive)
 	if err != nil {
 		return task.fatal(err)
@@ -1560,6 +1767,83 @@ func (task *installCompletion) tick() error {
 	return nil
 }
 
+// abortable returns an error explaining why the change can no longer
+// be aborted, if it can't.
+func abortable(next *configuration.NextConfiguration) error {
+	switch {
+	case len(next.BarrierReached1) != 0:
+		return fmt.Errorf("Change to version %v can no longer be aborted: barrier 1 has been reached by %v.",
+			next.Version, next.BarrierReached1)
+	default:
+		return nil
+	}
+}
+
+// AbortConfigurationChange starts to abort the configuration change
+// in progress. It returns the version of the change being aborted,
+// or an error explaining why it can't be aborted. The abort is
+// complete once the progress shows the change aborted.
+func (tt *TopologyTransmogrifier) AbortConfigurationChange() (uint32, error) {
+	type abortResult struct {
+		version uint32
+		err     error
+	}
+	resultChan := make(chan abortResult, 1)
+	enqueued := tt.enqueueQuery(topologyTransmogrifierMsgExe(func() error {
+		version, err := tt.abortConfigurationChange()
+		resultChan <- abortResult{version: version, err: err}
+		return nil
+	}))
+	if !enqueued {
+		return 0, errors.New("Topology: shutting down.")
+	}
+
+	select {
+	case result := <-resultChan:
+		return result.version, result.err
+	case <-time.After(server.AdminStatusTimeout):
+		return 0, errors.New("Topology: timed out requesting abort.")
+	}
+}
+
+func (tt *TopologyTransmogrifier) abortConfigurationChange() (uint32, error) {
+	if tt.active.IsBlank() {
+		return 0, errors.New("The cluster has not yet formed, so there is no previous configuration to revert to.")
+	}
+	goal := tt.active.Next()
+	if goal != nil {
+		if err := abortable(goal); err != nil {
+			return 0, err
+		}
+	} else if tt.task != nil && tt.task.goal().Version > tt.active.Version {
+		goal = tt.task.goal()
+	} else {
+		return 0, fmt.Errorf("No configuration change is in progress: the active topology is at version %v.",
+			tt.active.Version)
+	}
+	if _, found := tt.task.(*abortChange); found {
+		return goal.Version, nil
+	}
+	task := &abortChange{
+		targetConfig: &targetConfig{
+			TopologyTransmogrifier: tt,
+			config:                 goal,
+		},
+	}
+	if !task.isInRMs(tt.active.RMs()) {
+		return 0, fmt.Errorf("Change to version %v must be aborted by an RM of the active topology (%v).",
+			goal.Version, tt.active.RMs().NonEmpty())
+	}
+	if tt.task != nil {
+		tt.task.abandon()
+		tt.progress.taskEnded(TopologyEventTaskAbandoned, nil)
+	}
+	log.Printf("Topology: Abort of change to version %v requested.", goal.Version)
+	tt.task = task
+	tt.progress.taskStarted(task, goal.Version)
+	return goal.Version, nil
+}
+
 // utils
 
 func (task *targetConfig) createTopologyTransaction(read, write *configuration.Topology, active, passive common.RMIds) *msgs.Txn {
@@ -1603,11 +1887,7 @@ func (task *targetConfig) createTopologyTransaction(read, write *configuration.T
 		rw := action.Readwrite()
 		rw.SetVersion(read.DBVersion[:])
 		rw.SetValue(write.Serialize())
-		refs := msgs.NewVarIdPosList(seg, 1)
-		rw.SetReferences(refs)
-		varIdPos := refs.At(0)
-		varIdPos.SetId(write.Root.VarUUId[:])
-		varIdPos.SetPositions((capn.UInt8List)(*write.Root.Positions))
+		rw.SetReferences(write.RootsToSeg(seg))
 	}
 
 	allocs := msgs.NewAllocationList(seg, len(active)+len(passive))
@@ -1686,12 +1966,8 @@ func (task *targetConfig) getTopologyFromLocalDatabase() (*configuration.Topolog
 			return nil, fmt.Errorf("Internal error: read of topology version 0 gave non-write action")
 		}
 		write := updateAction.Write()
-		var rootPtr *msgs.VarIdPos
-		if refs := write.References(); refs.Len() == 1 {
-			root := refs.At(0)
-			rootPtr = &root
-		}
-		return configuration.TopologyFromCap(dbversion, rootPtr, write.Value())
+		refs := write.References()
+		return configuration.TopologyFromCap(dbversion, &refs, write.Value())
 	}
 }
 
@@ -1760,57 +2036,71 @@ func (task *targetConfig) rewriteTopology(read, write *configuration.Topology, a
 			fmt.Errorf("Internal error: update action from readwrite of topology gave non-write action!")
 	}
 	writeAction := updateAction.Write()
-	var rootVarPos *msgs.VarIdPos
-	if refs := writeAction.References(); refs.Len() == 1 {
-		root := refs.At(0)
-		rootVarPos = &root
-	} else if refs.Len() > 1 {
-		return nil, false,
-			fmt.Errorf("Internal error: update action from readwrite of topology has %v references instead of 1!",
-				refs.Len())
-	}
-	topology, err := configuration.TopologyFromCap(dbversion, rootVarPos, writeAction.Value())
+	refs := writeAction.References()
+	topology, err := configuration.TopologyFromCap(dbversion, &refs, writeAction.Value())
 	if err != nil {
 		return nil, false, err
 	}
 	return topology, false, nil
 }
 
-func (task *targetConfig) attemptCreateRoot(topology *configuration.Topology) (bool, error) {
+// attemptCreateRoots creates, in one txn, every root of the topology
+// which does not yet exist. On commit, the roots are added to the
+// topology.
+func (task *targetConfig) attemptCreateRoots(topology *configuration.Topology) (bool, error) {
+	missing := topology.MissingRoots()
+	if len(missing) == 0 {
+		return false, nil
+	}
 	twoFInc, fInc, f := int(topology.TwoFInc), int(topology.FInc), int(topology.F)
 	active := make([]common.RMId, fInc)
 	passive := make([]common.RMId, f)
-	// this is valid only because root's positions are hardcoded
 	nonEmpties := topology.RMs().NonEmpty()
 	for _, rmId := range nonEmpties {
 		if _, found := task.activeConnections[rmId]; !found {
 			return false, nil
 		}
 	}
-	copy(active, nonEmpties[:fInc])
-	nonEmpties = nonEmpties[fInc:]
-	copy(passive, nonEmpties[:f])
+	// roots' positions are hardcoded
+	rootPositions := make([]uint8, int(topology.MaxRMCount))
+	for idx := range rootPositions {
+		rootPositions[idx] = uint8(idx)
+	}
+	resolver := ch.NewResolver(topology.RMs(), topology.TwoFInc, topology.RMZones(), topology.RMWeights())
+	rootRMIds, err := resolver.ResolveHashCodes(rootPositions)
+	if err != nil {
+		return false, err
+	}
+	copy(active, rootRMIds[:fInc])
+	copy(passive, rootRMIds[fInc:fInc+f])
 
-	server.Log("Topology: Creating Root. Actives:", active, "; Passives:", passive)
+	server.Log("Topology: Creating Roots", missing, ". Actives:", active, "; Passives:", passive)
 
 	seg := capn.NewBuffer(nil)
 	txn := msgs.NewTxn(seg)
 	txn.SetSubmitter(uint32(task.connectionManager.RMId))
 	txn.SetSubmitterBootCount(task.connectionManager.BootCount)
-	actions := msgs.NewActionList(seg, 1)
+	actions := msgs.NewActionList(seg, len(missing))
 	txn.SetActions(actions)
-	action := actions.At(0)
-	vUUId := task.localConnection.NextVarUUId()
-	action.SetVarId(vUUId[:])
-	action.SetCreate()
-	create := action.Create()
-	positions := seg.NewUInt8List(int(topology.MaxRMCount))
-	create.SetPositions(positions)
-	for idx, l := 0, positions.Len(); idx < l; idx++ {
-		positions.Set(idx, uint8(idx))
-	}
-	create.SetValue([]byte{})
-	create.SetReferences(msgs.NewVarIdPosList(seg, 0))
+	roots := make([]configuration.Root, len(missing))
+	for idx := range missing {
+		action := actions.At(idx)
+		vUUId := task.localConnection.NextVarUUId()
+		action.SetVarId(vUUId[:])
+		action.SetCreate()
+		create := action.Create()
+		positions := seg.NewUInt8List(len(rootPositions))
+		create.SetPositions(positions)
+		for idy, position := range rootPositions {
+			positions.Set(idy, position)
+		}
+		create.SetValue([]byte{})
+		create.SetReferences(msgs.NewVarIdPosList(seg, 0))
+		roots[idx] = configuration.Root{
+			VarUUId:   vUUId,
+			Positions: (*common.Positions)(&positions),
+		}
+	}
 	allocs := msgs.NewAllocationList(seg, twoFInc)
 	txn.SetAllocations(allocs)
 	offset := 0
@@ -1823,9 +2113,11 @@ func (task *targetConfig) attemptCreateRoot(topology *configuration.Topology) (b
 			} else {
 				alloc.SetActive(0)
 			}
-			indices := seg.NewUInt16List(1)
+			indices := seg.NewUInt16List(len(missing))
 			alloc.SetActionIndices(indices)
-			indices.Set(0, 0)
+			for idz := range missing {
+				indices.Set(idz, uint16(idz))
+			}
 		}
 		offset += len(rmIds)
 	}
@@ -1839,16 +2131,17 @@ func (task *targetConfig) attemptCreateRoot(topology *configuration.Topology) (b
 		return false, nil
 	}
 	if result.Which() == msgs.OUTCOME_COMMIT {
-		server.Log("Topology: Root created in", vUUId)
-		topology.Root.VarUUId = vUUId
-		topology.Root.Positions = (*common.Positions)(&positions)
+		for idx, name := range missing {
+			server.Log("Topology: Root", name, "created in", roots[idx].VarUUId)
+			topology.SetRoot(name, roots[idx])
+		}
 		return false, nil
 	}
 	abort := result.Abort()
 	if abort.Which() == msgs.OUTCOMEABORT_RESUBMIT {
 		return true, nil
 	}
-	return false, fmt.Errorf("Internal error: creation of root gave rerun outcome")
+	return false, fmt.Errorf("Internal error: creation of roots gave rerun outcome")
 }
 
 // emigrator
@@ -1860,6 +2153,7 @@ type emigrator struct {
 	activeBatches     map[common.RMId]*sendBatch
 	topology          *configuration.Topology
 	conns             map[common.RMId]paxos.Connection
+	progress          *topologyProgress
 }
 
 func newEmigrator(task *migrate) *emigrator {
@@ -1867,6 +2161,7 @@ func newEmigrator(task *migrate) *emigrator {
 		db:                task.db,
 		connectionManager: task.connectionManager,
 		activeBatches:     make(map[common.RMId]*sendBatch),
+		progress:          task.progress,
 	}
 	e.topology = e.connectionManager.AddTopologySubscriber(eng.EmigratorSubscriber, e)
 	e.connectionManager.AddServerConnectionSubscriber(e)
@@ -1985,7 +2280,12 @@ func (it *dbIterator) iterate() {
 						cursor.Error(err)
 						return true
 					} else if len(matchingVarCaps) != 0 {
-						sb.add(&txnCap, matchingVarCaps)
+						history, err := it.varsHistory(cursor.RTxn, matchingVarCaps)
+						if err != nil {
+							cursor.Error(err)
+							return true
+						}
+						sb.add(&txnCap, matchingVarCaps, history)
 					}
 				}
 			}
@@ -2062,6 +2362,37 @@ func (it *dbIterator) matchVarsAgainstCond(cond configuration.Cond, varCaps []*m
 	return result, nil
 }
 
+// varsHistory returns the past versions of the vars which are
+// retained on disk, so they move along with the vars.
+func (it *dbIterator) varsHistory(rtxn *mdbs.RTxn, varCaps []*msgs.Var) ([]*migrationHistory, error) {
+	var result []*migrationHistory
+	for _, varCap := range varCaps {
+		vUUId := common.MakeVarUUId(varCap.Id())
+		entries, err := it.db.ReadVarHistory(rtxn, vUUId)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			txnId := common.MakeTxnId(entry.Var.WriteTxnId())
+			txnBytes := it.db.ReadTxnBytesFromDisk(rtxn, txnId)
+			if txnBytes == nil {
+				return nil, fmt.Errorf("Unable to find txn %v which wrote a past version of %v", txnId, vUUId)
+			}
+			seg, _, err := capn.ReadFromMemoryZeroCopy(txnBytes)
+			if err != nil {
+				return nil, err
+			}
+			txnCap := msgs.ReadRootTxn(seg)
+			result = append(result, &migrationHistory{
+				vUUId: vUUId,
+				entry: entry,
+				txn:   &txnCap,
+			})
+		}
+	}
+	return result, nil
+}
+
 func (it *dbIterator) ConnectedRMs(conns map[common.RMId]paxos.Connection) {
 	defer it.connectionManager.RemoveServerConnectionSubscriber(it)
 
@@ -2086,6 +2417,7 @@ func (it *dbIterator) ConnectedRMs(conns map[common.RMId]paxos.Connection) {
 			// necessary tidying up.
 			server.Log("Topology: Sending migration completion to", conn.RMId())
 			conn.Send(bites)
+			it.progress.completeSent(it.topology.Next().Version, conn.RMId())
 		}
 	}
 }
@@ -2094,23 +2426,32 @@ func (it *dbIterator) ConnectionEstablished(common.RMId, paxos.Connection, map[c
 }
 
 type sendBatch struct {
-	version uint32
-	conn    paxos.Connection
-	cond    configuration.Cond
-	elems   []*migrationElem
+	version  uint32
+	conn     paxos.Connection
+	cond     configuration.Cond
+	elems    []*migrationElem
+	progress *topologyProgress
 }
 
 type migrationElem struct {
-	txn  *msgs.Txn
-	vars []*msgs.Var
+	txn     *msgs.Txn
+	vars    []*msgs.Var
+	history []*migrationHistory
+}
+
+type migrationHistory struct {
+	vUUId *common.VarUUId
+	entry *db.VarHistoryEntry
+	txn   *msgs.Txn
 }
 
 func (e *emigrator) newBatch(conn paxos.Connection, cond configuration.Cond) *sendBatch {
 	return &sendBatch{
-		version: e.topology.Next().Version,
-		conn:    conn,
-		cond:    cond,
-		elems:   make([]*migrationElem, 0, server.MigrationBatchElemCount),
+		version:  e.topology.Next().Version,
+		conn:     conn,
+		cond:     cond,
+		elems:    make([]*migrationElem, 0, server.MigrationBatchElemCount),
+		progress: e.progress,
 	}
 }
 
@@ -2131,6 +2472,17 @@ func (sb *sendBatch) flush() {
 			vars.Set(idy, *varCap)
 		}
 		elemCap.SetVars(vars)
+		history := msgs.NewMigrationHistoryList(seg, len(elem.history))
+		for idy, mh := range elem.history {
+			historyCap := msgs.NewMigrationHistory(seg)
+			historyCap.SetVarId(mh.vUUId[:])
+			historyCap.SetClockElem(mh.entry.ClockElem)
+			historyCap.SetSupersededAt(uint64(mh.entry.SupersededAt.UnixNano()))
+			historyCap.SetVar(*mh.entry.Var)
+			historyCap.SetTxn(*mh.txn)
+			history.Set(idy, historyCap)
+		}
+		elemCap.SetHistory(history)
 		elems.Set(idx, elemCap)
 	}
 	migration.SetElems(elems)
@@ -2138,13 +2490,15 @@ func (sb *sendBatch) flush() {
 	bites := server.SegToBytes(seg)
 	server.Log("Topology: Migrating", len(sb.elems), "txns to", sb.conn.RMId())
 	sb.conn.Send(bites)
+	sb.progress.batchSent(sb.version, sb.conn.RMId(), len(sb.elems))
 	sb.elems = sb.elems[:0]
 }
 
-func (sb *sendBatch) add(txnCap *msgs.Txn, varCaps []*msgs.Var) {
+func (sb *sendBatch) add(txnCap *msgs.Txn, varCaps []*msgs.Var, history []*migrationHistory) {
 	elem := &migrationElem{
-		txn:  txnCap,
-		vars: varCaps,
+		txn:     txnCap,
+		vars:    varCaps,
+		history: history,
 	}
 	sb.elems = append(sb.elems, elem)
 	if len(sb.elems) == server.MigrationBatchElemCount {
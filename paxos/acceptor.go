@@ -9,6 +9,7 @@ import (
 	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/configuration"
 	"log"
+	"time"
 )
 
 type Acceptor struct {
@@ -62,10 +63,10 @@ func (a *Acceptor) Start() {
 
 func (a *Acceptor) Status(sc *server.StatusConsumer) {
 	sc.Emit(fmt.Sprintf("Acceptor for %v", a.txnId))
-	sc.Emit(fmt.Sprintf("- Current State: %v", a.currentState))
-	sc.Emit(fmt.Sprintf("- Outcome determined? %v", a.outcome != nil))
-	sc.Emit(fmt.Sprintf("- Pending TLC: %v", a.pendingTLC))
-	sc.Emit(fmt.Sprintf("- Received TSC: %v", a.tscReceived))
+	sc.EmitKV("Current State", a.currentState)
+	sc.EmitKV("Outcome determined", a.outcome != nil)
+	sc.EmitKV("Pending TLC", a.pendingTLC)
+	sc.EmitKV("Received TSC", a.tscReceived)
 	a.ballotAccumulator.Status(sc.Fork())
 	sc.Join()
 }
@@ -160,13 +161,16 @@ func (awtd *acceptorWriteToDisk) start() {
 	// to ensure correct order of writes, schedule the write from
 	// the current go-routine...
 	server.Log(awtd.txnId, "Writing 2B to disk...")
+	start := time.Now()
 	future := awtd.acceptorManager.DB.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
 		rwtxn.Put(awtd.acceptorManager.DB.BallotOutcomes, awtd.txnId[:], data, 0)
 		return true
 	})
 	go func() {
 		// ... but process the result in a new go-routine to avoid blocking the executor.
-		if ran, err := future.ResultError(); err != nil {
+		ran, err := future.ResultError()
+		acceptorWriteLatency.ObserveSince(start)
+		if err != nil {
 			panic(fmt.Sprintf("Error: %v Acceptor Write error: %v", awtd.txnId, err))
 		} else if ran != nil {
 			server.Log(awtd.txnId, "Writing 2B to disk...done.")
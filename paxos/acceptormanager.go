@@ -51,6 +51,7 @@ func (am *AcceptorManager) ensureInstance(txnId *common.TxnId, instId *instanceI
 		if !found {
 			aInst = new(acceptorInstances)
 			am.acceptors[*txnId] = aInst
+			acceptorsGauge.Inc()
 		}
 		aInst.addInstance(instId)
 		inst = &instance{
@@ -76,6 +77,7 @@ func (am *AcceptorManager) ensureAcceptor(txnId *common.TxnId, txnCap *msgs.Txn)
 		a := NewAcceptor(txnId, txnCap, am)
 		aInst = &acceptorInstances{acceptor: a}
 		am.acceptors[*txnId] = aInst
+		acceptorsGauge.Inc()
 		a.Start()
 		return a
 	}
@@ -110,6 +112,7 @@ func (am *AcceptorManager) loadFromData(txnId *common.TxnId, data []byte) error
 	acc := AcceptorFromData(txnId, &txn, &outcome, state.SendToAll(), &instances, am)
 	aInst := &acceptorInstances{acceptor: acc}
 	am.acceptors[*txnId] = aInst
+	acceptorsGauge.Inc()
 
 	for idx, l := 0, instances.Len(); idx < l; idx++ {
 		instancesForVar := instances.At(idx)
@@ -282,6 +285,7 @@ func (am *AcceptorManager) AcceptorFinished(txnId *common.TxnId) {
 	server.Log(txnId, "Acceptor finished")
 	if aInst, found := am.acceptors[*txnId]; found {
 		delete(am.acceptors, *txnId)
+		acceptorsGauge.Dec()
 		for _, instId := range aInst.instances {
 			delete(am.instances, *instId)
 		}
@@ -290,13 +294,13 @@ func (am *AcceptorManager) AcceptorFinished(txnId *common.TxnId) {
 
 func (am *AcceptorManager) Status(sc *server.StatusConsumer) {
 	s := sc.Fork()
-	s.Emit(fmt.Sprintf("- Live Instances: %v", len(am.instances)))
+	s.EmitKV("Live Instances", len(am.instances))
 	for instId, inst := range am.instances {
 		inst.status(instId, s.Fork())
 	}
 	s.Join()
 	s = sc.Fork()
-	s.Emit(fmt.Sprintf("- Acceptors: %v", len(am.acceptors)))
+	s.EmitKV("Acceptors", len(am.acceptors))
 	for _, aInst := range am.acceptors {
 		if acc := aInst.acceptor; acc != nil {
 			acc.Status(s.Fork())
@@ -394,9 +398,9 @@ func (i *instance) TwoATxnVotesReceived(request *msgs.TxnVoteAcceptRequest) (acc
 
 func (i *instance) status(instId instanceId, sc *server.StatusConsumer) {
 	sc.Emit(instId.String())
-	sc.Emit(fmt.Sprintf("- Promise Number: %v", i.promiseNum))
-	sc.Emit(fmt.Sprintf("- Accepted Number: %v", i.acceptedNum))
-	sc.Emit(fmt.Sprintf("- Accepted Ballot: %v", i.accepted))
+	sc.EmitKV("Promise Number", i.promiseNum)
+	sc.EmitKV("Accepted Number", i.acceptedNum)
+	sc.EmitKV("Accepted Ballot", i.accepted)
 	sc.Join()
 }
 
@@ -252,8 +252,8 @@ func (ba *BallotAccumulator) AddInstancesToSeg(seg *capn.Segment) msgs.Instances
 
 func (ba *BallotAccumulator) Status(sc *server.StatusConsumer) {
 	sc.Emit(fmt.Sprintf("Ballot Accumulator for %v", ba.txnId))
-	sc.Emit(fmt.Sprintf("- incomplete var count: %v", ba.incompleteVars))
-	sc.Emit(fmt.Sprintf("- retry? %v", ba.Txn.Retry()))
+	sc.EmitKV("incomplete var count", ba.incompleteVars)
+	sc.EmitKV("retry", ba.Txn.Retry())
 	sc.Join()
 }
 
@@ -337,6 +337,8 @@ func deflateTxn(txn *msgs.Txn, seg *capn.Segment) *msgs.Txn {
 	deflatedTxn.SetSubmitterBootCount(txn.SubmitterBootCount())
 	deflatedTxn.SetFInc(txn.FInc())
 	deflatedTxn.SetTopologyVersion(txn.TopologyVersion())
+	deflatedTxn.SetCollect(txn.Collect())
+	deflatedTxn.SetReadOnly(txn.ReadOnly())
 
 	deflatedTxn.SetAllocations(txn.Allocations())
 
@@ -519,6 +521,10 @@ func (br badReads) AddToSeg(seg *capn.Segment) msgs.Update_List {
 					action.Which(), action.VarId(), txnId))
 			}
 			clock.SetVarIdMax(*bra.vUUId, bra.clockElem)
+			// Every action of a collecting txn is of a collected var.
+			if bra.ballot.VoteCap.AbortBadRead().Collected() {
+				update.SetCollected(true)
+			}
 		}
 		update.SetClock(clock.AddToSeg(seg))
 	}
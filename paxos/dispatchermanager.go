@@ -16,7 +16,7 @@ type Dispatchers struct {
 	connectionManager  ConnectionManager
 }
 
-func NewDispatchers(cm ConnectionManager, rmId common.RMId, count uint8, db *db.Databases, lc eng.LocalConnection) *Dispatchers {
+func NewDispatchers(cm ConnectionManager, rmId common.RMId, count uint8, db *db.Databases, lc eng.LocalConnection, changeLog *eng.ChangeLog) *Dispatchers {
 	// It actually doesn't matter at this point what order we start up
 	// the acceptors. This is because we are called from the
 	// ConnectionManager constructor, and its actor loop hasn't been
@@ -28,7 +28,7 @@ func NewDispatchers(cm ConnectionManager, rmId common.RMId, count uint8, db *db.
 	d := &Dispatchers{
 		db:                 db,
 		AcceptorDispatcher: NewAcceptorDispatcher(count, rmId, cm, db),
-		VarDispatcher:      eng.NewVarDispatcher(count, rmId, cm, db, lc),
+		VarDispatcher:      eng.NewVarDispatcher(count, rmId, cm, db, lc, changeLog),
 		connectionManager:  cm,
 	}
 	d.ProposerDispatcher = NewProposerDispatcher(count, rmId, cm, db, d.VarDispatcher)
@@ -0,0 +1,12 @@
+package paxos
+
+import (
+	"goshawkdb.io/server"
+)
+
+var (
+	proposersGauge       = server.Metrics.Gauge("goshawkdb_proposers", "Live proposers.")
+	acceptorsGauge       = server.Metrics.Gauge("goshawkdb_acceptors", "Live acceptors.")
+	proposerWriteLatency = server.Metrics.Histogram("goshawkdb_mdb_write_seconds", "Latency of writes to disk.", server.DefaultLatencyBuckets, "dbi", "proposers")
+	acceptorWriteLatency = server.Metrics.Histogram("goshawkdb_mdb_write_seconds", "Latency of writes to disk.", server.DefaultLatencyBuckets, "dbi", "ballot_outcomes")
+)
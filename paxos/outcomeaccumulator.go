@@ -137,10 +137,10 @@ func (oa *OutcomeAccumulator) Status(sc *server.StatusConsumer) {
 			outcomeToAcceptors[outcome] = []common.RMId{rmId}
 		}
 	}
-	sc.Emit(fmt.Sprintf("- known outcomes from acceptors: %v", acceptors))
-	sc.Emit(fmt.Sprintf("- unique outcomes: %v", outcomeToAcceptors))
-	sc.Emit(fmt.Sprintf("- outcome decided? %v", oa.decidingOutcome != nil))
-	sc.Emit(fmt.Sprintf("- pending TGCs from: %v", oa.pendingTGC))
+	sc.EmitKV("known outcomes from acceptors", acceptors)
+	sc.EmitKV("unique outcomes", outcomeToAcceptors)
+	sc.EmitKV("outcome decided", oa.decidingOutcome != nil)
+	sc.EmitKV("pending TGCs from", oa.pendingTGC)
 	sc.Join()
 }
 
@@ -193,9 +193,9 @@ func (p *proposal) FinishProposing() []common.RMId {
 
 func (p *proposal) Status(sc *server.StatusConsumer) {
 	sc.Emit(fmt.Sprintf("Proposal for %v-%v", p.txnId, p.instanceRMId))
-	sc.Emit(fmt.Sprintf("- Acceptors: %v", p.acceptors))
-	sc.Emit(fmt.Sprintf("- Instances: %v", len(p.instances)))
-	sc.Emit(fmt.Sprintf("- Finished? %v", p.finished))
+	sc.EmitKV("Acceptors", p.acceptors)
+	sc.EmitKV("Instances", len(p.instances))
+	sc.EmitKV("Finished", p.finished)
 	sc.Join()
 }
 
@@ -10,6 +10,7 @@ import (
 	"goshawkdb.io/server/configuration"
 	eng "goshawkdb.io/server/txnengine"
 	"log"
+	"time"
 )
 
 type ProposerMode uint8
@@ -121,11 +122,11 @@ func (p *Proposer) Start() {
 
 func (p *Proposer) Status(sc *server.StatusConsumer) {
 	sc.Emit(fmt.Sprintf("Proposer for %v", p.txnId))
-	sc.Emit(fmt.Sprintf("- Mode: %v", p.mode))
-	sc.Emit(fmt.Sprintf("- Current state: %v", p.currentState))
+	sc.EmitKV("Mode", p.mode)
+	sc.EmitKV("Current state", p.currentState)
 	sc.Emit("- Outcome Accumulator")
 	p.outcomeAccumulator.Status(sc.Fork())
-	sc.Emit(fmt.Sprintf("- Locally Complete? %v", p.locallyCompleted))
+	sc.EmitKV("Locally Complete", p.locallyCompleted)
 	if p.txn != nil {
 		sc.Emit("- Txn")
 		p.txn.Status(sc.Fork())
@@ -412,12 +413,15 @@ func (palc *proposerAwaitLocallyComplete) maybeWriteToDisk() {
 
 	data := server.SegToBytes(stateSeg)
 
+	start := time.Now()
 	future := palc.proposerManager.DB.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
 		rwtxn.Put(palc.proposerManager.DB.Proposers, palc.txnId[:], data, 0)
 		return true
 	})
 	go func() {
-		if ran, err := future.ResultError(); err != nil {
+		ran, err := future.ResultError()
+		proposerWriteLatency.ObserveSince(start)
+		if err != nil {
 			panic(fmt.Sprintf("Error: %v when writing proposer to disk: %v\n", palc.txnId, err))
 		} else if ran != nil {
 			palc.proposerManager.Exe.Enqueue(palc.writeDone)
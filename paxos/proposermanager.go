@@ -59,6 +59,7 @@ func (pm *ProposerManager) loadFromData(txnId *common.TxnId, data []byte) error
 			return err
 		}
 		pm.proposers[*txnId] = proposer
+		proposersGauge.Inc()
 		proposer.Start()
 	}
 	return nil
@@ -118,9 +119,14 @@ func (pm *ProposerManager) TxnReceived(sender common.RMId, txnId *common.TxnId,
 				accept = !found
 			}
 		}
+		if txnCap.ReadOnly() {
+			pm.readOnlyTxnReceived(sender, txnId, txnCap, accept)
+			return
+		}
 		if accept {
 			proposer := NewProposer(pm, txnId, txnCap, ProposerActiveVoter, pm.topology)
 			pm.proposers[*txnId] = proposer
+			proposersGauge.Inc()
 			proposer.Start()
 
 		} else {
@@ -135,6 +141,7 @@ func (pm *ProposerManager) TxnReceived(sender common.RMId, txnId *common.TxnId,
 			// come back.
 			proposer := NewProposer(pm, txnId, txnCap, ProposerActiveLearner, pm.topology)
 			pm.proposers[*txnId] = proposer
+			proposersGauge.Inc()
 			proposer.Start()
 		}
 	}
@@ -235,6 +242,7 @@ func (pm *ProposerManager) TwoBTxnVotesReceived(sender common.RMId, txnId *commo
 
 			proposer := NewProposer(pm, txnId, &txnCap, ProposerActiveLearner, pm.topology)
 			pm.proposers[*txnId] = proposer
+			proposersGauge.Inc()
 			proposer.Start()
 			proposer.BallotOutcomeReceived(sender, &outcome)
 		} else {
@@ -244,6 +252,7 @@ func (pm *ProposerManager) TwoBTxnVotesReceived(sender common.RMId, txnId *commo
 				// we must be a learner.
 				proposer := NewProposer(pm, txnId, &txnCap, ProposerPassiveLearner, pm.topology)
 				pm.proposers[*txnId] = proposer
+				proposersGauge.Inc()
 				proposer.Start()
 				proposer.BallotOutcomeReceived(sender, &outcome)
 
@@ -288,7 +297,10 @@ func (pm *ProposerManager) TxnSubmissionAbortReceived(sender common.RMId, txnId
 
 // from proposer
 func (pm *ProposerManager) TxnFinished(txnId *common.TxnId) {
-	delete(pm.proposers, *txnId)
+	if _, found := pm.proposers[*txnId]; found {
+		delete(pm.proposers, *txnId)
+		proposersGauge.Dec()
+	}
 }
 
 // We have an outcome by this point, so we should stop sending proposals.
@@ -311,11 +323,11 @@ func (pm *ProposerManager) FinishProposers(txnId *common.TxnId) {
 }
 
 func (pm *ProposerManager) Status(sc *server.StatusConsumer) {
-	sc.Emit(fmt.Sprintf("Live proposers: %v", len(pm.proposers)))
+	sc.EmitKV("Live proposers", len(pm.proposers))
 	for _, prop := range pm.proposers {
 		prop.Status(sc.Fork())
 	}
-	sc.Emit(fmt.Sprintf("Live proposals: %v", len(pm.proposals)))
+	sc.EmitKV("Live proposals", len(pm.proposals))
 	for _, prop := range pm.proposals {
 		prop.Status(sc.Fork())
 	}
@@ -0,0 +1,86 @@
+package paxos
+
+import (
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	eng "goshawkdb.io/server/txnengine"
+)
+
+// A read-only txn does not go through paxos, and nothing about it is
+// written to disk. Instead, every active RM checks each of its vars
+// in the txn: the version read must be the current version, and
+// there must be no write in flight. Each active RM sends the
+// submitter an outcome, and the txn commits only if every one of
+// them is a commit. As every var is checked by F+1 of its 2F+1 RMs,
+// and any write which has committed was voted on by F+1 of them, at
+// least one of the RMs checking will know of every committed write.
+//
+// The commit carries the clock the reads would have voted with, merged
+// over this RM's vars, so the submitter can combine them into the
+// clock of the txn.
+//
+// Anything other than a commit is sent as an abort resubmit: it is
+// then up to the submitter to submit the txn normally, which will
+// find the correct updates should any of the reads be bad.
+func (pm *ProposerManager) readOnlyTxnReceived(sender common.RMId, txnId *common.TxnId, txnCap *msgs.Txn, accept bool) {
+	alloc := AllocForRMId(txnCap, pm.RMId)
+	if !accept || alloc == nil || alloc.Active() == 0 {
+		server.Log(txnId, "Read-only txn rejected")
+		pm.readOnlyTxnChecked(sender, txnCap, nil)
+		return
+	}
+	server.Log(txnId, "Read-only txn received")
+
+	actions := txnCap.Actions()
+	actionIndices := alloc.ActionIndices()
+	remaining := actionIndices.Len()
+	clock := eng.NewVectorClock()
+	if remaining == 0 {
+		pm.readOnlyTxnChecked(sender, txnCap, clock)
+		return
+	}
+	for idx, l := 0, actionIndices.Len(); idx < l; idx++ {
+		action := actions.At(int(actionIndices.At(idx)))
+		vUUId := common.MakeVarUUId(action.VarId())
+		readVsn := common.MakeTxnId(action.Read().Version())
+		pm.VarDispatcher.ApplyToVar(func(v *eng.Var) {
+			var varClock *eng.VectorClock
+			if v != nil {
+				varClock = v.ReadOnlyCheck(readVsn)
+			}
+			pm.Exe.Enqueue(func() {
+				if varClock == nil {
+					clock = nil
+				} else if clock != nil {
+					clock.MergeInMax(varClock)
+				}
+				remaining--
+				if remaining == 0 {
+					pm.readOnlyTxnChecked(sender, txnCap, clock)
+				}
+			})
+		}, false, vUUId)
+	}
+}
+
+// readOnlyTxnChecked sends the outcome: a commit with clock, or an
+// abort resubmit if clock is nil.
+func (pm *ProposerManager) readOnlyTxnChecked(sender common.RMId, txnCap *msgs.Txn, clock *eng.VectorClock) {
+	seg := capn.NewBuffer(nil)
+	msg := msgs.NewRootMessage(seg)
+	outcome := msgs.NewOutcome(seg)
+	msg.SetSubmissionOutcome(outcome)
+	outcome.SetTxn(*deflateTxn(txnCap, seg))
+	outcome.SetId(msgs.NewOutcomeIdList(seg, 0))
+	if clock != nil {
+		outcome.SetCommit(clock.AddToSeg(seg))
+	} else {
+		outcome.SetAbort()
+		outcome.Abort().SetResubmit()
+	}
+	// The submitter uses a repeating sender, so if this gets lost we
+	// will receive the txn again and check it again.
+	NewOneShotSender(server.SegToBytes(seg), pm, sender)
+}
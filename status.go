@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -10,15 +11,31 @@ type StatusConsumer struct {
 	sync.Mutex
 	forkCount int32
 	sep       string
-	slots     [][]string
+	slots     []*statusSlot
 	joined    chan struct{}
 }
 
+type statusSlot struct {
+	strs  []string
+	key   string
+	value interface{}
+	child *StatusNode
+}
+
+// StatusNode is the structured equivalent of the text produced by
+// Consume. Lines are from Emit, Fields are from EmitKV and Children
+// are the results of each Fork, in the order they were forked.
+type StatusNode struct {
+	Lines    []string               `json:",omitempty"`
+	Fields   map[string]interface{} `json:",omitempty"`
+	Children []*StatusNode          `json:",omitempty"`
+}
+
 func NewStatusConsumer() *StatusConsumer {
 	return &StatusConsumer{
 		forkCount: 1,
 		sep:       "\n ",
-		slots:     make([][]string, 0, 16),
+		slots:     make([]*statusSlot, 0, 16),
 		joined:    make(chan struct{}),
 	}
 }
@@ -31,9 +48,9 @@ func (s *StatusConsumer) Fork() *StatusConsumer {
 	slotIdx := len(s.slots)
 	s.slots = append(s.slots, nil)
 	s.Unlock()
-	go sc.Consume(func(str string) {
+	go sc.consume(func(str string, node *StatusNode) {
 		s.Lock()
-		s.slots[slotIdx] = []string{str}
+		s.slots[slotIdx] = &statusSlot{strs: []string{str}, child: node}
 		s.Unlock()
 		s.Join()
 	})
@@ -48,20 +65,58 @@ func (s *StatusConsumer) Join() {
 
 func (s *StatusConsumer) Emit(status ...string) {
 	s.Lock()
-	s.slots = append(s.slots, status)
+	s.slots = append(s.slots, &statusSlot{strs: status})
+	s.Unlock()
+}
+
+// EmitKV records a named value. In the text form it appears as a
+// "- key: value" line; in the StatusNode form it appears in
+// Fields. Values which are not basic types are converted to strings.
+func (s *StatusConsumer) EmitKV(key string, value interface{}) {
+	switch value.(type) {
+	case bool, string, int, int32, int64, uint, uint8, uint16, uint32, uint64, float64:
+	default:
+		value = fmt.Sprint(value)
+	}
+	s.Lock()
+	s.slots = append(s.slots, &statusSlot{
+		strs:  []string{fmt.Sprintf("- %s: %v", key, value)},
+		key:   key,
+		value: value,
+	})
 	s.Unlock()
 }
 
 func (s *StatusConsumer) Consume(fun func(string)) {
+	s.consume(func(str string, node *StatusNode) { fun(str) })
+}
+
+func (s *StatusConsumer) ConsumeNode(fun func(*StatusNode)) {
+	s.consume(func(str string, node *StatusNode) { fun(node) })
+}
+
+func (s *StatusConsumer) consume(fun func(string, *StatusNode)) {
 	buf := " "
+	node := &StatusNode{}
 	<-s.joined
-	for _, strs := range s.slots {
-		buf += strings.Join(strs, s.sep) + s.sep
+	for _, slot := range s.slots {
+		buf += strings.Join(slot.strs, s.sep) + s.sep
+		switch {
+		case slot.child != nil:
+			node.Children = append(node.Children, slot.child)
+		case len(slot.key) != 0:
+			if node.Fields == nil {
+				node.Fields = make(map[string]interface{})
+			}
+			node.Fields[slot.key] = slot.value
+		default:
+			node.Lines = append(node.Lines, slot.strs...)
+		}
 	}
 	if len(buf) == 1 {
-		fun(buf)
+		fun(buf, node)
 	} else {
 		end := len(buf) - len(s.sep)
-		fun(buf[:end])
+		fun(buf[:end], node)
 	}
 }
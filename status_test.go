@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func emitTestStatus(sc *StatusConsumer) {
+	sc.Emit("Top")
+	sc.EmitKV("count", 3)
+	sc.EmitKV("wait", time.Second)
+	child := sc.Fork()
+	child.Emit("Child")
+	child.EmitKV("ok", true)
+	grandchild := child.Fork()
+	grandchild.Emit("Grandchild")
+	grandchild.Join()
+	child.Join()
+	sc.Emit("After")
+	sc.Join()
+}
+
+func TestStatusConsume(t *testing.T) {
+	strChan := make(chan string, 1)
+	sc := NewStatusConsumer()
+	go sc.Consume(func(str string) { strChan <- str })
+	emitTestStatus(sc)
+
+	expected := " Top\n - count: 3\n - wait: 1s\n  Child\n  - ok: true\n   Grandchild\n After"
+	if str := <-strChan; str != expected {
+		t.Fatalf("Consume gave:\n%q\nexpected:\n%q", str, expected)
+	}
+}
+
+func TestStatusConsumeNode(t *testing.T) {
+	nodeChan := make(chan *StatusNode, 1)
+	sc := NewStatusConsumer()
+	go sc.ConsumeNode(func(node *StatusNode) { nodeChan <- node })
+	emitTestStatus(sc)
+	node := <-nodeChan
+
+	if len(node.Lines) != 2 || node.Lines[0] != "Top" || node.Lines[1] != "After" {
+		t.Fatalf("Lines are %v; expected [Top After]", node.Lines)
+	} else if len(node.Fields) != 2 || node.Fields["count"] != 3 || node.Fields["wait"] != "1s" {
+		t.Fatalf("Fields are %v; expected count: 3 and wait: 1s", node.Fields)
+	} else if len(node.Children) != 1 {
+		t.Fatalf("Found %v children; expected 1", len(node.Children))
+	}
+	child := node.Children[0]
+	if len(child.Lines) != 1 || child.Lines[0] != "Child" || len(child.Fields) != 1 || child.Fields["ok"] != true {
+		t.Fatalf("Child is %v; expected Child with ok: true", child)
+	} else if len(child.Children) != 1 || len(child.Children[0].Lines) != 1 || child.Children[0].Lines[0] != "Grandchild" {
+		t.Fatalf("Child has children %v; expected Grandchild", child.Children)
+	}
+
+	// This is what the admin listener serves from /status.
+	bites, err := json.Marshal(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Lines":["Top","After"],"Fields":{"count":3,"wait":"1s"},"Children":[{"Lines":["Child"],"Fields":{"ok":true},"Children":[{"Lines":["Grandchild"]}]}]}`
+	if str := string(bites); str != expected {
+		t.Fatalf("JSON is:\n%v\nexpected:\n%v", str, expected)
+	}
+}
+
+func TestStatusConsumeNodeEmpty(t *testing.T) {
+	nodeChan := make(chan *StatusNode, 1)
+	sc := NewStatusConsumer()
+	go sc.ConsumeNode(func(node *StatusNode) { nodeChan <- node })
+	sc.Join()
+
+	bites, err := json.Marshal(<-nodeChan)
+	if err != nil {
+		t.Fatal(err)
+	} else if str := string(bites); str != "{}" {
+		t.Fatalf("JSON of empty status is %v; expected {}", str)
+	}
+}
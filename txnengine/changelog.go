@@ -0,0 +1,378 @@
+package txnengine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ChangeLog appends every committed txn which writes to vars held by
+// this node to a local file. Each record is the txn's Outcome,
+// containing the txn and its commit clock, preceded by a header of
+// three big-endian uint32s: a magic number, the length of the Outcome
+// and its CRC32. A txn is recorded once it is locally complete, i.e.
+// its writes to our vars are on disk. Appending a record only writes
+// it to the file: a separate go-routine fsyncs the file, so a single
+// fsync covers every record appended whilst the previous fsync was in
+// progress, and the var go-routines never wait for the disk. Records
+// are in a causally consistent order: a txn is
+// held back until every txn which wrote a version of one of our vars
+// which it reads or overwrites, and which is itself waiting to be
+// recorded, has been recorded. Should such a txn not become locally
+// complete within server.ChangeLogDependencyTimeout, which should
+// only happen if something has gone wrong, we stop waiting for it: it
+// is recorded out of order whenever it completes.
+//
+// Every node holding a var written by a txn records the txn, so a
+// consumer of several nodes' logs must deduplicate by txn id, and
+// can order records from different logs by their commit clocks. Txns
+// which only roll vars are not recorded.
+//
+// The position of a record is its offset in the file. A consumer
+// resumes by reading from the position after the last record it
+// processed. Consumers should read with ReadSynced, which never
+// returns a record which could yet be lost in a crash: were such a
+// record lost, the positions after it would be reused.
+type ChangeLog struct {
+	lock         sync.Mutex
+	path         string
+	file         *os.File
+	closed       bool
+	offset       int64
+	synced       int64
+	pending      map[common.TxnId]*changeLogEntry
+	syncChan     chan struct{}
+	syncerDone   chan struct{}
+	shutdownChan chan struct{}
+}
+
+type changeLogEntry struct {
+	txnId       *common.TxnId
+	committedAt time.Time
+	bites       []byte
+	waitingOn   map[common.TxnId]server.EmptyStruct
+	waiters     []*changeLogEntry
+	abandoned   bool
+}
+
+const (
+	changeLogMagic      = 0x67736c67
+	changeLogHeaderSize = 12
+)
+
+var ErrChangeLogPosition = errors.New("Position is not the start of a change log record")
+
+func NewChangeLog(path string) (*ChangeLog, error) {
+	// If we crashed part way through appending a record, the file
+	// ends with a partial record which we must truncate away.
+	offset, err := ReadChangeLog(path, 0, func(int64, *msgs.Outcome) error { return nil })
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = file.Truncate(offset); err == nil {
+		_, err = file.Seek(offset, 0)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	cl := &ChangeLog{
+		path:         path,
+		file:         file,
+		offset:       offset,
+		synced:       offset,
+		pending:      make(map[common.TxnId]*changeLogEntry),
+		syncChan:     make(chan struct{}, 1),
+		syncerDone:   make(chan struct{}),
+		shutdownChan: make(chan struct{}),
+	}
+	go cl.syncer()
+	go cl.abandonStuck()
+	return cl, nil
+}
+
+func (cl *ChangeLog) Close() error {
+	cl.lock.Lock()
+	if cl.closed {
+		cl.lock.Unlock()
+		return nil
+	}
+	cl.closed = true
+	close(cl.shutdownChan)
+	cl.lock.Unlock()
+	// Nothing more is appended once closed, so once the syncer has
+	// stopped, we sync whatever it had yet to.
+	<-cl.syncerDone
+	err := cl.file.Sync()
+	if errClose := cl.file.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func (cl *ChangeLog) Status(sc *server.StatusConsumer) {
+	cl.lock.Lock()
+	sc.Emit("ChangeLog")
+	sc.EmitKV("position", cl.offset)
+	sc.EmitKV("synced position", cl.synced)
+	sc.EmitKV("pending", len(cl.pending))
+	cl.lock.Unlock()
+	sc.Join()
+}
+
+// committed must be called when the outcome of the txn is known to
+// be a commit, before any of its actions are applied to our vars.
+func (cl *ChangeLog) committed(txn *Txn) {
+	for idx := range txn.localActions {
+		action := &txn.localActions[idx]
+		if action.writeAction != nil && !action.roll {
+			cl.lock.Lock()
+			cl.pending[*txn.Id] = &changeLogEntry{
+				txnId:       txn.Id,
+				committedAt: time.Now(),
+				waitingOn:   make(map[common.TxnId]server.EmptyStruct),
+			}
+			cl.lock.Unlock()
+			return
+		}
+	}
+}
+
+// dependsOn must be called from the var's go-routine as the commit of
+// txnId is applied to the var, with the id of the txn which wrote the
+// version of the var which txnId reads or overwrites.
+func (cl *ChangeLog) dependsOn(txnId, frameTxnId *common.TxnId) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	entry, found := cl.pending[*txnId]
+	if !found {
+		return
+	}
+	dep, found := cl.pending[*frameTxnId]
+	if !found || dep == entry || dep.abandoned {
+		return
+	} else if _, found = entry.waitingOn[*frameTxnId]; !found {
+		entry.waitingOn[*frameTxnId] = server.EmptyStructVal
+		dep.waiters = append(dep.waiters, entry)
+	}
+}
+
+// locallyComplete records the txn once every txn it depends on has
+// been recorded.
+func (cl *ChangeLog) locallyComplete(txn *Txn) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	entry, found := cl.pending[*txn.Id]
+	if !found || cl.closed {
+		return
+	}
+
+	seg := capn.NewBuffer(nil)
+	outcome := msgs.NewRootOutcome(seg)
+	outcome.SetId(msgs.NewOutcomeIdList(seg, 0))
+	outcome.SetTxn(*txn.TxnCap)
+	outcome.SetCommit(txn.outcomeClock.AddToSeg(seg))
+	entry.bites = server.SegToBytes(seg)
+
+	if len(entry.waitingOn) == 0 {
+		cl.append(entry)
+		cl.requestSync()
+	}
+}
+
+func (cl *ChangeLog) append(entry *changeLogEntry) {
+	delete(cl.pending, *entry.txnId)
+	record := make([]byte, changeLogHeaderSize+len(entry.bites))
+	binary.BigEndian.PutUint32(record, changeLogMagic)
+	binary.BigEndian.PutUint32(record[4:], uint32(len(entry.bites)))
+	binary.BigEndian.PutUint32(record[8:], crc32.ChecksumIEEE(entry.bites))
+	copy(record[changeLogHeaderSize:], entry.bites)
+	if _, err := cl.file.Write(record); err != nil {
+		panic(fmt.Sprintf("Error when appending %v to change log: %v", entry.txnId, err))
+	}
+	cl.offset += int64(len(record))
+	changeLogRecords.Inc()
+	cl.release(entry)
+}
+
+// release stops the waiters of entry waiting for it, appending those
+// which are complete and have nothing else to wait for.
+func (cl *ChangeLog) release(entry *changeLogEntry) {
+	waiters := entry.waiters
+	entry.waiters = nil
+	for _, waiter := range waiters {
+		delete(waiter.waitingOn, *entry.txnId)
+		if len(waiter.waitingOn) == 0 && waiter.bites != nil {
+			cl.append(waiter)
+		}
+	}
+}
+
+// requestSync must be called with the lock held, after appending.
+func (cl *ChangeLog) requestSync() {
+	select {
+	case cl.syncChan <- server.EmptyStructVal:
+	default: // a sync is already requested, and will cover our appends.
+	}
+}
+
+// syncer fsyncs the file whenever records have been appended. The
+// lock is not held whilst syncing, so records appended during a sync
+// are covered by the next.
+func (cl *ChangeLog) syncer() {
+	defer close(cl.syncerDone)
+	for {
+		select {
+		case <-cl.shutdownChan:
+			return
+		case <-cl.syncChan:
+			cl.lock.Lock()
+			offset := cl.offset
+			cl.lock.Unlock()
+			if err := cl.file.Sync(); err != nil {
+				panic(fmt.Sprintf("Error when syncing change log: %v", err))
+			}
+			changeLogSyncs.Inc()
+			cl.lock.Lock()
+			cl.synced = offset
+			cl.lock.Unlock()
+		}
+	}
+}
+
+// SyncedPosition returns the position after the last record which is
+// known to be on disk.
+func (cl *ChangeLog) SyncedPosition() int64 {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	return cl.synced
+}
+
+var errChangeLogUnsynced = errors.New("Change log record is not yet synced")
+
+// ReadSynced is as ReadChangeLog, but stops before the first record
+// which is not yet known to be on disk.
+func (cl *ChangeLog) ReadSynced(from int64, fun func(int64, *msgs.Outcome) error) (int64, error) {
+	synced := cl.SyncedPosition()
+	if from >= synced {
+		return from, nil
+	}
+	last := from
+	_, err := ReadChangeLog(cl.path, from, func(position int64, outcome *msgs.Outcome) error {
+		if position > synced {
+			return errChangeLogUnsynced
+		}
+		last = position
+		return fun(position, outcome)
+	})
+	if err == errChangeLogUnsynced {
+		err = nil
+	}
+	return last, err
+}
+
+// abandonStuck periodically stops txns from waiting for txns which
+// have not become locally complete within the timeout. The abandoned
+// txns stay pending, so they are still recorded when they complete.
+func (cl *ChangeLog) abandonStuck() {
+	ticker := time.NewTicker(server.ChangeLogDependencyTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cl.shutdownChan:
+			return
+		case <-ticker.C:
+			cl.lock.Lock()
+			if cl.closed {
+				cl.lock.Unlock()
+				return
+			}
+			cutoff := time.Now().Add(-server.ChangeLogDependencyTimeout)
+			appended := false
+			for _, entry := range cl.pending {
+				if entry.bites == nil && !entry.abandoned && entry.committedAt.Before(cutoff) && len(entry.waiters) != 0 {
+					log.Printf("Change log: %v not locally complete after %v; recording its dependents without it", entry.txnId, server.ChangeLogDependencyTimeout)
+					entry.abandoned = true
+					changeLogAbandoned.Inc()
+					cl.release(entry)
+					appended = true
+				}
+			}
+			if appended {
+				cl.requestSync()
+			}
+			cl.lock.Unlock()
+		}
+	}
+}
+
+// ReadChangeLog calls fun with each complete record in the change log
+// at path from the position from, along with the position of the
+// following record. It returns the position after the last complete
+// record read. If from is not the start of a record,
+// ErrChangeLogPosition is returned, and nothing is read.
+func ReadChangeLog(path string, from int64, fun func(int64, *msgs.Outcome) error) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return from, err
+	}
+	defer file.Close()
+	if _, err = file.Seek(from, 0); err != nil {
+		return from, err
+	}
+	reader := bufio.NewReader(file)
+	header := make([]byte, changeLogHeaderSize)
+	start := from
+	for {
+		if _, err = io.ReadFull(reader, header); err != nil {
+			break
+		}
+		length := binary.BigEndian.Uint32(header[4:])
+		if binary.BigEndian.Uint32(header) != changeLogMagic || length > server.ChangeLogMaxRecordSize {
+			return from, changeLogCorrupt(start, from)
+		}
+		bites := make([]byte, length)
+		if _, err = io.ReadFull(reader, bites); err != nil {
+			break
+		} else if crc32.ChecksumIEEE(bites) != binary.BigEndian.Uint32(header[8:]) {
+			return from, changeLogCorrupt(start, from)
+		}
+		seg, _, errCap := capn.ReadFromMemoryZeroCopy(bites)
+		if errCap != nil {
+			return from, fmt.Errorf("Corrupt change log record at %v: %v", from, errCap)
+		}
+		outcome := msgs.ReadRootOutcome(seg)
+		from += int64(changeLogHeaderSize + len(bites))
+		if err = fun(from, &outcome); err != nil {
+			return from, err
+		}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return from, err
+}
+
+// A bad header for the first record means we were given a bad
+// position; for any later record, the log is corrupt.
+func changeLogCorrupt(start, position int64) error {
+	if start == position {
+		return ErrChangeLogPosition
+	}
+	return fmt.Errorf("Corrupt change log record at %v", position)
+}
@@ -0,0 +1,264 @@
+package txnengine
+
+import (
+	"encoding/binary"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newChangeLogTest(t *testing.T) (*ChangeLog, string) {
+	dir, err := ioutil.TempDir("", "changelog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "changes.log")
+	cl, err := NewChangeLog(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return cl, path
+}
+
+func changeLogTxnId(n byte) *common.TxnId {
+	txnId := common.MakeTxnId(make([]byte, common.KeyLen))
+	txnId[0] = n
+	return txnId
+}
+
+// changeLogAppend appends the commit of the txn numbered n, as
+// locallyComplete does, but without the txn itself.
+func changeLogAppend(cl *ChangeLog, n byte, sync bool) {
+	seg := capn.NewBuffer(nil)
+	outcome := msgs.NewRootOutcome(seg)
+	outcome.SetId(msgs.NewOutcomeIdList(seg, 0))
+	txn := msgs.NewTxn(seg)
+	txn.SetId(changeLogTxnId(n)[:])
+	outcome.SetTxn(txn)
+	outcome.SetCommit(NewVectorClock().AddToSeg(seg))
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	cl.append(&changeLogEntry{txnId: changeLogTxnId(n), bites: server.SegToBytes(seg)})
+	if sync {
+		cl.requestSync()
+	}
+}
+
+type changeLogRead struct {
+	position int64
+	txnId    *common.TxnId
+}
+
+func readChangeLogTest(t *testing.T, path string, from int64) ([]changeLogRead, int64, error) {
+	reads := []changeLogRead{}
+	end, err := ReadChangeLog(path, from, func(position int64, outcome *msgs.Outcome) error {
+		txn := outcome.Txn()
+		reads = append(reads, changeLogRead{position: position, txnId: common.MakeTxnId(append([]byte{}, txn.Id()...))})
+		return nil
+	})
+	return reads, end, err
+}
+
+func assertChangeLogReads(t *testing.T, reads []changeLogRead, ns ...byte) {
+	if len(reads) != len(ns) {
+		t.Fatalf("Read %v records; expected %v", len(reads), len(ns))
+	}
+	for idx, n := range ns {
+		if reads[idx].txnId.Compare(changeLogTxnId(n)) != common.EQ {
+			t.Fatalf("Record %v is of %v; expected %v", idx, reads[idx].txnId, changeLogTxnId(n))
+		}
+	}
+}
+
+func TestChangeLogFraming(t *testing.T) {
+	cl, path := newChangeLogTest(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	for n := byte(1); n <= 3; n++ {
+		changeLogAppend(cl, n, true)
+	}
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	bites, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each record is the magic number, the length and the CRC32 of
+	// the outcome, followed by the outcome.
+	length := binary.BigEndian.Uint32(bites[4:])
+	if magic := binary.BigEndian.Uint32(bites); magic != changeLogMagic {
+		t.Fatalf("First record has magic %x; expected %x", magic, changeLogMagic)
+	} else if crc := binary.BigEndian.Uint32(bites[8:]); crc != crc32.ChecksumIEEE(bites[changeLogHeaderSize:changeLogHeaderSize+length]) {
+		t.Fatalf("First record has CRC %x, which does not match its outcome", crc)
+	}
+
+	reads, end, err := readChangeLogTest(t, path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertChangeLogReads(t, reads, 1, 2, 3)
+	if reads[0].position != int64(changeLogHeaderSize+length) {
+		t.Fatalf("First record is followed by position %v; expected %v", reads[0].position, changeLogHeaderSize+length)
+	} else if end != int64(len(bites)) || reads[2].position != end {
+		t.Fatalf("Read to %v; expected the end of the file at %v", end, len(bites))
+	}
+
+	// Reading resumes from the position following a record.
+	reads, _, err = readChangeLogTest(t, path, reads[0].position)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertChangeLogReads(t, reads, 2, 3)
+
+	if _, _, err = readChangeLogTest(t, path, 1); err != ErrChangeLogPosition {
+		t.Fatalf("Reading from within a record gave %v; expected %v", err, ErrChangeLogPosition)
+	}
+}
+
+func TestChangeLogCorruptRecord(t *testing.T) {
+	cl, path := newChangeLogTest(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	for n := byte(1); n <= 2; n++ {
+		changeLogAppend(cl, n, true)
+	}
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reads, _, err := readChangeLogTest(t, path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := reads[0].position
+
+	bites, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bites[len(bites)-1] ^= 0xff
+	if err = ioutil.WriteFile(path, bites, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A bad CRC after the first record read is corruption...
+	reads, _, err = readChangeLogTest(t, path, 0)
+	if err == nil || err == ErrChangeLogPosition {
+		t.Fatalf("Reading a corrupt record gave %v; expected corruption", err)
+	}
+	assertChangeLogReads(t, reads, 1)
+	// ... but for the first record read, we were given a bad position.
+	if _, _, err = readChangeLogTest(t, path, second); err != ErrChangeLogPosition {
+		t.Fatalf("Reading from a corrupt record gave %v; expected %v", err, ErrChangeLogPosition)
+	}
+}
+
+func TestChangeLogTruncatedTail(t *testing.T) {
+	cl, path := newChangeLogTest(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	for n := byte(1); n <= 2; n++ {
+		changeLogAppend(cl, n, true)
+	}
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	complete := info.Size()
+
+	// Simulate a crash part way through appending a record: the
+	// header and some of the outcome made it to disk.
+	bites, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.Write(bites[:changeLogHeaderSize+4])
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The partial record is not read...
+	reads, end, err := readChangeLogTest(t, path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertChangeLogReads(t, reads, 1, 2)
+	if end != complete {
+		t.Fatalf("Read to %v; expected the end of the complete records at %v", end, complete)
+	}
+
+	// ... and is truncated away on opening, so new records follow the
+	// complete ones.
+	if cl, err = NewChangeLog(path); err != nil {
+		t.Fatal(err)
+	}
+	changeLogAppend(cl, 3, true)
+	if err = cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reads, _, err = readChangeLogTest(t, path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertChangeLogReads(t, reads, 1, 2, 3)
+	if reads[1].position != complete {
+		t.Fatalf("Second record is followed by position %v; expected %v", reads[1].position, complete)
+	}
+}
+
+func TestChangeLogReadSynced(t *testing.T) {
+	cl, path := newChangeLogTest(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer cl.Close()
+	for n := byte(1); n <= 2; n++ {
+		changeLogAppend(cl, n, true)
+	}
+	cl.lock.Lock()
+	offset := cl.offset
+	cl.lock.Unlock()
+	deadline := time.Now().Add(time.Minute)
+	for cl.SyncedPosition() != offset {
+		if time.Now().After(deadline) {
+			t.Fatalf("Change log synced to %v; expected %v", cl.SyncedPosition(), offset)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A record which is not yet synced is not read.
+	changeLogAppend(cl, 3, false)
+	reads := []*common.TxnId{}
+	end, err := cl.ReadSynced(0, func(position int64, outcome *msgs.Outcome) error {
+		txn := outcome.Txn()
+		reads = append(reads, common.MakeTxnId(append([]byte{}, txn.Id()...)))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(reads) != 2 || end != offset {
+		t.Fatalf("Read %v records to %v; expected the 2 synced records to %v", len(reads), end, offset)
+	}
+	if end, err = cl.ReadSynced(offset, func(int64, *msgs.Outcome) error {
+		t.Fatal("Read a record which is not synced")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	} else if end != offset {
+		t.Fatalf("Read to %v; expected nothing to be read from %v", end, offset)
+	}
+}
@@ -91,19 +91,22 @@ func (f *frame) Status(sc *server.StatusConsumer) {
 	for node := f.writes.First(); node != nil; node = node.Next() {
 		writeHistogram[int(node.Value.(txnStatus))]++
 	}
-	sc.Emit(fmt.Sprintf("- Read Count: %v %v", f.reads.Len(), readHistogram))
-	sc.Emit(fmt.Sprintf("- Uncommitted Read Count: %v", f.uncommittedReads))
-	sc.Emit(fmt.Sprintf("- Learnt future reads: %v", len(f.learntFutureReads)))
-	sc.Emit(fmt.Sprintf("- Write Count: %v %v", f.writes.Len(), writeHistogram))
-	sc.Emit(fmt.Sprintf("- Uncommitted Write Count: %v", f.uncommittedWrites))
-	sc.Emit(fmt.Sprintf("- RW Present: %v", f.rwPresent))
-	sc.Emit(fmt.Sprintf("- Mask: %v", f.mask))
-	sc.Emit(fmt.Sprintf("- Current State: %v", f.currentState))
-	sc.Emit(fmt.Sprintf("- Locked? %v", f.isLocked()))
-	sc.Emit(fmt.Sprintf("- Roll scheduled/active? %v/%v", f.rollScheduled, f.rollActive))
-	sc.Emit(fmt.Sprintf("- DescendentOnDisk? %v", f.onDisk))
-	sc.Emit(fmt.Sprintf("- Child == nil? %v", f.child == nil))
-	sc.Emit(fmt.Sprintf("- Parent == nil? %v", f.parent == nil))
+	sc.EmitKV("Read Count", f.reads.Len())
+	sc.EmitKV("Read Histogram", readHistogram)
+	sc.EmitKV("Uncommitted Read Count", f.uncommittedReads)
+	sc.EmitKV("Learnt future reads", len(f.learntFutureReads))
+	sc.EmitKV("Write Count", f.writes.Len())
+	sc.EmitKV("Write Histogram", writeHistogram)
+	sc.EmitKV("Uncommitted Write Count", f.uncommittedWrites)
+	sc.EmitKV("RW Present", f.rwPresent)
+	sc.EmitKV("Mask", f.mask)
+	sc.EmitKV("Current State", f.currentState)
+	sc.EmitKV("Locked", f.isLocked())
+	sc.EmitKV("Roll scheduled", f.rollScheduled)
+	sc.EmitKV("Roll active", f.rollActive)
+	sc.EmitKV("DescendentOnDisk", f.onDisk)
+	sc.EmitKV("Has Child", f.child != nil)
+	sc.EmitKV("Has Parent", f.parent != nil)
 	if f.parent != nil {
 		f.parent.Status(sc.Fork())
 	}
@@ -171,6 +174,15 @@ func (fo *frameOpen) ReadRetry(action *localAction) bool {
 	}
 }
 
+func (fo *frameOpen) ReadOnlyCheck(readVsn *common.TxnId) *VectorClock {
+	server.Log(fo.frame, "ReadOnlyCheck", readVsn)
+	if fo.currentState == fo && fo.frameTxnActions != nil && fo.writeVoteClock == nil &&
+		fo.writes.Len() == 0 && fo.frameTxnId.Compare(readVsn) == common.EQ {
+		return fo.readVoteClock.Clone()
+	}
+	return nil
+}
+
 func (fo *frameOpen) AddRead(action *localAction) {
 	txn := action.Txn
 	server.Log(fo.frame, "AddRead", txn, action.readVsn)
@@ -667,7 +679,7 @@ func (fo *frameOpen) maybeCreateChild() {
 
 func (fo *frameOpen) maybeScheduleRoll() {
 	// do not check vm.RollAllowed here.
-	if !fo.rollScheduled && !fo.rollActive && fo.currentState == fo && fo.child == nil && fo.writes.Len() == 0 && fo.v.positions != nil &&
+	if !fo.rollScheduled && !fo.rollActive && !fo.v.collected && fo.currentState == fo && fo.child == nil && fo.writes.Len() == 0 && fo.v.positions != nil &&
 		(fo.reads.Len() > fo.uncommittedReads || (len(fo.frameTxnClock.Clock) > fo.frameTxnActions.Len() && fo.parent == nil && fo.reads.Len() == 0 && len(fo.learntFutureReads) == 0)) {
 		fo.rollScheduled = true
 		fo.v.vm.ScheduleCallback(func() {
@@ -680,7 +692,7 @@ func (fo *frameOpen) maybeScheduleRoll() {
 }
 
 func (fo *frameOpen) maybeStartRoll() {
-	if fo.v.vm.RollAllowed && !fo.rollActive && fo.currentState == fo && fo.child == nil && fo.writes.Len() == 0 && fo.v.positions != nil &&
+	if fo.v.vm.RollAllowed && !fo.rollActive && !fo.v.collected && fo.currentState == fo && fo.child == nil && fo.writes.Len() == 0 && fo.v.positions != nil &&
 		(fo.reads.Len() > fo.uncommittedReads || (len(fo.frameTxnClock.Clock) > fo.frameTxnActions.Len() && fo.parent == nil && fo.reads.Len() == 0 && len(fo.learntFutureReads) == 0)) {
 		fo.rollActive = true
 		ctxn, varPosMap := fo.createRollClientTxn()
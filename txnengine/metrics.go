@@ -0,0 +1,20 @@
+package txnengine
+
+import (
+	"goshawkdb.io/server"
+)
+
+var (
+	votesCommit             = server.Metrics.Counter("goshawkdb_votes_total", "Votes cast by local vars.", "vote", "commit")
+	votesAbortBadRead       = server.Metrics.Counter("goshawkdb_votes_total", "Votes cast by local vars.", "vote", "abort_bad_read")
+	votesAbortDeadlock      = server.Metrics.Counter("goshawkdb_votes_total", "Votes cast by local vars.", "vote", "abort_deadlock")
+	votesAbortCollected     = server.Metrics.Counter("goshawkdb_votes_total", "Votes cast by local vars.", "vote", "abort_collected")
+	varWriteLatency         = server.Metrics.Histogram("goshawkdb_mdb_write_seconds", "Latency of writes to disk.", server.DefaultLatencyBuckets, "dbi", "vars")
+	varsCollected           = server.Metrics.Counter("goshawkdb_vars_collected_total", "Unreachable vars removed from local disk.")
+	collectedVarsReferenced = server.Metrics.Counter("goshawkdb_collected_vars_referenced_total", "Collected vars loaded again from their tombstones because a txn referenced them.")
+	readOnlyChecksPass      = server.Metrics.Counter("goshawkdb_read_only_checks_total", "Reads of read-only txns checked by local vars.", "result", "pass")
+	readOnlyChecksFail      = server.Metrics.Counter("goshawkdb_read_only_checks_total", "Reads of read-only txns checked by local vars.", "result", "fail")
+	changeLogRecords        = server.Metrics.Counter("goshawkdb_change_log_records_total", "Committed txns appended to the local change log.")
+	changeLogAbandoned      = server.Metrics.Counter("goshawkdb_change_log_abandoned_total", "Txns no longer waited for by the change log as they did not become locally complete in time.")
+	changeLogSyncs          = server.Metrics.Counter("goshawkdb_change_log_syncs_total", "Fsyncs of the local change log, each covering every record appended before it started.")
+)
@@ -87,6 +87,7 @@ func (action *localAction) IsImmigrant() bool {
 func (action *localAction) VoteDeadlock(clock *VectorClock) {
 	if action.ballot == nil {
 		action.ballot = NewBallot(action.vUUId, AbortDeadlock, clock)
+		votesAbortDeadlock.Inc()
 		action.voteCast(action.ballot, true)
 	}
 }
@@ -95,6 +96,19 @@ func (action *localAction) VoteBadRead(clock *VectorClock, txnId *common.TxnId,
 	if action.ballot == nil {
 		action.ballot = NewBallot(action.vUUId, AbortBadRead, clock)
 		action.ballot.CreateBadReadCap(txnId, actions)
+		votesAbortBadRead.Inc()
+		action.voteCast(action.ballot, true)
+	}
+}
+
+// VoteCollected votes as VoteBadRead does, but marks the vote so that
+// the submitter can tell that the var has been garbage collected.
+func (action *localAction) VoteCollected(clock *VectorClock, txnId *common.TxnId, actions *msgs.Action_List) {
+	if action.ballot == nil {
+		action.ballot = NewBallot(action.vUUId, AbortBadRead, clock)
+		action.ballot.CreateBadReadCap(txnId, actions)
+		action.ballot.VoteCap.AbortBadRead().SetCollected(true)
+		votesAbortCollected.Inc()
 		action.voteCast(action.ballot, true)
 	}
 }
@@ -102,6 +116,7 @@ func (action *localAction) VoteBadRead(clock *VectorClock, txnId *common.TxnId,
 func (action *localAction) VoteCommit(clock *VectorClock) bool {
 	if action.ballot == nil {
 		action.ballot = NewBallot(action.vUUId, Commit, clock)
+		votesCommit.Inc()
 		return !action.voteCast(action.ballot, false)
 	}
 	return false
@@ -365,14 +380,14 @@ func (txn *Txn) String() string {
 
 func (txn *Txn) Status(sc *server.StatusConsumer) {
 	sc.Emit(txn.Id.String())
-	sc.Emit(fmt.Sprintf("- Local Actions: %v", txn.localActions))
-	sc.Emit(fmt.Sprintf("- Current State: %v", txn.currentState))
-	sc.Emit(fmt.Sprintf("- Retry? %v", txn.Retry))
-	sc.Emit(fmt.Sprintf("- PreAborted? %v", txn.preAbortedBool))
-	sc.Emit(fmt.Sprintf("- Aborted? %v", txn.aborted))
-	sc.Emit(fmt.Sprintf("- Outcome Clock: %v", txn.outcomeClock))
-	sc.Emit(fmt.Sprintf("- Active Frames Count: %v", atomic.LoadInt32(&txn.activeFramesCount)))
-	sc.Emit(fmt.Sprintf("- Completed? %v", txn.completed))
+	sc.EmitKV("Local Actions", txn.localActions)
+	sc.EmitKV("Current State", txn.currentState)
+	sc.EmitKV("Retry", txn.Retry)
+	sc.EmitKV("PreAborted", txn.preAbortedBool)
+	sc.EmitKV("Aborted", txn.aborted)
+	sc.EmitKV("Outcome Clock", txn.outcomeClock)
+	sc.EmitKV("Active Frames Count", atomic.LoadInt32(&txn.activeFramesCount))
+	sc.EmitKV("Completed", txn.completed)
 	sc.Join()
 }
 
@@ -536,6 +551,9 @@ func (tro *txnReceiveOutcome) BallotOutcomeReceived(outcome *msgs.Outcome) {
 	switch outcome.Which() {
 	case msgs.OUTCOME_COMMIT:
 		tro.outcomeClock = VectorClockFromCap(outcome.Commit())
+		if tro.vd.changeLog != nil {
+			tro.vd.changeLog.committed(tro.Txn)
+		}
 	default:
 		tro.aborted = true
 	}
@@ -595,6 +613,9 @@ func (talc *txnAwaitLocallyComplete) LocallyComplete() {
 func (talc *txnAwaitLocallyComplete) locallyComplete() {
 	if talc.currentState == talc {
 		talc.nextState() // do state first!
+		if !talc.aborted && talc.vd.changeLog != nil {
+			talc.vd.changeLog.locallyComplete(talc.Txn)
+		}
 		talc.stateChange.TxnLocallyComplete(talc.Txn)
 	}
 }
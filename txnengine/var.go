@@ -3,6 +3,7 @@ package txnengine
 import (
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
+	mdb "github.com/msackman/gomdb"
 	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
@@ -24,6 +25,7 @@ type Var struct {
 	curFrame        *frame
 	curFrameOnDisk  *frame
 	writeInProgress func()
+	collected       bool
 	subscribers     map[common.TxnId]*VarWriteSubscriber
 	exe             *dispatcher.Executor
 	db              *db.Databases
@@ -69,6 +71,44 @@ func VarFromData(data []byte, exe *dispatcher.Executor, db *db.Databases, vm *Va
 	}
 }
 
+// VarFromTombstone recreates a var which has been garbage collected
+// from the var data of its tombstone. The collecting txn is not kept
+// on disk, so the frame is given an action which writes the var
+// empty, as the collecting txn did.
+func VarFromTombstone(data []byte, exe *dispatcher.Executor, db *db.Databases, vm *VarManager) (*Var, error) {
+	seg, _, err := capn.ReadFromMemoryZeroCopy(data)
+	if err != nil {
+		return nil, err
+	}
+	varCap := msgs.ReadRootVar(seg)
+
+	v := newVar(common.MakeVarUUId(varCap.Id()), exe, db, vm)
+	positions := varCap.Positions()
+	if positions.Len() != 0 {
+		v.positions = (*common.Positions)(&positions)
+	}
+	v.collected = true
+
+	writeTxnId := common.MakeTxnId(varCap.WriteTxnId())
+	writeTxnClock := VectorClockFromCap(varCap.WriteTxnClock())
+	writesClock := VectorClockFromCap(varCap.WritesClock())
+	server.Log(v.UUId, "Restored from tombstone", writeTxnId)
+
+	actionsSeg := capn.NewBuffer(nil)
+	actions := msgs.NewActionList(actionsSeg, 1)
+	action := actions.At(0)
+	action.SetVarId(v.UUId[:])
+	action.SetWrite()
+	write := action.Write()
+	write.SetValue([]byte{})
+	write.SetReferences(msgs.NewVarIdPosList(actionsSeg, 0))
+
+	v.curFrame = NewFrame(nil, v, writeTxnId, &actions, writeTxnClock, writesClock)
+	v.curFrameOnDisk = v.curFrame
+	v.varCap = &varCap
+	return v, nil
+}
+
 func NewVar(uuid *common.VarUUId, exe *dispatcher.Executor, db *db.Databases, vm *VarManager) *Var {
 	v := newVar(uuid, exe, db, vm)
 
@@ -113,6 +153,14 @@ func (v *Var) ReceiveTxn(action *localAction) {
 	server.Log(v.UUId, "ReceiveTxn", action)
 	isRead, isWrite := action.IsRead(), action.IsWrite()
 
+	if v.collected {
+		// A collected var must never be written back out, and the
+		// client must be told, rather than shown the var empty.
+		action.VoteCollected(v.curFrame.frameTxnClock, v.curFrame.frameTxnId, v.curFrame.frameTxnActions)
+		v.maybeMakeInactive()
+		return
+	}
+
 	if isRead && action.Retry {
 		if voted := v.curFrame.ReadRetry(action); !voted {
 			v.AddWriteSubscriber(action.Id,
@@ -141,6 +189,21 @@ func (v *Var) ReceiveTxn(action *localAction) {
 	}
 }
 
+// ReadOnlyCheck is used by read-only txns, which are not voted
+// on. A read of readVsn can commit now only if readVsn is the current
+// version and there is no write in flight. If so, it returns the
+// clock a read would have voted with; otherwise nil.
+func (v *Var) ReadOnlyCheck(readVsn *common.TxnId) *VectorClock {
+	clock := v.curFrame.ReadOnlyCheck(readVsn)
+	if clock == nil {
+		readOnlyChecksFail.Inc()
+	} else {
+		readOnlyChecksPass.Inc()
+	}
+	v.maybeMakeInactive()
+	return clock
+}
+
 func (v *Var) ReceiveTxnOutcome(action *localAction) {
 	server.Log(v.UUId, "ReceiveTxnOutcome", action)
 	isRead, isWrite := action.IsRead(), action.IsWrite()
@@ -170,6 +233,9 @@ func (v *Var) ReceiveTxnOutcome(action *localAction) {
 		}
 
 	default:
+		if cl := action.vd.changeLog; cl != nil {
+			cl.dependsOn(action.Id, action.frame.frameTxnId)
+		}
 		switch {
 		case isRead && isWrite:
 			action.frame.ReadWriteCommitted(action)
@@ -236,6 +302,16 @@ func (v *Var) maybeWriteFrame(f *frame, action *localAction, positions *common.P
 		v.maybeMakeInactive()
 	}
 
+	// A collecting txn is only ever submitted for vars which are
+	// unreachable from the root. The var is deleted from Vars, and
+	// only a tombstone is left in CollectedVars; the collecting txn
+	// itself is not written to disk. From now on, the var must never
+	// be written or rolled, as that would write it back out.
+	collect := action.TxnCap.Collect()
+	if collect {
+		v.collected = true
+	}
+
 	oldVarCap := *v.varCap
 
 	varSeg := capn.NewBuffer(nil)
@@ -256,26 +332,65 @@ func (v *Var) maybeWriteFrame(f *frame, action *localAction, positions *common.P
 
 	txnBytes := action.TxnRootBytes()
 
+	// If the cluster retains history, the version on disk which we
+	// are superseding is moved into the history rather than released.
+	var historyBytes []byte
+	var historyClockElem uint64
+	if topology := v.vm.Topology; topology != nil && topology.HistoryRetentionSeconds > 0 && v.curFrameOnDisk != nil {
+		historyBytes = server.SegToBytes(oldVarCap.Segment)
+		historyClockElem = v.curFrameOnDisk.frameTxnClock.Clock[*v.UUId]
+	}
+	releaseOnDisk := func(rwtxn *mdbs.RWTxn) error {
+		if historyBytes != nil {
+			return v.db.WriteVarHistory(rwtxn, v.UUId, historyClockElem, time.Now(), v.curFrameOnDisk.frameTxnId, historyBytes)
+		} else if v.curFrameOnDisk != nil {
+			return v.db.DeleteTxnFromDisk(rwtxn, v.curFrameOnDisk.frameTxnId)
+		}
+		return nil
+	}
+
 	// to ensure correct order of writes, schedule the write from
 	// the current go-routine...
+	start := time.Now()
 	future := v.db.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
-		if err := v.db.WriteTxnToDisk(rwtxn, f.frameTxnId, txnBytes); err == nil {
-			if err = rwtxn.Put(v.db.Vars, v.UUId[:], varData, 0); err == nil {
-				if v.curFrameOnDisk != nil {
-					v.db.DeleteTxnFromDisk(rwtxn, v.curFrameOnDisk.frameTxnId)
-				}
+		if collect {
+			if err := v.db.WriteCollectedVar(rwtxn, v.UUId, time.Now(), varData); err != nil {
+				rwtxn.Error(err)
+				return nil
+			} else if err = rwtxn.Del(v.db.Vars, v.UUId[:], nil); err != nil && err != mdb.NotFound {
+				rwtxn.Error(err)
+				return nil
+			} else if err = releaseOnDisk(rwtxn); err != nil {
+				rwtxn.Error(err)
+				return nil
 			}
+			return true
+		}
+		if err := v.db.WriteTxnToDisk(rwtxn, f.frameTxnId, txnBytes); err != nil {
+			rwtxn.Error(err)
+			return nil
+		} else if err = rwtxn.Put(v.db.Vars, v.UUId[:], varData, 0); err != nil {
+			rwtxn.Error(err)
+			return nil
+		} else if err = releaseOnDisk(rwtxn); err != nil {
+			rwtxn.Error(err)
+			return nil
 		}
 		return true
 	})
 	go func() {
 		// ... but process the result in a new go-routine to avoid blocking the executor.
-		if ran, err := future.ResultError(); err != nil {
+		ran, err := future.ResultError()
+		varWriteLatency.ObserveSince(start)
+		if err != nil {
 			panic(fmt.Sprintf("Var error when writing to disk: %v\n", err))
 		} else if ran != nil {
 			// Switch back to the right go-routine
 			v.applyToVar(func() {
 				server.Log(v.UUId, "Wrote", f.frameTxnId)
+				if collect {
+					varsCollected.Inc()
+				}
 				v.curFrameOnDisk = f
 				for ancestor := f.parent; ancestor != nil && ancestor.DescendentOnDisk(); ancestor = ancestor.parent {
 				}
@@ -338,14 +453,15 @@ func (v *Var) applyToVar(fun func()) {
 func (v *Var) Status(sc *server.StatusConsumer) {
 	sc.Emit(v.UUId.String())
 	if v.positions == nil {
-		sc.Emit("- Positions: unknown")
+		sc.EmitKV("Positions", "unknown")
 	} else {
-		sc.Emit(fmt.Sprintf("- Positions: %v", v.positions))
+		sc.EmitKV("Positions", v.positions)
 	}
 	sc.Emit("- CurFrame:")
 	v.curFrame.Status(sc.Fork())
-	sc.Emit(fmt.Sprintf("- Subscribers: %v", len(v.subscribers)))
-	sc.Emit(fmt.Sprintf("- Idle? %v", v.isIdle()))
-	sc.Emit(fmt.Sprintf("- IsOnDisk? %v", v.isOnDisk(false)))
+	sc.EmitKV("Subscribers", len(v.subscribers))
+	sc.EmitKV("Idle", v.isIdle())
+	sc.EmitKV("Collected", v.collected)
+	sc.EmitKV("IsOnDisk", v.isOnDisk(false))
 	sc.Join()
 }
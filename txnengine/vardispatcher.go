@@ -35,11 +35,15 @@ const (
 type VarDispatcher struct {
 	dispatcher.Dispatcher
 	varmanagers []*VarManager
+	changeLog   *ChangeLog
 }
 
-func NewVarDispatcher(count uint8, rmId common.RMId, cm TopologyPublisher, db *db.Databases, lc LocalConnection) *VarDispatcher {
+// changeLog may be nil, in which case committed txns are not
+// recorded.
+func NewVarDispatcher(count uint8, rmId common.RMId, cm TopologyPublisher, db *db.Databases, lc LocalConnection, changeLog *ChangeLog) *VarDispatcher {
 	vd := &VarDispatcher{
 		varmanagers: make([]*VarManager, count),
+		changeLog:   changeLog,
 	}
 	vd.Dispatcher.Init(count)
 	for idx, exe := range vd.Executors {
@@ -60,6 +64,9 @@ func (vd *VarDispatcher) Status(sc *server.StatusConsumer) {
 		manager := vd.varmanagers[idx]
 		executor.Enqueue(func() { manager.Status(s) })
 	}
+	if vd.changeLog != nil {
+		vd.changeLog.Status(sc.Fork())
+	}
 	sc.Join()
 }
 
@@ -9,6 +9,7 @@ import (
 	"goshawkdb.io/server/configuration"
 	"goshawkdb.io/server/db"
 	"goshawkdb.io/server/dispatcher"
+	"log"
 	"math/rand"
 	"time"
 )
@@ -153,22 +154,48 @@ func (vm *VarManager) find(uuid *common.VarUUId) (*Var, bool) {
 		// worry about pointers into the db
 		if bites, err := rtxn.Get(vm.db.Vars, uuid[:]); err == nil {
 			return bites
+		} else if bites, err := rtxn.Get(vm.db.CollectedVars, uuid[:]); err == nil {
+			_, varBites, err := db.CollectedVarFromData(bites)
+			if err != nil {
+				rtxn.Error(err)
+				return nil
+			}
+			return collectedVarData(varBites)
 		} else {
 			return true
 		}
 	}).ResultError()
 
+	var bites []byte
+	collected := false
+	switch data := result.(type) {
+	case []byte:
+		bites = data
+	case collectedVarData:
+		bites, collected = data, true
+	}
+
 	if err != nil {
 		panic(fmt.Sprintf("Error when loading %v from disk: %v", uuid, err))
 	} else if result == nil { // shutdown
 		return nil, true
-	} else if bites, ok := result.([]byte); ok {
-		v, err := VarFromData(bites, vm.exe, vm.db, vm)
+	} else if bites != nil {
+		var v *Var
+		var err error
+		if collected {
+			v, err = VarFromTombstone(bites, vm.exe, vm.db, vm)
+		} else {
+			v, err = VarFromData(bites, vm.exe, vm.db, vm)
+		}
 		if err != nil {
 			panic(fmt.Sprintf("Error when recreating %v: %v", uuid, err))
 		} else if v == nil { // shutdown
 			return v, true
 		} else {
+			if collected {
+				collectedVarsReferenced.Inc()
+				log.Printf("Warning: %v was garbage collected, but has been referenced again.", uuid)
+			}
 			vm.active[*v.UUId] = v
 			return v, false
 		}
@@ -177,11 +204,15 @@ func (vm *VarManager) find(uuid *common.VarUUId) (*Var, bool) {
 	}
 }
 
+// collectedVarData distinguishes the var data of tombstones found in
+// CollectedVars from vars found in Vars.
+type collectedVarData []byte
+
 func (vm *VarManager) Status(sc *server.StatusConsumer) {
-	sc.Emit(fmt.Sprintf("- Active Vars: %v", len(vm.active)))
-	sc.Emit(fmt.Sprintf("- Callbacks: %v", len(vm.callbacks)))
-	sc.Emit(fmt.Sprintf("- Beater live? %v", vm.beaterLive))
-	sc.Emit(fmt.Sprintf("- Roll allowed? %v", vm.RollAllowed))
+	sc.EmitKV("Active Vars", len(vm.active))
+	sc.EmitKV("Callbacks", len(vm.callbacks))
+	sc.EmitKV("Beater live", vm.beaterLive)
+	sc.EmitKV("Roll allowed", vm.RollAllowed)
 	for _, v := range vm.active {
 		v.Status(sc.Fork())
 	}